@@ -0,0 +1,45 @@
+// c7n-slaver 部署在集群中，替 c7nctl 执行 sql、命令和 http 请求。使用 --job 时只执行 k8s job 中的一个任务
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/choerodon/c7nctl/pkg/slaver/server"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	grpcAddr := flag.String("grpc-addr", ":9001", "address of the grpc server")
+	httpAddr := flag.String("http-addr", ":9000", "address of the http server")
+	debug := flag.Bool("debug", false, "enable debug logs")
+	job := flag.String("job", "", "run the job defined in the file and exit")
+	flag.Parse()
+
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+	if *job != "" {
+		if err := server.New(nil).RunJobFile(context.Background(), *job, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	cred, err := server.CredentialsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s := server.New(cred)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		s.Stop()
+	}()
+	if err = s.ListenAndServe(*grpcAddr, *httpAddr); err != nil {
+		log.Fatal(err)
+	}
+}
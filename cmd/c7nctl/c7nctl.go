@@ -39,6 +39,9 @@ func main() {
 			log.SetLevel(log.DebugLevel)
 		}
 		c7nCfg.Init(settings.KubeConfig, settings.Namespace)
+		c7nCfg.StateBackend = settings.StateBackend
+		c7nCfg.StateFile = settings.StateFile
+		c7nCfg.Instance = settings.Instance
 	})
 	if err := cmd.Execute(); err != nil {
 		log.Error(err)
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/choerodon/c7nctl/pkg/action"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/cmd/helm/require"
+	"io"
+)
+
+const checkDomainsDesc = `
+Check every domain defined under spec.resources of the config file in parallel.
+
+The dns mode checks the domain resolves to the ingress controller, the http mode
+asks the slaver to serve a token and fetches it through the domain, and the tls mode
+checks the certificate served on port 443 is trusted and not about to expire.
+
+	$ c7nctl check domains -c config.yaml
+	$ c7nctl check domains -c config.yaml --mode dns,http,tls
+	$ c7nctl check domains -c config.yaml --ingress-ip 192.168.1.10
+`
+
+func newCheckCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check the environment before installing choerodon",
+	}
+	cmd.AddCommand(newCheckDomainsCmd(cfg, out))
+	return cmd
+}
+
+func newCheckDomainsCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	client := action.NewCheckDomains(cfg)
+	var version string
+	var allowPlaintext bool
+
+	cmd := &cobra.Command{
+		Use:   "domains",
+		Short: "Check the domains defined in the config file",
+		Long:  checkDomainsDesc,
+		Args:  require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			userConfig, err := getUserConfig(settings.ConfigFile)
+			if err != nil {
+				return err
+			}
+			client.Namespace = settings.Namespace
+			for _, m := range client.Modes {
+				if m == action.DomainCheckHTTP {
+					instDef, err := getInstallDefinition("", version)
+					if err != nil {
+						return err
+					}
+					instDef.MergerConfig(userConfig)
+					client.Slaver = &instDef.Spec.Basic.Slaver
+					if allowPlaintext {
+						client.Slaver.AllowPlaintext = true
+					}
+				}
+			}
+			return cfg.RecordRun(client.Namespace, version, func() error {
+				return client.Run(userConfig, out)
+			})
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVarP(&version, "version", "v", v.Version, "version of choerodon which defines the slaver")
+	flags.StringSliceVar(&client.Modes, "mode", client.Modes, "check modes: dns, http, tls")
+	flags.StringSliceVar(&client.IngressIPs, "ingress-ip", nil, "external addresses of the ingress controller, discovered from the cluster by default")
+	flags.StringVar(&client.IngressService, "ingress-service", "", "service of the ingress controller in the format namespace/name")
+	flags.IntVar(&client.MinValidDays, "min-valid-days", client.MinValidDays, "minimum days before the certificate expires")
+	flags.DurationVar(&client.Timeout, "timeout", client.Timeout, "timeout of dns and tls checks")
+	flags.IntVar(&client.Parallel, "parallel", client.Parallel, "number of domains checked in parallel")
+	flags.BoolVar(&client.KeepSlaver, "keep-slaver", false, "keep the slaver deployed by the http mode after the check")
+	flags.BoolVar(&allowPlaintext, "allow-plaintext-slaver", false, "allow an unencrypted and unauthenticated connection to a slaver image without TLS support")
+	return cmd
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/choerodon/c7nctl/pkg/action"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/cmd/helm/require"
+	"io"
+)
+
+const historyDesc = `
+Show the history of c7nctl runs which changed the cluster in the namespace.
+
+Every install and slaver deploy, exec, sql, request and cleanup is recorded with the
+operator, host, versions, the command line with secrets redacted, the result of each
+task and the duration.
+
+	$ c7nctl history list
+	$ c7nctl history show 20210401-101010-3fa2c1
+`
+
+func newHistoryCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	client := action.NewHistory(cfg)
+
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show the history of c7nctl runs",
+		Long:  historyDesc,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			client.Namespace = settings.Namespace
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the runs, the latest first",
+		Args:    require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.List(out)
+		},
+	}
+	showCmd := &cobra.Command{
+		Use:   "show ID",
+		Short: "Show a run and the result of its tasks, ID can be a prefix",
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.Show(args[0], out)
+		},
+	}
+
+	cmd.AddCommand(listCmd, showCmd)
+	return cmd
+}
@@ -43,6 +43,13 @@ To specify configuration files or resource file path, use the '--config/--resour
 
 To check the generated manifests of a release without installing the chart,
 the '--debug' and '--client-only' flags can be combined.
+
+To install without any outbound network, use '--from-package' with an offline package created by
+'c7nctl package' and pushed by 'c7nctl package push'. The install definition, values and charts are
+read from the package, chart versions are resolved from its manifest, and images are rewritten to
+'--image-repo':
+
+	$ c7nctl install c7n -c config.yaml --from-package ./choerodon-offline-1.1 --image-repo harbor.example.com/c7n
 `
 
 // installCmd represents the resource command
@@ -60,13 +67,20 @@ func newInstallCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
 			setUserConfig(settings.SkipInput)
 			// TODO 添加到 install 中
 			client.ResourceClient.Init()
-			if err := runInstall(args, client, out); err != nil {
+			// 没有指定 --version 时使用离线包的版本
+			if client.FromPackage != "" && !cmd.Flags().Changed("version") {
+				client.Version = ""
+			}
+			if err := runInstall(args, cfg, client, out); err != nil {
 				log.Errorf("Install Choerodon failed: %s", err)
 				metrics.ErrorMsg = []string{err.Error()}
 			} else {
 				log.Info("Install Choerodon succeed")
 			}
-			metrics.Send()
+			// 离线安装时不访问外部网络
+			if client.FromPackage == "" {
+				metrics.Send()
+			}
 		},
 	}
 
@@ -76,45 +90,77 @@ func newInstallCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
 	return cmd
 }
 
-func runInstall(args []string, client *action.Install, out io.Writer) error {
+func runInstall(args []string, cfg *action.C7nConfiguration, client *action.Install, out io.Writer) error {
+	if client.FromPackage != "" {
+		cleanup, err := client.LoadPackage()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+	instDef, err := loadInstallDefinition(args, client)
+	if err != nil {
+		return err
+	}
+	// 模拟安装时默认不修改集群中的安装记录
+	if client.ClientOnly && settings.StateBackend == "" {
+		cfg.StateBackend = c7nclient.StateBackendMemory
+	}
+	if err = client.InitInstance(instDef); err != nil {
+		return err
+	}
+	return cfg.RecordRun(client.Namespace, client.Version, func() error {
+		return client.Run(instDef)
+	})
+}
+
+// loadInstallDefinition 读取用户配置和安装定义，并将配置合并到安装定义中
+func loadInstallDefinition(args []string, client *action.Install) (*resource.InstallDefinition, error) {
 	var err error
 	client.Name, err = getName(args)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	userConfig, err := getUserConfig(settings.ConfigFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	client.Setup(userConfig)
 	log.Infof("The current installing choerodon version is %s", client.Version)
 
-	instDef := &resource.InstallDefinition{}
-	var instDefByte []byte
-	if instDefByte, err = c7nutils.GetInstallDefinition("", client.Version); err != nil {
-		return std_errors.WithMessage(err, "Failed to get install configuration file")
-	}
-	err = yaml_v2.Unmarshal(instDefByte, instDef)
+	instDef, err := getInstallDefinition(client.InstallDefinitionFile(), client.Version)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if !instDef.IsApplication(client.Name) {
-		return std_errors.New("Please input right release name!")
+		return nil, std_errors.New("Please input right release name!")
 	}
 	instDef.MergerConfig(userConfig)
 	client.Namespace = settings.Namespace
-	return client.Run(instDef)
+	return instDef, nil
 }
 
 func addInstallFlags(fs *pflag.FlagSet, client *action.Install) {
 	fs.StringVarP(&client.Version, "version", "v", v.Version, "version of choerodon which will installation")
-	fs.StringVar(&client.Prefix, "prefix", "", "add prefix to all helm release")
+	fs.StringVar(&client.Prefix, "prefix", "", "add prefix to all helm releases and pvcs, the instance defaults to <prefix>-<namespace>")
 	fs.StringVar(&client.ImageRepository, "image-repo", "", "default image repository of all release")
 	fs.StringVar(&client.ChartRepository, "chart-repo", "", "chart repository url")
 	fs.StringVar(&client.DatasourceTpl, "datasource-url", "", "datasource url template")
+	fs.StringVar(&client.IngressClassName, "ingress-class", "", "ingress class of the ingress used to check domains")
+	fs.StringVar(&client.JobRunner, "job-runner", "", "how release jobs are executed: slaver or k8s-job")
+	fs.StringVar(&client.JobImage, "job-image", "", "image of the kubernetes jobs, required when --job-runner=k8s-job, must be built from docker/slaver.Dockerfile")
+	fs.StringVar(&client.HelmTimeout, "helm-timeout", "", "time to wait for each helm operation, defaults to 5m")
 
 	fs.BoolVar(&client.ThinMode, "thin-mode", false, "install choerodon using Low resource consumption")
 	fs.BoolVar(&client.ClientOnly, "client-only", false, "simulate an install")
+	fs.BoolVar(&client.FatalDomainCheck, "fatal-domain-check", false, "abort the installation when a domain check fails")
+	fs.BoolVar(&client.KeepSlaver, "keep-slaver", false, "keep the slaver after the installation for troubleshooting")
+	fs.BoolVar(&client.AllowPlaintextSlaver, "allow-plaintext-slaver", false, "allow an unencrypted and unauthenticated connection to a slaver image without TLS support")
+	fs.BoolVar(&client.HelmWait, "wait", false, "wait until the resources of each release are ready")
+	fs.BoolVar(&client.HelmAtomic, "atomic", false, "roll back a release automatically when its install or upgrade fails, sets --wait")
+	fs.BoolVar(&client.Locked, "locked", false, "install the chart versions recorded in the lock file")
+	fs.StringVar(&client.LockFile, "lock-file", resource.DefaultLockFile, "lock file which records the chart version of each release")
+	fs.StringVar(&client.FromPackage, "from-package", "", "install from an offline package directory or archive created by c7nctl package")
 
 	addResourceClientFlags(fs, client.ResourceClient)
 }
@@ -170,6 +216,19 @@ func getUserConfig(filePath string) (*config.C7nConfig, error) {
 	return userConfig, nil
 }
 
+// getInstallDefinition 读取本地的安装定义文件 file，file 为空时获取 version 对应的远程安装定义
+func getInstallDefinition(file, version string) (*resource.InstallDefinition, error) {
+	instDefByte, err := c7nutils.GetInstallDefinition(file, version)
+	if err != nil {
+		return nil, std_errors.WithMessage(err, "Failed to get install configuration file")
+	}
+	instDef := &resource.InstallDefinition{}
+	if err = yaml_v2.Unmarshal(instDefByte, instDef); err != nil {
+		return nil, err
+	}
+	return instDef, nil
+}
+
 func getName(args []string) (string, error) {
 	if len(args) > 1 {
 		return args[0], std_errors.Errorf("expected at most one arguments, unexpected arguments: %v", strings.Join(args[1:], ", "))
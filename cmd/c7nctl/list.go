@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/choerodon/c7nctl/pkg/action"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/cmd/helm/require"
+	"io"
+)
+
+const listDesc = `
+List the choerodon instances in all namespaces of the cluster.
+
+An instance is identified by its name, which defaults to <prefix>-<namespace>, or the
+namespace when installed without a prefix. Use --instance to manage a specific instance.
+
+	$ c7nctl list
+	$ c7nctl install c7n --prefix staging
+	$ c7nctl state list --instance staging-c7n-system
+`
+
+func newListCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	client := action.NewList(cfg)
+
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the choerodon instances in the cluster",
+		Long:    listDesc,
+		Args:    require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.Run(out)
+		},
+	}
+	return cmd
+}
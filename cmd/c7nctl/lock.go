@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/choerodon/c7nctl/pkg/action"
+	"github.com/choerodon/c7nctl/pkg/resource"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/cmd/helm/require"
+	"io"
+)
+
+const lockDesc = `
+Manage the lock file which records the chart version of each release.
+
+Every install writes the resolved chart versions to the lock file, and an install with
+'--locked' installs exactly the versions in it, so the same platform can be deployed again
+later. Run 'c7nctl lock update' to resolve the versions again without installing.
+
+	$ c7nctl lock update c7n -c config.yaml
+	$ c7nctl install c7n -c config.yaml --locked
+`
+
+func newLockCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lock",
+		Short: "Manage the lock file of chart versions",
+		Long:  lockDesc,
+	}
+	cmd.AddCommand(newLockUpdateCmd(cfg, out))
+	return cmd
+}
+
+func newLockUpdateCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	client := action.NewInstall(cfg)
+	client.ResourceClient = resource.NewClient(nil, "")
+
+	cmd := &cobra.Command{
+		Use:   "update [NAME]",
+		Short: "Resolve the chart versions of an application and write them to the lock file",
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client.ResourceClient.Init()
+			instDef, err := loadInstallDefinition(args, client)
+			if err != nil {
+				return err
+			}
+			return client.UpdateLock(instDef, out)
+		},
+	}
+
+	fs := cmd.Flags()
+	fs.StringVarP(&client.Version, "version", "v", v.Version, "version of choerodon")
+	fs.StringVar(&client.ChartRepository, "chart-repo", "", "chart repository url")
+	fs.StringVar(&client.LockFile, "lock-file", resource.DefaultLockFile, "lock file which records the chart version of each release")
+	addResourceClientFlags(fs, client.ResourceClient)
+	return cmd
+}
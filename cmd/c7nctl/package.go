@@ -1,12 +1,20 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"fmt"
+	"github.com/choerodon/c7nctl/internal/version"
+	"github.com/choerodon/c7nctl/pkg/image"
+	"github.com/choerodon/c7nctl/pkg/offline"
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/choerodon/c7nctl/pkg/resource"
 	"github.com/choerodon/c7nctl/pkg/utils"
 	mapset "github.com/deckarep/golang-set/v2"
-	"github.com/yidaqiang/go-chartmuseum"
-	"os/exec"
-	"strings"
+	"helm.sh/helm/v3/cmd/helm/require"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"path"
+	"sort"
+	"time"
 
 	"github.com/choerodon/c7nctl/pkg/action"
 	"github.com/choerodon/c7nctl/pkg/config"
@@ -20,15 +28,46 @@ import (
 	"regexp"
 )
 
-const packageDesc = `Generate a Choerodon offline installation package`
+const packageDesc = `
+Generate a Choerodon offline installation package.
+
+The charts, images and install resources (install.yml, values templates and sql scripts, from
+'--resource-path' or the remote manifests) are saved to ./choerodon-offline-<version>, then packed into a single
+tar.gz archive together with a manifest of every chart and image, the SHA-256 checksums of all
+files, and a signature of the checksums when '--sign-key' is given. The signing key is an ed25519
+private key in PEM format:
+
+	$ openssl genpkey -algorithm ed25519 -out c7n.key
+	$ openssl pkey -in c7n.key -pubout -out c7n.pub
+	$ c7nctl package -c package.yaml --sign-key c7n.key
+	$ c7nctl package verify choerodon-offline-1.1.tar.gz --public-key c7n.pub
+`
+
+const packageVerifyDesc = `
+Verify the completeness and integrity of an offline installation package before carrying it
+into an air-gapped site: every file matches its checksum, no file is missing or unexpected, every
+chart and image in the manifest is present, and the signature is valid when '--public-key' is given.
+`
 
 type packageOption struct {
 	configFile string
+
+	// 镜像的保存格式和拉取参数
+	imageFormat string
+	platform    string
+	concurrency int
+
+	output  string
+	signKey string
+
+	// 本地的安装资源目录，为空时获取远程的安装资源
+	resourcePath string
 }
 
 // upgradeCmd represents the upgrade command
 func newPackageCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
 	var pkgOpt = packageOption{}
+	resourceClient := resource.NewClient(nil, "")
 	cmd := &cobra.Command{
 		Use:   "package",
 		Short: "Generate a Choerodon offline installation package",
@@ -38,27 +77,71 @@ func newPackageCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
 			if err != nil {
 				return err
 			}
-			chartPath := fmt.Sprintf("./choerodon-offline-%s/chart", cvm.Spec.VersionRegexp)
-			imagePath := fmt.Sprintf("./choerodon-offline-%s/image/", cvm.Spec.VersionRegexp)
-
-			chartClient, _ := chartmuseum.NewClient(chartmuseum.WithBaseURL(cvm.Spec.Chart.DefaultSource.Url))
-			chart := cvm.Spec.Chart
+			var signKey ed25519.PrivateKey
+			if pkgOpt.signKey != "" {
+				if signKey, err = offline.LoadPrivateKey(pkgOpt.signKey); err != nil {
+					return err
+				}
+			}
+			pkgDir := fmt.Sprintf("./choerodon-offline-%s", cvm.Spec.VersionRegexp)
+			chartPath := path.Join(pkgDir, offline.ChartDir)
+			imagePath := path.Join(pkgDir, offline.ImageDir)
 
 			sureFilePath(cvm.Spec.VersionRegexp)
+			manifest := &offline.Manifest{
+				Name:        cvm.Name,
+				Version:     cvm.Spec.VersionRegexp,
+				ToolVersion: version.GetVersion(),
+				CreatedAt:   time.Now().UTC(),
+				ImageFormat: pkgOpt.imageFormat,
+			}
+			// 所有的 chart 和镜像都拉取之后再返回错误，失败时不生成离线包
+			var errs []error
+
+			// 组件没有定义 source 时使用 default-source
+			repos := []repository.Repository{cvm.Spec.Chart.DefaultSource.Repository()}
+			for _, c := range cvm.Spec.Chart.Component {
+				if c.Source.Url != "" {
+					repos = append(repos, c.Source.Repository())
+				}
+			}
+			repository.SetRepositories(repos)
 
 			for _, c := range cvm.Spec.Chart.Component {
+				source := repos[0]
+				if c.Source.Url != "" {
+					source = c.Source.Repository()
+				}
 				if c.Version == "" {
-					c.Version, _ = utils.GetReleaseTag(chart.DefaultSource.Url+"/"+chart.DefaultSource.Repo, c.Name, cvm.Spec.VersionRegexp)
+					c.Version, _ = utils.GetReleaseTag(source.URL, c.Name, cvm.Spec.VersionRegexp)
 					logrus.Debugf("Chart %s version is %s\n", c.Name, c.Version)
 				}
-				chartVersionOption := chartmuseum.NewChartVersionOption(c.Name, c.Version)
-				_, err = chartClient.Charts.DownloadChart(chart.DefaultSource.Repo, chartPath, chartVersionOption)
+				file, err := source.Pull(c.Name, c.Version, chartPath)
+				if err == nil {
+					err = manifest.AddChart(pkgDir, c.Name, c.Version, file)
+				}
 				if err != nil {
 					logrus.Error(err)
+					errs = append(errs, err)
 				}
 			}
 
 			imageSet := mapset.NewSet[string]()
+			// 保存安装资源，slaver 和 release job 使用的镜像也保存到离线包中
+			resourceClient.Init()
+			res := &action.PackageResources{
+				ResourceClient: resourceClient,
+				ResourcePath:   pkgOpt.resourcePath,
+				Version:        cvm.Spec.VersionRegexp,
+			}
+			extraImages, err := res.Save(path.Join(pkgDir, offline.ResourceDir))
+			if err != nil {
+				logrus.Error(err)
+				errs = append(errs, err)
+			}
+			for _, i := range extraImages {
+				imageSet.Add(i)
+			}
 			complieRegex := regexp.MustCompile("image: (.*?)\n")
 			files, _ := ioutil.ReadDir(chartPath)
 			for _, fi := range files {
@@ -73,48 +156,140 @@ func newPackageCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
 						return err
 					}
 					matchArr := complieRegex.FindAllStringSubmatch(template, -1)
-					for _, image := range matchArr {
-						imageSet.Add(image[1])
+					for _, m := range matchArr {
+						imageSet.Add(m[1])
 					}
 				}
 			}
-			imageSet.Each(func(image string) bool {
-				cmd := exec.Command("docker", "pull", image)
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				err := cmd.Run()
+			for _, i := range cvm.Spec.Image.Images {
+				imageSet.Add(i)
+			}
+			images := imageSet.ToSlice()
+			sort.Strings(images)
 
-				if err != nil {
-					logrus.Fatalf("cmd.Run() failed with %s\n", err)
-				}
-				imageArr := strings.Split(image, "/")
-				imageNameAndVersion := strings.Split(imageArr[len(imageArr)-1], ":")
-				imagePathOne := imagePath + imageNameAndVersion[0] + "-" + imageNameAndVersion[1] + ".tar"
-				_, exist := utils.IsFileExist(imagePathOne)
-				if exist {
-					logrus.Infof("skip image %s", image)
-				} else {
-					cmd = exec.Command("docker", "save", image, "-o", imagePathOne)
-					cmd.Stdout = os.Stdout
-					cmd.Stderr = os.Stderr
-					err = cmd.Run()
-					if err != nil {
-						logrus.Fatalf("cmd.Run() failed with %s\n", err)
-					}
+			registries := map[string]repository.Repository{}
+			for _, r := range cvm.Spec.Image.Registry {
+				registries[r.Domain] = r.Credentials()
+			}
+			puller := &image.Puller{
+				Registries:  registries,
+				Format:      pkgOpt.imageFormat,
+				Platform:    pkgOpt.platform,
+				Concurrency: pkgOpt.concurrency,
+			}
+			pulled, err := puller.Pull(images, imagePath)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if len(errs) > 0 {
+				return utilerrors.NewAggregate(errs)
+			}
+			for _, i := range pulled {
+				img := offline.Image{Reference: i.Reference, Digest: i.Digest}
+				if i.File != "" {
+					img.File = path.Join(offline.ImageDir, i.File)
 				}
+				manifest.Images = append(manifest.Images, img)
+			}
 
-				return false
-			})
+			output := pkgOpt.output
+			if output == "" {
+				output = pkgDir + ".tar.gz"
+			}
+			if err = offline.Create(pkgDir, output, manifest, signKey); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "Offline package %s is created with %d charts and %d images\n", output, len(manifest.Charts), len(manifest.Images))
 			return nil
 		},
 	}
+	cmd.AddCommand(newPackageVerifyCmd(out))
+	cmd.AddCommand(newPackagePushCmd(cfg, out))
 
-	flags := cmd.PersistentFlags()
+	flags := cmd.Flags()
 	flags.StringVarP(&pkgOpt.configFile, "config", "c", "config.yaml", "离线包定义文件")
+	flags.StringVar(&pkgOpt.imageFormat, "image-format", image.FormatDockerArchive, "镜像的保存格式，docker-archive 或者 oci")
+	flags.StringVar(&pkgOpt.platform, "platform", image.DefaultPlatform, "多架构镜像使用的平台")
+	flags.IntVar(&pkgOpt.concurrency, "concurrency", image.DefaultConcurrency, "同时拉取的镜像数量")
+	flags.StringVarP(&pkgOpt.output, "output", "o", "", "离线包的文件名，默认为 choerodon-offline-<version>.tar.gz")
+	flags.StringVar(&pkgOpt.signKey, "sign-key", "", "签名使用的 PEM 格式的 ed25519 私钥")
+	flags.StringVarP(&pkgOpt.resourcePath, "resource-path", "r", "", "本地的安装资源目录，默认获取远程的安装资源")
+	addResourceClientFlags(flags, resourceClient)
 
 	return cmd
 }
 
+func newPackageVerifyCmd(out io.Writer) *cobra.Command {
+	var publicKey string
+	cmd := &cobra.Command{
+		Use:   "verify ARCHIVE",
+		Short: "Verify the completeness and integrity of an offline installation package",
+		Long:  packageVerifyDesc,
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var key ed25519.PublicKey
+			if publicKey != "" {
+				var err error
+				if key, err = offline.LoadPublicKey(publicKey); err != nil {
+					return err
+				}
+			}
+			m, err := offline.Verify(args[0], key)
+			if err != nil {
+				return errors.WithMessage(err, fmt.Sprintf("Offline package %s is invalid", args[0]))
+			}
+			fmt.Fprintf(out, "Offline package %s is valid\n", args[0])
+			fmt.Fprintf(out, "Choerodon version: %s\nCreated at: %s by c7nctl %s\nCharts: %d\nImages: %d\n",
+				m.Version, m.CreatedAt.Format(time.RFC3339), m.ToolVersion, len(m.Charts), len(m.Images))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&publicKey, "public-key", "", "校验签名使用的 PEM 格式的 ed25519 公钥")
+	return cmd
+}
+
+const packagePushDesc = `
+Push the charts and images of an offline installation package into private repositories.
+
+The package is verified first. Charts are uploaded to 'chart.default-target' or the 'target' of
+their component, which can be a chartmuseum, a Harbor chart repository (<harbor>/chartrepo/<project>),
+a Nexus helm hosted repository (<nexus>/repository/<repo>) or an OCI registry (oci://...). Images are
+pushed to the first registry in 'image.registry' as <domain>/<repository>/<image name>. Charts and
+images which already exist are skipped, and the --chart-repo and --image-repo to install with are
+printed at the end.
+
+	$ c7nctl package push choerodon-offline-1.1.tar.gz -c package.yaml
+`
+
+func newPackagePushCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	client := action.NewPackagePush(cfg)
+	var configFile, publicKey string
+	cmd := &cobra.Command{
+		Use:   "push ARCHIVE",
+		Short: "Push the charts and images of an offline installation package into private repositories",
+		Long:  packagePushDesc,
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cvm, err := getPackageConfig(configFile)
+			if err != nil {
+				return err
+			}
+			if publicKey != "" {
+				if client.PublicKey, err = offline.LoadPublicKey(publicKey); err != nil {
+					return err
+				}
+			}
+			return client.Run(args[0], cvm, out)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&configFile, "config", "c", "config.yaml", "离线包定义文件，包含 chart 和镜像的目标仓库")
+	flags.StringVar(&publicKey, "public-key", "", "校验签名使用的 PEM 格式的 ed25519 公钥")
+	flags.StringVar(&client.WorkDir, "work-dir", "", "解压离线包的目录，默认使用临时目录")
+	flags.IntVar(&client.Concurrency, "concurrency", image.DefaultConcurrency, "同时推送的镜像数量")
+	return cmd
+}
+
 func sureFilePath(version string) {
 	err := os.MkdirAll(fmt.Sprintf("./choerodon-offline-%s/chart", version), 0766)
 	if err != nil {
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/choerodon/c7nctl/pkg/action"
+	std_errors "github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/cmd/helm/require"
+	"io"
+	"strconv"
+	"time"
+)
+
+const rollbackDesc = `
+Roll back a release or a whole application.
+
+With a release name, the release is rolled back to the revision like 'helm rollback',
+the previous revision is used when the revision is omitted.
+
+	$ c7nctl rollback choerodon-iam
+	$ c7nctl rollback choerodon-iam 3
+
+With '--to', every release of the application is rolled back to the revision recorded
+at a successful run, in reverse dependency order. The run is a run id of 'c7nctl history'
+or a time, which means the last successful run finished before it.
+
+	$ c7nctl rollback c7n --to 20210401-101010-3fa2c1
+	$ c7nctl rollback c7n --to "2021-04-01 10:00:00" --dry-run
+`
+
+func newRollbackCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	client := action.NewRollback(cfg)
+
+	cmd := &cobra.Command{
+		Use:   "rollback RELEASE [REVISION] | APPLICATION --to RUN",
+		Short: "Roll back a release or an application",
+		Long:  rollbackDesc,
+		Args:  require.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			client.Namespace = settings.Namespace
+			if client.To != "" {
+				if len(args) > 1 {
+					return std_errors.New("revision can not be used with --to")
+				}
+				return client.Record(func() error {
+					return client.RollbackApplication(args[0], out)
+				})
+			}
+			if len(args) > 2 {
+				return std_errors.Errorf("expected at most two arguments, got %d", len(args))
+			}
+			revision := 0
+			if len(args) == 2 {
+				var err error
+				if revision, err = strconv.Atoi(args[1]); err != nil || revision <= 0 {
+					return std_errors.Errorf("invalid revision %s", args[1])
+				}
+			}
+			return client.Record(func() error {
+				return client.RollbackRelease(args[0], revision, out)
+			})
+		},
+	}
+
+	f := cmd.Flags()
+	f.StringVar(&client.To, "to", "", "roll back every release of the application to a run id or a time")
+	f.BoolVar(&client.Wait, "wait", false, "wait until the resources of each release are ready")
+	f.DurationVar(&client.Timeout, "helm-timeout", 5*time.Minute, "time to wait for each helm operation")
+	f.BoolVar(&client.DisableHooks, "no-hooks", false, "prevent hooks from running during rollback")
+	f.BoolVar(&client.DryRun, "dry-run", false, "print what would be rolled back")
+	return cmd
+}
@@ -47,6 +47,13 @@ func newRootCmd(actionConfig *action.C7nConfiguration, out io.Writer, args []str
 		newUpgradeCmd(out),
 		newVersionCmd(out),
 		newPackageCmd(actionConfig, out),
+		newSlaverCmd(actionConfig, out),
+		newCheckCmd(actionConfig, out),
+		newHistoryCmd(actionConfig, out),
+		newStateCmd(actionConfig, out),
+		newListCmd(actionConfig, out),
+		newLockCmd(actionConfig, out),
+		newRollbackCmd(actionConfig, out),
 	)
 
 	// TODO 完成命令自动补全功能
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"github.com/choerodon/c7nctl/pkg/action"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/cmd/helm/require"
+	"io"
+	"strings"
+)
+
+const slaverDesc = `
+Manage the c7n-slaver which is used by the installer to execute sql, commands and
+requests inside the cluster. It is useful to debug in-cluster connectivity.
+
+	$ c7nctl slaver status
+	$ c7nctl slaver exec -- nslookup c7n-mysql
+	$ c7nctl slaver sql --infra c7n-mysql "SHOW DATABASES"
+	$ c7nctl slaver request http://choerodon-register:8000/eureka/apps
+	$ c7nctl slaver cleanup
+`
+
+func newSlaverCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	client := action.NewSlaver(cfg)
+	var version string
+	var allowPlaintext bool
+
+	cmd := &cobra.Command{
+		Use:   "slaver",
+		Short: "Manage the c7n-slaver in the cluster",
+		Long:  slaverDesc,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			instDef, err := getInstallDefinition("", version)
+			if err != nil {
+				return err
+			}
+			client.Namespace = settings.Namespace
+			instance, err := cfg.ResolveInstance(client.Namespace, "", true)
+			if err != nil {
+				return err
+			}
+			instDef.SetInstance(instance, client.Namespace)
+			client.Slaver = &instDef.Spec.Basic.Slaver
+			if allowPlaintext {
+				client.Slaver.AllowPlaintext = true
+			}
+			return nil
+		},
+	}
+	cmd.PersistentFlags().StringVarP(&version, "version", "v", v.Version, "version of choerodon which defines the slaver")
+	cmd.PersistentFlags().BoolVar(&allowPlaintext, "allow-plaintext-slaver", false, "allow an unencrypted and unauthenticated connection to a slaver image without TLS support")
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of the slaver",
+		Args:  require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.Status(out)
+		},
+	}
+	deployCmd := &cobra.Command{
+		Use:   "deploy",
+		Short: "Deploy the slaver",
+		Args:  require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cfg.RecordRun(client.Namespace, version, func() error {
+				return client.Deploy()
+			})
+		},
+	}
+	execCmd := &cobra.Command{
+		Use:   "exec -- COMMAND",
+		Short: "Execute a shell command in the slaver",
+		Args:  require.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cfg.RecordRun(client.Namespace, version, func() error {
+				return client.Exec(strings.Join(args, " "), out)
+			})
+		},
+	}
+	sqlCmd := &cobra.Command{
+		Use:   "sql SQL...",
+		Short: "Execute sql on an infrastructure release through the slaver",
+		Args:  require.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cfg.RecordRun(client.Namespace, version, func() error {
+				return client.Sql(args)
+			})
+		},
+	}
+	sqlFlags := sqlCmd.Flags()
+	sqlFlags.StringVar(&client.Infra, "infra", "c7n-mysql", "the release which provides the database")
+	sqlFlags.StringVar(&client.Database, "database", "", "the database to use")
+	sqlFlags.StringVar(&client.SqlType, "type", "mysql", "the database type, mysql or postgres")
+
+	requestCmd := &cobra.Command{
+		Use:   "request URL",
+		Short: "Send a http request from the slaver",
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cfg.RecordRun(client.Namespace, version, func() error {
+				return client.Request(args[0], out)
+			})
+		},
+	}
+	requestFlags := requestCmd.Flags()
+	requestFlags.StringVarP(&client.Method, "method", "X", "GET", "the http method")
+	requestFlags.StringVarP(&client.Body, "data", "d", "", "the http request body")
+	requestFlags.StringArrayVarP(&client.Header, "header", "H", []string{}, "the http header in the format name:value")
+
+	cleanupCmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Remove the slaver daemonSet, service and ingress",
+		Args:  require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cfg.RecordRun(client.Namespace, version, func() error {
+				return client.Cleanup()
+			})
+		},
+	}
+
+	cmd.AddCommand(
+		statusCmd,
+		deployCmd,
+		execCmd,
+		sqlCmd,
+		requestCmd,
+		cleanupCmd,
+	)
+	return cmd
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"github.com/choerodon/c7nctl/pkg/action"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	std_errors "github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"helm.sh/helm/v3/cmd/helm/require"
+	"io"
+	"os"
+	"strings"
+)
+
+const stateDesc = `
+Inspect and repair the install state of choerodon in the namespace. Every release,
+release job and persistent volume is a task, the installation skips the tasks which
+have succeed.
+
+	$ c7nctl state list --type release
+	$ c7nctl state get gitlab
+	$ c7nctl state set-status gitlab failed --reason "deleted by hand"
+	$ c7nctl state reset predb
+	$ c7nctl state delete gitlab
+	$ c7nctl state export -f state.yaml
+	$ c7nctl state import -f state.yaml
+`
+
+const stateMigrateDesc = `
+Copy the install state and the run history of the namespace from one state backend
+to another. The source is kept, remove it by yourself after checking the target.
+
+The supported backends are configmap (default), secret, file and memory.
+
+	$ c7nctl state migrate --from configmap --to secret
+	$ c7nctl state migrate --from configmap --to file --to-file ./c7n-state.yaml
+`
+
+func newStateCmd(cfg *action.C7nConfiguration, out io.Writer) *cobra.Command {
+	client := action.NewState(cfg)
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Manage the install state of choerodon",
+		Long:  stateDesc,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			client.Namespace = settings.Namespace
+			client.Yes = client.Yes || settings.SkipInput
+		},
+	}
+	types := strings.Join(action.TaskTypes, ", ")
+	cmd.PersistentFlags().StringVar(&client.Type, "type", "", "only the tasks of the type: "+types)
+
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List the tasks",
+		Args:    require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.List(out)
+		},
+	}
+	getCmd := &cobra.Command{
+		Use:   "get NAME",
+		Short: "Print a task in yaml",
+		Args:  require.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.Get(args[0], out)
+		},
+	}
+	setStatusCmd := &cobra.Command{
+		Use:   "set-status NAME STATUS",
+		Short: "Set the status of a task: " + strings.Join(action.TaskStatuses, ", "),
+		Args:  require.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.SetStatus(args[0], args[1])
+		},
+	}
+	setStatusCmd.Flags().StringVar(&client.Reason, "reason", "", "the reason of the status")
+
+	resetCmd := &cobra.Command{
+		Use:   "reset NAME...",
+		Short: "Reset tasks to uninitialized so that they are executed again",
+		Args:  require.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.Reset(args)
+		},
+	}
+	resetCmd.Flags().BoolVar(&client.ResetMigrations, "migrations", false, "also forget the executed sql scripts")
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete NAME...",
+		Short: "Delete tasks from the install state",
+		Args:  require.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.Delete(args)
+		},
+	}
+	exportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the tasks in yaml",
+		Args:  require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" || file == "-" {
+				return client.Export(out)
+			}
+			// task 中可能有数据库密码
+			f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return client.Export(f)
+		},
+	}
+	exportCmd.Flags().StringVarP(&file, "file", "f", "", "write to the file instead of stdout")
+
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Import the tasks exported by c7nctl state export",
+		Args:  require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if file == "" {
+				return std_errors.New("the file to import is required, use -f - to read from stdin")
+			}
+			if file == "-" {
+				if !client.Yes {
+					return std_errors.New("--yes is required when reading from stdin")
+				}
+				return client.Import(os.Stdin)
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return client.Import(f)
+		},
+	}
+	importFlags := importCmd.Flags()
+	importFlags.StringVarP(&file, "file", "f", "", "the file to import, - for stdin")
+	importFlags.BoolVar(&client.Overwrite, "overwrite", false, "overwrite the existing tasks")
+
+	for _, c := range []*cobra.Command{setStatusCmd, resetCmd, deleteCmd, importCmd} {
+		c.Flags().BoolVarP(&client.Yes, "yes", "y", false, "don't ask for confirmation")
+	}
+
+	cmd.AddCommand(
+		listCmd,
+		getCmd,
+		setStatusCmd,
+		resetCmd,
+		deleteCmd,
+		exportCmd,
+		importCmd,
+		newStateMigrateCmd(client, out),
+	)
+	return cmd
+}
+
+func newStateMigrateCmd(client *action.State, out io.Writer) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrate the install state between state backends",
+		Long:  stateMigrateDesc,
+		Args:  require.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return client.Migrate(out)
+		},
+	}
+
+	backends := strings.Join(c7nclient.StateBackends, ", ")
+	flags := cmd.Flags()
+	flags.StringVar(&client.From, "from", c7nclient.StateBackendConfigMap, "the source state backend: "+backends)
+	flags.StringVar(&client.FromFile, "from-file", "", "the source state file when --from=file")
+	flags.StringVar(&client.To, "to", "", "the target state backend: "+backends)
+	flags.StringVar(&client.ToFile, "to-file", "", "the target state file when --to=file")
+	cmd.MarkFlagRequired("to")
+	return cmd
+}
@@ -10,6 +10,7 @@ import (
 	v1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/kubernetes/staging/src/k8s.io/apimachinery/pkg/api/errors"
 	"time"
 )
@@ -27,6 +28,12 @@ type C7nConfiguration struct {
 	// helm3 的都是依赖于这个
 	//
 	HelmClient *c7nclient.Helm3Client
+
+	// 保存安装记录的方式，为空时使用 configmap
+	StateBackend string
+	StateFile    string
+	// 为空时使用命名空间中的默认实例
+	Instance string
 }
 
 func (c *C7nConfiguration) Init(kubeconfig, namespace string) {
@@ -38,6 +45,48 @@ func (c *C7nConfiguration) Init(kubeconfig, namespace string) {
 	c.KubeClient = c7nclient.NewK8sClient(kubeclient, namespace)
 }
 
+// ResolveInstance 确定命令操作的实例并保存到 c.Instance，所有命令都通过这里得到默认实例。
+// 优先使用 --instance；prefix 不为空时为 <prefix>-<namespace>；discover 为 true 时使用命名空间中唯一的实例，
+// 这样不指定 --instance 也能操作使用前缀安装的实例
+func (c *C7nConfiguration) ResolveInstance(namespace, prefix string, discover bool) (string, error) {
+	instance := c.Instance
+	if instance == "" && prefix == "" && discover {
+		var err error
+		if instance, err = c7nclient.FindInstance(c.clientSet(), c.StateBackend, namespace); err != nil {
+			return "", err
+		}
+	}
+	if instance == "" {
+		instance = c7nclient.DefaultInstance(prefix, namespace)
+	}
+	if err := c7nclient.ValidateInstance(instance); err != nil {
+		return "", err
+	}
+	c.Instance = instance
+	return instance, nil
+}
+
+func (c *C7nConfiguration) clientSet() kubernetes.Interface {
+	if c.KubeClient == nil {
+		return nil
+	}
+	return c.KubeClient.GetClientSet()
+}
+
+// InitState 初始化保存 namespace 中安装记录的 StateStore
+func (c *C7nConfiguration) InitState(namespace string) error {
+	if _, err := c.ResolveInstance(namespace, "", true); err != nil {
+		return err
+	}
+	return c7nclient.InitStateStore(c7nclient.StateOptions{
+		Backend:   c.StateBackend,
+		Client:    c.KubeClient.GetClientSet(),
+		File:      c.StateFile,
+		Namespace: namespace,
+		Instance:  c.Instance,
+	})
+}
+
 // 基础组件——比如 gitlab-ha ——有 app 标签，c7n 有 choerodon.io/release 标签
 // TODO 去掉 app label
 func (c *C7nConfiguration) CheckReleasePodRunning(rls, namespace string) {
@@ -111,7 +160,7 @@ func (c *C7nConfiguration) CreateImagePullSecret(drs []resource.DockerRegistry)
 	}
 	for _, ds := range drs {
 		if _, err := c.KubeClient.CreateImagePullSecret(ds.Server, ds.Username, ds.Password, ds.SecretName); err != nil {
-			log.Error("Create image pull secret %s failed: %s", ds.SecretName, err)
+			log.Errorf("Create image pull secret %s failed: %s", ds.SecretName, err)
 			continue
 		}
 		c.KubeClient.PatchServiceAccount(ds.ServiceAccount, ds.SecretName)
@@ -0,0 +1,365 @@
+package action
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/config"
+	c7nslaver "github.com/choerodon/c7nctl/pkg/slaver"
+	"github.com/gosuri/uitable"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DomainCheckDNS  = "dns"
+	DomainCheckHTTP = "http"
+	DomainCheckTLS  = "tls"
+)
+
+// 用于发现 ingress controller 的 service
+var ingressControllerSelectors = []string{
+	"app.kubernetes.io/name=ingress-nginx",
+	"app=nginx-ingress",
+	"app.kubernetes.io/name=traefik",
+}
+
+// CheckDomains 检查 config.yaml 中 spec.resources 定义的域名
+type CheckDomains struct {
+	cfg *C7nConfiguration
+
+	Namespace string
+	// http 检查需要通过 slaver 完成，检查结束后删除 slaver，KeepSlaver 为 true 时保留
+	Slaver     *c7nslaver.Slaver
+	KeepSlaver bool
+
+	Modes []string
+	// ingress controller 的外部 IP，为空时从集群中获取
+	IngressIPs []string
+	// ingress controller 的 service，格式为 namespace/name
+	IngressService string
+	// 证书剩余的有效天数小于该值时检查失败
+	MinValidDays int
+	Timeout      time.Duration
+	Parallel     int
+
+	// 方便测试时替换
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	dialTLS    func(domain string) ([]*tlsCert, error)
+}
+
+// DomainCheckResult 是一个域名在一种模式下的检查结果
+type DomainCheckResult struct {
+	Resource string
+	Domain   string
+	Mode     string
+	Err      error
+	Message  string
+}
+
+type tlsCert struct {
+	NotAfter time.Time
+	Subject  string
+}
+
+func NewCheckDomains(cfg *C7nConfiguration) *CheckDomains {
+	return &CheckDomains{
+		cfg:          cfg,
+		Modes:        []string{DomainCheckDNS},
+		MinValidDays: 7,
+		Timeout:      10 * time.Second,
+		Parallel:     8,
+	}
+}
+
+func (c *CheckDomains) Run(uc *config.C7nConfig, out io.Writer) error {
+	for _, m := range c.Modes {
+		if m != DomainCheckDNS && m != DomainCheckHTTP && m != DomainCheckTLS {
+			return std_errors.Errorf("unsupported check mode %s, must be one of dns, http, tls", m)
+		}
+	}
+	domains := uc.GetDomains()
+	if len(domains) == 0 {
+		log.Info("No domain is defined in spec.resources")
+		return nil
+	}
+
+	results, err := c.Check(domains)
+	if err != nil {
+		return err
+	}
+
+	table := uitable.New()
+	table.MaxColWidth = 80
+	table.AddRow("RESOURCE", "DOMAIN", "MODE", "RESULT", "MESSAGE")
+	failed := 0
+	for _, r := range results {
+		result, msg := "ok", r.Message
+		if r.Err != nil {
+			failed++
+			result, msg = "failed", r.Err.Error()
+		}
+		table.AddRow(r.Resource, r.Domain, r.Mode, result, msg)
+	}
+	fmt.Fprintln(out, table.String())
+	if failed > 0 {
+		return std_errors.Errorf("%d domain checks failed", failed)
+	}
+	return nil
+}
+
+// Check 并发检查所有域名，结果按照 resource 和模式排序
+func (c *CheckDomains) Check(domains map[string]string) ([]DomainCheckResult, error) {
+	var ingressIPs map[string]bool
+	if containsString(c.Modes, DomainCheckDNS) {
+		ips, err := c.getIngressIPs()
+		if err != nil {
+			return nil, err
+		}
+		ingressIPs = make(map[string]bool)
+		for _, ip := range ips {
+			ingressIPs[ip] = true
+		}
+	}
+
+	if containsString(c.Modes, DomainCheckHTTP) {
+		// 检查结束后先停止端口转发再清理 slaver
+		stopCh := make(chan struct{})
+		defer func() {
+			close(stopCh)
+			cleanSlaver(c.Slaver, c.KeepSlaver)
+		}()
+		if _, err := c.Slaver.InitSalver(c.cfg.KubeClient.GetClientSet(), c.Namespace, stopCh); err != nil {
+			return nil, std_errors.WithMessage(err, "Create Slaver failed")
+		}
+		// 所有域名的规则放在同一个 Ingress 中，先创建好再并发检查
+		for _, d := range domains {
+			if err := c.Slaver.InstallIngress(d); err != nil {
+				return nil, err
+			}
+		}
+		defer func() {
+			if err := c.Slaver.DeleteIngress(); err != nil {
+				log.Warnf("Delete ingress of slaver failed: %s", err)
+			}
+		}()
+	}
+
+	var results []DomainCheckResult
+	for name, d := range domains {
+		for _, m := range c.Modes {
+			results = append(results, DomainCheckResult{Resource: name, Domain: d, Mode: m})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Resource != results[j].Resource {
+			return results[i].Resource < results[j].Resource
+		}
+		return results[i].Mode < results[j].Mode
+	})
+
+	parallel := c.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for idx := range results {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *DomainCheckResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			switch r.Mode {
+			case DomainCheckDNS:
+				r.Message, r.Err = c.checkDNS(r.Domain, ingressIPs)
+			case DomainCheckHTTP:
+				if r.Err = c.Slaver.VerifyClusterDomain(r.Domain); r.Err == nil {
+					r.Message = "domain points to the cluster"
+				}
+			case DomainCheckTLS:
+				r.Message, r.Err = c.checkTLS(r.Domain)
+			}
+		}(&results[idx])
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// checkDNS 检查域名解析到的地址中至少有一个是 ingress controller 的地址
+func (c *CheckDomains) checkDNS(domain string, ingressIPs map[string]bool) (string, error) {
+	addrs, err := c.lookup(domain)
+	if err != nil {
+		return "", err
+	}
+	for _, a := range addrs {
+		if ingressIPs[a] {
+			return fmt.Sprintf("resolved to %s", strings.Join(addrs, ",")), nil
+		}
+	}
+	var ips []string
+	for ip := range ingressIPs {
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return "", std_errors.Errorf("resolved to %s, want one of the ingress addresses %s", strings.Join(addrs, ","), strings.Join(ips, ","))
+}
+
+// lookup 解析域名的地址，测试时替换 lookupHost
+func (c *CheckDomains) lookup(host string) ([]string, error) {
+	lookup := c.lookupHost
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupHost
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+	return lookup(ctx, host)
+}
+
+// checkTLS 检查 443 端口的证书是否可信，以及是否即将过期
+func (c *CheckDomains) checkTLS(domain string) (string, error) {
+	dial := c.dialTLS
+	if dial == nil {
+		dial = c.defaultDialTLS
+	}
+	certs, err := dial(domain)
+	if err != nil {
+		return "", err
+	}
+	if len(certs) == 0 {
+		return "", std_errors.New("no certificate is presented")
+	}
+	// 证书链中最早过期的证书决定了有效期
+	notAfter := certs[0].NotAfter
+	for _, cert := range certs[1:] {
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	days := int(time.Until(notAfter).Hours() / 24)
+	if days < c.MinValidDays {
+		return "", std_errors.Errorf("certificate expires in %d days at %s", days, notAfter.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("certificate of %s expires in %d days", certs[0].Subject, days), nil
+}
+
+func (c *CheckDomains) defaultDialTLS(domain string) ([]*tlsCert, error) {
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(domain, "443"), &tls.Config{ServerName: domain})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var certs []*tlsCert
+	for _, cert := range conn.ConnectionState().PeerCertificates {
+		certs = append(certs, &tlsCert{NotAfter: cert.NotAfter, Subject: cert.Subject.CommonName})
+	}
+	return certs, nil
+}
+
+// getIngressIPs 依次使用配置的 IP、指定的 service、自动发现的 ingress controller service 以及节点的地址
+func (c *CheckDomains) getIngressIPs() ([]string, error) {
+	if len(c.IngressIPs) > 0 {
+		return c.IngressIPs, nil
+	}
+	client := c.cfg.KubeClient.GetClientSet()
+	ctx := context.Background()
+
+	if c.IngressService != "" {
+		nsName := strings.SplitN(c.IngressService, "/", 2)
+		if len(nsName) != 2 {
+			return nil, std_errors.Errorf("ingress service %s must be in the format namespace/name", c.IngressService)
+		}
+		svc, err := client.CoreV1().Services(nsName[0]).Get(ctx, nsName[1], meta_v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		ips := c.serviceExternalIPs(svc)
+		if len(ips) == 0 {
+			return nil, std_errors.Errorf("service %s has no external address", c.IngressService)
+		}
+		return ips, nil
+	}
+
+	for _, selector := range ingressControllerSelectors {
+		svcs, err := client.CoreV1().Services("").List(ctx, meta_v1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		var ips []string
+		for idx := range svcs.Items {
+			ips = append(ips, c.serviceExternalIPs(&svcs.Items[idx])...)
+		}
+		if len(ips) > 0 {
+			log.Debugf("Using addresses %v of ingress controller services %s", ips, selector)
+			return ips, nil
+		}
+	}
+
+	// ingress controller 一般使用 hostNetwork 部署在节点上
+	ips, err := nodeAddresses(client)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, std_errors.New("can't find the address of ingress controller, please specify it by --ingress-ip")
+	}
+	log.Debugf("Using node addresses %v as ingress addresses", ips)
+	return ips, nil
+}
+
+// serviceExternalIPs 返回 service 的外部地址。AWS ELB 等负载均衡只提供域名，解析为 IP 后与域名解析的结果比较
+func (c *CheckDomains) serviceExternalIPs(svc *core_v1.Service) []string {
+	ips := append([]string{}, svc.Spec.ExternalIPs...)
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		if ing.IP != "" {
+			ips = append(ips, ing.IP)
+		}
+		if ing.Hostname == "" {
+			continue
+		}
+		addrs, err := c.lookup(ing.Hostname)
+		if err != nil {
+			log.Warnf("Failed to resolve the load balancer %s of service %s/%s: %s", ing.Hostname, svc.Namespace, svc.Name, err)
+			continue
+		}
+		ips = append(ips, addrs...)
+	}
+	return ips
+}
+
+func nodeAddresses(client kubernetes.Interface) ([]string, error) {
+	nodes, err := client.CoreV1().Nodes().List(context.Background(), meta_v1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, n := range nodes.Items {
+		for _, a := range n.Status.Addresses {
+			if a.Type == core_v1.NodeExternalIP || a.Type == core_v1.NodeInternalIP {
+				ips = append(ips, a.Address)
+			}
+		}
+	}
+	return ips, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
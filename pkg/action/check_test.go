@@ -0,0 +1,90 @@
+package action
+
+import (
+	"context"
+	"errors"
+	core_v1 "k8s.io/api/core/v1"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckDomains(t *testing.T) {
+	c := NewCheckDomains(nil)
+	c.Modes = []string{DomainCheckDNS, DomainCheckTLS}
+	c.IngressIPs = []string{"10.0.0.1", "10.0.0.2"}
+	c.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		switch host {
+		case "api.example.com":
+			return []string{"10.0.0.2"}, nil
+		case "app.example.com":
+			return []string{"192.168.0.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	c.dialTLS = func(domain string) ([]*tlsCert, error) {
+		switch domain {
+		case "api.example.com":
+			return []*tlsCert{
+				{NotAfter: time.Now().Add(90 * 24 * time.Hour), Subject: domain},
+				{NotAfter: time.Now().Add(365 * 24 * time.Hour), Subject: "ca"},
+			}, nil
+		case "app.example.com":
+			return []*tlsCert{{NotAfter: time.Now().Add(3 * 24 * time.Hour), Subject: domain}}, nil
+		}
+		return nil, errors.New("x509: certificate signed by unknown authority")
+	}
+
+	results, err := c.Check(map[string]string{
+		"choerodon-gateway": "api.example.com",
+		"choerodon-front":   "app.example.com",
+		"minio":             "minio.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		resource string
+		mode     string
+		ok       bool
+	}{
+		{"choerodon-front", DomainCheckDNS, false},
+		{"choerodon-front", DomainCheckTLS, false},
+		{"choerodon-gateway", DomainCheckDNS, true},
+		{"choerodon-gateway", DomainCheckTLS, true},
+		{"minio", DomainCheckDNS, false},
+		{"minio", DomainCheckTLS, false},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("want %d results, got %d", len(want), len(results))
+	}
+	for idx, w := range want {
+		r := results[idx]
+		if r.Resource != w.resource || r.Mode != w.mode || (r.Err == nil) != w.ok {
+			t.Errorf("want %s %s ok=%v, got %s %s %v", w.resource, w.mode, w.ok, r.Resource, r.Mode, r.Err)
+		}
+	}
+}
+
+func TestServiceExternalIPs(t *testing.T) {
+	c := NewCheckDomains(nil)
+	c.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		if host == "a1b2.elb.amazonaws.com" {
+			return []string{"52.1.1.1", "52.1.1.2"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	svc := &core_v1.Service{}
+	svc.Spec.ExternalIPs = []string{"10.0.0.1"}
+	svc.Status.LoadBalancer.Ingress = []core_v1.LoadBalancerIngress{
+		{IP: "10.0.0.2"},
+		// 只提供域名的负载均衡使用解析到的地址
+		{Hostname: "a1b2.elb.amazonaws.com"},
+		{Hostname: "unknown.elb.amazonaws.com"},
+	}
+	want := []string{"10.0.0.1", "10.0.0.2", "52.1.1.1", "52.1.1.2"}
+	if ips := c.serviceExternalIPs(svc); !reflect.DeepEqual(ips, want) {
+		t.Errorf("want %v, got %v", want, ips)
+	}
+}
@@ -0,0 +1,109 @@
+package action
+
+import (
+	"fmt"
+	"github.com/choerodon/c7nctl/internal/version"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	c7nutils "github.com/choerodon/c7nctl/pkg/utils"
+	"github.com/gosuri/uitable"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+)
+
+// RecordRun 执行 run 并将本次执行保存到命名空间的执行记录中，用于修改集群的命令
+func (c *C7nConfiguration) RecordRun(namespace, c7nVersion string, run func() error) error {
+	if err := c.InitState(namespace); err != nil {
+		return err
+	}
+	record := c7nclient.StartRun(c7nclient.RunRecord{
+		Command:       strings.Join(c7nutils.RedactArgs(os.Args), " "),
+		C7nctlVersion: version.GetVersion(),
+		Version:       c7nVersion,
+		Namespace:     namespace,
+		Instance:      c.Instance,
+	})
+	err := run()
+	// 执行记录保存失败不影响命令的结果
+	if ferr := c7nclient.FinishRun(record, err); ferr != nil {
+		log.Warnf("Failed to save the run history: %s", ferr)
+	} else {
+		log.Debugf("Saved run %s", record.ID)
+	}
+	return err
+}
+
+// History 查看命名空间中 c7nctl 的执行记录
+type History struct {
+	cfg *C7nConfiguration
+
+	Namespace string
+}
+
+func NewHistory(cfg *C7nConfiguration) *History {
+	return &History{
+		cfg: cfg,
+	}
+}
+
+func (h *History) init() error {
+	return h.cfg.InitState(h.Namespace)
+}
+
+func (h *History) List(out io.Writer) error {
+	if err := h.init(); err != nil {
+		return err
+	}
+	runs, err := c7nclient.ListRuns()
+	if err != nil {
+		return err
+	}
+	table := uitable.New()
+	table.MaxColWidth = 60
+	table.AddRow("ID", "STATUS", "START", "DURATION", "OPERATOR", "VERSION", "COMMAND")
+	for _, r := range runs {
+		table.AddRow(r.ID, r.Status, r.StartTime.Format("2006-01-02 15:04:05"), r.Duration, r.Operator, r.Version, r.Command)
+	}
+	fmt.Fprintln(out, table.String())
+	return nil
+}
+
+func (h *History) Show(id string, out io.Writer) error {
+	if err := h.init(); err != nil {
+		return err
+	}
+	r, err := c7nclient.GetRun(id)
+	if err != nil {
+		return err
+	}
+	table := uitable.New()
+	table.Wrap = true
+	table.AddRow("ID:", r.ID)
+	table.AddRow("Command:", r.Command)
+	table.AddRow("Operator:", r.Operator)
+	table.AddRow("Host:", r.Host)
+	table.AddRow("c7nctl version:", r.C7nctlVersion)
+	table.AddRow("Choerodon version:", r.Version)
+	table.AddRow("Namespace:", r.Namespace)
+	table.AddRow("Start:", r.StartTime.Format("2006-01-02 15:04:05"))
+	table.AddRow("End:", r.EndTime.Format("2006-01-02 15:04:05"))
+	table.AddRow("Duration:", r.Duration)
+	table.AddRow("Status:", r.Status)
+	if r.Reason != "" {
+		table.AddRow("Reason:", r.Reason)
+	}
+	fmt.Fprintln(out, table.String())
+
+	if len(r.Tasks) > 0 {
+		tasks := uitable.New()
+		tasks.MaxColWidth = 80
+		tasks.AddRow("TASK", "TYPE", "STATUS", "REASON")
+		for _, t := range r.Tasks {
+			tasks.AddRow(t.Name, t.Type, t.Status, t.Reason)
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, tasks.String())
+	}
+	return nil
+}
@@ -6,13 +6,17 @@ import (
 	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
 	"github.com/choerodon/c7nctl/pkg/common/graph"
 	"github.com/choerodon/c7nctl/pkg/config"
+	"github.com/choerodon/c7nctl/pkg/offline"
+	"github.com/choerodon/c7nctl/pkg/repository"
 	"github.com/choerodon/c7nctl/pkg/resource"
 	c7nslaver "github.com/choerodon/c7nctl/pkg/slaver"
 	c7nutils "github.com/choerodon/c7nctl/pkg/utils"
 	std_errors "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -30,6 +34,10 @@ type Install struct {
 	//
 	C7nGatewayUrl string
 	ClientOnly    bool
+	// 安装完成后保留 slaver，用于排查问题
+	KeepSlaver bool
+	// 允许使用明文连接不支持 TLS 的旧版本 slaver
+	AllowPlaintextSlaver bool
 
 	// 以下都是初始化到 InstallDefinition 的配置项
 	Prefix          string
@@ -37,6 +45,28 @@ type Install struct {
 	ChartRepository string
 	DatasourceTpl   string
 	ThinMode        bool
+	// 检查域名时创建的 Ingress 使用的 IngressClass
+	IngressClassName string
+	// 域名检查失败时终止安装
+	FatalDomainCheck bool
+	// 执行 release job 的方式：slaver 或者 k8s-job
+	JobRunner string
+	// 使用 k8s-job 执行时的镜像
+	JobImage string
+	// 所有 release 默认的 helm 选项
+	HelmWait    bool
+	HelmAtomic  bool
+	HelmTimeout string
+	// 使用 lock 文件中的 chart 版本安装
+	Locked   bool
+	LockFile string
+	// 离线包的目录或者 tar.gz 文件，安装资源、chart 和版本都从离线包中读取
+	FromPackage string
+
+	executor c7nslaver.Executor
+	// 离线包的 manifest 和解压后的目录
+	pkg    *offline.Manifest
+	pkgDir string
 }
 
 func NewInstall(cfg *C7nConfiguration) *Install {
@@ -86,6 +116,7 @@ func (i *Install) Setup(c *config.C7nConfig) {
 		c.Spec.ChartRepository = i.ChartRepository
 	}
 	log.Debugf("Chart repository is %s", c.GetImageRepository())
+	repository.SetRepositories(c.GetChartRepositories())
 
 	if i.DatasourceTpl != "" {
 		c.Spec.DatasourceTpl = i.DatasourceTpl
@@ -100,6 +131,44 @@ func (i *Install) Setup(c *config.C7nConfig) {
 	if i.ThinMode {
 		c.Spec.ThinMode = true
 	}
+
+	if i.IngressClassName != "" {
+		c.Spec.IngressClassName = i.IngressClassName
+	}
+	log.Debugf("Ingress class is %s", c.GetIngressClassName())
+
+	if i.FatalDomainCheck {
+		c.Spec.FatalDomainCheck = true
+	}
+
+	if i.JobRunner != "" {
+		c.Spec.JobRunner = i.JobRunner
+	}
+	if i.JobImage != "" {
+		c.Spec.JobImage = i.JobImage
+	}
+	log.Debugf("Job runner is %s", c.Spec.JobRunner)
+
+	if i.HelmWait {
+		c.Spec.HelmWait = true
+	}
+	if i.HelmAtomic {
+		c.Spec.HelmAtomic = true
+	}
+	if i.HelmTimeout != "" {
+		c.Spec.HelmTimeout = i.HelmTimeout
+	}
+}
+
+// InitInstance 设置安装的实例，没有指定时使用前缀和命名空间生成默认的实例名称。需要在 MergerConfig 之后调用
+func (i *Install) InitInstance(instDef *resource.InstallDefinition) error {
+	// 安装时由前缀决定实例，不使用命名空间中已有的其他实例
+	if _, err := i.cfg.ResolveInstance(i.Namespace, instDef.Spec.Basic.Prefix, false); err != nil {
+		return err
+	}
+	instDef.SetInstance(i.cfg.Instance, i.Namespace)
+	log.Infof("Installing choerodon instance %s in namespace %s", i.cfg.Instance, i.Namespace)
+	return nil
 }
 
 func (i *Install) Run(instDef *resource.InstallDefinition) (err error) {
@@ -112,22 +181,73 @@ func (i *Install) Run(instDef *resource.InstallDefinition) (err error) {
 	if err = i.CheckNamespace(); err != nil {
 		return err
 	}
+	// chart 仓库的认证信息可以保存在安装命名空间的 secret 中
+	if err = i.loadRepositorySecrets(); err != nil {
+		return err
+	}
 
+	if err = validateJobRunner(instDef); err != nil {
+		return err
+	}
+
+	if i.pkg != nil {
+		if err = i.rewritePackageImages(instDef); err != nil {
+			return err
+		}
+	}
+
+	// 不同前缀的实例使用各自的镜像仓库认证信息
+	for idx := range instDef.Spec.Basic.DockerRegistry {
+		dr := &instDef.Spec.Basic.DockerRegistry[idx]
+		dr.SecretName = instDef.GetReleaseName(dr.SecretName)
+	}
 	i.cfg.CreateImagePullSecret(instDef.Spec.Basic.DockerRegistry)
-	// 初始化 slaver
-	stopCh := make(chan struct{})
-	if _, err = instDef.Spec.Basic.Slaver.InitSalver(i.cfg.KubeClient.GetClientSet(), i.Namespace, stopCh); err != nil {
-		return std_errors.WithMessage(err, "Create Slaver failed")
+	if instDef.UseJobRunner() {
+		// 不部署 slaver，每个 release job 作为 k8s job 执行
+		i.executor = i.newJobRunner(instDef)
+	} else {
+		// 初始化 slaver，安装结束时无论成功与否都先停止端口转发再清理 slaver
+		stopCh := make(chan struct{})
+		defer func() {
+			close(stopCh)
+			i.cleanSlaver(&instDef.Spec.Basic.Slaver)
+		}()
+		// 每次安装都为 slaver 生成新的 token 和证书
+		instDef.Spec.Basic.Slaver.RotateCredentials = true
+		if i.AllowPlaintextSlaver {
+			instDef.Spec.Basic.Slaver.AllowPlaintext = true
+		}
+		if _, err = instDef.Spec.Basic.Slaver.InitSalver(i.cfg.KubeClient.GetClientSet(), i.Namespace, stopCh); err != nil {
+			return std_errors.WithMessage(err, "Create Slaver failed")
+		}
+		i.executor = &instDef.Spec.Basic.Slaver
 	}
-	defer func() {
-		stopCh <- struct{}{}
-	}()
 
 	// 渲染 Release
-	c7nclient.InitC7nLogs(i.cfg.KubeClient.GetClientSet(), i.Namespace)
+	if err = i.cfg.InitState(i.Namespace); err != nil {
+		return err
+	}
+	// 避免多个 c7nctl 同时安装同一个实例
+	unlock, err := c7nclient.LockTasks(c7nclient.LockHolder())
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	if err = instDef.RenderReleases(i.Name, i.cfg.KubeClient, i.Namespace); err != nil {
 		return err
 	}
+	// 安装前确定所有 release 的版本，并保存到 lock 文件中用于重复安装
+	lock, err := i.ResolveVersions(instDef)
+	if err != nil {
+		return err
+	}
+	if !i.ClientOnly && !i.Locked {
+		if err = lock.Save(i.lockFile()); err != nil {
+			log.Warnf("Failed to write lock file %s: %s", i.lockFile(), err)
+		} else {
+			log.Infof("Chart versions are saved to %s, run install with --locked to reuse them", i.lockFile())
+		}
+	}
 	if i.ClientOnly {
 		instDef.PrintRelease(i.Name)
 	}
@@ -142,6 +262,55 @@ func (i *Install) Run(instDef *resource.InstallDefinition) (err error) {
 	return nil
 }
 
+// validateJobRunner 检查执行 release job 的方式。发布的 slaver 镜像不支持 c7n-slaver --job，
+// 使用 k8s-job 时必须指定使用 docker/slaver.Dockerfile 构建的镜像
+func validateJobRunner(instDef *resource.InstallDefinition) error {
+	switch instDef.Spec.Basic.JobRunner {
+	case "", c7nslaver.JobRunnerSlaver:
+	case c7nslaver.JobRunnerK8sJob:
+		if instDef.Spec.Basic.JobImage == "" {
+			return std_errors.Errorf("jobImage (--job-image) is required when the job runner is %s, use an image built from docker/slaver.Dockerfile", c7nslaver.JobRunnerK8sJob)
+		}
+	default:
+		return std_errors.Errorf("unsupported job runner %s, must be %s or %s", instDef.Spec.Basic.JobRunner, c7nslaver.JobRunnerSlaver, c7nslaver.JobRunnerK8sJob)
+	}
+	return nil
+}
+
+// newJobRunner 创建使用 k8s job 执行 release job 的执行器
+func (i *Install) newJobRunner(instDef *resource.InstallDefinition) *c7nslaver.JobRunner {
+	image := instDef.Spec.Basic.JobImage
+	runner := c7nslaver.NewJobRunner(i.cfg.KubeClient.GetClientSet(), i.Namespace, image)
+	runner.ImagePullPolicy = instDef.Spec.Basic.Slaver.ImagePullPolicy
+	for k, v := range instDef.Labels() {
+		runner.Labels[k] = v
+	}
+	// slaver 不存在时也需要初始化，用于检查域名等
+	instDef.Spec.Basic.Slaver.Init(i.cfg.KubeClient.GetClientSet(), i.Namespace)
+	log.Infof("Release jobs will be executed as kubernetes jobs with image %s", image)
+	return runner
+}
+
+// 安装结束后删除 slaver 的所有资源，使用 --keep-slaver 保留 slaver 以便使用 c7nctl slaver 排查问题
+func (i *Install) cleanSlaver(slaver *c7nslaver.Slaver) {
+	cleanSlaver(slaver, i.KeepSlaver || i.ClientOnly)
+}
+
+// loadScript 读取 release 任务中引用的 sql 脚本，支持 URL、本地的安装资源目录以及远程的安装资源
+func (i *Install) loadScript(path string) ([]byte, error) {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return c7nutils.GetRemoteResource(path)
+	}
+	if fi, err := os.Stat(i.ResourcePath); err == nil && fi.IsDir() {
+		return ioutil.ReadFile(filepath.Join(i.ResourcePath, path))
+	}
+	data, err := i.ResourceClient.GetResource(i.Version, path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
 func (i *Install) InstallReleases(inst *resource.InstallDefinition) error {
 	rs := inst.Spec.Release[i.Name]
 	releaseGraph := graph.NewReleaseGraph(rs)
@@ -156,25 +325,19 @@ func (i *Install) InstallReleases(inst *resource.InstallDefinition) error {
 		}
 
 		rvurl := fmt.Sprintf("/%s/%s.yaml", c7nconsts.DefaultHelmValuesPath, rls.Name)
-		rr, err := i.ResourceClient.GetResource(i.Version, rvurl)
+		rr, err := i.loadScript(rvurl)
 		if err != nil {
 			return err
 		}
-		vals, err := inst.RenderHelmValues(rls, rr)
+		// 前面的 release job 执行后才能渲染引用其输出变量的模版
+		if err = inst.RenderJobOutputs(rls); err != nil {
+			return err
+		}
+		vals, err := inst.RenderHelmValues(rls, string(rr))
 		if err != nil {
 			return err
 		}
 
-		if rls.RepoURL == "" {
-			rls.RepoURL = inst.Spec.Basic.ChartRepository
-		}
-		if rls.Version == "" {
-			version, err := c7nutils.GetReleaseTag(rls.RepoURL, rls.Chart, i.Version)
-			if err != nil {
-				return err
-			}
-			rls.Version = version
-		}
 		args := c7nclient.ChartArgs{
 			RepoUrl:     rls.RepoURL,
 			Namespace:   i.Namespace,
@@ -185,6 +348,21 @@ func (i *Install) InstallReleases(inst *resource.InstallDefinition) error {
 		if rls.RepoURL != "" {
 			args.RepoUrl = rls.RepoURL
 		}
+		if err = inst.HelmOptions(rls).Apply(&args); err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("Release %s has invalid helm options", rls.Name))
+		}
+		patches, err := c7nclient.NewPatchRenderer(rls.Name, inst.Spec.Basic.Patches)
+		if err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("Release %s has invalid patches", rls.Name))
+		}
+		if patches != nil {
+			args.PostRenderer = c7nclient.ChainPostRenderers(args.PostRenderer, patches)
+		}
+		if i.pkg != nil {
+			if err = i.usePackage(inst, rls, &args); err != nil {
+				return err
+			}
+		}
 
 		if i.ClientOnly {
 			fmt.Printf("------------- Installingg helm release %s -------------", rls.Name)
@@ -194,14 +372,14 @@ func (i *Install) InstallReleases(inst *resource.InstallDefinition) error {
 			c7nutils.PrettyPrint(vals)
 			continue
 		}
-		if err = i.installRelease(rls, vals, args, &inst.Spec.Basic.Slaver); err != nil {
+		if err = i.installRelease(rls, vals, args, i.executor); err != nil {
 			return std_errors.WithMessage(err, fmt.Sprintf("Release %s install failed", rls.Name))
 		}
 	}
 	return nil
 }
 
-func (i *Install) installRelease(rls *resource.Release, vals map[string]interface{}, args c7nclient.ChartArgs, slaver *c7nslaver.Slaver) error {
+func (i *Install) installRelease(rls *resource.Release, vals map[string]interface{}, args c7nclient.ChartArgs, executor c7nslaver.Executor) error {
 	task, err := c7nclient.GetTask(rls.Name)
 	if err != nil {
 		return err
@@ -210,6 +388,12 @@ func (i *Install) installRelease(rls *resource.Release, vals map[string]interfac
 		log.Infof("Release %s is already installed", rls.Name)
 		return nil
 	}
+	// 完成后更新 task 状态，失败时也需要保存
+	defer func() {
+		if _, err := c7nclient.SaveTask(*task); err != nil {
+			log.Error(err)
+		}
+	}()
 
 	// 等待依赖项安装完成
 	for _, r := range rls.Requirements {
@@ -217,29 +401,35 @@ func (i *Install) installRelease(rls *resource.Release, vals map[string]interfac
 	}
 
 	// 执行前置命令
-	if err := rls.ExecutePreCommands(slaver); err != nil {
+	if err := rls.ExecutePreCommands(executor, i.loadScript); err != nil {
 		task.Status = c7nconsts.FailedStatus
+		task.Reason = err.Error()
 		return std_errors.WithMessage(err, fmt.Sprintf("Release %s execute pre commands failed", rls.Name))
 	}
 
 	log.Infof("installing %s", rls.Name)
 	// TODO 使用统一的 io.writer
-	// 使用 upgrade --install cmd
-	_, err = i.cfg.HelmClient.Upgrade(args, vals, os.Stdout)
+	// 使用 upgrade --install cmd，开启 atomic 时失败的 release 已经被 helm 回滚
+	rel, err := i.cfg.HelmClient.Upgrade(args, vals, os.Stdout)
 	if err != nil {
 		task.Status = c7nconsts.FailedStatus
+		task.Reason = err.Error()
 		return err
 	}
+	// 记录 release 的版本和依赖，用于 c7nctl rollback
+	task.ReleaseName = rel.Name
+	task.Revision = rel.Version
+	task.Application = i.Name
+	task.Requirements = rls.Requirements
 	// 将异步的 afterInstall 改为同步，AfterInstall 其依赖检查依靠前面的
-	if err := rls.ExecuteAfterTasks(slaver); err != nil {
+	if err := rls.ExecuteAfterTasks(executor, i.loadScript); err != nil {
 		task.Status = c7nconsts.FailedStatus
+		task.Reason = err.Error()
 		return std_errors.WithMessage(err, "Execute after task failed")
 	}
 
 	task.Status = c7nconsts.SucceedStatus
+	task.Reason = ""
 	log.Infof("Successfully installed %s", rls.Name)
-
-	// 完成后更新 task 状态
-	defer c7nclient.SaveTask(*task)
-	return err
+	return nil
 }
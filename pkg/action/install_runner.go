@@ -2,10 +2,10 @@ package action
 
 import (
 	"fmt"
-	c7nclient "github.com/choerodon/c7nctl/pkg/client"
 	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
 	"github.com/choerodon/c7nctl/pkg/config"
 	"github.com/choerodon/c7nctl/pkg/resource"
+	log "github.com/sirupsen/logrus"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -26,7 +26,7 @@ func (ir *InstallRunner) InitInstallRunner(c *config.C7nConfig) {
 
 	if c.Spec.ResourcePath == "" {
 		// 默认到 github 上获取资源文件
-		c.Spec.ResourcePath = fmt.Sprintf(c7nconsts.OpenSourceResourceURL+c7nconsts.OpenSourceResourceBasePath, ir.Version)
+		c.Spec.ResourcePath = fmt.Sprintf(c7nconsts.OpenSourceResourceURL+c7nconsts.OpenSourceResourceBasePath, ir.Version, "")
 	}
 	if ir.ResourcePath == "" {
 		ir.ResourcePath = c.Spec.ImageRepository
@@ -34,7 +34,9 @@ func (ir *InstallRunner) InitInstallRunner(c *config.C7nConfig) {
 }
 
 func (ir *InstallRunner) RenderGitlabRunner(id *resource.InstallDefinition, namespace string) {
-	c7nclient.InitC7nLogs(ir.cfg.KubeClient.GetClientSet(), namespace)
+	if err := ir.cfg.InitState(namespace); err != nil {
+		log.Error(err)
+	}
 	/*
 		if err := id.renderRelease(id.Spec.Runner); err != nil {
 			log.Errorf("Release gitlab runner render failed: %+v", err)
@@ -0,0 +1,56 @@
+package action
+
+import (
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	"github.com/choerodon/c7nctl/pkg/resource"
+	"testing"
+)
+
+func TestValidateJobRunner(t *testing.T) {
+	cases := []struct {
+		runner, image string
+		valid         bool
+	}{
+		{"", "", true},
+		{"slaver", "", true},
+		{"k8s-job", "registry.example.com/c7n/c7n-slaver:latest", true},
+		// 发布的 slaver 镜像不支持 --job，必须指定 jobImage
+		{"k8s-job", "", false},
+		{"docker", "", false},
+	}
+	for _, c := range cases {
+		instDef := &resource.InstallDefinition{}
+		instDef.Spec.Basic.JobRunner = c.runner
+		instDef.Spec.Basic.JobImage = c.image
+		if err := validateJobRunner(instDef); (err == nil) != c.valid {
+			t.Errorf("runner %q image %q: want valid %v, got %v", c.runner, c.image, c.valid, err)
+		}
+	}
+}
+
+func TestResolveInstance(t *testing.T) {
+	cases := []struct {
+		instance, prefix, backend string
+		discover                  bool
+		want                      string
+	}{
+		{"", "", "", false, "c7n-system"},
+		{"", "staging", "", false, "staging-c7n-system"},
+		// --instance 优先于前缀
+		{"dev", "staging", "", false, "dev"},
+		// 不能列出实例时使用默认实例
+		{"", "", c7nclient.StateBackendMemory, true, "c7n-system"},
+		{"", "", "", true, "c7n-system"},
+	}
+	for _, c := range cases {
+		cfg := &C7nConfiguration{Instance: c.instance, StateBackend: c.backend}
+		got, err := cfg.ResolveInstance("c7n-system", c.prefix, c.discover)
+		if err != nil || got != c.want || cfg.Instance != c.want {
+			t.Errorf("ResolveInstance(%+v) = %s %v, want %s", c, got, err, c.want)
+		}
+	}
+	cfg := &C7nConfiguration{Instance: "Staging_1"}
+	if _, err := cfg.ResolveInstance("c7n-system", "", true); err == nil {
+		t.Error("want error for invalid instance name")
+	}
+}
@@ -0,0 +1,33 @@
+package action
+
+import (
+	"fmt"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	"github.com/gosuri/uitable"
+	"io"
+)
+
+// List 列出集群中所有的 choerodon 实例
+type List struct {
+	cfg *C7nConfiguration
+}
+
+func NewList(cfg *C7nConfiguration) *List {
+	return &List{
+		cfg: cfg,
+	}
+}
+
+func (l *List) Run(out io.Writer) error {
+	instances, err := c7nclient.ListInstances(l.cfg.KubeClient.GetClientSet(), l.cfg.StateBackend)
+	if err != nil {
+		return err
+	}
+	table := uitable.New()
+	table.AddRow("INSTANCE", "NAMESPACE", "PREFIX", "VERSION", "TASKS", "SUCCEED", "FAILED", "UPDATED")
+	for _, ins := range instances {
+		table.AddRow(ins.Name, ins.Namespace, ins.Prefix, ins.Version, ins.Tasks, ins.Succeed, ins.Failed, ins.Updated.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Fprintln(out, table.String())
+	return nil
+}
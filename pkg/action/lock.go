@@ -0,0 +1,131 @@
+package action
+
+import (
+	"fmt"
+	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/choerodon/c7nctl/pkg/resource"
+	"github.com/gosuri/uitable"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	"time"
+)
+
+func (i *Install) lockFile() string {
+	if i.LockFile == "" {
+		return resource.DefaultLockFile
+	}
+	return i.LockFile
+}
+
+// loadRepositorySecrets 读取 chart 仓库保存在安装命名空间的 secret 中的认证信息
+func (i *Install) loadRepositorySecrets() error {
+	var kubeClient kubernetes.Interface
+	if i.cfg.KubeClient != nil {
+		if cs := i.cfg.KubeClient.GetClientSet(); cs != nil {
+			kubeClient = cs
+		}
+	}
+	return repository.LoadSecrets(kubeClient, i.Namespace)
+}
+
+// ResolveVersions 确定应用中所有 release 的 chart 版本并返回对应的 lock 文件。
+// Locked 时使用 lock 文件中的版本，否则在 chart 仓库中查找满足 versionConstraint 的最高版本
+func (i *Install) ResolveVersions(inst *resource.InstallDefinition) (*resource.LockFile, error) {
+	var locked *resource.LockFile
+	if i.Locked {
+		var err error
+		if locked, err = resource.LoadLockFile(i.lockFile()); err != nil {
+			return nil, err
+		}
+		if locked.Name != i.Name || locked.ChoerodonVersion != i.Version {
+			return nil, std_errors.Errorf("lock file %s is generated for %s %s, not %s %s", i.lockFile(), locked.Name, locked.ChoerodonVersion, i.Name, i.Version)
+		}
+	}
+
+	lock := &resource.LockFile{Name: i.Name, ChoerodonVersion: i.Version, Generated: time.Now()}
+	for _, rls := range inst.Spec.Release[i.Name] {
+		if rls.RepoURL == "" {
+			rls.RepoURL = inst.Spec.Basic.ChartRepository
+		}
+		if rls.RepoURL == "" {
+			rls.RepoURL = c7nconsts.DefaultRepoUrl
+		}
+		constraint := ""
+		if rls.Version == "" {
+			constraint = rls.VersionConstraint
+			if constraint == "" {
+				constraint = repository.DefaultConstraint(i.Version)
+			}
+		}
+
+		switch {
+		case locked != nil:
+			lr := locked.Get(rls.Name)
+			if lr == nil {
+				return nil, std_errors.Errorf("release %s is not in lock file %s, run `c7nctl lock update %s` first", rls.Name, i.lockFile(), i.Name)
+			}
+			if rls.Version != "" && rls.Version != lr.Version {
+				return nil, std_errors.Errorf("release %s requires version %s but %s is locked, run `c7nctl lock update %s` first", rls.Name, rls.Version, lr.Version, i.Name)
+			}
+			if constraint != "" {
+				if _, err := repository.MatchVersion([]string{lr.Version}, constraint); err != nil {
+					return nil, std_errors.Errorf("locked version %s of release %s does not satisfy %s, run `c7nctl lock update %s` first", lr.Version, rls.Name, constraint, i.Name)
+				}
+			}
+			rls.Version = lr.Version
+		case rls.Version == "" && i.pkg != nil:
+			version, err := i.resolvePackageVersion(rls, constraint)
+			if err != nil {
+				return nil, err
+			}
+			rls.Version = version
+		case i.pkg != nil:
+			if _, ok := i.pkg.Chart(rls.Chart, rls.Version); !ok {
+				return nil, std_errors.Errorf("chart %s-%s of release %s is not in the offline package", rls.Chart, rls.Version, rls.Name)
+			}
+		case rls.Version == "":
+			version, err := repository.Find(rls.RepoURL).ResolveVersion(rls.Chart, constraint)
+			if err != nil {
+				return nil, std_errors.WithMessage(err, fmt.Sprintf("Release %s", rls.Name))
+			}
+			rls.Version = version
+		}
+		log.Debugf("Release %s uses chart %s-%s", rls.Name, rls.Chart, rls.Version)
+
+		lock.Releases = append(lock.Releases, resource.LockedRelease{
+			Name:       rls.Name,
+			Chart:      rls.Chart,
+			RepoURL:    rls.RepoURL,
+			Version:    rls.Version,
+			Constraint: constraint,
+		})
+	}
+	return lock, nil
+}
+
+// UpdateLock 重新解析应用中所有 release 的版本并写入 lock 文件
+func (i *Install) UpdateLock(inst *resource.InstallDefinition, out io.Writer) error {
+	if err := i.loadRepositorySecrets(); err != nil {
+		return err
+	}
+	i.Locked = false
+	lock, err := i.ResolveVersions(inst)
+	if err != nil {
+		return err
+	}
+	if err = lock.Save(i.lockFile()); err != nil {
+		return std_errors.WithMessage(err, fmt.Sprintf("Failed to write lock file %s", i.lockFile()))
+	}
+
+	table := uitable.New()
+	table.AddRow("RELEASE", "CHART", "VERSION", "CONSTRAINT")
+	for _, r := range lock.Releases {
+		table.AddRow(r.Name, r.Chart, r.Version, r.Constraint)
+	}
+	fmt.Fprintln(out, table.String())
+	fmt.Fprintf(out, "\nLock file %s is updated\n", i.lockFile())
+	return nil
+}
@@ -0,0 +1,96 @@
+package action
+
+import (
+	"bytes"
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/choerodon/c7nctl/pkg/resource"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveVersions(t *testing.T) {
+	index := `apiVersion: v1
+entries:
+  choerodon-iam:
+  - name: choerodon-iam
+    version: 1.1.0
+  - name: choerodon-iam
+    version: 1.1.2
+  - name: choerodon-iam
+    version: 1.2.0-alpha.1
+  gitlab-ha:
+  - name: gitlab-ha
+    version: 0.2.3
+  - name: gitlab-ha
+    version: 0.3.0
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(index))
+	}))
+	defer server.Close()
+	repository.SetRepositories(nil)
+
+	newInstDef := func() *resource.InstallDefinition {
+		return &resource.InstallDefinition{Spec: resource.Spec{
+			Basic: resource.Basic{ChartRepository: server.URL},
+			Release: map[string][]*resource.Release{"c7n": {
+				{Name: "choerodon-iam", Chart: "choerodon-iam"},
+				{Name: "gitlab", Chart: "gitlab-ha", VersionConstraint: "~0.2"},
+				{Name: "minio", Chart: "minio", Version: "5.0.4"},
+			}},
+		}}
+	}
+	i := NewInstall(&C7nConfiguration{})
+	i.Name = "c7n"
+	i.Version = "1.1"
+	i.LockFile = filepath.Join(t.TempDir(), "c7nctl.lock")
+
+	var out bytes.Buffer
+	if err := i.UpdateLock(newInstDef(), &out); err != nil {
+		t.Fatal(err)
+	}
+	lock, err := resource.LoadLockFile(i.LockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"choerodon-iam": "1.1.2", "gitlab": "0.2.3", "minio": "5.0.4"}
+	for name, version := range expected {
+		if lr := lock.Get(name); lr == nil || lr.Version != version {
+			t.Errorf("expected %s %s in lock file, got %+v", name, version, lr)
+		}
+	}
+	if !strings.Contains(out.String(), "1.1.2") {
+		t.Errorf("unexpected output %s", out.String())
+	}
+
+	// 使用 lock 文件时不查询仓库
+	index = "apiVersion: v1\nentries: {}\n"
+	repository.SetRepositories(nil)
+	i.Locked = true
+	instDef := newInstDef()
+	if _, err = i.ResolveVersions(instDef); err != nil {
+		t.Fatal(err)
+	}
+	if v := instDef.Spec.Release["c7n"][0].Version; v != "1.1.2" {
+		t.Errorf("expected locked version 1.1.2, got %s", v)
+	}
+
+	// lock 文件不满足新的约束时需要更新
+	instDef = newInstDef()
+	instDef.Spec.Release["c7n"][1].VersionConstraint = "~0.3"
+	if _, err = i.ResolveVersions(instDef); err == nil {
+		t.Error("expected an error of out-of-date lock file")
+	}
+	instDef = newInstDef()
+	instDef.Spec.Release["c7n"] = append(instDef.Spec.Release["c7n"], &resource.Release{Name: "redis", Chart: "redis"})
+	if _, err = i.ResolveVersions(instDef); err == nil {
+		t.Error("expected an error of release not in lock file")
+	}
+	i.Version = "1.2"
+	if _, err = i.ResolveVersions(newInstDef()); err == nil {
+		t.Error("expected an error of another choerodon version")
+	}
+}
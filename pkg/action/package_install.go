@@ -0,0 +1,146 @@
+package action
+
+import (
+	"fmt"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	"github.com/choerodon/c7nctl/pkg/image"
+	"github.com/choerodon/c7nctl/pkg/offline"
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/choerodon/c7nctl/pkg/resource"
+	c7nutils "github.com/choerodon/c7nctl/pkg/utils"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// LoadPackage 使用离线包中的安装资源、chart 和 manifest，安装时不再访问外部网络，需要在 Setup 之前调用。
+// 离线包是 tar.gz 文件时先校验并解压到临时目录，返回的 cleanup 删除临时目录
+func (i *Install) LoadPackage() (cleanup func(), err error) {
+	cleanup = func() {}
+	dir := i.FromPackage
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return cleanup, err
+	}
+	if !fi.IsDir() {
+		if _, err = offline.Verify(dir, nil); err != nil {
+			return cleanup, std_errors.WithMessage(err, fmt.Sprintf("Offline package %s is invalid", dir))
+		}
+		if dir, err = ioutil.TempDir("", "c7n-package-"); err != nil {
+			return cleanup, err
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+		if err = offline.Extract(i.FromPackage, dir); err != nil {
+			cleanup()
+			return func() {}, err
+		}
+	}
+	m, err := offline.LoadManifest(dir)
+	if err != nil {
+		cleanup()
+		return func() {}, err
+	}
+	resourcePath := filepath.Join(dir, offline.ResourceDir)
+	if _, err = os.Stat(filepath.Join(resourcePath, c7nutils.InstallConfigPath)); err != nil {
+		cleanup()
+		return func() {}, std_errors.Errorf("offline package %s contains no install definition, create it again with c7nctl package", i.FromPackage)
+	}
+	i.pkg, i.pkgDir = m, dir
+	i.ResourcePath = resourcePath
+	if i.Version == "" {
+		i.Version = m.Version
+	}
+	log.Infof("Installing from offline package %s of choerodon %s", i.FromPackage, m.Version)
+	return cleanup, nil
+}
+
+// InstallDefinitionFile 返回离线包中的安装定义文件，不是从离线包安装时返回空
+func (i *Install) InstallDefinitionFile() string {
+	if i.pkg == nil {
+		return ""
+	}
+	return filepath.Join(i.ResourcePath, c7nutils.InstallConfigPath)
+}
+
+// resolvePackageVersion 在离线包的 manifest 中查找满足 constraint 的 chart 版本。
+// 没有指定版本约束并且离线包中只有一个版本时使用这个版本
+func (i *Install) resolvePackageVersion(rls *resource.Release, constraint string) (string, error) {
+	versions := i.pkg.ChartVersions(rls.Chart)
+	if len(versions) == 0 {
+		return "", std_errors.Errorf("chart %s of release %s is not in the offline package", rls.Chart, rls.Name)
+	}
+	version, err := repository.MatchVersion(versions, constraint)
+	if err != nil && rls.VersionConstraint == "" && len(versions) == 1 {
+		log.Warnf("Chart %s-%s in the offline package does not satisfy %s, use it for release %s", rls.Chart, versions[0], constraint, rls.Name)
+		return versions[0], nil
+	}
+	if err != nil {
+		return "", std_errors.WithMessage(err, fmt.Sprintf("Release %s in the offline package", rls.Name))
+	}
+	return version, nil
+}
+
+// usePackage 使用离线包中的 chart 安装 release，并将镜像替换为 --image-repo 中的镜像
+func (i *Install) usePackage(inst *resource.InstallDefinition, rls *resource.Release, args *c7nclient.ChartArgs) error {
+	chart, ok := i.pkg.Chart(rls.Chart, rls.Version)
+	if !ok {
+		return std_errors.Errorf("chart %s-%s of release %s is not in the offline package", rls.Chart, rls.Version, rls.Name)
+	}
+	// helm 的 LocateChart 直接使用本地的 chart 文件
+	args.ChartName = filepath.Join(i.pkgDir, filepath.FromSlash(chart.File))
+	args.RepoUrl = ""
+
+	if rewrite := i.packageImageRewriter(inst, rls.Name); rewrite != nil {
+		args.PostRenderer = c7nclient.ChainPostRenderers(args.PostRenderer, c7nclient.NewImageRenderer(rls.Name, rewrite))
+	}
+	return nil
+}
+
+// packageImageRewriter 返回将 owner 使用的镜像替换为 --image-repo 中镜像的函数，没有配置镜像仓库时返回 nil
+func (i *Install) packageImageRewriter(inst *resource.InstallDefinition, owner string) c7nclient.ImageRewriter {
+	repo := inst.GetImageRepository()
+	if repo == "" {
+		log.Warnf("No image repository is configured, images of %s are pulled from their original registries", owner)
+		return nil
+	}
+	images := map[string]bool{}
+	for _, img := range i.pkg.Images {
+		images[img.Reference] = true
+	}
+	return func(name string) (string, error) {
+		if !images[name] {
+			log.Warnf("Image %s of %s is not in the offline package", name, owner)
+		}
+		return image.Rewrite(name, repo)
+	}
+}
+
+// rewritePackageImages 替换 slaver、k8s job 和 release job 使用的镜像，这些镜像不经过 helm 渲染
+func (i *Install) rewritePackageImages(inst *resource.InstallDefinition) error {
+	rewrite := i.packageImageRewriter(inst, "slaver and release jobs")
+	if rewrite == nil {
+		return nil
+	}
+	images := []*string{&inst.Spec.Basic.Slaver.Image, &inst.Spec.Basic.JobImage}
+	for _, rls := range inst.Spec.Release[i.Name] {
+		for idx := range rls.PreInstall {
+			images = append(images, &rls.PreInstall[idx].Image)
+		}
+		for idx := range rls.AfterInstall {
+			images = append(images, &rls.AfterInstall[idx].Image)
+		}
+	}
+	for _, img := range images {
+		if *img == "" {
+			continue
+		}
+		rewritten, err := rewrite(*img)
+		if err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("Failed to rewrite image %s", *img))
+		}
+		*img = rewritten
+	}
+	return nil
+}
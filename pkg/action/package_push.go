@@ -0,0 +1,132 @@
+package action
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/config"
+	"github.com/choerodon/c7nctl/pkg/image"
+	"github.com/choerodon/c7nctl/pkg/offline"
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/gosuri/uitable"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PackagePush 将离线包中的 chart 和镜像推送到离线包定义中的目标仓库
+type PackagePush struct {
+	cfg *C7nConfiguration
+
+	// 校验离线包签名的公钥，为空时不校验签名
+	PublicKey ed25519.PublicKey
+	// 解压离线包的目录，为空时使用临时目录并在完成后删除
+	WorkDir     string
+	Concurrency int
+}
+
+func NewPackagePush(cfg *C7nConfiguration) *PackagePush {
+	return &PackagePush{cfg: cfg}
+}
+
+// Run 校验并解压离线包，上传所有的 chart 到 chart.default-target 或者组件的 target，
+// 推送所有的镜像到 image.registry 中的第一个镜像仓库，最后输出安装时使用的 --chart-repo 和 --image-repo
+func (p *PackagePush) Run(archive string, cvm *config.ChoerodonVersion, out io.Writer) error {
+	if cvm.Spec.Chart.DefaultTarget.Url == "" {
+		return std_errors.New("chart.default-target is required to push charts")
+	}
+	if len(cvm.Spec.Image.Registry) == 0 {
+		return std_errors.New("image.registry is required to push images")
+	}
+	m, err := offline.Verify(archive, p.PublicKey)
+	if err != nil {
+		return std_errors.WithMessage(err, fmt.Sprintf("Offline package %s is invalid", archive))
+	}
+	dir := p.WorkDir
+	if dir == "" {
+		if dir, err = ioutil.TempDir("", "c7n-package-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+	log.Infof("Extracting %s to %s", archive, dir)
+	if err = offline.Extract(archive, dir); err != nil {
+		return err
+	}
+
+	table := uitable.New()
+	table.AddRow("TYPE", "NAME", "TARGET", "RESULT")
+	var errs []error
+	for _, c := range m.Charts {
+		target := chartTarget(cvm.Spec.Chart, c.Name)
+		pushed, err := target.Push(filepath.Join(dir, filepath.FromSlash(c.File)))
+		result := pushResult(!pushed, err)
+		if err != nil {
+			errs = append(errs, err)
+			log.Error(err)
+		} else if pushed {
+			log.Infof("Pushed chart %s-%s to %s", c.Name, c.Version, target.URL)
+		}
+		table.AddRow("chart", c.Name+"-"+c.Version, target.URL, result)
+	}
+
+	registry := cvm.Spec.Image.Registry[0]
+	pusher := &image.Pusher{
+		Domain:      registry.Domain,
+		Repository:  registry.Repository,
+		Registry:    registry.Credentials(),
+		Concurrency: p.Concurrency,
+	}
+	sources := make([]image.Source, 0, len(m.Images))
+	for _, i := range m.Images {
+		src := image.Source{Reference: i.Reference, Digest: i.Digest}
+		if i.File != "" {
+			src.File = filepath.Join(dir, filepath.FromSlash(i.File))
+		}
+		sources = append(sources, src)
+	}
+	pushed, err := pusher.Push(filepath.Join(dir, offline.ImageDir), sources)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, i := range pushed {
+		table.AddRow("image", i.Source, i.Target, pushResult(i.Skipped, nil))
+	}
+	fmt.Fprintln(out, table.String())
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+
+	imageRepo := strings.TrimRight(registry.Domain, "/")
+	if repo := strings.Trim(registry.Repository, "/"); repo != "" {
+		imageRepo += "/" + repo
+	}
+	fmt.Fprintf(out, "\nAll charts and images are pushed, install with:\n\n    --chart-repo %s --image-repo %s\n",
+		cvm.Spec.Chart.DefaultTarget.Repository().URL, imageRepo)
+	return nil
+}
+
+// chartTarget 返回组件 name 的目标仓库，组件没有定义 target 时使用 default-target
+func chartTarget(chart config.Chart, name string) repository.Repository {
+	for _, c := range chart.Component {
+		if c.Name == name && c.Target.Url != "" {
+			return c.Target.Repository()
+		}
+	}
+	return chart.DefaultTarget.Repository()
+}
+
+func pushResult(skipped bool, err error) string {
+	switch {
+	case err != nil:
+		return "failed"
+	case skipped:
+		return "skipped"
+	default:
+		return "pushed"
+	}
+}
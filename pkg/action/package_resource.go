@@ -0,0 +1,103 @@
+package action
+
+import (
+	"fmt"
+	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
+	"github.com/choerodon/c7nctl/pkg/resource"
+	c7nutils "github.com/choerodon/c7nctl/pkg/utils"
+	mapset "github.com/deckarep/golang-set/v2"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	yaml_v2 "gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackageResources 将安装定义、values 模版和 sql 脚本保存到离线包中，从离线包安装时不再获取远程的安装资源
+type PackageResources struct {
+	ResourceClient *resource.Client
+	// 本地的安装资源目录，比如 ./manifests，为空时获取 Version 对应的远程安装资源
+	ResourcePath string
+	Version      string
+}
+
+// Save 将安装资源保存到 dest 目录，返回 slaver 和 release job 使用的镜像，这些镜像不在 chart 中
+func (p *PackageResources) Save(dest string) ([]string, error) {
+	data, err := p.load(c7nutils.InstallConfigPath)
+	if err != nil {
+		return nil, std_errors.WithMessage(err, "Failed to get install configuration file")
+	}
+	instDef := &resource.InstallDefinition{}
+	if err = yaml_v2.Unmarshal(data, instDef); err != nil {
+		return nil, err
+	}
+	if err = writeResource(dest, c7nutils.InstallConfigPath, data); err != nil {
+		return nil, err
+	}
+
+	files := mapset.NewSet[string]()
+	images := mapset.NewSet[string]()
+	for _, img := range []string{instDef.Spec.Basic.Slaver.Image, instDef.Spec.Basic.JobImage} {
+		if img != "" {
+			images.Add(img)
+		}
+	}
+	for _, releases := range instDef.Spec.Release {
+		for _, rls := range releases {
+			files.Add(path.Join(c7nconsts.DefaultHelmValuesPath, rls.Name+".yaml"))
+			for _, job := range append(append([]resource.ReleaseJob{}, rls.PreInstall...), rls.AfterInstall...) {
+				if job.Image != "" {
+					images.Add(job.Image)
+				}
+				for _, f := range job.SqlFiles {
+					// URL 中的脚本安装时仍然需要访问网络
+					if strings.HasPrefix(f, "http://") || strings.HasPrefix(f, "https://") {
+						log.Warnf("Sql file %s of %s is not saved to the offline package", f, job.Name)
+						continue
+					}
+					files.Add(strings.TrimPrefix(path.Clean("/"+f), "/"))
+				}
+			}
+		}
+	}
+	names := files.ToSlice()
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := p.load(name)
+		if err != nil {
+			return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to get install resource %s", name))
+		}
+		if err = writeResource(dest, name, data); err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("Saved %d install resources of choerodon %s", len(names)+1, p.Version)
+
+	result := images.ToSlice()
+	sort.Strings(result)
+	return result, nil
+}
+
+// load 读取安装资源 name，name 是相对于 manifests 目录的路径
+func (p *PackageResources) load(name string) ([]byte, error) {
+	if p.ResourcePath != "" {
+		return ioutil.ReadFile(filepath.Join(p.ResourcePath, filepath.FromSlash(name)))
+	}
+	if name == c7nutils.InstallConfigPath {
+		return c7nutils.GetInstallDefinition("", p.Version)
+	}
+	data, err := p.ResourceClient.GetResource(p.Version, "/"+name)
+	return []byte(data), err
+}
+
+func writeResource(dest, name string, data []byte) error {
+	file := filepath.Join(dest, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
@@ -0,0 +1,76 @@
+package action
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPackageResourcesSave(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"install.yml": `version: "1.1"
+spec:
+  basic:
+    jobImage: registry.example.com/c7n/job:1.0
+    slaver:
+      image: registry.example.com/c7n/c7n-slaver:0.1.1
+  release:
+    c7n:
+      - name: choerodon-iam
+        chart: choerodon-iam
+        preinstall:
+          - name: choerodon-iam-predb
+            sqlFiles:
+              - sql/iam.sql
+              - https://example.com/remote.sql
+        afterinstall:
+          - name: choerodon-iam-init
+            image: registry.example.com/c7n/mysql:5.7
+            commands:
+              - echo ok
+`,
+		"values/choerodon-iam.yaml": "env: {}\n",
+		"sql/iam.sql":               "select 1;\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := t.TempDir()
+	res := &PackageResources{ResourcePath: src}
+	images, err := res.Save(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("expected %s in resources: %v", name, err)
+		} else if string(data) != content {
+			t.Errorf("unexpected content of %s: %s", name, data)
+		}
+	}
+	expected := []string{
+		"registry.example.com/c7n/c7n-slaver:0.1.1",
+		"registry.example.com/c7n/job:1.0",
+		"registry.example.com/c7n/mysql:5.7",
+	}
+	if !reflect.DeepEqual(images, expected) {
+		t.Errorf("expected images %v, got %v", expected, images)
+	}
+
+	// 缺少 values 文件时失败
+	os.Remove(filepath.Join(src, "values", "choerodon-iam.yaml"))
+	if _, err = res.Save(t.TempDir()); err == nil {
+		t.Error("expected an error when a values file is missing")
+	}
+}
@@ -0,0 +1,312 @@
+package action
+
+import (
+	"fmt"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
+	"github.com/choerodon/c7nctl/pkg/common/graph"
+	"github.com/choerodon/c7nctl/pkg/resource"
+	"github.com/gosuri/uitable"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/release"
+	"io"
+	"time"
+)
+
+// 回滚应用时 --to 支持的时间格式，使用本地时区
+var rollbackTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// Rollback 回滚单个 release 或者将应用的所有 release 回滚到之前一次成功执行后的版本
+type Rollback struct {
+	cfg *C7nConfiguration
+
+	Namespace string
+	// 回滚应用时的目标，执行记录的 ID 或者时间
+	To string
+	// helm 的选项
+	Wait         bool
+	Timeout      time.Duration
+	DisableHooks bool
+	// 只打印回滚计划
+	DryRun bool
+}
+
+func NewRollback(cfg *C7nConfiguration) *Rollback {
+	return &Rollback{
+		cfg: cfg,
+	}
+}
+
+// Record 执行回滚并保存到执行记录中。--dry-run 时不修改集群，只读取安装记录，不保存执行记录
+func (r *Rollback) Record(run func() error) error {
+	if r.DryRun {
+		if err := r.cfg.InitState(r.Namespace); err != nil {
+			return err
+		}
+		return run()
+	}
+	return r.cfg.RecordRun(r.Namespace, "", run)
+}
+
+// lock 获取安装记录的锁，--dry-run 时不获取锁
+func (r *Rollback) lock() (func(), error) {
+	if r.DryRun {
+		return func() {}, nil
+	}
+	return c7nclient.LockTasks(c7nclient.LockHolder())
+}
+
+// rollbackStep 是回滚应用时一个 release 的回滚计划
+type rollbackStep struct {
+	task    c7nclient.TaskInfo
+	current int
+	target  int
+}
+
+func (r *Rollback) chartArgs(releaseName string) c7nclient.ChartArgs {
+	return c7nclient.ChartArgs{
+		ReleaseName:  releaseName,
+		Namespace:    r.Namespace,
+		Wait:         r.Wait,
+		Timeout:      r.Timeout,
+		DisableHooks: r.DisableHooks,
+	}
+}
+
+// RollbackRelease 将 release 回滚到 revision，revision 为 0 时回滚到上一个版本。name 可以是 helm 中的名称或者安装记录中的名称
+func (r *Rollback) RollbackRelease(name string, revision int, out io.Writer) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	releaseName := name
+	task, err := findReleaseTask(name)
+	if err != nil {
+		return err
+	}
+	if task != nil && task.ReleaseName != "" {
+		releaseName = task.ReleaseName
+	}
+	if r.DryRun {
+		fmt.Fprintf(out, "Release %s would be rolled back to revision %s\n", releaseName, revisionString(revision))
+		return nil
+	}
+	rel, err := r.rollback(releaseName, revision, task)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Rollback was a success! Release %s is at revision %d\n", releaseName, rel.Version)
+	return nil
+}
+
+// RollbackApplication 按照依赖的逆序，将应用的所有 release 回滚到 To 指定的执行记录成功后的版本。
+// To 是时间时使用该时间之前最后一次成功的执行记录
+func (r *Rollback) RollbackApplication(app string, out io.Writer) error {
+	unlock, err := r.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	run, err := findRollbackRun(r.To)
+	if err != nil {
+		return err
+	}
+	log.Infof("Rolling back application %s to run %s finished at %s", app, run.ID, run.EndTime.Format("2006-01-02 15:04:05"))
+
+	tasks, err := c7nclient.ListTasks()
+	if err != nil {
+		return err
+	}
+	var releases []c7nclient.TaskInfo
+	for _, t := range tasks {
+		if t.Type == c7nconsts.StaticReleaseKey && t.Application == app {
+			releases = append(releases, t)
+		}
+	}
+	if len(releases) == 0 {
+		return std_errors.Errorf("no release of application %s is recorded, only releases installed by c7nctl with revisions can be rolled back", app)
+	}
+
+	var steps []rollbackStep
+	for _, t := range reverseDependencyOrder(releases) {
+		history, err := r.cfg.HelmClient.History(t.ReleaseName)
+		if err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("Failed to get the history of release %s", t.ReleaseName))
+		}
+		step := rollbackStep{task: t, target: runRevision(run, t.Name)}
+		if step.target == 0 {
+			// 执行记录中没有 release 的版本时使用执行结束时已经部署的版本
+			if rel := revisionAt(history, run.EndTime); rel != nil {
+				step.target = rel.Version
+			}
+		}
+		if len(history) > 0 {
+			step.current = history[len(history)-1].Version
+		}
+		steps = append(steps, step)
+	}
+
+	table := uitable.New()
+	table.AddRow("RELEASE", "FROM", "TO", "RESULT")
+	defer func() {
+		fmt.Fprintln(out, table.String())
+	}()
+	for _, s := range steps {
+		switch {
+		case s.target == 0:
+			log.Warnf("Release %s was not installed at run %s, skip it", s.task.ReleaseName, run.ID)
+			table.AddRow(s.task.ReleaseName, s.current, "-", "skipped")
+			continue
+		case s.target == s.current:
+			table.AddRow(s.task.ReleaseName, s.current, s.target, "unchanged")
+			continue
+		case r.DryRun:
+			table.AddRow(s.task.ReleaseName, s.current, s.target, "pending")
+			continue
+		}
+		log.Infof("Rolling back release %s from revision %d to %d", s.task.ReleaseName, s.current, s.target)
+		task := s.task
+		if _, err := r.rollback(s.task.ReleaseName, s.target, &task); err != nil {
+			table.AddRow(s.task.ReleaseName, s.current, s.target, "failed")
+			return err
+		}
+		table.AddRow(s.task.ReleaseName, s.current, s.target, "rolled back")
+	}
+	return nil
+}
+
+// rollback 回滚 release 并更新其安装记录，task 为 nil 时不是 c7nctl 安装的 release
+func (r *Rollback) rollback(releaseName string, revision int, task *c7nclient.TaskInfo) (*release.Release, error) {
+	rel, err := r.cfg.HelmClient.Rollback(r.chartArgs(releaseName), revision)
+	if task != nil {
+		if _, uerr := c7nclient.UpdateTask(task.Name, func(t *c7nclient.TaskInfo) error {
+			if err != nil {
+				t.Status = c7nconsts.FailedStatus
+				t.Reason = err.Error()
+				return nil
+			}
+			t.Status = c7nconsts.SucceedStatus
+			t.Reason = ""
+			t.ReleaseName = rel.Name
+			t.Revision = rel.Version
+			if rel.Chart != nil && rel.Chart.Metadata != nil {
+				t.Version = rel.Chart.Metadata.Version
+			}
+			return nil
+		}); uerr != nil {
+			log.Errorf("Failed to update task %s: %s", task.Name, uerr)
+		}
+	}
+	if err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("Release %s rollback failed", releaseName))
+	}
+	return rel, nil
+}
+
+// findReleaseTask 返回 helm 中的名称或者安装记录中的名称为 name 的 release 的安装记录，不存在时返回 nil
+func findReleaseTask(name string) (*c7nclient.TaskInfo, error) {
+	tasks, err := c7nclient.ListTasks()
+	if err != nil {
+		return nil, err
+	}
+	for idx, t := range tasks {
+		if t.Type == c7nconsts.StaticReleaseKey && t.ReleaseName == name {
+			return &tasks[idx], nil
+		}
+	}
+	for idx, t := range tasks {
+		if t.Type == c7nconsts.StaticReleaseKey && t.Name == name {
+			return &tasks[idx], nil
+		}
+	}
+	return nil, nil
+}
+
+// findRollbackRun 返回 to 对应的成功的执行记录，to 可以是执行记录的 ID 或者时间
+func findRollbackRun(to string) (*c7nclient.RunRecord, error) {
+	if to == "" {
+		return nil, std_errors.New("the run id or time to roll back to is required")
+	}
+	if t, ok := parseRollbackTime(to); ok {
+		runs, err := c7nclient.ListRuns()
+		if err != nil {
+			return nil, err
+		}
+		// 最近的在前
+		for idx, run := range runs {
+			if run.Status == c7nconsts.SucceedStatus && !run.EndTime.After(t) {
+				return &runs[idx], nil
+			}
+		}
+		return nil, std_errors.Errorf("no successful run before %s", to)
+	}
+	run, err := c7nclient.GetRun(to)
+	if err != nil {
+		return nil, err
+	}
+	if run.Status != c7nconsts.SucceedStatus {
+		return nil, std_errors.Errorf("run %s is %s, only successful runs can be rolled back to", run.ID, run.Status)
+	}
+	return run, nil
+}
+
+func parseRollbackTime(s string) (time.Time, bool) {
+	for _, layout := range rollbackTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// runRevision 返回执行记录中 release 的版本，没有记录时返回 0
+func runRevision(run *c7nclient.RunRecord, name string) int {
+	for _, t := range run.Tasks {
+		if t.Name == name && t.Status == c7nconsts.SucceedStatus {
+			return t.Revision
+		}
+	}
+	return 0
+}
+
+// revisionAt 返回 t 时已经成功部署的最后一个版本，history 按照 revision 升序排列
+func revisionAt(history []*release.Release, t time.Time) *release.Release {
+	var found *release.Release
+	for _, rel := range history {
+		if rel.Info == nil || rel.Info.LastDeployed.Time.After(t) {
+			continue
+		}
+		switch rel.Info.Status {
+		case release.StatusDeployed, release.StatusSuperseded:
+			found = rel
+		}
+	}
+	return found
+}
+
+// reverseDependencyOrder 返回按照依赖的逆序排列的 release，被依赖的 release 在后
+func reverseDependencyOrder(tasks []c7nclient.TaskInfo) []c7nclient.TaskInfo {
+	byName := map[string]c7nclient.TaskInfo{}
+	var rls []*resource.Release
+	for _, t := range tasks {
+		byName[t.Name] = t
+		rls = append(rls, &resource.Release{Name: t.Name, Requirements: t.Requirements})
+	}
+	q := graph.NewReleaseGraph(rls).TopoSortByKahn()
+	var ordered []c7nclient.TaskInfo
+	for !q.IsEmpty() {
+		ordered = append([]c7nclient.TaskInfo{byName[q.Dequeue().Name]}, ordered...)
+	}
+	return ordered
+}
+
+func revisionString(revision int) string {
+	if revision == 0 {
+		return "previous"
+	}
+	return fmt.Sprint(revision)
+}
@@ -0,0 +1,135 @@
+package action
+
+import (
+	"bytes"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
+	std_errors "github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/release"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRevisionAt(t *testing.T) {
+	base := time.Date(2021, 4, 1, 10, 0, 0, 0, time.Local)
+	rel := func(version int, minutes int, status release.Status) *release.Release {
+		return &release.Release{Version: version, Info: &release.Info{LastDeployed: helmtime.Time{Time: base.Add(time.Duration(minutes) * time.Minute)}, Status: status}}
+	}
+	history := []*release.Release{
+		rel(1, 0, release.StatusSuperseded),
+		rel(2, 10, release.StatusFailed),
+		rel(3, 20, release.StatusSuperseded),
+		rel(4, 30, release.StatusDeployed),
+	}
+	cases := []struct {
+		minutes  int
+		expected int
+	}{{-1, 0}, {5, 1}, {15, 1}, {25, 3}, {60, 4}}
+	for _, c := range cases {
+		found := revisionAt(history, base.Add(time.Duration(c.minutes)*time.Minute))
+		revision := 0
+		if found != nil {
+			revision = found.Version
+		}
+		if revision != c.expected {
+			t.Errorf("%d minutes: expected revision %d, got %d", c.minutes, c.expected, revision)
+		}
+	}
+}
+
+func TestReverseDependencyOrder(t *testing.T) {
+	tasks := []c7nclient.TaskInfo{
+		{Name: "choerodon-iam", Requirements: []string{"choerodon-register", "c7n-mysql"}},
+		{Name: "c7n-mysql"},
+		{Name: "choerodon-register", Requirements: []string{"c7n-mysql"}},
+		{Name: "choerodon-gateway", Requirements: []string{"choerodon-iam"}},
+	}
+	index := map[string]int{}
+	for idx, task := range reverseDependencyOrder(tasks) {
+		index[task.Name] = idx
+	}
+	if len(index) != len(tasks) {
+		t.Fatalf("expected %d releases, got %v", len(tasks), index)
+	}
+	for _, task := range tasks {
+		for _, r := range task.Requirements {
+			if index[task.Name] > index[r] {
+				t.Errorf("%s should be rolled back before its requirement %s", task.Name, r)
+			}
+		}
+	}
+}
+
+func TestFindRollbackRun(t *testing.T) {
+	if err := c7nclient.InitStateStore(c7nclient.StateOptions{Backend: c7nclient.StateBackendMemory, Namespace: "c7n-system"}); err != nil {
+		t.Fatal(err)
+	}
+	succeed := c7nclient.StartRun(c7nclient.RunRecord{Namespace: "c7n-system"})
+	c7nclient.SaveTask(c7nclient.TaskInfo{Name: "choerodon-iam", Type: c7nconsts.StaticReleaseKey, Status: c7nconsts.SucceedStatus, Revision: 3})
+	if err := c7nclient.FinishRun(succeed, nil); err != nil {
+		t.Fatal(err)
+	}
+	afterSucceed := time.Now()
+	time.Sleep(time.Second)
+	failed := c7nclient.StartRun(c7nclient.RunRecord{Namespace: "c7n-system"})
+	if err := c7nclient.FinishRun(failed, std_errors.New("helm failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	run, err := findRollbackRun(succeed.ID)
+	if err != nil || run.ID != succeed.ID {
+		t.Fatalf("expected run %s, got %v %v", succeed.ID, run, err)
+	}
+	if revision := runRevision(run, "choerodon-iam"); revision != 3 {
+		t.Errorf("expected revision 3, got %d", revision)
+	}
+	if _, err = findRollbackRun(failed.ID); err == nil {
+		t.Error("expected an error of failed run")
+	}
+	if run, err = findRollbackRun(time.Now().Format(time.RFC3339)); err != nil || run.ID != succeed.ID {
+		t.Errorf("expected run %s before now, got %v %v", succeed.ID, run, err)
+	}
+	if run, err = findRollbackRun(afterSucceed.Add(time.Second).Format("2006-01-02 15:04:05")); err != nil || run.ID != succeed.ID {
+		t.Errorf("expected run %s, got %v %v", succeed.ID, run, err)
+	}
+	if _, err = findRollbackRun("2000-01-01"); err == nil {
+		t.Error("expected an error when no run is before the time")
+	}
+}
+
+func TestRollbackDryRun(t *testing.T) {
+	cfg := &C7nConfiguration{
+		KubeClient:   c7nclient.NewK8sClient(nil, "c7n-system"),
+		StateBackend: c7nclient.StateBackendFile,
+		StateFile:    filepath.Join(t.TempDir(), "state.yaml"),
+	}
+	if err := cfg.InitState("c7n-system"); err != nil {
+		t.Fatal(err)
+	}
+	// 其他 c7nctl 持有锁时也可以模拟回滚
+	unlock, err := c7nclient.LockTasks("bob@host/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	r := NewRollback(cfg)
+	r.Namespace = "c7n-system"
+	r.DryRun = true
+	var out bytes.Buffer
+	if err = r.Record(func() error {
+		return r.RollbackRelease("choerodon-iam", 3, &out)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "choerodon-iam would be rolled back to revision 3") {
+		t.Errorf("unexpected output %s", out.String())
+	}
+	// 模拟回滚不保存执行记录
+	if runs, err := c7nclient.ListRuns(); err != nil || len(runs) != 0 {
+		t.Errorf("want no runs, got %+v %v", runs, err)
+	}
+}
@@ -0,0 +1,186 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	c7nslaver "github.com/choerodon/c7nctl/pkg/slaver"
+	"github.com/gosuri/uitable"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// Slaver 用于排查集群内部的网络，数据库连接等问题
+type Slaver struct {
+	cfg *C7nConfiguration
+
+	Namespace string
+	// slaver 的定义来自 install.yml 中的 spec.basic.slaver
+	Slaver *c7nslaver.Slaver
+
+	// sql 子命令
+	Infra    string
+	Database string
+	SqlType  string
+
+	// request 子命令
+	Method string
+	Body   string
+	Header []string
+}
+
+func NewSlaver(cfg *C7nConfiguration) *Slaver {
+	return &Slaver{
+		cfg: cfg,
+	}
+}
+
+func (s *Slaver) init() {
+	s.Slaver.Init(s.cfg.KubeClient.GetClientSet(), s.Namespace)
+}
+
+// connect 部署 slaver（如果不存在）并转发端口，使用完后需要关闭 stopCh
+func (s *Slaver) connect(stopCh <-chan struct{}) error {
+	if _, err := s.Slaver.InitSalver(s.cfg.KubeClient.GetClientSet(), s.Namespace, stopCh); err != nil {
+		return std_errors.WithMessage(err, "Create Slaver failed")
+	}
+	return nil
+}
+
+func (s *Slaver) Status(out io.Writer) error {
+	s.init()
+	ctx := context.Background()
+	client := s.Slaver.Client
+
+	ds, err := client.AppsV1().DaemonSets(s.Namespace).Get(ctx, s.Slaver.Name, meta_v1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			fmt.Fprintf(out, "Slaver %s is not deployed in namespace %s\n", s.Slaver.Name, s.Namespace)
+			return nil
+		}
+		return err
+	}
+	fmt.Fprintf(out, "DaemonSet: %s desired: %d ready: %d image: %s\n", ds.Name,
+		ds.Status.DesiredNumberScheduled, ds.Status.NumberReady, ds.Spec.Template.Spec.Containers[0].Image)
+
+	if _, err = client.CoreV1().Services(s.Namespace).Get(ctx, s.Slaver.Name, meta_v1.GetOptions{}); err == nil {
+		fmt.Fprintf(out, "Service: %s\n", s.Slaver.Name)
+	} else if !k8serrors.IsNotFound(err) {
+		return err
+	}
+	hosts, err := s.Slaver.GetIngressHosts()
+	if err != nil {
+		return err
+	}
+	if hosts != nil {
+		fmt.Fprintf(out, "Ingress: %schecker hosts: %s\n", s.Slaver.Name, strings.Join(hosts, ","))
+	}
+
+	pods, err := s.Slaver.GetPods()
+	if err != nil {
+		return err
+	}
+	table := uitable.New()
+	table.MaxColWidth = 60
+	table.AddRow("POD", "NODE", "IP", "STATUS")
+	for _, po := range pods.Items {
+		table.AddRow(po.Name, po.Spec.NodeName, po.Status.PodIP, po.Status.Phase)
+	}
+	fmt.Fprintln(out, table.String())
+	return nil
+}
+
+func (s *Slaver) Deploy() error {
+	s.init()
+	if _, err := s.Slaver.CheckInstall(); err != nil {
+		return std_errors.WithMessage(err, "Create Slaver failed")
+	}
+	if _, err := s.Slaver.InstallService(); err != nil {
+		return std_errors.WithMessage(err, "Create Slaver service failed")
+	}
+	log.Infof("Slaver %s is deployed in namespace %s", s.Slaver.Name, s.Namespace)
+	return nil
+}
+
+func (s *Slaver) Exec(command string, out io.Writer) error {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := s.connect(stopCh); err != nil {
+		return err
+	}
+
+	output, err := s.Slaver.ExecuteRemoteCommandOutput([]string{command})
+	for _, o := range output {
+		fmt.Fprintln(out, o)
+	}
+	return err
+}
+
+func (s *Slaver) Sql(sqlList []string) error {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := s.connect(stopCh); err != nil {
+		return err
+	}
+
+	// 数据库的连接信息保存在安装记录中
+	if err := s.cfg.InitState(s.Namespace); err != nil {
+		return err
+	}
+	infra, err := c7nclient.GetTask(s.Infra)
+	if err != nil {
+		return err
+	}
+	if err = s.Slaver.ExecuteRemoteSql(sqlList, &infra.Resource, s.Database, s.SqlType); err != nil {
+		return err
+	}
+	log.Infof("Successfully executed %d sql on %s", len(sqlList), s.Infra)
+	return nil
+}
+
+func (s *Slaver) Request(url string, out io.Writer) error {
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := s.connect(stopCh); err != nil {
+		return err
+	}
+
+	header := make(map[string][]string)
+	for _, h := range s.Header {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			return std_errors.Errorf("header %s must be in the format name:value", h)
+		}
+		name := strings.TrimSpace(kv[0])
+		header[name] = append(header[name], strings.TrimSpace(kv[1]))
+	}
+	f := c7nslaver.Forward{
+		Url:    url,
+		Body:   s.Body,
+		Method: s.Method,
+		Header: header,
+	}
+	body, err := s.Slaver.ExecuteRemoteRequest(f)
+	fmt.Fprintln(out, body)
+	return err
+}
+
+// cleanSlaver 删除 slaver 的所有资源，keep 为 true 时保留
+func cleanSlaver(slaver *c7nslaver.Slaver, keep bool) {
+	if keep {
+		log.Infof("Keep slaver %s, run `c7nctl slaver cleanup` to remove it", slaver.Name)
+		return
+	}
+	if err := slaver.Uninstall(); err != nil {
+		log.Errorf("Clean up slaver %s failed: %s", slaver.Name, err)
+	}
+}
+
+func (s *Slaver) Cleanup() error {
+	s.init()
+	return s.Slaver.Uninstall()
+}
@@ -0,0 +1,358 @@
+package action
+
+import (
+	"fmt"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
+	c7nutils "github.com/choerodon/c7nctl/pkg/utils"
+	"github.com/ghodss/yaml"
+	"github.com/gosuri/uitable"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// TaskStatuses 是 task 可以设置的状态
+var TaskStatuses = []string{
+	c7nconsts.UninitializedStatus,
+	c7nconsts.RenderedStatus,
+	c7nconsts.InstalledStatus,
+	c7nconsts.CreatedStatus,
+	c7nconsts.SucceedStatus,
+	c7nconsts.FailedStatus,
+}
+
+// TaskTypes 是 task 的类型
+var TaskTypes = []string{
+	c7nconsts.StaticReleaseKey,
+	c7nconsts.StaticTaskKey,
+	c7nconsts.StaticPersistentKey,
+}
+
+// State 管理保存在 StateStore 中的安装记录
+type State struct {
+	cfg *C7nConfiguration
+
+	Namespace string
+	// 只操作该类型的 task
+	Type string
+	// 修改前不需要确认
+	Yes bool
+
+	// set-status 子命令
+	Reason string
+	// reset 子命令同时清除已经执行的 sql 脚本，重新安装时会再次执行
+	ResetMigrations bool
+	// import 子命令覆盖已经存在的 task
+	Overwrite bool
+
+	// migrate 子命令
+	From     string
+	FromFile string
+	To       string
+	ToFile   string
+
+	confirm func(tip string) (bool, error)
+}
+
+func NewState(cfg *C7nConfiguration) *State {
+	return &State{
+		cfg:     cfg,
+		confirm: c7nutils.Confirm,
+	}
+}
+
+func (s *State) store(backend, file string) (c7nclient.StateStore, error) {
+	return c7nclient.NewStateStore(c7nclient.StateOptions{
+		Backend:   backend,
+		Client:    s.cfg.KubeClient.GetClientSet(),
+		File:      file,
+		Namespace: s.Namespace,
+	})
+}
+
+// Migrate 将安装记录从 s.From 复制到 s.To，不会删除原来的记录
+func (s *State) Migrate(out io.Writer) error {
+	if s.From == s.To && s.FromFile == s.ToFile {
+		return std_errors.New("the source and the target of the migration are the same")
+	}
+	from, err := s.store(s.From, s.FromFile)
+	if err != nil {
+		return err
+	}
+	to, err := s.store(s.To, s.ToFile)
+	if err != nil {
+		return err
+	}
+	// 从旧版本的 c7n-logs 迁移时先将其拆分
+	if s.From == "" || s.From == c7nclient.StateBackendConfigMap {
+		if err = c7nclient.InitC7nLogs(s.cfg.KubeClient.GetClientSet(), s.Namespace); err != nil {
+			return err
+		}
+	}
+	unlock, err := from.Lock(c7nclient.LockHolder())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	count, err := c7nclient.MigrateState(from, to)
+	if err != nil {
+		return std_errors.WithMessage(err, "Failed to migrate the state")
+	}
+	log.Debugf("Migrated state of namespace %s from %s to %s", s.Namespace, s.From, s.To)
+	fmt.Fprintf(out, "Migrated %d tasks from %s to %s, use --state-backend=%s from now on\n", count, s.From, s.To, s.To)
+	return nil
+}
+
+func (s *State) init() error {
+	if s.Type != "" && !containsString(TaskTypes, s.Type) {
+		return std_errors.Errorf("unsupported task type %s, must be one of %s", s.Type, strings.Join(TaskTypes, ", "))
+	}
+	return s.cfg.InitState(s.Namespace)
+}
+
+// mutate 确认后加锁修改 task，并保存执行记录
+func (s *State) mutate(tip string, change func() error) error {
+	if !s.Yes {
+		ok, err := s.confirm(tip)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return std_errors.New("canceled")
+		}
+	}
+	return s.cfg.RecordRun(s.Namespace, "", func() error {
+		unlock, err := c7nclient.LockTasks(c7nclient.LockHolder())
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return change()
+	})
+}
+
+// getTask 返回 task，指定了类型时检查 task 的类型
+func (s *State) getTask(name string) (*c7nclient.TaskInfo, error) {
+	task, err := c7nclient.GetTask(name)
+	if err != nil {
+		return nil, err
+	}
+	if s.Type != "" && task.Type != s.Type {
+		return nil, std_errors.Errorf("task %s is a %s, not a %s", name, task.Type, s.Type)
+	}
+	return task, nil
+}
+
+func (s *State) tasks() ([]c7nclient.TaskInfo, error) {
+	tasks, err := c7nclient.ListTasks()
+	if err != nil {
+		return nil, err
+	}
+	if s.Type == "" {
+		return tasks, nil
+	}
+	var filtered []c7nclient.TaskInfo
+	for _, t := range tasks {
+		if t.Type == s.Type {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered, nil
+}
+
+func (s *State) List(out io.Writer) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	tasks, err := s.tasks()
+	if err != nil {
+		return err
+	}
+	table := uitable.New()
+	table.MaxColWidth = 60
+	table.AddRow("NAME", "TYPE", "STATUS", "VERSION", "DATE", "REASON")
+	for _, t := range tasks {
+		table.AddRow(t.Name, t.Type, t.Status, t.Version, t.Date.Format("2006-01-02 15:04:05"), t.Reason)
+	}
+	fmt.Fprintln(out, table.String())
+	return nil
+}
+
+func (s *State) Get(name string, out io.Writer) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	task, err := s.getTask(name)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(task)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+func (s *State) SetStatus(name, status string) error {
+	if !containsString(TaskStatuses, status) {
+		return std_errors.Errorf("unsupported status %s, must be one of %s", status, strings.Join(TaskStatuses, ", "))
+	}
+	if err := s.init(); err != nil {
+		return err
+	}
+	task, err := s.getTask(name)
+	if err != nil {
+		return err
+	}
+	return s.mutate(fmt.Sprintf("Set the status of %s %s from %s to %s?", task.Type, name, task.Status, status), func() error {
+		_, err := c7nclient.UpdateTask(name, func(task *c7nclient.TaskInfo) error {
+			task.Status = status
+			task.Reason = s.Reason
+			return nil
+		})
+		if err == nil {
+			log.Infof("Set the status of %s to %s", name, status)
+		}
+		return err
+	})
+}
+
+// Reset 将 task 设置为未初始化，重新安装时会再次执行
+func (s *State) Reset(names []string) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := s.getTask(name); err != nil {
+			return err
+		}
+	}
+	tip := fmt.Sprintf("Reset %s, they will be executed again in the next installation?", strings.Join(names, ", "))
+	if s.ResetMigrations {
+		tip = fmt.Sprintf("Reset %s and forget the executed sql scripts, the scripts will be executed again in the next installation?", strings.Join(names, ", "))
+	}
+	return s.mutate(tip, func() error {
+		for _, name := range names {
+			if _, err := c7nclient.UpdateTask(name, func(task *c7nclient.TaskInfo) error {
+				task.Status = c7nconsts.UninitializedStatus
+				task.Reason = ""
+				if s.ResetMigrations {
+					task.Migrations = nil
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			log.Infof("Reset %s", name)
+		}
+		return nil
+	})
+}
+
+func (s *State) Delete(names []string) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := s.getTask(name); err != nil {
+			return err
+		}
+	}
+	return s.mutate(fmt.Sprintf("Delete %s from the install state? The releases in the cluster are kept", strings.Join(names, ", ")), func() error {
+		for _, name := range names {
+			if err := c7nclient.DeleteTask(name); err != nil {
+				return err
+			}
+			log.Infof("Deleted %s", name)
+		}
+		return nil
+	})
+}
+
+// Export 以 yaml 格式输出所有的 task，格式与旧版本 c7n-logs 中保存的一致
+func (s *State) Export(out io.Writer) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	tasks, err := s.tasks()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+// Import 导入 Export 输出的 task，默认跳过已经存在的 task
+func (s *State) Import(in io.Reader) error {
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	var tasks []c7nclient.TaskInfo
+	if err = yaml.Unmarshal(data, &tasks); err != nil {
+		return std_errors.WithMessage(err, "Failed to decode the tasks")
+	}
+	for _, t := range tasks {
+		if t.Name == "" {
+			return std_errors.New("task name can't be empty")
+		}
+		if t.Status != "" && !containsString(TaskStatuses, t.Status) {
+			return std_errors.Errorf("task %s has unsupported status %s", t.Name, t.Status)
+		}
+	}
+	if err = s.init(); err != nil {
+		return err
+	}
+	if s.Type != "" {
+		var filtered []c7nclient.TaskInfo
+		for _, t := range tasks {
+			if t.Type == s.Type {
+				filtered = append(filtered, t)
+			}
+		}
+		tasks = filtered
+	}
+	if len(tasks) == 0 {
+		log.Info("No task to import")
+		return nil
+	}
+
+	tip := fmt.Sprintf("Import %d tasks to namespace %s, existing tasks are skipped?", len(tasks), s.Namespace)
+	if s.Overwrite {
+		tip = fmt.Sprintf("Import %d tasks to namespace %s and overwrite existing tasks?", len(tasks), s.Namespace)
+	}
+	return s.mutate(tip, func() error {
+		imported := 0
+		for _, t := range tasks {
+			t := t
+			// 冲突时 mutate 会被再次调用
+			var skipped bool
+			if _, err := c7nclient.UpdateTask(t.Name, func(task *c7nclient.TaskInfo) error {
+				// 不存在的 task 只有名称
+				skipped = task.Type != "" && !s.Overwrite
+				if !skipped {
+					*task = t
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if skipped {
+				log.Infof("Skip existing task %s", t.Name)
+			} else {
+				imported++
+			}
+		}
+		log.Infof("Imported %d tasks", imported)
+		return nil
+	})
+}
@@ -0,0 +1,102 @@
+package action
+
+import (
+	"bytes"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	cfg := &C7nConfiguration{
+		KubeClient:   c7nclient.NewK8sClient(nil, "c7n-system"),
+		StateBackend: c7nclient.StateBackendFile,
+		StateFile:    filepath.Join(t.TempDir(), "state.yaml"),
+	}
+	s := NewState(cfg)
+	s.Namespace = "c7n-system"
+	s.Yes = true
+
+	tasks := `- Name: gitlab
+  Type: release
+  Status: succeed
+- Name: predb
+  Type: task
+  Status: failed
+  Migrations:
+  - Script: init.sql
+`
+	if err := s.Import(strings.NewReader(tasks)); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := s.List(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "gitlab") || !strings.Contains(out.String(), "predb") {
+		t.Errorf("unexpected list %s", out.String())
+	}
+
+	if err := s.SetStatus("gitlab", "deleted"); err == nil {
+		t.Error("want error for unsupported status")
+	}
+	s.Reason = "deleted by hand"
+	if err := s.SetStatus("gitlab", c7nconsts.FailedStatus); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := c7nclient.GetTask("gitlab"); task.Status != c7nconsts.FailedStatus || task.Reason != "deleted by hand" {
+		t.Errorf("unexpected task %+v", task)
+	}
+
+	// 指定类型时只操作该类型的 task
+	s.Type = c7nconsts.StaticReleaseKey
+	if err := s.Reset([]string{"predb"}); err == nil {
+		t.Error("want error for task of another type")
+	}
+	s.Type = ""
+	s.ResetMigrations = true
+	if err := s.Reset([]string{"predb"}); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := c7nclient.GetTask("predb"); task.Status != c7nconsts.UninitializedStatus || len(task.Migrations) != 0 {
+		t.Errorf("unexpected task %+v", task)
+	}
+
+	out.Reset()
+	if err := s.Export(&out); err != nil {
+		t.Fatal(err)
+	}
+	exported := out.String()
+
+	// 取消确认时不修改
+	s.Yes = false
+	s.confirm = func(tip string) (bool, error) { return false, nil }
+	if err := s.Delete([]string{"gitlab"}); err == nil {
+		t.Error("want error when canceled")
+	}
+	s.confirm = func(tip string) (bool, error) { return true, nil }
+	if err := s.Delete([]string{"gitlab", "predb"}); err != nil {
+		t.Fatal(err)
+	}
+	if tasks, _ := c7nclient.ListTasks(); len(tasks) != 0 {
+		t.Errorf("want no tasks, got %+v", tasks)
+	}
+
+	if err := s.Import(strings.NewReader(exported)); err != nil {
+		t.Fatal(err)
+	}
+	if task, err := c7nclient.GetTask("gitlab"); err != nil || task.Reason != "deleted by hand" {
+		t.Errorf("unexpected task %+v %v", task, err)
+	}
+	if err := s.Import(strings.NewReader("- Name: bad\n  Status: unknown\n")); err == nil {
+		t.Error("want error for unsupported status")
+	}
+
+	// 修改 task 的操作会保存执行记录
+	if runs, _ := c7nclient.ListRuns(); len(runs) != 5 {
+		t.Errorf("want 5 runs, got %d", len(runs))
+	}
+}
@@ -13,12 +13,19 @@ type EnvSettings struct {
 	Debug      bool
 	SkipInput  bool
 	Timeout    int
+	// 保存安装记录的方式：configmap, secret, file, memory
+	StateBackend string
+	StateFile    string
+	// 同一个集群中有多个 choerodon 时使用的实例名称
+	Instance string
 }
 
 func New() *EnvSettings {
 	return &EnvSettings{
 		// TODO complete env default setting
-		Namespace: os.Getenv("C7N_NAMESPACE"),
+		Namespace:    os.Getenv("C7N_NAMESPACE"),
+		StateBackend: os.Getenv("C7N_STATE_BACKEND"),
+		Instance:     os.Getenv("C7N_INSTANCE"),
 	}
 }
 
@@ -33,6 +40,9 @@ func (s *EnvSettings) AddFlags(fs *pflag.FlagSet) {
 	fs.BoolVar(&s.Debug, "debug", false, "enable verbose output")
 	fs.BoolVar(&s.SkipInput, "skip-input", false, "skip up unnecessary input")
 	fs.IntVar(&s.Timeout, "timeout", 0, "the number of seconds the Operation has time out")
+	fs.StringVar(&s.StateBackend, "state-backend", s.StateBackend, "where the install state is saved: configmap, secret, file or memory, defaults to configmap")
+	fs.StringVar(&s.Instance, "instance", s.Instance, "the name of the choerodon instance, defaults to <prefix>-<namespace> or <namespace> without prefix on install, and to the only instance in the namespace for other commands")
+	fs.StringVar(&s.StateFile, "state-file", "", "the state file when --state-backend=file, defaults to ~/.c7n/state/<instance>.yaml")
 }
 
 func homeDir() string {
@@ -2,6 +2,8 @@ package client
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/choerodon/c7nctl/pkg/common/consts"
 	c7nerrors "github.com/choerodon/c7nctl/pkg/common/errors"
@@ -13,16 +15,39 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 )
 
+const (
+	// 保存 task 的 configMap 的 key
+	taskDataKey = "task"
+	// task 的类型保存在 label 中，用于列出所有的 task
+	TaskTypeLabel = "choerodon.io/c7n-logs"
+	// task 的名称可能不是合法的资源名称，原始名称保存在 annotation 中
+	taskNameAnnotation = "choerodon.io/task-name"
+	// 旧版本的 c7n-logs 迁移后添加该 annotation
+	migratedAnnotation = "choerodon.io/migrated"
+	// configMap 名称的最大长度
+	maxObjectNameLength = 253
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// C7nLogs 将每个 task 保存在单独的 configMap 或者 secret 中，避免所有 task 保存在一个对象中超过 1MiB 的限制，
+// 并且修改不同的 task 不会相互冲突。更新时遇到 resourceVersion 冲突会重新读取后重试
 type C7nLogs struct {
-	client    *kubernetes.Clientset
+	client    kubernetes.Interface
+	objects   stateObjects
 	Name      string
 	namespace string
-	// 避免 yaml.Unmarshal 无法取地址
-	Tasks map[string]*[]TaskInfo
+	// 默认实例为空，其他实例的对象名称中包含实例名称并添加 InstanceLabel，见 objectName
+	instance string
+	// 保证同一个进程中的并发读写是串行的
+	mu sync.Mutex
 }
 
 /*
@@ -49,20 +74,80 @@ type TaskInfo struct {
 	TaskType string
 	Version  string
 	Prefix   string
+	// 已经执行的 sql 脚本，用于检查脚本在执行后是否被修改
+	Migrations []Migration
+	// release job 的 http 请求中提取的变量
+	Outputs map[string]string `json:",omitempty"`
+	// 使用 k8s job 执行时收集的日志
+	Logs string `json:",omitempty"`
+	// release 最后一次安装或者回滚后 helm 中的名称和版本
+	ReleaseName string `json:",omitempty"`
+	Revision    int    `json:",omitempty"`
+	// release 所属的应用及其依赖，用于按照依赖的逆序回滚应用
+	Application  string   `json:",omitempty"`
+	Requirements []string `json:",omitempty"`
 }
 
-var c7nLogs C7nLogs
+// Migration 记录执行过的 sql 脚本及其校验和
+type Migration struct {
+	Script   string
+	Checksum string
+	Date     time.Time
+}
 
-func InitC7nLogs(client *kubernetes.Clientset, namespace string) {
-	var once sync.Once
-	once.Do(func() {
-		if c7nLogs.client == nil {
-			c7nLogs.client = client
-			c7nLogs.namespace = namespace
-			c7nLogs.Name = consts.StaticLogsCM
-			c7nLogs.Tasks = map[string]*[]TaskInfo{}
+// GetMigration 返回已经执行的脚本，没有执行过时返回 nil
+func (t *TaskInfo) GetMigration(script string) *Migration {
+	for idx := range t.Migrations {
+		if t.Migrations[idx].Script == script {
+			return &t.Migrations[idx]
 		}
-	})
+	}
+	return nil
+}
+
+// NewC7nLogs 返回将 task 保存在 configMap 中的 StateStore
+func NewC7nLogs(client kubernetes.Interface, namespace string) *C7nLogs {
+	client = validClient(client)
+	return &C7nLogs{
+		client:    client,
+		objects:   &configMapObjects{client: client, namespace: namespace},
+		namespace: namespace,
+		Name:      consts.StaticLogsCM,
+	}
+}
+
+// NewSecretC7nLogs 返回将 task 保存在 secret 中的 StateStore
+func NewSecretC7nLogs(client kubernetes.Interface, namespace string) *C7nLogs {
+	client = validClient(client)
+	return &C7nLogs{
+		client:    client,
+		objects:   &secretObjects{client: client, namespace: namespace},
+		namespace: namespace,
+		Name:      consts.StaticLogsCM,
+	}
+}
+
+// scope 使 task 只属于 instance，默认实例使用原来的名称
+func (c *C7nLogs) scope(instance string) *C7nLogs {
+	if IsDefaultInstance(instance, c.namespace) {
+		return c
+	}
+	c.instance = instance
+	c.Name = fmt.Sprintf("%s-%s", consts.StaticLogsCM, instance)
+	return c
+}
+
+// owns 判断对象是否属于当前实例，没有 InstanceLabel 的对象属于默认实例
+func (c *C7nLogs) owns(cm *v1.ConfigMap) bool {
+	return cm.Labels[consts.InstanceLabel] == c.instance
+}
+
+// 获取 kubeconfig 失败时 clientset 为 nil
+func validClient(client kubernetes.Interface) kubernetes.Interface {
+	if cs, ok := client.(*kubernetes.Clientset); ok && cs == nil {
+		return nil
+	}
+	return client
 }
 
 func NewReleaseTask(release, namespace, version, prefix string) *TaskInfo {
@@ -80,7 +165,7 @@ func NewReleaseTask(release, namespace, version, prefix string) *TaskInfo {
 func NewReleaseJobTask(rlsJob, taskType, version string) *TaskInfo {
 	return &TaskInfo{
 		Name:      rlsJob,
-		Namespace: c7nLogs.namespace,
+		Namespace: stateStore.Namespace(),
 		Type:      consts.StaticTaskKey,
 		Status:    consts.UninitializedStatus,
 		Date:      time.Now(),
@@ -89,123 +174,301 @@ func NewReleaseJobTask(rlsJob, taskType, version string) *TaskInfo {
 	}
 }
 
+// GetTask 返回 task 的副本，不存在时返回 TaskInfoIsNotFoundError
 func GetTask(task string) (*TaskInfo, error) {
-	if err := getC7nLogs(c7nLogs.namespace, c7nLogs.Name); err != nil {
-		panic(err)
-	}
-	for group := range c7nLogs.Tasks {
-		tasks := *c7nLogs.Tasks[group]
-		for idx, t := range tasks {
-			if t.Name == task {
-				return &tasks[idx], nil
-			}
-		}
-		log.Debugf("Task %s isn't in group %s", task, group)
-	}
-	return nil, stderrors.WithMessage(c7nerrors.TaskInfoIsNotFoundError, fmt.Sprintf("Task %s isn't in configMaps c7n-logs", task))
+	return stateStore.Get(task)
 }
 
+// SaveTask 保存 task，已经存在时覆盖
 func SaveTask(t TaskInfo) (*TaskInfo, error) {
-	if t.Name != "" {
-		task, err := GetTask(t.Name)
-		// 错误为不存在时，任务追加到末尾。
-		if err != nil {
-			if stderrors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
-				log.Debugf("Task %s isn't in c7n-logs,new add it", t.Name)
-				*c7nLogs.Tasks[t.Type] = append(*c7nLogs.Tasks[t.Type], t)
-			} else {
-				return nil, stderrors.WithMessage(err, "Getting task failed when save Task: ")
-			}
-		} else {
-			*task = t
-			log.Debugf("Update task is %+v", *task)
-		}
-	} else {
+	if t.Name == "" {
 		log.Debug("Task is empty，Please confirm that the task exists")
+		return &t, nil
+	}
+	return UpdateTask(t.Name, func(task *TaskInfo) error {
+		*task = t
+		return nil
+	})
+}
+
+// UpdateTask 读取 task 并使用 mutate 修改后保存，冲突时重新读取并再次调用 mutate。task 不存在时 mutate 的参数只有名称
+func UpdateTask(name string, mutate func(task *TaskInfo) error) (*TaskInfo, error) {
+	task, err := stateStore.Update(name, mutate)
+	if err == nil {
+		recordTask(task)
 	}
+	return task, err
+}
+
+// ListTasks 返回所有的 task，按照类型和名称排序
+func ListTasks() ([]TaskInfo, error) {
+	return stateStore.List()
+}
+
+// DeleteTask 删除 task，不存在时不返回错误
+func DeleteTask(name string) error {
+	return stateStore.Delete(name)
+}
 
-	if err := saveC7nLogs(); err != nil {
+func (c *C7nLogs) Namespace() string {
+	return c.namespace
+}
+
+func (c *C7nLogs) checkClient() error {
+	if c.client == nil {
+		return stderrors.New("c7n-logs is not initialized")
+	}
+	return nil
+}
+
+// objectName 返回保存 task 的 configMap 名称，名称不合法时替换非法字符并添加原始名称的哈希。
+// 默认实例使用原来的 c7n-logs-<task>，其他实例使用 c7n-logs.<instance>.<task>，实例名称中没有 "."，
+// 所以不同实例的 task 不会使用同一个对象
+func (c *C7nLogs) objectName(task string) string {
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(task), "-")
+	name = strings.Trim(name, "-.")
+	prefix := c.Name + "-"
+	if c.instance != "" {
+		prefix = fmt.Sprintf("%s.%s.", consts.StaticLogsCM, c.instance)
+	}
+	if name == task && len(prefix)+len(name) <= maxObjectNameLength {
+		return prefix + name
+	}
+	sum := sha256.Sum256([]byte(task))
+	hash := hex.EncodeToString(sum[:])[:10]
+	if max := maxObjectNameLength - len(prefix) - len(hash) - 1; len(name) > max {
+		name = name[:max]
+	}
+	return fmt.Sprintf("%s%s-%s", prefix, name, hash)
+}
+
+func (c *C7nLogs) Get(name string) (*TaskInfo, error) {
+	if err := c.checkClient(); err != nil {
 		return nil, err
 	}
-	return &t, nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, task, err := c.get(name)
+	return task, err
 }
 
-func saveC7nLogs() error {
-	cm, err := getConfigMaps(c7nLogs.namespace, c7nLogs.Name)
+func (c *C7nLogs) get(name string) (*v1.ConfigMap, *TaskInfo, error) {
+	cm, err := c.objects.get(c.objectName(name))
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return nil, nil, stderrors.WithMessage(c7nerrors.TaskInfoIsNotFoundError, fmt.Sprintf("Task %s isn't in c7n-logs", name))
+		}
+		return nil, nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to get task %s", name))
+	}
+	if err = c.checkOwner(cm, name); err != nil {
+		return nil, nil, err
+	}
+	task, err := decodeTask(cm)
 	if err != nil {
-		return stderrors.WithMessage(err, "Save configMaps c7n-logs failed: ")
+		return nil, nil, err
+	}
+	return cm, task, nil
+}
+
+// checkOwner 确认对象保存的是当前实例的 task，避免读取或者覆盖其他实例以及旧版本名称相同的对象
+func (c *C7nLogs) checkOwner(cm *v1.ConfigMap, name string) error {
+	if !c.owns(cm) {
+		return stderrors.Errorf("%s %s of task %s belongs to instance %s", c.objects.resource().Resource, cm.Name, name, cm.Labels[consts.InstanceLabel])
+	}
+	if task, ok := cm.Annotations[taskNameAnnotation]; ok && task != name {
+		return stderrors.Errorf("%s %s belongs to task %s instead of %s", c.objects.resource().Resource, cm.Name, task, name)
+	}
+	return nil
+}
+
+func decodeTask(cm *v1.ConfigMap) (*TaskInfo, error) {
+	task := &TaskInfo{}
+	if err := yaml.Unmarshal([]byte(cm.Data[taskDataKey]), task); err != nil {
+		return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to decode task in %s", cm.Name))
+	}
+	return task, nil
+}
+
+func (c *C7nLogs) Update(name string, mutate func(task *TaskInfo) error) (*TaskInfo, error) {
+	if err := c.checkClient(); err != nil {
+		return nil, err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	for key := range c7nLogs.Tasks {
-		tbyte, err := yaml.Marshal(*c7nLogs.Tasks[key])
+	var saved *TaskInfo
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		cm, task, err := c.get(name)
+		if err != nil && !stderrors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
+			return err
+		}
+		if task == nil {
+			task = &TaskInfo{Name: name}
+		}
+		if err := mutate(task); err != nil {
+			return err
+		}
+		if task.Name != name {
+			return stderrors.Errorf("task name can't be changed from %s to %s", name, task.Name)
+		}
+		data, err := yaml.Marshal(task)
 		if err != nil {
-			log.Error(err)
+			return err
 		}
-		if cm.Data == nil {
-			cm.Data = map[string]string{}
+
+		if cm == nil {
+			cm = &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        c.objectName(name),
+					Namespace:   c.namespace,
+					Labels:      c.labels(task.Type),
+					Annotations: map[string]string{taskNameAnnotation: name},
+				},
+				Data: map[string]string{taskDataKey: string(data)},
+			}
+			err = c.objects.create(cm)
+			// 其他 c7nctl 同时创建了 task 时重新读取后更新
+			if k8serrors.IsAlreadyExists(err) {
+				return k8serrors.NewConflict(c.objects.resource(), cm.Name, err)
+			}
+		} else {
+			cm.Labels = c.labels(task.Type)
+			cm.Data = map[string]string{taskDataKey: string(data)}
+			err = c.objects.update(cm)
 		}
-		cm.Data[key] = string(tbyte)
-		log.Debugf("Saving ConfigMaps key %s", key)
+		if err != nil {
+			return err
+		}
+		saved = task
+		log.Debugf("Saved task %s", name)
+		return nil
+	})
+	if err != nil {
+		return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to save task %s", name))
 	}
-	if _, err = c7nLogs.client.CoreV1().ConfigMaps(c7nLogs.namespace).Update(context.Background(), cm,
-		metav1.UpdateOptions{}); err != nil {
-		return stderrors.WithMessage(err, "Save configMaps c7n-logs failed: ")
+	return saved, nil
+}
+
+func (c *C7nLogs) labels(taskType string) map[string]string {
+	l := c.commonLabels()
+	l[TaskTypeLabel] = taskType
+	return l
+}
+
+func (c *C7nLogs) commonLabels() map[string]string {
+	l := map[string]string{}
+	for k, v := range consts.CommonLabels {
+		l[k] = v
 	}
-	return nil
+	if c.instance != "" {
+		l[consts.InstanceLabel] = c.instance
+	}
+	return l
 }
 
-// 如果不存在 c7n-logs 就创建，之后将其数据初始化到 c7nLogs
-func getC7nLogs(namespace, cmName string) error {
-	cm, err := getConfigMaps(namespace, cmName)
-	if err != nil {
-		return stderrors.WithMessage(err, "Get configMaps c7n-logs failed: ")
+func (c *C7nLogs) List() ([]TaskInfo, error) {
+	if err := c.checkClient(); err != nil {
+		return nil, err
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	for key := range cm.Data {
-		if c7nLogs.Tasks[key] == nil {
-			log.Debugf("Task type %s is empty", key)
-			c7nLogs.Tasks[key] = new([]TaskInfo)
+	cms, err := c.objects.list(TaskTypeLabel)
+	if err != nil {
+		return nil, stderrors.WithMessage(err, "Failed to list tasks")
+	}
+	tasks := make([]TaskInfo, 0, len(cms))
+	for idx := range cms {
+		if !c.owns(&cms[idx]) {
+			continue
 		}
-
-		if err = yaml.Unmarshal([]byte(cm.Data[key]), c7nLogs.Tasks[key]); err != nil {
-			panic(err)
+		task, err := decodeTask(&cms[idx])
+		if err != nil {
+			return nil, err
 		}
-		log.Debugf("Getting ConfigMaps key %s", key)
+		tasks = append(tasks, *task)
+	}
+	sortTasks(tasks)
+	return tasks, nil
+}
+
+func (c *C7nLogs) Delete(name string) error {
+	if err := c.checkClient(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cm, err := c.objects.get(c.objectName(name))
+	if k8serrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return stderrors.WithMessage(err, fmt.Sprintf("Failed to delete task %s", name))
+	}
+	// 不删除其他实例的对象
+	if err = c.checkOwner(cm, name); err != nil {
+		return err
+	}
+	err = c.objects.delete(cm.Name)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return stderrors.WithMessage(err, fmt.Sprintf("Failed to delete task %s", name))
 	}
 	return nil
 }
 
-func getConfigMaps(namespace, cmName string) (cm *v1.ConfigMap, err error) {
-	cm, err = c7nLogs.client.CoreV1().ConfigMaps(c7nLogs.namespace).Get(context.Background(), c7nLogs.Name, metav1.GetOptions{})
-	// 如果不存在则创建 cm
+// migrateLegacy 将旧版本保存在 c7n-logs 中的 task 复制到单独的 configMap 中，已经存在的 task 不会被覆盖
+func (c *C7nLogs) migrateLegacy() error {
+	if err := c.checkClient(); err != nil {
+		return err
+	}
+	// 旧版本只有默认实例
+	if c.instance != "" {
+		return nil
+	}
+	configMaps := c.client.CoreV1().ConfigMaps(c.namespace)
+	legacy, err := configMaps.Get(context.Background(), c.Name, metav1.GetOptions{})
 	if err != nil {
 		if k8serrors.IsNotFound(err) {
-			log.Infof("Config map %s isn't existing, now Create it", cmName)
+			return nil
+		}
+		return stderrors.WithMessage(err, fmt.Sprintf("Failed to get configMaps %s", c.Name))
+	}
+	if legacy.Annotations[migratedAnnotation] == "true" {
+		return nil
+	}
 
-			cm = &v1.ConfigMap{
-				ObjectMeta: metav1.ObjectMeta{
-					Name:      cmName,
-					Namespace: namespace,
-					Labels:    consts.CommonLabels,
-				},
-				Data: map[string]string{
-					consts.StaticReleaseKey:    "",
-					consts.StaticPersistentKey: "",
-					consts.StaticTaskKey:       "",
-				},
+	log.Infof("Migrating tasks in configMaps %s", c.Name)
+	for key, data := range legacy.Data {
+		var tasks []TaskInfo
+		if err := yaml.Unmarshal([]byte(data), &tasks); err != nil {
+			return stderrors.WithMessage(err, fmt.Sprintf("Failed to decode %s of configMaps %s", key, c.Name))
+		}
+		for _, t := range tasks {
+			if t.Name == "" {
+				continue
 			}
-			cm, err = c7nLogs.client.CoreV1().ConfigMaps(c7nLogs.namespace).Create(context.Background(), cm,
-				metav1.CreateOptions{})
-			if err != nil {
-				return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to create configMaps %s in namespace %s",
-					c7nLogs.Name, c7nLogs.namespace))
+			if _, err := c.Update(t.Name, func(task *TaskInfo) error {
+				// 迁移中断后重新执行时，不覆盖已经迁移并更新过的 task
+				if task.Type == "" {
+					*task = t
+				}
+				return nil
+			}); err != nil {
+				return err
 			}
-			log.Infof("Successfully created ConfigMaps %s in namespace %s", c7nLogs.Name, c7nLogs.namespace)
-		} else {
-			return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to get configMaps %s in namespace %s",
-				c7nLogs.Name, c7nLogs.namespace))
 		}
 	}
-	log.Debugf("Using existing configMaps %s in namespace %s", c7nLogs.Name, c7nLogs.namespace)
-	return cm, nil
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		legacy, err := configMaps.Get(context.Background(), c.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if legacy.Annotations == nil {
+			legacy.Annotations = map[string]string{}
+		}
+		legacy.Annotations[migratedAnnotation] = "true"
+		_, err = configMaps.Update(context.Background(), legacy, metav1.UpdateOptions{})
+		return err
+	})
 }
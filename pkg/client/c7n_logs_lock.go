@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	stderrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationclient "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"os"
+	"os/user"
+	"time"
+)
+
+// lockLostError 表示续期时发现锁已经被删除或者被其他 c7nctl 获取
+type lockLostError struct {
+	holder string
+}
+
+func (e *lockLostError) Error() string {
+	if e.holder == "" {
+		return "lost the lock of c7n-logs, the lease was deleted"
+	}
+	return fmt.Sprintf("lost the lock of c7n-logs to %s", e.holder)
+}
+
+// 锁的有效期，持有锁的 c7nctl 每隔 1/3 有效期续期一次，进程异常退出后锁在有效期后失效
+var lockDuration = 60 * time.Second
+
+// LockHolder 返回当前 c7nctl 的标识：用户@主机/进程号
+func LockHolder() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s/%d", name, host, os.Getpid())
+}
+
+// LockTasks 获取安装记录的锁，避免多个 c7nctl 同时修改同一个安装
+func LockTasks(holder string) (func(), error) {
+	return stateStore.Lock(holder)
+}
+
+// Lock 使用命名空间中的 Lease 作为锁，锁被其他 c7nctl 持有并且没有过期时返回错误。
+// 获取成功后会在后台续期，调用返回的函数释放锁
+func (c *C7nLogs) Lock(holder string) (func(), error) {
+	if err := c.checkClient(); err != nil {
+		return nil, err
+	}
+	leases := c.client.CoordinationV1().Leases(c.namespace)
+	name := c.Name + "-lock"
+	seconds := int32(lockDuration / time.Second)
+	now := metav1.NewMicroTime(time.Now())
+
+	lease, err := leases.Get(context.Background(), name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: c.commonLabels()},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if lease, err = leases.Create(context.Background(), lease, metav1.CreateOptions{}); err != nil {
+			if k8serrors.IsAlreadyExists(err) {
+				return nil, stderrors.Errorf("c7n-logs in namespace %s is being locked by another c7nctl", c.namespace)
+			}
+			return nil, stderrors.WithMessage(err, "Failed to lock c7n-logs")
+		}
+	case err != nil:
+		return nil, stderrors.WithMessage(err, "Failed to lock c7n-logs")
+	default:
+		if h := lease.Spec.HolderIdentity; h != nil && *h != "" && *h != holder && !leaseExpired(lease) {
+			return nil, stderrors.Errorf("c7n-logs in namespace %s is locked by %s since %s, wait for it to finish or retry after %s",
+				c.namespace, *h, lease.Spec.AcquireTime.Format(time.RFC3339), lockDuration)
+		}
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.LeaseDurationSeconds = &seconds
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+		// 其他 c7nctl 同时获取了锁时 resourceVersion 冲突
+		if lease, err = leases.Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+			if k8serrors.IsConflict(err) {
+				return nil, stderrors.Errorf("c7n-logs in namespace %s is being locked by another c7nctl", c.namespace)
+			}
+			return nil, stderrors.WithMessage(err, "Failed to lock c7n-logs")
+		}
+	}
+	log.Debugf("Locked c7n-logs as %s", holder)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				updated, err := renewLease(leases, lease, holder)
+				if _, lost := err.(*lockLostError); lost {
+					log.Errorf("%s, other c7nctl may modify c7n-logs in namespace %s at the same time", err, c.namespace)
+					return
+				}
+				if err != nil {
+					log.Warnf("Failed to renew the lock of c7n-logs: %s", err)
+				}
+				lease = updated
+			}
+		}
+	}()
+
+	return func() {
+		close(stopCh)
+		<-done
+		// 只释放自己持有的锁
+		current, err := leases.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil || current.Spec.HolderIdentity == nil || *current.Spec.HolderIdentity != holder {
+			return
+		}
+		if err := leases.Delete(context.Background(), name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			log.Warnf("Failed to release the lock of c7n-logs: %s", err)
+		}
+	}, nil
+}
+
+// renewLease 续期 holder 持有的锁。resourceVersion 冲突时重新读取 lease，锁已经被删除或者被其他 c7nctl 获取时返回 lockLostError
+func renewLease(leases coordinationclient.LeaseInterface, lease *coordinationv1.Lease, holder string) (*coordinationv1.Lease, error) {
+	renew := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &renew
+	updated, err := leases.Update(context.Background(), lease, metav1.UpdateOptions{})
+	if err == nil {
+		return updated, nil
+	}
+	if !k8serrors.IsConflict(err) && !k8serrors.IsNotFound(err) {
+		return lease, err
+	}
+	current, err := leases.Get(context.Background(), lease.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return lease, &lockLostError{}
+	}
+	if err != nil {
+		return lease, err
+	}
+	if h := current.Spec.HolderIdentity; h == nil || *h != holder {
+		lost := &lockLostError{holder: "another c7nctl"}
+		if h != nil && *h != "" {
+			lost.holder = *h
+		}
+		return current, lost
+	}
+	current.Spec.RenewTime = &renew
+	if updated, err = leases.Update(context.Background(), current, metav1.UpdateOptions{}); err != nil {
+		return current, err
+	}
+	return updated, nil
+}
+
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	expire := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().After(expire)
+}
@@ -1,33 +1,32 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/choerodon/c7nctl/pkg/common/consts"
+	c7nerrors "github.com/choerodon/c7nctl/pkg/common/errors"
 	"github.com/choerodon/c7nctl/pkg/config"
 	"github.com/choerodon/c7nctl/pkg/utils"
-	"k8s.io/client-go/kubernetes"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 	"os"
-	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
-func initKubeClient() *kubernetes.Clientset {
-	var defaultKubeconfigPath string
-	if home := homeDir(); home != "" {
-		defaultKubeconfigPath = filepath.Join(home, ".kube", "config")
-	}
-
-	client, err := GetKubeClient(defaultKubeconfigPath)
-	if err != nil {
-		fmt.Println(err)
-	}
-	return client
-}
-
 func TestSaveAndGetTask(t *testing.T) {
-	InitC7nLogs(initKubeClient(), "ydq-test")
+	if err := InitC7nLogs(fake.NewSimpleClientset(), "ydq-test"); err != nil {
+		t.Fatal(err)
+	}
 
 	c7nLogsTest := []struct {
 		TaskInfo,
@@ -369,19 +368,25 @@ func TestSaveAndGetTask(t *testing.T) {
 		if err != nil {
 			t.Error(err)
 		}
+		task, err := GetTask(c.TaskInfo.Name)
+		if err != nil {
+			t.Error(err)
+		}
+		if reflect.DeepEqual(c.Result, task) || task.RefName != c.TaskInfo.RefName {
+			t.Errorf("Taskinfo no equal %+v", task)
+		}
 		// update
 		c.TaskInfo.RefName += "-test"
-		task, err := GetTask(c.TaskInfo.Name)
 		_, err = SaveTask(c.TaskInfo)
 		if err != nil {
 			t.Error(err)
 		}
-
+		task, err = GetTask(c.TaskInfo.Name)
 		if err != nil {
 			t.Error(err)
 		}
-		if reflect.DeepEqual(c.Result, task) {
-			t.Errorf("Taskinfo no equal %+v", task)
+		if task.RefName != c.TaskInfo.RefName {
+			t.Errorf("want ref name %s after update, got %s", c.TaskInfo.RefName, task.RefName)
 		}
 	}
 }
@@ -392,3 +397,199 @@ func homeDir() string {
 	}
 	return os.Getenv("USERPROFILE") // windows
 }
+
+func TestC7nLogsStore(t *testing.T) {
+	legacyTasks := `- Name: c7n-mysql
+  Type: release
+  Status: succeed
+- Name: Upper_Case
+  Type: release
+  Status: failed
+`
+	legacy := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: consts.StaticLogsCM, Namespace: "c7n-system"},
+		Data: map[string]string{
+			consts.StaticReleaseKey: legacyTasks,
+			consts.StaticTaskKey:    "",
+		},
+	}
+	client := fake.NewSimpleClientset(legacy)
+	if err := InitC7nLogs(client, "c7n-system"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 旧版本的 task 迁移到单独的 configMap
+	task, err := GetTask("c7n-mysql")
+	if err != nil || task.Status != consts.SucceedStatus {
+		t.Fatalf("unexpected task %+v %v", task, err)
+	}
+	if _, err = client.CoreV1().ConfigMaps("c7n-system").Get(context.Background(), "c7n-logs-c7n-mysql", metav1.GetOptions{}); err != nil {
+		t.Error(err)
+	}
+	cm, _ := client.CoreV1().ConfigMaps("c7n-system").Get(context.Background(), consts.StaticLogsCM, metav1.GetOptions{})
+	if cm.Annotations[migratedAnnotation] != "true" {
+		t.Errorf("legacy c7n-logs should be marked as migrated")
+	}
+
+	// 名称不合法的 task
+	if name := stateStore.(*C7nLogs).objectName("Upper_Case"); !strings.HasPrefix(name, "c7n-logs-upper-case-") {
+		t.Errorf("unexpected object name %s", name)
+	}
+	if task, err = GetTask("Upper_Case"); err != nil || task.Status != consts.FailedStatus {
+		t.Fatalf("unexpected task %+v %v", task, err)
+	}
+
+	if _, err = GetTask("not-exist"); !errors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
+		t.Errorf("want not found, got %v", err)
+	}
+	if _, err = SaveTask(*NewReleaseJobTask("predb", consts.SqlTask, "0.25")); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := ListTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 3 || tasks[0].Name != "Upper_Case" || tasks[2].Name != "predb" {
+		t.Errorf("unexpected tasks %+v", tasks)
+	}
+
+	if err = DeleteTask("predb"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = GetTask("predb"); !errors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
+		t.Errorf("task should be deleted, got %v", err)
+	}
+}
+
+func TestUpdateTaskConflict(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	if err := InitC7nLogs(client, "c7n-system"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveTask(TaskInfo{Name: "gitlab", Type: consts.StaticReleaseKey}); err != nil {
+		t.Fatal(err)
+	}
+	// 第一次更新时模拟其他 c7nctl 修改了 task
+	conflicts := 1
+	client.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, k8serrors.NewConflict(v1.Resource("configmaps"), "c7n-logs-gitlab", errors.New("object has been modified"))
+		}
+		return false, nil, nil
+	})
+
+	calls := 0
+	task, err := UpdateTask("gitlab", func(task *TaskInfo) error {
+		calls++
+		task.Status = consts.SucceedStatus
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || task.Status != consts.SucceedStatus {
+		t.Errorf("want mutate called twice, got %d %+v", calls, task)
+	}
+
+	// 并发更新不同的 task
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := SaveTask(TaskInfo{Name: fmt.Sprintf("task-%d", i), Type: consts.StaticTaskKey}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if tasks, _ := ListTasks(); len(tasks) != 11 {
+		t.Errorf("want 11 tasks, got %d", len(tasks))
+	}
+}
+
+func TestLockTasks(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	if err := InitC7nLogs(client, "c7n-system"); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := LockTasks("alice@host/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LockTasks("bob@host/2"); err == nil || !strings.Contains(err.Error(), "alice@host/1") {
+		t.Errorf("want locked by alice, got %v", err)
+	}
+	unlock()
+	unlock, err = LockTasks("bob@host/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	// 过期的锁可以被其他 c7nctl 获取
+	lease, _ := client.CoordinationV1().Leases("c7n-system").Get(context.Background(), "c7n-logs-lock", metav1.GetOptions{})
+	expired := metav1.NewMicroTime(time.Now().Add(-2 * lockDuration))
+	lease.Spec.RenewTime = &expired
+	client.CoordinationV1().Leases("c7n-system").Update(context.Background(), lease, metav1.UpdateOptions{})
+	unlockAlice, err := LockTasks("alice@host/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockAlice()
+}
+
+func TestRenewLease(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	if err := InitC7nLogs(client, "c7n-system"); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := LockTasks("alice@host/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	leases := client.CoordinationV1().Leases("c7n-system")
+	lease, _ := leases.Get(context.Background(), "c7n-logs-lock", metav1.GetOptions{})
+
+	// 模拟 resourceVersion 冲突：第一次更新失败
+	conflicts := 1
+	client.PrependReactor("update", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, k8serrors.NewConflict(coordinationv1.Resource("leases"), "c7n-logs-lock", errors.New("conflict"))
+		}
+		return false, nil, nil
+	})
+	// 锁仍然属于自己时重新读取后续期
+	renewed, err := renewLease(leases, lease.DeepCopy(), "alice@host/1")
+	if err != nil || *renewed.Spec.HolderIdentity != "alice@host/1" {
+		t.Fatalf("want the lease renewed, got %+v %v", renewed, err)
+	}
+
+	// 锁被其他 c7nctl 获取后停止续期
+	bob := "bob@host/2"
+	renewed.Spec.HolderIdentity = &bob
+	if _, err = leases.Update(context.Background(), renewed, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	conflicts = 1
+	_, err = renewLease(leases, lease.DeepCopy(), "alice@host/1")
+	if _, lost := err.(*lockLostError); !lost || !strings.Contains(err.Error(), bob) {
+		t.Errorf("want lock lost to bob, got %v", err)
+	}
+	if current, _ := leases.Get(context.Background(), "c7n-logs-lock", metav1.GetOptions{}); *current.Spec.HolderIdentity != bob {
+		t.Errorf("the lease of bob should not be changed, got %+v", current)
+	}
+
+	// lease 被删除
+	if err = leases.Delete(context.Background(), "c7n-logs-lock", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = renewLease(leases, lease.DeepCopy(), "alice@host/1"); err == nil {
+		t.Error("want lock lost for deleted lease")
+	} else if _, lost := err.(*lockLostError); !lost {
+		t.Errorf("want lock lost, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"bytes"
 	"fmt"
+	"github.com/choerodon/c7nctl/pkg/repository"
 	"github.com/pkg/errors"
 	liberrors "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -15,11 +16,14 @@ import (
 	"helm.sh/helm/v3/pkg/downloader"
 	"helm.sh/helm/v3/pkg/getter"
 	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/postrender"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
 	"helm.sh/helm/v3/pkg/storage/driver"
 	"io"
 	"os"
 	"strings"
+	"time"
 )
 
 var helmClient *Helm3Client
@@ -40,6 +44,17 @@ type ChartArgs struct {
 	KeyFile     string
 	CaFile      string
 	ChartName   string
+	// 为空时使用 repository.Find 中 RepoUrl 的认证信息
+	Username              string
+	Password              string
+	InsecureSkipTLSverify bool
+	// helm 的选项，Atomic 时安装或者升级失败会自动回滚
+	Wait         bool
+	Atomic       bool
+	Timeout      time.Duration
+	DisableHooks bool
+	SkipCRDs     bool
+	PostRenderer postrender.PostRenderer
 }
 
 func NewHelm3Client(cfg *action.Configuration) *Helm3Client {
@@ -70,7 +85,7 @@ func (h *Helm3Client) Install(cArgs ChartArgs, vals map[string]interface{}, out
 	// TODO 移动到 helm3Client
 	os.Setenv("HELM_NAMESPACE", cArgs.Namespace)
 	settings := cli.New()
-	cp, err := client.ChartPathOptions.LocateChart(cArgs.ChartName, settings)
+	cp, err := locateChart(&client.ChartPathOptions, cArgs.ChartName, settings)
 	if err != nil {
 		return nil, err
 	}
@@ -136,21 +151,62 @@ func (h *Helm3Client) Install(cArgs ChartArgs, vals map[string]interface{}, out
 
 func (h *Helm3Client) newHelm3Install(cfg *action.Configuration, args ChartArgs) *action.Install {
 	install := action.NewInstall(cfg)
-	install.ChartPathOptions = action.ChartPathOptions{
-		CaFile:   args.CaFile,
-		CertFile: args.CertFile,
-		KeyFile:  args.KeyFile,
-		Keyring:  args.Keyring,
-		RepoURL:  args.RepoUrl,
-		Verify:   args.Verify,
-		Version:  args.Version,
-	}
+	install.ChartPathOptions = chartPathOptions(args)
 	install.ReleaseName = args.ReleaseName
 	install.Namespace = args.Namespace
+	install.Wait = args.Wait
+	install.Atomic = args.Atomic
+	install.Timeout = args.Timeout
+	install.DisableHooks = args.DisableHooks
+	install.SkipCRDs = args.SkipCRDs
+	install.PostRenderer = args.PostRenderer
 
 	return install
 }
 
+// chartPathOptions 返回查找 chart 的选项，args 中没有设置的认证信息从 repository 的配置中获取
+func chartPathOptions(args ChartArgs) action.ChartPathOptions {
+	r := repository.Find(args.RepoUrl)
+	if args.Username != "" {
+		r.Username, r.Password = args.Username, args.Password
+	}
+	if args.CaFile != "" {
+		r.CaFile = args.CaFile
+	}
+	if args.CertFile != "" {
+		r.CertFile, r.KeyFile = args.CertFile, args.KeyFile
+	}
+	return action.ChartPathOptions{
+		CaFile:                r.CaFile,
+		CertFile:              r.CertFile,
+		KeyFile:               r.KeyFile,
+		InsecureSkipTLSverify: r.InsecureSkipTLSVerify || args.InsecureSkipTLSverify,
+		Keyring:               args.Keyring,
+		Password:              r.Password,
+		RepoURL:               args.RepoUrl,
+		Username:              r.Username,
+		Verify:                args.Verify,
+		Version:               args.Version,
+	}
+}
+
+// locateChart 返回 chart 的本地路径，helm 3.4 不支持 oci 仓库，先下载到 helm 的缓存目录中
+func locateChart(c *action.ChartPathOptions, name string, settings *cli.EnvSettings) (string, error) {
+	if !repository.IsOCI(c.RepoURL) {
+		return c.LocateChart(name, settings)
+	}
+	r := repository.Repository{
+		URL:                   c.RepoURL,
+		Username:              c.Username,
+		Password:              c.Password,
+		CaFile:                c.CaFile,
+		CertFile:              c.CertFile,
+		KeyFile:               c.KeyFile,
+		InsecureSkipTLSVerify: c.InsecureSkipTLSverify,
+	}
+	return r.Pull(name, c.Version, settings.RepositoryCache)
+}
+
 func (h *Helm3Client) newHelm3Template(cfg *action.Configuration) *action.Install {
 	client := action.NewInstall(cfg)
 
@@ -169,29 +225,16 @@ func (h *Helm3Client) newHelm3Template(cfg *action.Configuration) *action.Instal
 }
 func (h *Helm3Client) newHelm3Upgrade(cfg *action.Configuration, args ChartArgs) *action.Upgrade {
 	upgrade := action.NewUpgrade(cfg)
-	upgrade.ChartPathOptions = action.ChartPathOptions{
-		CaFile:   args.CaFile,
-		CertFile: args.CertFile,
-		KeyFile:  args.KeyFile,
-		Keyring:  args.Keyring,
-		RepoURL:  args.RepoUrl,
-		Verify:   args.Verify,
-		Version:  args.Version,
-	}
+	upgrade.ChartPathOptions = chartPathOptions(args)
 	// 默认更新或者安装
 	upgrade.Install = true
-	//upgrade.CreateNamespace = createNamespace
-	//upgrade.DryRun = false
-	//upgrade.DisableHooks = false
-	//upgrade.SkipCRDs = false
-	//upgrade.Timeout = xx
-	//upgrade.Wait = false
-	//upgrade.Devel = false
 	upgrade.Namespace = args.Namespace
-	//upgrade.Atomic = client.Atomic
-	//upgrade.PostRenderer = client.PostRenderer
-	//upgrade.DisableOpenAPIValidation = client.DisableOpenAPIValidation
-	//upgrade.SubNotes = client.SubNotes
+	upgrade.Wait = args.Wait
+	upgrade.Atomic = args.Atomic
+	upgrade.Timeout = args.Timeout
+	upgrade.DisableHooks = args.DisableHooks
+	upgrade.SkipCRDs = args.SkipCRDs
+	upgrade.PostRenderer = args.PostRenderer
 	return upgrade
 }
 
@@ -202,7 +245,7 @@ func RunHelmInstall(client *action.Install, chart string, vals map[string]interf
 		client.Version = ">0.0.0-0"
 	}
 	settings := cli.New()
-	cp, err := client.ChartPathOptions.LocateChart(chart, settings)
+	cp, err := locateChart(&client.ChartPathOptions, chart, settings)
 	if err != nil {
 		return nil, err
 	}
@@ -300,6 +343,11 @@ func (h *Helm3Client) Upgrade(cArgs ChartArgs, vals map[string]interface{}, out
 		} else if err != nil {
 			return nil, err
 		}
+		// 被中断的安装或者升级会一直处于 pending 状态，helm 不会再处理该 release
+		if last, err := h.Releases.Last(cArgs.ReleaseName); err == nil && last.Info.Status.IsPending() {
+			return nil, liberrors.Errorf("release %s is %s, another helm operation is in progress or was interrupted, roll it back with `c7nctl rollback %s -n %s` and try again",
+				cArgs.ReleaseName, last.Info.Status, cArgs.ReleaseName, cArgs.Namespace)
+		}
 	}
 
 	log.Debugf("Original chart version: %q", client.Version)
@@ -309,7 +357,7 @@ func (h *Helm3Client) Upgrade(cArgs ChartArgs, vals map[string]interface{}, out
 	}
 	settings := cli.New()
 
-	chartPath, err := client.ChartPathOptions.LocateChart(cArgs.ChartName, settings)
+	chartPath, err := locateChart(&client.ChartPathOptions, cArgs.ChartName, settings)
 	if err != nil {
 		return nil, err
 	}
@@ -337,6 +385,29 @@ func (h *Helm3Client) Upgrade(cArgs ChartArgs, vals map[string]interface{}, out
 	return rel, nil
 }
 
+// Rollback 将 release 回滚到 revision，revision 为 0 时回滚到上一个版本，返回回滚后新的 release
+func (h *Helm3Client) Rollback(cArgs ChartArgs, revision int) (*release.Release, error) {
+	client := action.NewRollback(h.Configuration)
+	client.Version = revision
+	client.Wait = cArgs.Wait
+	client.Timeout = cArgs.Timeout
+	client.DisableHooks = cArgs.DisableHooks
+	if err := client.Run(cArgs.ReleaseName); err != nil {
+		return nil, liberrors.Wrap(err, "ROLLBACK FAILED")
+	}
+	return h.Releases.Last(cArgs.ReleaseName)
+}
+
+// History 返回 release 的所有版本，按照 revision 升序排列
+func (h *Helm3Client) History(releaseName string) ([]*release.Release, error) {
+	rels, err := action.NewHistory(h.Configuration).Run(releaseName)
+	if err != nil {
+		return nil, err
+	}
+	releaseutil.SortByRevision(rels)
+	return rels, nil
+}
+
 func (h *Helm3Client) Template(chartFile string, out io.Writer) (string, error) {
 	client := h.newHelm3Template(h.Configuration)
 	valueOpts := &values.Options{}
@@ -388,12 +459,12 @@ func runInstall(args []string, client *action.Install, valueOpts *values.Options
 	}
 	client.ReleaseName = name
 	settings := cli.New()
-	cp, err := client.ChartPathOptions.LocateChart(chart, settings)
+	cp, err := locateChart(&client.ChartPathOptions, chart, settings)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debug("CHART PATH: %s\n", cp)
+	log.Debugf("CHART PATH: %s\n", cp)
 
 	p := getter.All(settings)
 	vals, err := valueOpts.MergeValues(p)
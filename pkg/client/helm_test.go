@@ -1,6 +1,7 @@
 package client
 
 import (
+	"github.com/choerodon/c7nctl/pkg/repository"
 	"os"
 	"testing"
 )
@@ -8,6 +9,24 @@ import (
 func TestVals(t *testing.T) {
 }
 
+func TestChartPathOptions(t *testing.T) {
+	repository.SetRepositories([]repository.Repository{{URL: "https://harbor.example.com", Username: "admin", Password: "secret", CaFile: "ca.crt"}})
+	defer repository.SetRepositories(nil)
+
+	opts := chartPathOptions(ChartArgs{RepoUrl: "https://harbor.example.com/chartrepo/c7n", Version: "1.1.0"})
+	if opts.Username != "admin" || opts.Password != "secret" || opts.CaFile != "ca.crt" || opts.Version != "1.1.0" {
+		t.Errorf("credentials of repository are not used: %+v", opts)
+	}
+	opts = chartPathOptions(ChartArgs{RepoUrl: "https://harbor.example.com/chartrepo/c7n", Username: "c7n", Password: "c7n"})
+	if opts.Username != "c7n" || opts.Password != "c7n" || opts.CaFile != "ca.crt" {
+		t.Errorf("credentials of args are not used: %+v", opts)
+	}
+	opts = chartPathOptions(ChartArgs{RepoUrl: "https://charts.example.com/c7n"})
+	if opts.Username != "" || opts.CaFile != "" {
+		t.Errorf("unexpected credentials: %+v", opts)
+	}
+}
+
 func TestHelm3Client_Install(t *testing.T) {
 	cfg := InitConfiguration("", "default")
 	helmClient := NewHelm3Client(cfg)
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	stderrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+// 包含容器列表的字段，容器中的 image 会被替换
+var containerFields = []string{"containers", "initContainers", "ephemeralContainers"}
+
+// ImageRewriter 返回镜像替换后的名称
+type ImageRewriter func(image string) (string, error)
+
+// ImageRenderer 是 helm 的 post-renderer，替换渲染后的 manifest 中所有容器的镜像，比如替换为离线包推送到的私有镜像仓库
+type ImageRenderer struct {
+	release string
+	rewrite ImageRewriter
+}
+
+func NewImageRenderer(release string, rewrite ImageRewriter) *ImageRenderer {
+	return &ImageRenderer{release: release, rewrite: rewrite}
+}
+
+// Run 实现 postrender.PostRenderer，没有容器的资源保持原样
+func (r *ImageRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	docs := manifestSeparator.Split(renderedManifests.String(), -1)
+	out := &bytes.Buffer{}
+	for idx, doc := range docs {
+		rewritten, err := r.rewriteDoc(doc)
+		if err != nil {
+			return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to rewrite images of release %s", r.release))
+		}
+		if idx > 0 {
+			out.WriteString("---\n")
+		}
+		out.WriteString(rewritten)
+	}
+	return out, nil
+}
+
+func (r *ImageRenderer) rewriteDoc(doc string) (string, error) {
+	if strings.TrimSpace(doc) == "" {
+		return doc, nil
+	}
+	var obj map[string]interface{}
+	if err := yaml.Unmarshal([]byte(doc), &obj); err != nil || obj == nil {
+		return doc, nil
+	}
+	changed, err := r.walk(obj)
+	if err != nil || !changed {
+		return doc, err
+	}
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// walk 递归查找容器列表并替换其中的镜像，返回是否有镜像被替换
+func (r *ImageRenderer) walk(v interface{}) (bool, error) {
+	changed := false
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if containsString(containerFields, k) {
+				c, err := r.rewriteContainers(child)
+				if err != nil {
+					return false, err
+				}
+				changed = changed || c
+				continue
+			}
+			c, err := r.walk(child)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+	case []interface{}:
+		for _, child := range val {
+			c, err := r.walk(child)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+	}
+	return changed, nil
+}
+
+func (r *ImageRenderer) rewriteContainers(v interface{}) (bool, error) {
+	containers, ok := v.([]interface{})
+	if !ok {
+		return false, nil
+	}
+	changed := false
+	for _, c := range containers {
+		container, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		image, ok := container["image"].(string)
+		if !ok || image == "" {
+			continue
+		}
+		rewritten, err := r.rewrite(image)
+		if err != nil {
+			return false, stderrors.WithMessage(err, fmt.Sprintf("image %s", image))
+		}
+		if rewritten != image {
+			log.Debugf("Rewrite image %s to %s in release %s", image, rewritten, r.release)
+			container["image"] = rewritten
+			changed = true
+		}
+	}
+	return changed, nil
+}
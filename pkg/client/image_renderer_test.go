@@ -0,0 +1,51 @@
+package client
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestImageRenderer(t *testing.T) {
+	manifests := testManifests + `---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: choerodon-iam-init
+spec:
+  initContainers:
+  - name: wait
+    image: busybox:1.31
+  containers:
+  - name: main
+    image: choerodon-iam:1.1.0
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: choerodon-iam
+data:
+  image: choerodon-iam:1.1.0
+`
+	renderer := NewImageRenderer("choerodon-iam", func(image string) (string, error) {
+		return "harbor.example.com/c7n/" + image, nil
+	})
+	out, err := renderer.Run(bytes.NewBufferString(manifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := out.String()
+	for _, expected := range []string{
+		"image: harbor.example.com/c7n/choerodon-iam:1.1.0",
+		"image: harbor.example.com/c7n/busybox:1.31",
+		"  image: choerodon-iam:1.1.0\n",
+		"kind: Service",
+	} {
+		if !strings.Contains(result, expected) {
+			t.Errorf("expected %q in rendered manifests:\n%s", expected, result)
+		}
+	}
+	if n := strings.Count(result, "harbor.example.com/c7n/"); n != 3 {
+		t.Errorf("expected 3 rewritten images, got %d:\n%s", n, result)
+	}
+}
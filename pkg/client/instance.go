@@ -0,0 +1,159 @@
+package client
+
+import (
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/common/consts"
+	stderrors "github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 实例名称会添加到 slaver 等资源的名称中，需要留出长度
+const maxInstanceLength = 40
+
+// Instance 是集群中的一个 choerodon 安装
+type Instance struct {
+	Name      string
+	Namespace string
+	Prefix    string
+	Version   string
+	Tasks     int
+	Succeed   int
+	Failed    int
+	// 最近一个 task 的创建时间
+	Updated time.Time
+}
+
+// DefaultInstance 返回默认的实例名称：没有前缀时为命名空间，否则为 <prefix>-<namespace>
+func DefaultInstance(prefix, namespace string) string {
+	if prefix == "" {
+		return namespace
+	}
+	return fmt.Sprintf("%s-%s", prefix, namespace)
+}
+
+// IsDefaultInstance 判断 instance 是否是命名空间中没有前缀的实例，默认实例的资源不添加 InstanceLabel，与旧版本兼容
+func IsDefaultInstance(instance, namespace string) bool {
+	return instance == "" || instance == namespace
+}
+
+// ValidateInstance 检查实例名称是否可以用于资源名称和 label
+func ValidateInstance(instance string) error {
+	if errs := validation.IsDNS1123Label(instance); len(errs) > 0 {
+		return stderrors.Errorf("invalid instance name %s: %s", instance, strings.Join(errs, ", "))
+	}
+	if len(instance) > maxInstanceLength {
+		return stderrors.Errorf("invalid instance name %s: must be no more than %d characters", instance, maxInstanceLength)
+	}
+	return nil
+}
+
+// ListInstances 返回集群中所有命名空间的实例，只支持保存在 configMap 和 secret 中的 task
+func ListInstances(client kubernetes.Interface, backend string) ([]Instance, error) {
+	return listInstances(client, backend, metav1.NamespaceAll)
+}
+
+// ListNamespaceInstances 返回 namespace 中的实例，只需要命名空间的权限
+func ListNamespaceInstances(client kubernetes.Interface, backend, namespace string) ([]Instance, error) {
+	return listInstances(client, backend, namespace)
+}
+
+func listInstances(client kubernetes.Interface, backend, namespace string) ([]Instance, error) {
+	client = validClient(client)
+	if client == nil {
+		return nil, stderrors.New("kubernetes client is not initialized")
+	}
+	var objects stateObjects
+	switch backend {
+	case "", StateBackendConfigMap:
+		objects = &configMapObjects{client: client, namespace: namespace}
+	case StateBackendSecret:
+		objects = &secretObjects{client: client, namespace: namespace}
+	default:
+		return nil, stderrors.Errorf("listing instances is not supported by the %s state backend", backend)
+	}
+	cms, err := objects.list(TaskTypeLabel)
+	if err != nil {
+		return nil, stderrors.WithMessage(err, "Failed to list tasks")
+	}
+
+	instances := map[string]*Instance{}
+	for idx := range cms {
+		cm := &cms[idx]
+		name := cm.Labels[consts.InstanceLabel]
+		if name == "" {
+			name = cm.Namespace
+		}
+		key := cm.Namespace + "/" + name
+		ins, ok := instances[key]
+		if !ok {
+			ins = &Instance{Name: name, Namespace: cm.Namespace}
+			instances[key] = ins
+		}
+		task, err := decodeTask(cm)
+		if err != nil {
+			return nil, err
+		}
+		ins.Tasks++
+		switch task.Status {
+		case consts.SucceedStatus:
+			ins.Succeed++
+		case consts.FailedStatus:
+			ins.Failed++
+		}
+		if task.Type == consts.StaticReleaseKey {
+			if task.Prefix != "" {
+				ins.Prefix = task.Prefix
+			}
+			if task.Version != "" {
+				ins.Version = task.Version
+			}
+		}
+		if task.Date.After(ins.Updated) {
+			ins.Updated = task.Date
+		}
+	}
+
+	result := make([]Instance, 0, len(instances))
+	for _, ins := range instances {
+		result = append(result, *ins)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// FindInstance 返回 namespace 中唯一的实例。没有实例或者 backend 不支持列出实例时返回默认实例，有多个实例时返回错误
+func FindInstance(client kubernetes.Interface, backend, namespace string) (string, error) {
+	switch backend {
+	case "", StateBackendConfigMap, StateBackendSecret:
+	default:
+		return DefaultInstance("", namespace), nil
+	}
+	if validClient(client) == nil {
+		return DefaultInstance("", namespace), nil
+	}
+	instances, err := ListNamespaceInstances(client, backend, namespace)
+	if err != nil {
+		return "", err
+	}
+	switch len(instances) {
+	case 0:
+		return DefaultInstance("", namespace), nil
+	case 1:
+		return instances[0].Name, nil
+	}
+	names := make([]string, 0, len(instances))
+	for _, ins := range instances {
+		names = append(names, ins.Name)
+	}
+	return "", stderrors.Errorf("found instances %s in namespace %s, please specify one with --instance", strings.Join(names, ", "), namespace)
+}
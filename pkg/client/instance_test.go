@@ -0,0 +1,195 @@
+package client
+
+import (
+	"context"
+	"github.com/choerodon/c7nctl/pkg/common/consts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+	"time"
+)
+
+func TestInstances(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	save := func(instance, namespace, prefix string) StateStore {
+		store, err := NewStateStore(StateOptions{Client: client, Namespace: namespace, Instance: instance})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = store.Update("gitlab", func(task *TaskInfo) error {
+			*task = *NewReleaseTask("gitlab", namespace, "1.0", prefix)
+			task.Status = consts.SucceedStatus
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	}
+	def := save(DefaultInstance("", "c7n-system"), "c7n-system", "")
+	staging := save(DefaultInstance("staging", "c7n-system"), "c7n-system", "staging")
+	save("", "other", "")
+
+	// 不同实例的 task 相互独立
+	if _, err := staging.Update("predb", func(task *TaskInfo) error {
+		task.Type = consts.StaticTaskKey
+		task.Status = consts.FailedStatus
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if tasks, _ := def.List(); len(tasks) != 1 {
+		t.Errorf("want 1 task in the default instance, got %+v", tasks)
+	}
+	if tasks, _ := staging.List(); len(tasks) != 2 {
+		t.Errorf("want 2 tasks in the staging instance, got %+v", tasks)
+	}
+	// 默认实例使用原来的名称，不添加 label
+	cm, err := client.CoreV1().ConfigMaps("c7n-system").Get(context.Background(), "c7n-logs-gitlab", metav1.GetOptions{})
+	if err != nil || cm.Labels[consts.InstanceLabel] != "" {
+		t.Errorf("unexpected configMap %+v %v", cm, err)
+	}
+	cm, err = client.CoreV1().ConfigMaps("c7n-system").Get(context.Background(), "c7n-logs.staging-c7n-system.gitlab", metav1.GetOptions{})
+	if err != nil || cm.Labels[consts.InstanceLabel] != "staging-c7n-system" {
+		t.Errorf("unexpected configMap %+v %v", cm, err)
+	}
+
+	// 执行记录和锁也属于实例
+	if err = staging.SaveRun(&RunRecord{ID: "run-1", StartTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if runs, _ := def.ListRuns(); len(runs) != 0 {
+		t.Errorf("want no runs in the default instance, got %+v", runs)
+	}
+	unlock, err := def.Lock("alice@host/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	unlockStaging, err := staging.Lock("bob@host/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 锁的 lease 使用实例的 labels
+	leases, err := client.CoordinationV1().Leases("c7n-system").List(context.Background(), metav1.ListOptions{LabelSelector: consts.InstanceLabel + "=staging-c7n-system"})
+	if err != nil || len(leases.Items) != 1 {
+		t.Errorf("want the lease of the staging instance, got %+v %v", leases, err)
+	}
+	unlockStaging()
+
+	instances, err := ListInstances(client, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 3 {
+		t.Fatalf("want 3 instances, got %+v", instances)
+	}
+	ins := instances[0]
+	if ins.Name != "c7n-system" || ins.Namespace != "c7n-system" || ins.Tasks != 1 || ins.Version != "1.0" {
+		t.Errorf("unexpected instance %+v", ins)
+	}
+	ins = instances[1]
+	if ins.Name != "staging-c7n-system" || ins.Prefix != "staging" || ins.Tasks != 2 || ins.Succeed != 1 || ins.Failed != 1 {
+		t.Errorf("unexpected instance %+v", ins)
+	}
+	if instances[2].Namespace != "other" {
+		t.Errorf("unexpected instance %+v", instances[2])
+	}
+
+	if err = ValidateInstance("Staging_1"); err == nil {
+		t.Error("want error for invalid instance name")
+	}
+}
+
+func TestFindInstance(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	for _, ins := range []struct{ name, namespace string }{
+		{DefaultInstance("dev", "team"), "team"},
+		{DefaultInstance("", "c7n-system"), "c7n-system"},
+		{DefaultInstance("staging", "c7n-system"), "c7n-system"},
+	} {
+		store, err := NewStateStore(StateOptions{Client: client, Namespace: ins.namespace, Instance: ins.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = store.Update("gitlab", func(task *TaskInfo) error {
+			task.Type = consts.StaticReleaseKey
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 命名空间中只有使用前缀安装的实例时使用该实例
+	if ins, err := FindInstance(client, "", "team"); err != nil || ins != "dev-team" {
+		t.Errorf("want instance dev-team, got %s %v", ins, err)
+	}
+	if ins, err := FindInstance(client, "", "empty"); err != nil || ins != "empty" {
+		t.Errorf("want the default instance, got %s %v", ins, err)
+	}
+	if _, err := FindInstance(client, "", "c7n-system"); err == nil {
+		t.Error("want error for several instances")
+	}
+	if ins, err := FindInstance(client, StateBackendFile, "team"); err != nil || ins != "team" {
+		t.Errorf("want the default instance for the file backend, got %s %v", ins, err)
+	}
+}
+
+func TestInstanceObjectNames(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := func(instance string) StateStore {
+		s, err := NewStateStore(StateOptions{Client: client, Namespace: "c7n-system", Instance: instance})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	def, staging := store(""), store("staging")
+
+	// 默认实例的 staging-foo 和 staging 实例的 foo 使用不同的对象
+	for _, c := range []struct {
+		store StateStore
+		name  string
+	}{{def, "staging-foo"}, {staging, "foo"}} {
+		c := c
+		if _, err := c.store.Update(c.name, func(task *TaskInfo) error {
+			task.Type = consts.StaticTaskKey
+			task.RefName = c.name
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if task, err := def.Get("staging-foo"); err != nil || task.RefName != "staging-foo" {
+		t.Errorf("unexpected task %+v %v", task, err)
+	}
+	if task, err := staging.Get("foo"); err != nil || task.RefName != "foo" {
+		t.Errorf("unexpected task %+v %v", task, err)
+	}
+
+	// 旧版本中 staging 实例的 foo 保存在 c7n-logs-staging-foo，默认实例不能读取、覆盖或者删除其他实例的对象
+	legacy := store("legacy")
+	if _, err := legacy.Update("foo", func(task *TaskInfo) error {
+		task.Type = consts.StaticTaskKey
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cm, err := client.CoreV1().ConfigMaps("c7n-system").Get(context.Background(), "c7n-logs.legacy.foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.ResourceVersion = ""
+	cm.Name = "c7n-logs-legacy-foo"
+	if _, err = client.CoreV1().ConfigMaps("c7n-system").Create(context.Background(), cm, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = def.Get("legacy-foo"); err == nil {
+		t.Error("want error for a task of another instance")
+	}
+	if _, err = def.Update("legacy-foo", func(task *TaskInfo) error { return nil }); err == nil {
+		t.Error("want error when overwriting a task of another instance")
+	}
+	if err = def.Delete("legacy-foo"); err == nil {
+		t.Error("want error when deleting a task of another instance")
+	}
+}
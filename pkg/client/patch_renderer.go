@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/config"
+	jsonpatch "github.com/evanphx/json-patch"
+	stderrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/postrender"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"path"
+	"regexp"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+var manifestSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// PatchRenderer 是 helm 的 post-renderer，将补丁应用到渲染后的 manifest
+type PatchRenderer struct {
+	release string
+	patches []compiledPatch
+}
+
+type compiledPatch struct {
+	config.Patch
+	selector  labels.Selector
+	patch     []byte
+	jsonPatch jsonpatch.Patch
+}
+
+type manifestObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name   string            `json:"name"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+}
+
+// NewPatchRenderer 返回应用到 release 的补丁的 post-renderer，没有补丁时返回 nil
+func NewPatchRenderer(release string, patches []config.Patch) (*PatchRenderer, error) {
+	r := &PatchRenderer{release: release}
+	for idx, p := range patches {
+		if len(p.Releases) > 0 && !containsString(p.Releases, release) {
+			continue
+		}
+		cp, err := compilePatch(p)
+		if err != nil {
+			return nil, stderrors.WithMessage(err, fmt.Sprintf("invalid patch %d", idx))
+		}
+		r.patches = append(r.patches, cp)
+	}
+	if len(r.patches) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+func compilePatch(p config.Patch) (compiledPatch, error) {
+	cp := compiledPatch{Patch: p, selector: labels.Everything()}
+	var err error
+	if p.Target.LabelSelector != "" {
+		if cp.selector, err = labels.Parse(p.Target.LabelSelector); err != nil {
+			return cp, err
+		}
+	}
+	if p.Target.Name != "" {
+		if _, err = path.Match(p.Target.Name, ""); err != nil {
+			return cp, err
+		}
+	}
+	switch {
+	case p.Patch != "" && p.JSONPatch != "":
+		return cp, stderrors.New("only one of patch and json-patch can be set")
+	case p.Patch != "":
+		if cp.patch, err = yaml.YAMLToJSON([]byte(p.Patch)); err != nil {
+			return cp, err
+		}
+	case p.JSONPatch != "":
+		data, err := yaml.YAMLToJSON([]byte(p.JSONPatch))
+		if err != nil {
+			return cp, err
+		}
+		if cp.jsonPatch, err = jsonpatch.DecodePatch(data); err != nil {
+			return cp, err
+		}
+	default:
+		return cp, stderrors.New("patch or json-patch is required")
+	}
+	return cp, nil
+}
+
+func (p *compiledPatch) matches(obj *manifestObject) bool {
+	if p.Target.Kind != "" && !strings.EqualFold(p.Target.Kind, obj.Kind) {
+		return false
+	}
+	if p.Target.Name != "" {
+		if ok, _ := path.Match(p.Target.Name, obj.Metadata.Name); !ok {
+			return false
+		}
+	}
+	return p.selector.Matches(labels.Set(obj.Metadata.Labels))
+}
+
+func (p *compiledPatch) apply(obj *manifestObject, doc []byte) ([]byte, error) {
+	if p.jsonPatch != nil {
+		return p.jsonPatch.Apply(doc)
+	}
+	gvk := schema.FromAPIVersionAndKind(obj.APIVersion, obj.Kind)
+	typed, err := scheme.Scheme.New(gvk)
+	if err != nil {
+		// CRD 等没有结构定义的资源使用 JSON merge patch
+		return jsonpatch.MergePatch(doc, p.patch)
+	}
+	return strategicpatch.StrategicMergePatch(doc, p.patch, typed)
+}
+
+// Run 实现 postrender.PostRenderer，没有匹配补丁的资源保持原样
+func (r *PatchRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	docs := manifestSeparator.Split(renderedManifests.String(), -1)
+	out := &bytes.Buffer{}
+	for idx, doc := range docs {
+		patched, err := r.patch(doc)
+		if err != nil {
+			return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to patch manifests of release %s", r.release))
+		}
+		if idx > 0 {
+			out.WriteString("---\n")
+		}
+		out.WriteString(patched)
+	}
+	return out, nil
+}
+
+func (r *PatchRenderer) patch(doc string) (string, error) {
+	if strings.TrimSpace(doc) == "" {
+		return doc, nil
+	}
+	data, err := yaml.YAMLToJSON([]byte(doc))
+	if err != nil {
+		return "", err
+	}
+	obj := &manifestObject{}
+	if err = yaml.Unmarshal([]byte(doc), obj); err != nil || obj.Kind == "" {
+		return doc, nil
+	}
+	patched := false
+	for idx := range r.patches {
+		p := &r.patches[idx]
+		if !p.matches(obj) {
+			continue
+		}
+		if data, err = p.apply(obj, data); err != nil {
+			return "", stderrors.WithMessage(err, fmt.Sprintf("%s %s", obj.Kind, obj.Metadata.Name))
+		}
+		log.Debugf("Patched %s %s of release %s", obj.Kind, obj.Metadata.Name, r.release)
+		patched = true
+	}
+	if !patched {
+		return doc, nil
+	}
+	result, err := yaml.JSONToYAML(data)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// ChainPostRenderers 依次执行 renderers，忽略 nil
+func ChainPostRenderers(renderers ...postrender.PostRenderer) postrender.PostRenderer {
+	var chain postRendererChain
+	for _, r := range renderers {
+		if r != nil && !isNilRenderer(r) {
+			chain = append(chain, r)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return chain
+}
+
+func isNilRenderer(r postrender.PostRenderer) bool {
+	switch p := r.(type) {
+	case *PatchRenderer:
+		return p == nil
+	case *ImageRenderer:
+		return p == nil
+	}
+	return false
+}
+
+type postRendererChain []postrender.PostRenderer
+
+func (c postRendererChain) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	var err error
+	for _, r := range c {
+		if renderedManifests, err = r.Run(renderedManifests); err != nil {
+			return nil, err
+		}
+	}
+	return renderedManifests, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"bytes"
+	"github.com/choerodon/c7nctl/pkg/config"
+	"strings"
+	"testing"
+)
+
+const testManifests = `---
+# Source: c7n/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: choerodon-iam
+  labels:
+    app: choerodon-iam
+spec:
+  template:
+    spec:
+      containers:
+      - name: choerodon-iam
+        image: choerodon-iam:1.1.0
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: choerodon-iam
+  labels:
+    app: choerodon-iam
+spec:
+  ports:
+  - port: 8030
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: choerodon-iam-widget
+spec:
+  size: 1
+`
+
+func TestPatchRenderer(t *testing.T) {
+	patches := []config.Patch{
+		{
+			Target: config.PatchTarget{Kind: "Deployment", LabelSelector: "app=choerodon-iam"},
+			Patch: `
+spec:
+  template:
+    spec:
+      containers:
+      - name: choerodon-iam
+        resources:
+          limits:
+            memory: 2Gi
+      nodeSelector:
+        role: c7n`,
+		},
+		{
+			Target:    config.PatchTarget{Kind: "Service", Name: "choerodon-*"},
+			JSONPatch: `[{"op": "add", "path": "/metadata/annotations", "value": {"c7n/patched": "true"}}]`,
+		},
+		{
+			Target: config.PatchTarget{Kind: "Widget"},
+			Patch:  "spec:\n  size: 3",
+		},
+		{
+			Releases: []string{"choerodon-asgard"},
+			Patch:    "metadata:\n  labels:\n    asgard: \"true\"",
+		},
+	}
+	r, err := NewPatchRenderer("choerodon-iam", patches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := r.Run(bytes.NewBufferString(testManifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := out.String()
+	for _, s := range []string{"image: choerodon-iam:1.1.0", "memory: 2Gi", "role: c7n", "c7n/patched: \"true\"", "size: 3"} {
+		if !strings.Contains(result, s) {
+			t.Errorf("expected %q in patched manifests:\n%s", s, result)
+		}
+	}
+	if strings.Contains(result, "asgard") {
+		t.Errorf("patch of other release is applied:\n%s", result)
+	}
+	if n := strings.Count(result, "---\n"); n != 3 {
+		t.Errorf("expected 3 separators, got %d", n)
+	}
+
+	if r, err = NewPatchRenderer("choerodon-front", patches[3:]); err != nil || r != nil {
+		t.Errorf("expected no renderer, got %v %v", r, err)
+	}
+	invalid := []config.Patch{
+		{Patch: "a: b", JSONPatch: "[]"},
+		{},
+		{Target: config.PatchTarget{LabelSelector: "app in ("}, Patch: "a: b"},
+		{JSONPatch: "{}"},
+	}
+	for _, p := range invalid {
+		if _, err = NewPatchRenderer("choerodon-iam", []config.Patch{p}); err == nil {
+			t.Errorf("expected an error of invalid patch %+v", p)
+		}
+	}
+}
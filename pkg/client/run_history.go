@@ -0,0 +1,193 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/common/consts"
+	"github.com/ghodss/yaml"
+	stderrors "github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"os/user"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 执行记录保存在 c7n-run-<id> 中，并添加该 label
+	RunLabel   = "choerodon.io/c7n-run"
+	runDataKey = "run"
+)
+
+// RunRecord 是一次修改集群的 c7nctl 执行记录
+type RunRecord struct {
+	ID string
+	// 隐藏了敏感信息的命令行
+	Command       string
+	Operator      string
+	Host          string
+	C7nctlVersion string
+	// choerodon 的版本
+	Version   string
+	Namespace string
+	Instance  string `json:",omitempty"`
+	StartTime time.Time
+	EndTime   time.Time
+	Duration  string
+	Status    string
+	Reason    string `json:",omitempty"`
+	// 执行过程中保存过的 task 的最终状态
+	Tasks []TaskResult `json:",omitempty"`
+}
+
+type TaskResult struct {
+	Name   string
+	Type   string
+	Status string
+	Reason string `json:",omitempty"`
+	// release 在本次执行后的 helm 版本
+	Revision int `json:",omitempty"`
+}
+
+var (
+	runMu      sync.Mutex
+	currentRun *RunRecord
+)
+
+// StartRun 开始记录一次执行，之后保存的 task 都会记录到其中，调用 FinishRun 保存执行记录
+func StartRun(r RunRecord) *RunRecord {
+	runMu.Lock()
+	defer runMu.Unlock()
+	r.StartTime = time.Now()
+	// 同一秒内可能有多次执行
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	r.ID = fmt.Sprintf("%s-%s", r.StartTime.Format("20060102-150405"), hex.EncodeToString(suffix))
+	if r.Operator == "" {
+		if u, err := user.Current(); err == nil {
+			r.Operator = u.Username
+		}
+	}
+	if r.Host == "" {
+		r.Host, _ = os.Hostname()
+	}
+	currentRun = &r
+	return currentRun
+}
+
+// recordTask 将 task 的状态记录到当前的执行记录中
+func recordTask(t *TaskInfo) {
+	runMu.Lock()
+	defer runMu.Unlock()
+	if currentRun == nil {
+		return
+	}
+	result := TaskResult{Name: t.Name, Type: t.Type, Status: t.Status, Reason: t.Reason, Revision: t.Revision}
+	for idx := range currentRun.Tasks {
+		if currentRun.Tasks[idx].Name == t.Name {
+			currentRun.Tasks[idx] = result
+			return
+		}
+	}
+	currentRun.Tasks = append(currentRun.Tasks, result)
+}
+
+// FinishRun 根据 err 设置执行结果并保存执行记录
+func FinishRun(r *RunRecord, err error) error {
+	runMu.Lock()
+	if currentRun == r {
+		currentRun = nil
+	}
+	r.EndTime = time.Now()
+	r.Duration = r.EndTime.Sub(r.StartTime).Round(time.Second).String()
+	r.Status = consts.SucceedStatus
+	if err != nil {
+		r.Status = consts.FailedStatus
+		r.Reason = err.Error()
+	}
+	runMu.Unlock()
+	return stateStore.SaveRun(r)
+}
+
+// ListRuns 返回所有的执行记录，最近的在前
+func ListRuns() ([]RunRecord, error) {
+	return stateStore.ListRuns()
+}
+
+// GetRun 返回执行记录，id 可以是前缀
+func GetRun(id string) (*RunRecord, error) {
+	runs, err := stateStore.ListRuns()
+	if err != nil {
+		return nil, err
+	}
+	var found []RunRecord
+	for _, r := range runs {
+		if r.ID == id {
+			return &r, nil
+		}
+		if strings.HasPrefix(r.ID, id) {
+			found = append(found, r)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, stderrors.Errorf("run %s is not found", id)
+	case 1:
+		return &found[0], nil
+	}
+	return nil, stderrors.Errorf("run %s is ambiguous, %d runs match", id, len(found))
+}
+
+func (c *C7nLogs) SaveRun(r *RunRecord) error {
+	if err := c.checkClient(); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l := c.commonLabels()
+	l[RunLabel] = "true"
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "c7n-run-" + r.ID,
+			Namespace: c.namespace,
+			Labels:    l,
+		},
+		Data: map[string]string{runDataKey: string(data)},
+	}
+	if err = c.objects.create(cm); k8serrors.IsAlreadyExists(err) {
+		err = c.objects.update(cm)
+	}
+	if err != nil {
+		return stderrors.WithMessage(err, fmt.Sprintf("Failed to save run %s", r.ID))
+	}
+	return nil
+}
+
+func (c *C7nLogs) ListRuns() ([]RunRecord, error) {
+	if err := c.checkClient(); err != nil {
+		return nil, err
+	}
+	cms, err := c.objects.list(RunLabel)
+	if err != nil {
+		return nil, stderrors.WithMessage(err, "Failed to list runs")
+	}
+	runs := make([]RunRecord, 0, len(cms))
+	for _, cm := range cms {
+		if !c.owns(&cm) {
+			continue
+		}
+		var r RunRecord
+		if err := yaml.Unmarshal([]byte(cm.Data[runDataKey]), &r); err != nil {
+			return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to decode run in %s", cm.Name))
+		}
+		runs = append(runs, r)
+	}
+	sortRuns(runs)
+	return runs, nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"github.com/choerodon/c7nctl/pkg/common/consts"
+	"k8s.io/client-go/kubernetes/fake"
+	"testing"
+)
+
+func TestRunHistory(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	if err := InitC7nLogs(client, "c7n-system"); err != nil {
+		t.Fatal(err)
+	}
+	// 执行开始前保存的 task 不记录
+	if _, err := SaveTask(TaskInfo{Name: "before", Type: consts.StaticReleaseKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	run := StartRun(RunRecord{Command: "c7nctl install c7n", Version: "1.0", Namespace: "c7n-system"})
+	if run.ID == "" || run.Operator == "" {
+		t.Errorf("want id and operator set, got %+v", run)
+	}
+	if _, err := SaveTask(TaskInfo{Name: "gitlab", Type: consts.StaticReleaseKey, Status: consts.RenderedStatus}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SaveTask(TaskInfo{Name: "gitlab", Type: consts.StaticReleaseKey, Status: consts.FailedStatus, Reason: "timeout"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := FinishRun(run, errors.New("install gitlab failed")); err != nil {
+		t.Fatal(err)
+	}
+	// 执行结束后保存的 task 不记录
+	if _, err := SaveTask(TaskInfo{Name: "after", Type: consts.StaticReleaseKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	runs, err := ListRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("want 1 run, got %d", len(runs))
+	}
+	got, err := GetRun(run.ID[:8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != consts.FailedStatus || got.Reason != "install gitlab failed" || got.Command != "c7nctl install c7n" {
+		t.Errorf("unexpected run %+v", got)
+	}
+	if len(got.Tasks) != 1 || got.Tasks[0].Name != "gitlab" || got.Tasks[0].Status != consts.FailedStatus || got.Tasks[0].Reason != "timeout" {
+		t.Errorf("unexpected tasks %+v", got.Tasks)
+	}
+	if _, err := GetRun("not-exist"); err == nil {
+		t.Error("want error for an unknown run")
+	}
+}
@@ -0,0 +1,208 @@
+package client
+
+import (
+	"fmt"
+	"github.com/ghodss/yaml"
+	stderrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore 将 task 保存在本地的 yaml 文件中，每次读写都会重新读取文件，可以提交到 git 仓库中管理
+type FileStore struct {
+	Path      string
+	namespace string
+	mu        sync.Mutex
+}
+
+func NewFileStore(path, namespace string) *FileStore {
+	return &FileStore{
+		Path:      path,
+		namespace: namespace,
+	}
+}
+
+func (f *FileStore) Namespace() string {
+	return f.namespace
+}
+
+func (f *FileStore) load() (*stateData, error) {
+	state := &stateData{Namespace: f.namespace}
+	data, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to read state file %s", f.Path))
+	}
+	if err = yaml.Unmarshal(data, state); err != nil {
+		return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to decode state file %s", f.Path))
+	}
+	return state, nil
+}
+
+// save 先写入临时文件再重命名，避免 c7nctl 中断时文件不完整
+func (f *FileStore) save(state *stateData) error {
+	data, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return stderrors.WithMessage(err, fmt.Sprintf("Failed to create directory of state file %s", f.Path))
+	}
+	tmp := f.Path + ".tmp"
+	// task 中可能有数据库密码
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return stderrors.WithMessage(err, fmt.Sprintf("Failed to write state file %s", f.Path))
+	}
+	if err = os.Rename(tmp, f.Path); err != nil {
+		return stderrors.WithMessage(err, fmt.Sprintf("Failed to write state file %s", f.Path))
+	}
+	return nil
+}
+
+func (f *FileStore) Get(name string) (*TaskInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return state.get(name)
+}
+
+func (f *FileStore) Update(name string, mutate func(task *TaskInfo) error) (*TaskInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	task, err := state.update(name, mutate)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.save(state); err != nil {
+		return nil, err
+	}
+	log.Debugf("Saved task %s", name)
+	return task, nil
+}
+
+func (f *FileStore) List() ([]TaskInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return state.list()
+}
+
+func (f *FileStore) Delete(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, err := f.load()
+	if err != nil {
+		return err
+	}
+	if state.index(name) < 0 {
+		return nil
+	}
+	state.delete(name)
+	return f.save(state)
+}
+
+func (f *FileStore) SaveRun(r *RunRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, err := f.load()
+	if err != nil {
+		return err
+	}
+	state.saveRun(r)
+	return f.save(state)
+}
+
+func (f *FileStore) ListRuns() ([]RunRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return state.listRuns(), nil
+}
+
+// Lock 创建 <Path>.lock 文件作为锁，持有锁时定期更新文件的修改时间，超过有效期没有更新的锁可以被其他 c7nctl 获取
+func (f *FileStore) Lock(holder string) (func(), error) {
+	lockFile := f.Path + ".lock"
+	if err := os.MkdirAll(filepath.Dir(lockFile), 0755); err != nil {
+		return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to lock state file %s", f.Path))
+	}
+	for {
+		file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = file.WriteString(holder)
+			file.Close()
+			if err != nil {
+				os.Remove(lockFile)
+				return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to lock state file %s", f.Path))
+			}
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to lock state file %s", f.Path))
+		}
+		info, statErr := os.Stat(lockFile)
+		if statErr != nil {
+			// 锁刚好被释放
+			continue
+		}
+		current, _ := ioutil.ReadFile(lockFile)
+		if time.Since(info.ModTime()) < lockDuration && strings.TrimSpace(string(current)) != holder {
+			return nil, stderrors.Errorf("state file %s is locked by %s since %s, wait for it to finish or retry after %s",
+				f.Path, strings.TrimSpace(string(current)), info.ModTime().Format(time.RFC3339), lockDuration)
+		}
+		// 过期的锁
+		if err = os.Remove(lockFile); err != nil && !os.IsNotExist(err) {
+			return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to lock state file %s", f.Path))
+		}
+	}
+	log.Debugf("Locked state file %s as %s", f.Path, holder)
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				now := time.Now()
+				if err := os.Chtimes(lockFile, now, now); err != nil {
+					log.Warnf("Failed to renew the lock of state file %s: %s", f.Path, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stopCh)
+		<-done
+		// 只释放自己持有的锁
+		if current, err := ioutil.ReadFile(lockFile); err == nil && strings.TrimSpace(string(current)) == holder {
+			if err = os.Remove(lockFile); err != nil {
+				log.Warnf("Failed to release the lock of state file %s: %s", f.Path, err)
+			}
+		}
+	}, nil
+}
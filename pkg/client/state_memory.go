@@ -0,0 +1,169 @@
+package client
+
+import (
+	"fmt"
+	c7nerrors "github.com/choerodon/c7nctl/pkg/common/errors"
+	"github.com/ghodss/yaml"
+	stderrors "github.com/pkg/errors"
+	"sync"
+)
+
+// MemoryStore 只在内存中保存 task，c7nctl 退出后丢失，用于 dry run
+type MemoryStore struct {
+	namespace string
+	mu        sync.Mutex
+	state     stateData
+}
+
+// stateData 是 file 方式保存的文件内容
+type stateData struct {
+	Namespace string
+	Tasks     []TaskInfo
+	Runs      []RunRecord
+}
+
+func NewMemoryStore(namespace string) *MemoryStore {
+	return &MemoryStore{
+		namespace: namespace,
+		state:     stateData{Namespace: namespace},
+	}
+}
+
+func (m *MemoryStore) Namespace() string {
+	return m.namespace
+}
+
+func (m *MemoryStore) Get(name string) (*TaskInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.get(name)
+}
+
+func (m *MemoryStore) Update(name string, mutate func(task *TaskInfo) error) (*TaskInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.update(name, mutate)
+}
+
+func (m *MemoryStore) List() ([]TaskInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.list()
+}
+
+func (m *MemoryStore) Delete(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.delete(name)
+	return nil
+}
+
+func (m *MemoryStore) SaveRun(r *RunRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.saveRun(r)
+	return nil
+}
+
+func (m *MemoryStore) ListRuns() ([]RunRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.listRuns(), nil
+}
+
+// Lock 内存中的 task 只有当前进程可以修改，不需要加锁
+func (m *MemoryStore) Lock(holder string) (func(), error) {
+	return func() {}, nil
+}
+
+func (s *stateData) index(name string) int {
+	for idx := range s.Tasks {
+		if s.Tasks[idx].Name == name {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (s *stateData) get(name string) (*TaskInfo, error) {
+	idx := s.index(name)
+	if idx < 0 {
+		return nil, stderrors.WithMessage(c7nerrors.TaskInfoIsNotFoundError, fmt.Sprintf("Task %s isn't in the state", name))
+	}
+	return copyTask(&s.Tasks[idx])
+}
+
+func (s *stateData) update(name string, mutate func(task *TaskInfo) error) (*TaskInfo, error) {
+	task := &TaskInfo{Name: name}
+	idx := s.index(name)
+	if idx >= 0 {
+		var err error
+		if task, err = copyTask(&s.Tasks[idx]); err != nil {
+			return nil, err
+		}
+	}
+	if err := mutate(task); err != nil {
+		return nil, stderrors.WithMessage(err, fmt.Sprintf("Failed to save task %s", name))
+	}
+	if task.Name != name {
+		return nil, stderrors.Errorf("task name can't be changed from %s to %s", name, task.Name)
+	}
+	// 保存副本，避免调用者修改返回的 task 后影响保存的数据
+	saved, err := copyTask(task)
+	if err != nil {
+		return nil, err
+	}
+	if idx >= 0 {
+		s.Tasks[idx] = *saved
+	} else {
+		s.Tasks = append(s.Tasks, *saved)
+	}
+	return task, nil
+}
+
+func (s *stateData) list() ([]TaskInfo, error) {
+	tasks := make([]TaskInfo, 0, len(s.Tasks))
+	for idx := range s.Tasks {
+		task, err := copyTask(&s.Tasks[idx])
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, *task)
+	}
+	sortTasks(tasks)
+	return tasks, nil
+}
+
+func (s *stateData) delete(name string) {
+	if idx := s.index(name); idx >= 0 {
+		s.Tasks = append(s.Tasks[:idx], s.Tasks[idx+1:]...)
+	}
+}
+
+func (s *stateData) saveRun(r *RunRecord) {
+	for idx := range s.Runs {
+		if s.Runs[idx].ID == r.ID {
+			s.Runs[idx] = *r
+			return
+		}
+	}
+	s.Runs = append(s.Runs, *r)
+}
+
+func (s *stateData) listRuns() []RunRecord {
+	runs := append([]RunRecord{}, s.Runs...)
+	sortRuns(runs)
+	return runs
+}
+
+func copyTask(t *TaskInfo) (*TaskInfo, error) {
+	data, err := yaml.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	task := &TaskInfo{}
+	if err = yaml.Unmarshal(data, task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// stateObjects 读写保存 task 的 kubernetes 对象，统一转换成 configMap 处理
+type stateObjects interface {
+	resource() schema.GroupResource
+	get(name string) (*v1.ConfigMap, error)
+	create(cm *v1.ConfigMap) error
+	update(cm *v1.ConfigMap) error
+	list(selector string) ([]v1.ConfigMap, error)
+	delete(name string) error
+}
+
+type configMapObjects struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func (o *configMapObjects) resource() schema.GroupResource {
+	return v1.Resource("configmaps")
+}
+
+func (o *configMapObjects) get(name string) (*v1.ConfigMap, error) {
+	return o.client.CoreV1().ConfigMaps(o.namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func (o *configMapObjects) create(cm *v1.ConfigMap) error {
+	_, err := o.client.CoreV1().ConfigMaps(o.namespace).Create(context.Background(), cm, metav1.CreateOptions{})
+	return err
+}
+
+func (o *configMapObjects) update(cm *v1.ConfigMap) error {
+	_, err := o.client.CoreV1().ConfigMaps(o.namespace).Update(context.Background(), cm, metav1.UpdateOptions{})
+	return err
+}
+
+func (o *configMapObjects) list(selector string) ([]v1.ConfigMap, error) {
+	cms, err := o.client.CoreV1().ConfigMaps(o.namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	return cms.Items, nil
+}
+
+func (o *configMapObjects) delete(name string) error {
+	return o.client.CoreV1().ConfigMaps(o.namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+}
+
+// secretObjects 将数据保存在 secret 中，kubernetes 的 RBAC 可以限制读取 secret 的用户
+type secretObjects struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func (o *secretObjects) resource() schema.GroupResource {
+	return v1.Resource("secrets")
+}
+
+func (o *secretObjects) get(name string) (*v1.ConfigMap, error) {
+	secret, err := o.client.CoreV1().Secrets(o.namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return secretToConfigMap(secret), nil
+}
+
+func (o *secretObjects) create(cm *v1.ConfigMap) error {
+	_, err := o.client.CoreV1().Secrets(o.namespace).Create(context.Background(), configMapToSecret(cm), metav1.CreateOptions{})
+	return err
+}
+
+func (o *secretObjects) update(cm *v1.ConfigMap) error {
+	_, err := o.client.CoreV1().Secrets(o.namespace).Update(context.Background(), configMapToSecret(cm), metav1.UpdateOptions{})
+	return err
+}
+
+func (o *secretObjects) list(selector string) ([]v1.ConfigMap, error) {
+	secrets, err := o.client.CoreV1().Secrets(o.namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+	cms := make([]v1.ConfigMap, 0, len(secrets.Items))
+	for idx := range secrets.Items {
+		cms = append(cms, *secretToConfigMap(&secrets.Items[idx]))
+	}
+	return cms, nil
+}
+
+func (o *secretObjects) delete(name string) error {
+	return o.client.CoreV1().Secrets(o.namespace).Delete(context.Background(), name, metav1.DeleteOptions{})
+}
+
+func secretToConfigMap(secret *v1.Secret) *v1.ConfigMap {
+	cm := &v1.ConfigMap{ObjectMeta: secret.ObjectMeta, Data: map[string]string{}}
+	for k, v := range secret.Data {
+		cm.Data[k] = string(v)
+	}
+	return cm
+}
+
+func configMapToSecret(cm *v1.ConfigMap) *v1.Secret {
+	secret := &v1.Secret{ObjectMeta: cm.ObjectMeta, Type: v1.SecretTypeOpaque, Data: map[string][]byte{}}
+	for k, v := range cm.Data {
+		secret.Data[k] = []byte(v)
+	}
+	return secret
+}
@@ -0,0 +1,154 @@
+package client
+
+import (
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/common/consts"
+	stderrors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// 保存在命名空间的 configMap 中，默认的方式
+	StateBackendConfigMap = "configmap"
+	// 保存在命名空间的 secret 中，task 中有数据库密码等敏感信息时使用
+	StateBackendSecret = "secret"
+	// 保存在本地的 yaml 文件中，用于 GitOps 或者不允许创建 configMap 的集群
+	StateBackendFile = "file"
+	// 只保存在内存中，用于 dry run
+	StateBackendMemory = "memory"
+)
+
+var StateBackends = []string{StateBackendConfigMap, StateBackendSecret, StateBackendFile, StateBackendMemory}
+
+// StateStore 保存安装过程中 task 的状态和 c7nctl 的执行记录
+type StateStore interface {
+	// Namespace 返回安装的命名空间
+	Namespace() string
+	// Get 返回 task 的副本，不存在时返回 TaskInfoIsNotFoundError
+	Get(name string) (*TaskInfo, error)
+	// Update 读取 task 并使用 mutate 修改后保存，task 不存在时 mutate 的参数只有名称
+	Update(name string, mutate func(task *TaskInfo) error) (*TaskInfo, error)
+	// List 返回所有的 task，按照类型和名称排序
+	List() ([]TaskInfo, error)
+	// Delete 删除 task，不存在时不返回错误
+	Delete(name string) error
+	SaveRun(r *RunRecord) error
+	// ListRuns 返回所有的执行记录，最近的在前
+	ListRuns() ([]RunRecord, error)
+	// Lock 获取锁，避免多个 c7nctl 同时修改同一个安装，调用返回的函数释放锁
+	Lock(holder string) (func(), error)
+}
+
+// StateOptions 是创建 StateStore 的选项
+type StateOptions struct {
+	Backend string
+	Client  kubernetes.Interface
+	// 使用 file 时保存的文件，默认为 ~/.c7n/state/<instance>.yaml
+	File      string
+	Namespace string
+	// 为空时使用默认实例
+	Instance string
+}
+
+var stateStore StateStore = NewC7nLogs(nil, "")
+
+// NewStateStore 根据 opts.Backend 创建 StateStore，默认使用 configMap
+func NewStateStore(opts StateOptions) (StateStore, error) {
+	switch opts.Backend {
+	case "", StateBackendConfigMap:
+		return NewC7nLogs(opts.Client, opts.Namespace).scope(opts.Instance), nil
+	case StateBackendSecret:
+		return NewSecretC7nLogs(opts.Client, opts.Namespace).scope(opts.Instance), nil
+	case StateBackendFile:
+		file := opts.File
+		if file == "" {
+			instance := opts.Instance
+			if instance == "" {
+				instance = opts.Namespace
+			}
+			file = DefaultStateFile(instance)
+		}
+		return NewFileStore(file, opts.Namespace), nil
+	case StateBackendMemory:
+		return NewMemoryStore(opts.Namespace), nil
+	}
+	return nil, stderrors.Errorf("unsupported state backend %s, must be one of %v", opts.Backend, StateBackends)
+}
+
+// DefaultStateFile 返回 file 方式默认保存实例的文件
+func DefaultStateFile(instance string) string {
+	return filepath.Join(consts.DefaultConfigPath, "state", fmt.Sprintf("%s.yaml", instance))
+}
+
+// InitStateStore 创建并使用 StateStore，使用 configMap 时将旧版本的 c7n-logs 迁移到单独的 configMap 中
+func InitStateStore(opts StateOptions) error {
+	store, err := NewStateStore(opts)
+	if err != nil {
+		return err
+	}
+	if c, ok := store.(*C7nLogs); ok && backendName(opts.Backend) == StateBackendConfigMap {
+		if err = c.migrateLegacy(); err != nil {
+			return err
+		}
+	}
+	log.Debugf("Using %s state backend", backendName(opts.Backend))
+	stateStore = store
+	return nil
+}
+
+// InitC7nLogs 使用命名空间中的 configMap 保存 task，并将旧版本保存在一个 configMap 中的 task 迁移到单独的 configMap 中
+func InitC7nLogs(client kubernetes.Interface, namespace string) error {
+	return InitStateStore(StateOptions{Client: client, Namespace: namespace})
+}
+
+// MigrateState 将 from 中所有的 task 和执行记录复制到 to 中，to 中已经存在的 task 会被覆盖
+func MigrateState(from, to StateStore) (int, error) {
+	tasks, err := from.List()
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range tasks {
+		t := t
+		if _, err = to.Update(t.Name, func(task *TaskInfo) error {
+			*task = t
+			return nil
+		}); err != nil {
+			return 0, err
+		}
+	}
+	runs, err := from.ListRuns()
+	if err != nil {
+		return 0, err
+	}
+	for idx := range runs {
+		if err = to.SaveRun(&runs[idx]); err != nil {
+			return 0, err
+		}
+	}
+	return len(tasks), nil
+}
+
+func backendName(backend string) string {
+	if backend == "" {
+		return StateBackendConfigMap
+	}
+	return backend
+}
+
+func sortTasks(tasks []TaskInfo) {
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Type != tasks[j].Type {
+			return tasks[i].Type < tasks[j].Type
+		}
+		return tasks[i].Name < tasks[j].Name
+	})
+}
+
+func sortRuns(runs []RunRecord) {
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartTime.After(runs[j].StartTime)
+	})
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/choerodon/c7nctl/pkg/common/consts"
+	c7nerrors "github.com/choerodon/c7nctl/pkg/common/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testStateStore(t *testing.T, store StateStore) {
+	if _, err := store.Get("gitlab"); !errors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
+		t.Errorf("want not found, got %v", err)
+	}
+	if _, err := store.Update("gitlab", func(task *TaskInfo) error {
+		task.Type = consts.StaticReleaseKey
+		task.Values = []ChartValue{{Name: "password", Value: "secret"}}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Update("predb", func(task *TaskInfo) error {
+		task.Type = consts.StaticTaskKey
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	task, err := store.Update("gitlab", func(task *TaskInfo) error {
+		task.Status = consts.SucceedStatus
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 修改返回的 task 不影响保存的数据
+	task.Values[0].Value = "changed"
+	if task, err = store.Get("gitlab"); err != nil || task.Status != consts.SucceedStatus || task.Values[0].Value != "secret" {
+		t.Errorf("unexpected task %+v %v", task, err)
+	}
+	if _, err = store.Update("gitlab", func(task *TaskInfo) error {
+		task.Name = "other"
+		return nil
+	}); err == nil {
+		t.Error("want error when the name is changed")
+	}
+
+	tasks, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].Name != "gitlab" || tasks[1].Name != "predb" {
+		t.Errorf("unexpected tasks %+v", tasks)
+	}
+
+	now := time.Now()
+	for i, id := range []string{"older", "newer"} {
+		if err = store.SaveRun(&RunRecord{ID: id, StartTime: now.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := store.ListRuns()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ID != "newer" {
+		t.Errorf("unexpected runs %+v", runs)
+	}
+
+	if err = store.Delete("predb"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Delete("predb"); err != nil {
+		t.Errorf("deleting a missing task should not fail: %v", err)
+	}
+	if tasks, _ = store.List(); len(tasks) != 1 {
+		t.Errorf("want 1 task, got %d", len(tasks))
+	}
+}
+
+func TestStateBackends(t *testing.T) {
+	dir := t.TempDir()
+	client := fake.NewSimpleClientset()
+	for _, backend := range StateBackends {
+		t.Run(backend, func(t *testing.T) {
+			store, err := NewStateStore(StateOptions{
+				Backend:   backend,
+				Client:    client,
+				File:      filepath.Join(dir, "state", "c7n-system.yaml"),
+				Namespace: "c7n-system",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			testStateStore(t, store)
+		})
+	}
+
+	// secret 中保存 task，不创建 configMap
+	if _, err := client.CoreV1().Secrets("c7n-system").Get(context.Background(), "c7n-logs-gitlab", metav1.GetOptions{}); err != nil {
+		t.Error(err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "state", "c7n-system.yaml")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected state file %v %v", info, err)
+	}
+	if _, err := NewStateStore(StateOptions{Backend: "etcd"}); err == nil {
+		t.Error("want error for unsupported backend")
+	}
+}
+
+func TestMigrateState(t *testing.T) {
+	from := NewC7nLogs(fake.NewSimpleClientset(), "c7n-system")
+	for _, name := range []string{"c7n-mysql", "gitlab"} {
+		if _, err := from.Update(name, func(task *TaskInfo) error {
+			task.Type = consts.StaticReleaseKey
+			task.Status = consts.SucceedStatus
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := from.SaveRun(&RunRecord{ID: "run-1", StartTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	to := NewFileStore(filepath.Join(t.TempDir(), "state.yaml"), "c7n-system")
+	count, err := MigrateState(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("want 2 tasks migrated, got %d", count)
+	}
+	if task, err := to.Get("gitlab"); err != nil || task.Status != consts.SucceedStatus {
+		t.Errorf("unexpected task %+v %v", task, err)
+	}
+	if runs, _ := to.ListRuns(); len(runs) != 1 || runs[0].ID != "run-1" {
+		t.Errorf("unexpected runs %+v", runs)
+	}
+}
+
+func TestFileStoreLock(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "state.yaml"), "c7n-system")
+	unlock, err := store.Lock("alice@host/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Lock("bob@host/2"); err == nil || !strings.Contains(err.Error(), "alice@host/1") {
+		t.Errorf("want locked by alice, got %v", err)
+	}
+	unlock()
+
+	unlock, err = store.Lock("bob@host/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	// 过期的锁可以被其他 c7nctl 获取
+	expired := time.Now().Add(-2 * lockDuration)
+	os.Chtimes(store.Path+".lock", expired, expired)
+	unlockAlice, err := store.Lock("alice@host/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockAlice()
+}
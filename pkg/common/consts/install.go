@@ -29,6 +29,8 @@ const (
 	// C7nLabelKey 默认 label
 	C7nLabelKey   = "c7n-usage"
 	C7nLabelValue = "c7n-installer"
+	// 同一个集群中有多个 choerodon 时，除默认实例外的资源都添加该 label
+	InstanceLabel = "choerodon.io/instance"
 
 	MetricsUrl = "http://get.devops.hand-china.com/api/v1/metrics"
 	IpAddr     = "ns1.dnspod.net:6666"
@@ -22,4 +22,5 @@ const (
 	staticExecutedKey  = "execute"
 	SqlTask            = "sql"
 	HttpGetTask        = "httpGet"
+	ShellTask          = "shell"
 )
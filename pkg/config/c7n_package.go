@@ -1,5 +1,11 @@
 package config
 
+import (
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"strconv"
+	"strings"
+)
+
 type ChoerodonVersion struct {
 	Name string `yaml:"name"`
 
@@ -31,10 +37,32 @@ type ChartComponent struct {
 }
 
 type ChartRepository struct {
+	// chartmuseum 的地址或者 oci://<镜像仓库>
 	Url      string `yaml:"url"`
 	Repo     string `yaml:"repo"`
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
+	CaFile   string `yaml:"ca-file"`
+	CertFile string `yaml:"cert-file"`
+	KeyFile  string `yaml:"key-file"`
+	Insecure bool   `yaml:"insecure"`
+}
+
+// Repository 返回 url/repo 对应的 chart 仓库
+func (r ChartRepository) Repository() repository.Repository {
+	url := strings.TrimRight(r.Url, "/")
+	if r.Repo != "" {
+		url += "/" + strings.Trim(r.Repo, "/")
+	}
+	return repository.Repository{
+		URL:                   url,
+		Username:              r.Username,
+		Password:              r.Password,
+		CaFile:                r.CaFile,
+		CertFile:              r.CertFile,
+		KeyFile:               r.KeyFile,
+		InsecureSkipTLSVerify: r.Insecure,
+	}
 }
 
 type Image struct {
@@ -49,3 +77,13 @@ type ImageRegistry struct {
 	Password   string `yaml:"password"`
 	Insecure   string `yaml:"insecure"`
 }
+
+// Credentials 返回拉取镜像时使用的认证信息
+func (r ImageRegistry) Credentials() repository.Repository {
+	insecure, _ := strconv.ParseBool(r.Insecure)
+	return repository.Repository{
+		Username:              r.Username,
+		Password:              r.Password,
+		InsecureSkipTLSVerify: insecure,
+	}
+}
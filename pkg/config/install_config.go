@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"github.com/choerodon/c7nctl/pkg/repository"
 	"io/ioutil"
 	"k8s.io/api/core/v1"
 	"os"
@@ -51,6 +52,53 @@ type Option struct {
 	ChartRepository string `yaml:"chart-repo"`
 	DatasourceTpl   string `yaml:"datasource-tpl"`
 	ThinMode        bool   `yaml:"thin-mode"`
+	// 检查域名时创建的 Ingress 使用的 IngressClass
+	IngressClassName string `yaml:"ingress-class"`
+	// 域名检查失败时终止安装
+	FatalDomainCheck bool `yaml:"fatal-domain-check"`
+	// 执行 release job 的方式：slaver 或者 k8s-job
+	JobRunner string `yaml:"job-runner"`
+	// 使用 k8s-job 执行时的镜像，必须是使用 docker/slaver.Dockerfile 构建的镜像
+	JobImage string `yaml:"job-image"`
+	// chart 仓库的认证信息，按照 url 前缀匹配 chart-repo 和 release 的仓库地址
+	ChartRepositories []repository.Repository `yaml:"chart-repositories"`
+	// 所有 release 默认的 helm 选项，install.yml 中 release 的设置优先
+	HelmWait    bool   `yaml:"helm-wait"`
+	HelmAtomic  bool   `yaml:"helm-atomic"`
+	HelmTimeout string `yaml:"helm-timeout"`
+	// 安装前修改 release 渲染后的 manifest，不需要修改 chart
+	Patches []Patch `yaml:"patches"`
+	// 注入到所有 release 的 values 中的调度策略，install.yml 中 basic.scheduling 的设置被覆盖
+	Scheduling Scheduling `yaml:"scheduling"`
+}
+
+// Scheduling 是所有 release 使用的调度和 pod 策略
+type Scheduling struct {
+	NodeSelector       map[string]string      `yaml:"node-selector"`
+	Tolerations        []interface{}          `yaml:"tolerations"`
+	Affinity           map[string]interface{} `yaml:"affinity"`
+	PriorityClassName  string                 `yaml:"priority-class-name"`
+	ImagePullSecrets   []string               `yaml:"image-pull-secrets"`
+	PodSecurityContext map[string]interface{} `yaml:"pod-security-context"`
+}
+
+// Patch 是应用到 release 渲染后的 manifest 中匹配 target 的资源的补丁，patch 和 json-patch 只能设置一个
+type Patch struct {
+	// 应用补丁的 release，为空时应用到所有 release
+	Releases []string    `yaml:"releases"`
+	Target   PatchTarget `yaml:"target"`
+	// yaml 格式的 strategic merge patch，不是 kubernetes 内置的资源时作为 JSON merge patch
+	Patch string `yaml:"patch"`
+	// yaml 或者 json 格式的 JSON patch (RFC 6902)
+	JSONPatch string `yaml:"json-patch"`
+}
+
+// PatchTarget 选择应用补丁的资源，没有设置的条件匹配所有资源
+type PatchTarget struct {
+	Kind string `yaml:"kind"`
+	// 可以使用通配符，比如 *-service
+	Name          string `yaml:"name"`
+	LabelSelector string `yaml:"label-selector"`
 }
 
 type Persistence struct {
@@ -100,6 +148,17 @@ func (c *C7nConfig) GetResource(key string) *Resource {
 	return nil
 }
 
+// GetDomains 返回 spec.resources 中定义的域名，key 为 resource 的名称
+func (c *C7nConfig) GetDomains() map[string]string {
+	domains := make(map[string]string)
+	for name, res := range c.Spec.Resources {
+		if res != nil && res.Domain != "" {
+			domains[name] = res.Domain
+		}
+	}
+	return domains
+}
+
 func (c *C7nConfig) GetPrefix() string {
 	return c.Spec.Prefix
 }
@@ -111,6 +170,10 @@ func (c *C7nConfig) GetChartRepository() string {
 	return c.Spec.ChartRepository
 }
 
+func (c *C7nConfig) GetChartRepositories() []repository.Repository {
+	return c.Spec.ChartRepositories
+}
+
 func (c *C7nConfig) GetDatasourceTpl() string {
 	return c.Spec.DatasourceTpl
 }
@@ -119,6 +182,10 @@ func (c *C7nConfig) GetThinMode() bool {
 	return c.Spec.ThinMode
 }
 
+func (c *C7nConfig) GetIngressClassName() string {
+	return c.Spec.IngressClassName
+}
+
 func (c *C7nConfig) GetStorageClass() string {
 	return c.Spec.Persistence.StorageClassName
 }
@@ -0,0 +1,142 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// dockerArchiveManifest 是 docker save 生成的 manifest.json 中的一项
+type dockerArchiveManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// writeDockerArchive 将镜像写入可以使用 docker load 导入的 tar 文件，层保持压缩的格式
+func writeDockerArchive(path string, img *image, store *blobStore) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	err = func() error {
+		m := dockerArchiveManifest{Config: img.manifest.Config.Digest.Encoded() + ".json"}
+		if img.ref.Tag != "" {
+			m.RepoTags = []string{img.ref.Name() + ":" + img.ref.Tag}
+		}
+		if err := addBlob(tw, m.Config, store.path(img.manifest.Config.Digest)); err != nil {
+			return err
+		}
+		added := map[string]bool{}
+		for _, l := range img.manifest.Layers {
+			name := l.Digest.Encoded() + "/layer.tar"
+			m.Layers = append(m.Layers, name)
+			// 镜像中可能有相同的层
+			if added[name] {
+				continue
+			}
+			added[name] = true
+			if err := addBlob(tw, name, store.path(l.Digest)); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal([]dockerArchiveManifest{m})
+		if err != nil {
+			return err
+		}
+		if err = tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0644, Size: int64(len(data))}); err != nil {
+			return err
+		}
+		if _, err = tw.Write(data); err != nil {
+			return err
+		}
+		return tw.Close()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func addBlob(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// writeOCIIndex 将镜像的 manifest 保存到 OCI layout 中并更新 index.json，保留之前拉取的其他镜像
+func writeOCIIndex(dest string, images []*image, store *blobStore) error {
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(dest, ocispec.ImageLayoutFile), layout, 0644); err != nil {
+		return err
+	}
+
+	indexFile := filepath.Join(dest, "index.json")
+	index := ocispec.Index{Versioned: specs.Versioned{SchemaVersion: 2}}
+	if data, err := ioutil.ReadFile(indexFile); err == nil {
+		if err = json.Unmarshal(data, &index); err != nil {
+			return err
+		}
+	}
+	manifests := map[string]ocispec.Descriptor{}
+	for _, m := range index.Manifests {
+		manifests[m.Annotations[ocispec.AnnotationRefName]] = m
+	}
+	for _, img := range images {
+		if _, err = store.put(img.data); err != nil {
+			return err
+		}
+		name := img.ref.String()
+		manifests[name] = ocispec.Descriptor{
+			MediaType: img.mediaType,
+			Digest:    img.digest(),
+			Size:      int64(len(img.data)),
+			Annotations: map[string]string{
+				ocispec.AnnotationRefName:     name,
+				containerdImageNameAnnotation: name,
+			},
+		}
+	}
+	index.Manifests = make([]ocispec.Descriptor, 0, len(manifests))
+	for _, m := range manifests {
+		index.Manifests = append(index.Manifests, m)
+	}
+	sort.Slice(index.Manifests, func(i, j int) bool {
+		return index.Manifests[i].Annotations[ocispec.AnnotationRefName] < index.Manifests[j].Annotations[ocispec.AnnotationRefName]
+	})
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := indexFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, indexFile)
+}
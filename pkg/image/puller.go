@@ -0,0 +1,314 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// FormatDockerArchive 每个镜像保存为一个可以 docker load 的 tar 文件
+	FormatDockerArchive = "docker-archive"
+	// FormatOCI 所有镜像保存在一个 OCI layout 目录中，共享的层只保存一次
+	FormatOCI = "oci"
+
+	DefaultPlatform    = "linux/amd64"
+	DefaultConcurrency = 4
+
+	dockerManifestMediaType     = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	// containerd 导入 OCI layout 时使用的镜像名称
+	containerdImageNameAnnotation = "io.containerd.image.name"
+)
+
+var manifestMediaTypes = []string{
+	ocispec.MediaTypeImageManifest,
+	dockerManifestMediaType,
+	ocispec.MediaTypeImageIndex,
+	dockerManifestListMediaType,
+}
+
+// Puller 不依赖 docker daemon，直接从镜像仓库拉取镜像并保存为 docker-archive 或者 OCI layout
+type Puller struct {
+	// 镜像仓库的认证信息，key 是镜像仓库的域名
+	Registries map[string]repository.Repository
+	// FormatDockerArchive 或者 FormatOCI，默认为 FormatDockerArchive
+	Format string
+	// 多架构镜像使用的平台，比如 linux/amd64 或者 linux/arm64/v8
+	Platform string
+	// 同时拉取的镜像数量
+	Concurrency int
+	// docker-archive 格式时层的缓存目录，默认为 <dest>/.blobs，全部成功后删除默认的缓存目录
+	CacheDir string
+
+	mu      sync.Mutex
+	clients map[string]*repository.ImageRegistry
+}
+
+// Pulled 是保存完成的镜像
+type Pulled struct {
+	// 拉取时使用的镜像名称
+	Reference string
+	// manifest 的 digest，多架构镜像时是 Platform 对应的 manifest
+	Digest digest.Digest
+	// docker-archive 格式时保存的文件名，OCI 格式时为空
+	File string
+}
+
+// image 是拉取完成的镜像
+type image struct {
+	name     string
+	ref      Reference
+	manifest ocispec.Manifest
+	// manifest 的原始内容及其类型
+	data      []byte
+	mediaType string
+}
+
+// Pull 并行拉取 images 并保存到 dest 目录中，单个镜像失败不影响其他镜像，全部完成后返回所有的错误
+// 和按照 images 的顺序保存成功的镜像
+func (p *Puller) Pull(images []string, dest string) ([]Pulled, error) {
+	format := p.Format
+	if format == "" {
+		format = FormatDockerArchive
+	}
+	if format != FormatDockerArchive && format != FormatOCI {
+		return nil, std_errors.Errorf("unsupported image format %s, must be %s or %s", format, FormatDockerArchive, FormatOCI)
+	}
+	if _, _, _, err := parsePlatform(p.platform()); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+	cacheDir := p.CacheDir
+	if format == FormatOCI {
+		cacheDir = filepath.Join(dest, "blobs")
+	} else if cacheDir == "" {
+		cacheDir = filepath.Join(dest, ".blobs")
+	}
+	store := newBlobStore(cacheDir)
+
+	concurrency := p.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	results := make([]*image, len(images))
+	errs := make([]error, len(images))
+	var wg sync.WaitGroup
+	for idx, name := range images {
+		wg.Add(1)
+		go func(idx int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[idx], errs[idx] = p.pull(name, format, dest, store)
+			if errs[idx] != nil {
+				errs[idx] = std_errors.WithMessage(errs[idx], fmt.Sprintf("Failed to pull image %s", name))
+				log.Error(errs[idx])
+			}
+		}(idx, name)
+	}
+	wg.Wait()
+
+	var (
+		saved  []*image
+		pulled []Pulled
+	)
+	for _, img := range results {
+		if img == nil {
+			continue
+		}
+		saved = append(saved, img)
+		item := Pulled{Reference: img.name, Digest: img.digest()}
+		if format == FormatDockerArchive {
+			item.File = img.ref.FileName()
+		}
+		pulled = append(pulled, item)
+	}
+	if format == FormatOCI {
+		if err := writeOCIIndex(dest, saved, store); err != nil {
+			errs = append(errs, std_errors.WithMessage(err, "Failed to write OCI index"))
+		}
+	}
+	err := utilerrors.NewAggregate(errs)
+	if err == nil && format == FormatDockerArchive && p.CacheDir == "" {
+		// 失败时保留缓存用于继续下载
+		os.RemoveAll(cacheDir)
+	}
+	return pulled, err
+}
+
+// pull 拉取镜像的 manifest、config 和所有的层，docker-archive 格式时写入镜像的 tar 文件
+func (p *Puller) pull(name, format, dest string, store *blobStore) (*image, error) {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return nil, err
+	}
+	client, err := p.client(ref)
+	if err != nil {
+		return nil, err
+	}
+	img, err := p.fetchManifest(client, ref)
+	if err != nil {
+		return nil, err
+	}
+	img.name = name
+	archive := filepath.Join(dest, ref.FileName())
+	if format == FormatDockerArchive {
+		if _, err = os.Stat(archive); err == nil {
+			log.Infof("Image %s is already saved to %s, skip it", ref, archive)
+			return img, nil
+		}
+	}
+	for _, desc := range append([]ocispec.Descriptor{img.manifest.Config}, img.manifest.Layers...) {
+		desc := desc
+		if err = store.fetch(desc, func(offset int64) (io.ReadCloser, bool, error) {
+			return client.Blob(ref.Repository, desc.Digest.String(), offset)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if format == FormatDockerArchive {
+		if err = writeDockerArchive(archive, img, store); err != nil {
+			return nil, err
+		}
+		log.Infof("Saved image %s to %s", ref, archive)
+	} else {
+		log.Infof("Pulled image %s", ref)
+	}
+	return img, nil
+}
+
+// fetchManifest 获取镜像的 manifest，多架构镜像使用 Platform 对应的 manifest
+func (p *Puller) fetchManifest(client *repository.ImageRegistry, ref Reference) (*image, error) {
+	data, mediaType, err := client.Manifest(ref.Repository, ref.reference(), manifestMediaTypes)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Digest != "" {
+		if actual := ref.Digest.Algorithm().FromBytes(data); actual != ref.Digest {
+			return nil, std_errors.Errorf("digest of manifest mismatch, got %s", actual)
+		}
+	}
+	if mediaType == ocispec.MediaTypeImageIndex || mediaType == dockerManifestListMediaType {
+		index := ocispec.Index{}
+		if err = json.Unmarshal(data, &index); err != nil {
+			return nil, err
+		}
+		desc, err := selectPlatform(index, p.platform())
+		if err != nil {
+			return nil, err
+		}
+		if err = desc.Digest.Validate(); err != nil {
+			return nil, err
+		}
+		if data, mediaType, err = client.Manifest(ref.Repository, desc.Digest.String(), manifestMediaTypes); err != nil {
+			return nil, err
+		}
+		if actual := desc.Digest.Algorithm().FromBytes(data); actual != desc.Digest {
+			return nil, std_errors.Errorf("digest of %s manifest mismatch, got %s", p.platform(), actual)
+		}
+	}
+	if mediaType != ocispec.MediaTypeImageManifest && mediaType != dockerManifestMediaType {
+		return nil, std_errors.Errorf("unsupported manifest type %s", mediaType)
+	}
+	img := &image{ref: ref, data: data, mediaType: mediaType}
+	if err = json.Unmarshal(data, &img.manifest); err != nil {
+		return nil, err
+	}
+	if img.manifest.Config.Digest == "" {
+		return nil, std_errors.New("manifest has no config")
+	}
+	// digest 用于保存 blob 的路径，需要先校验
+	for _, desc := range append([]ocispec.Descriptor{img.manifest.Config}, img.manifest.Layers...) {
+		if err = desc.Digest.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// client 返回镜像仓库的客户端，同一个镜像仓库共享认证的 token
+func (p *Puller) client(ref Reference) (*repository.ImageRegistry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.clients[ref.Domain]; ok {
+		return c, nil
+	}
+	var repo repository.Repository
+	for domain, r := range p.Registries {
+		if registryDomain(domain) == ref.Domain {
+			repo = r
+		}
+	}
+	c, err := repository.NewImageRegistry(ref.host(), repo)
+	if err != nil {
+		return nil, err
+	}
+	if p.clients == nil {
+		p.clients = map[string]*repository.ImageRegistry{}
+	}
+	p.clients[ref.Domain] = c
+	return c, nil
+}
+
+func (p *Puller) platform() string {
+	if p.Platform == "" {
+		return DefaultPlatform
+	}
+	return p.Platform
+}
+
+// registryDomain 去掉镜像仓库地址中的协议和路径
+func registryDomain(s string) string {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "https://"), "http://")
+	if idx := strings.Index(s, "/"); idx > 0 {
+		s = s[:idx]
+	}
+	return s
+}
+
+func parsePlatform(platform string) (goos, arch, variant string, err error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", std_errors.Errorf("invalid platform %s, must be os/arch[/variant]", platform)
+	}
+	if len(parts) == 3 {
+		variant = parts[2]
+	}
+	return parts[0], parts[1], variant, nil
+}
+
+// selectPlatform 返回多架构镜像中 platform 对应的 manifest
+func selectPlatform(index ocispec.Index, platform string) (ocispec.Descriptor, error) {
+	goos, arch, variant, err := parsePlatform(platform)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	for _, m := range index.Manifests {
+		if m.Platform == nil || m.Platform.OS != goos || m.Platform.Architecture != arch {
+			continue
+		}
+		if variant == "" || m.Platform.Variant == variant {
+			return m, nil
+		}
+	}
+	return ocispec.Descriptor{}, std_errors.Errorf("no manifest for platform %s", platform)
+}
+
+// digest 返回 manifest 的 digest
+func (i *image) digest() digest.Digest {
+	return digest.FromBytes(i.data)
+}
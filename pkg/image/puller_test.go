@@ -0,0 +1,310 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry 是只支持拉取的镜像仓库，记录每个 blob 的请求次数
+type fakeRegistry struct {
+	manifests map[string][]byte
+	types     map[string]string
+	blobs     map[digest.Digest][]byte
+
+	mu       sync.Mutex
+	requests map[digest.Digest]int
+	uploads  int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+		blobs:     map[digest.Digest][]byte{},
+		requests:  map[digest.Digest]int{},
+	}
+}
+
+func (f *fakeRegistry) blob(data []byte) ocispec.Descriptor {
+	d := digest.FromBytes(data)
+	f.blobs[d] = data
+	return ocispec.Descriptor{Digest: d, Size: int64(len(data))}
+}
+
+func (f *fakeRegistry) manifest(name, reference, mediaType string, v interface{}) ocispec.Descriptor {
+	data, _ := json.Marshal(v)
+	d := digest.FromBytes(data)
+	for _, ref := range []string{reference, d.String()} {
+		f.manifests[name+":"+ref] = data
+		f.types[name+":"+ref] = mediaType
+	}
+	return ocispec.Descriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+// image 添加镜像 name:tag，layers 是每一层的内容
+func (f *fakeRegistry) image(name, tag string, layers ...string) {
+	m := ocispec.Manifest{Config: f.blob([]byte(`{"architecture":"amd64","os":"linux"}`))}
+	m.SchemaVersion = 2
+	for _, l := range layers {
+		m.Layers = append(m.Layers, f.blob([]byte(l)))
+	}
+	f.manifest(name, tag, dockerManifestMediaType, m)
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.HasSuffix(path, "/blobs/uploads/") && r.Method == http.MethodPost:
+		w.Header().Set("Location", "/v2/"+path+"upload-id")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	case strings.HasSuffix(path, "/blobs/uploads/upload-id") && r.Method == http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		d := digest.Digest(r.URL.Query().Get("digest"))
+		if digest.FromBytes(data) != d {
+			http.Error(w, "digest invalid", http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.blobs[d] = data
+		f.uploads++
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if idx := strings.Index(path, "/manifests/"); idx > 0 {
+		key := path[:idx] + ":" + path[idx+len("/manifests/"):]
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.Method == http.MethodPut {
+			data, _ := ioutil.ReadAll(r.Body)
+			d := digest.FromBytes(data)
+			for _, k := range []string{key, path[:idx] + ":" + d.String()} {
+				f.manifests[k] = data
+				f.types[k] = r.Header.Get("Content-Type")
+			}
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		data, ok := f.manifests[key]
+		if !ok {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", f.types[key])
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.Write(data)
+		return
+	}
+	if idx := strings.Index(path, "/blobs/"); idx > 0 {
+		d := digest.Digest(path[idx+len("/blobs/"):])
+		f.mu.Lock()
+		data, ok := f.blobs[d]
+		if ok && r.Method == http.MethodGet {
+			f.requests[d]++
+		}
+		f.mu.Unlock()
+		if !ok {
+			http.Error(w, "blob unknown", http.StatusNotFound)
+			return
+		}
+		var offset int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset); err == nil && offset < len(data) {
+			w.WriteHeader(http.StatusPartialContent)
+			data = data[offset:]
+		}
+		w.Write(data)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func newTestPuller(f *fakeRegistry) (*Puller, string, func()) {
+	server := httptest.NewServer(f)
+	domain := strings.TrimPrefix(server.URL, "http://")
+	p := &Puller{Registries: map[string]repository.Repository{server.URL: {}}, Concurrency: 2}
+	return p, domain, server.Close
+}
+
+func readTar(t *testing.T, path string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	files := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(tr)
+		files[h.Name] = string(data)
+	}
+}
+
+func TestPullDockerArchive(t *testing.T) {
+	f := newFakeRegistry()
+	f.image("c7n/choerodon-iam", "1.1.0", "base", "iam")
+	f.image("c7n/choerodon-asgard", "1.1.0", "base", "asgard")
+	p, domain, done := newTestPuller(f)
+	defer done()
+
+	dest, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	images := []string{domain + "/c7n/choerodon-iam:1.1.0", domain + "/c7n/choerodon-asgard:1.1.0"}
+	pulled, err := p.Pull(images, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) != 2 || pulled[0].Reference != images[0] || pulled[0].File != "choerodon-iam-1.1.0.tar" {
+		t.Errorf("unexpected pulled images %+v", pulled)
+	}
+	// 共享的层和 config 只下载一次
+	for d, n := range f.requests {
+		if n != 1 {
+			t.Errorf("blob %s is requested %d times", d, n)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dest, ".blobs")); !os.IsNotExist(err) {
+		t.Errorf("cache dir is not removed")
+	}
+
+	files := readTar(t, filepath.Join(dest, "choerodon-iam-1.1.0.tar"))
+	manifest := []dockerArchiveManifest{}
+	if err = json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != 1 || len(manifest[0].Layers) != 2 {
+		t.Fatalf("unexpected manifest.json %s", files["manifest.json"])
+	}
+	if tags := manifest[0].RepoTags; len(tags) != 1 || tags[0] != images[0] {
+		t.Errorf("unexpected repo tags %v", tags)
+	}
+	if files[manifest[0].Layers[1]] != "iam" {
+		t.Errorf("unexpected layer %q", files[manifest[0].Layers[1]])
+	}
+	if _, ok := files[manifest[0].Config]; !ok {
+		t.Errorf("config %s is not saved", manifest[0].Config)
+	}
+}
+
+func TestPullOCI(t *testing.T) {
+	f := newFakeRegistry()
+	f.image("c7n/redis", "amd64", "redis-amd64")
+	f.image("c7n/redis", "arm64", "redis-arm64")
+	amd64 := descriptorOf(f, "c7n/redis:amd64")
+	arm64 := descriptorOf(f, "c7n/redis:arm64")
+	amd64.Platform = &ocispec.Platform{OS: "linux", Architecture: "amd64"}
+	arm64.Platform = &ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+	index := ocispec.Index{Manifests: []ocispec.Descriptor{amd64, arm64}}
+	index.SchemaVersion = 2
+	f.manifest("c7n/redis", "6.0", dockerManifestListMediaType, index)
+
+	p, domain, done := newTestPuller(f)
+	defer done()
+	p.Format = FormatOCI
+	p.Platform = "linux/arm64/v8"
+
+	dest, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	pulled, err := p.Pull([]string{domain + "/c7n/redis:6.0", domain + "/c7n/missing:1.0"}, dest)
+	if len(pulled) != 1 || pulled[0].Digest != arm64.Digest {
+		t.Errorf("unexpected pulled images %+v", pulled)
+	}
+	if err == nil || !strings.Contains(err.Error(), "c7n/missing:1.0") {
+		t.Fatalf("expected error of missing image, got %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dest, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := ocispec.Index{}
+	if err = json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Manifests) != 1 || result.Manifests[0].Digest != arm64.Digest {
+		t.Fatalf("unexpected index.json %s", data)
+	}
+	if name := result.Manifests[0].Annotations[ocispec.AnnotationRefName]; name != domain+"/c7n/redis:6.0" {
+		t.Errorf("unexpected ref name %s", name)
+	}
+	for _, d := range []digest.Digest{arm64.Digest, digest.FromString("redis-arm64")} {
+		if _, err = os.Stat(filepath.Join(dest, "blobs", "sha256", d.Encoded())); err != nil {
+			t.Errorf("blob %s is not saved", d)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dest, "blobs", "sha256", digest.FromString("redis-amd64").Encoded())); err == nil {
+		t.Errorf("layer of other platform is saved")
+	}
+	if _, err = os.Stat(filepath.Join(dest, ocispec.ImageLayoutFile)); err != nil {
+		t.Errorf("oci-layout is not saved")
+	}
+}
+
+func descriptorOf(f *fakeRegistry, key string) ocispec.Descriptor {
+	data := f.manifests[key]
+	return ocispec.Descriptor{MediaType: f.types[key], Digest: digest.FromBytes(data), Size: int64(len(data))}
+}
+
+func TestBlobStoreResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("0123456789")
+	desc := ocispec.Descriptor{Digest: digest.FromBytes(content), Size: int64(len(content))}
+	store := newBlobStore(dir)
+	partial := store.path(desc.Digest) + ".partial"
+	if err = os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(partial, content[:4], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var offsets []int64
+	err = store.fetch(desc, func(offset int64) (io.ReadCloser, bool, error) {
+		offsets = append(offsets, offset)
+		return ioutil.NopCloser(bytes.NewReader(content[offset:])), true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(offsets) != 1 || offsets[0] != 4 {
+		t.Errorf("expected to resume from 4, got %v", offsets)
+	}
+	data, err := ioutil.ReadFile(store.path(desc.Digest))
+	if err != nil || !bytes.Equal(data, content) {
+		t.Errorf("unexpected blob %q: %v", data, err)
+	}
+}
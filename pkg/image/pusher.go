@@ -0,0 +1,356 @@
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	dockerConfigMediaType    = "application/vnd.docker.container.image.v1+json"
+	dockerLayerMediaType     = "application/vnd.docker.image.rootfs.diff.tar"
+	dockerGzipLayerMediaType = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+// Source 是离线包中保存的镜像
+type Source struct {
+	Reference string
+	// 镜像 manifest 的 digest，OCI 格式时用于在 index.json 中查找 manifest
+	Digest digest.Digest
+	// docker-archive 文件的路径，为空时从 OCI layout 中读取
+	File string
+}
+
+// Pushed 是推送完成的镜像
+type Pushed struct {
+	Source string
+	Target string
+	// 目标仓库中已经存在相同的镜像
+	Skipped bool
+}
+
+// Pusher 将 Puller 保存的镜像推送到镜像仓库，镜像的路径替换为 Domain/Repository/<镜像名称>
+type Pusher struct {
+	// 目标镜像仓库的域名和路径，比如 harbor.example.com 和 c7n
+	Domain     string
+	Repository string
+	// 目标镜像仓库的认证信息
+	Registry repository.Repository
+	// 同时推送的镜像数量
+	Concurrency int
+
+	once   sync.Once
+	client *repository.ImageRegistry
+	err    error
+}
+
+// Target 返回镜像在目标仓库中的名称，只保留镜像名称的最后一级，比如 c7n/choerodon-iam:1.1.0 替换为 <Domain>/<Repository>/choerodon-iam:1.1.0
+func (p *Pusher) Target(ref Reference) Reference {
+	name := ref.Repository[strings.LastIndex(ref.Repository, "/")+1:]
+	if repo := strings.Trim(p.Repository, "/"); repo != "" {
+		name = repo + "/" + name
+	}
+	return Reference{Domain: registryDomain(p.Domain), Repository: name, Tag: ref.Tag, Digest: ref.Digest}
+}
+
+// Rewrite 返回镜像 name 推送到 repo 之后的名称，repo 和安装时的 --image-repo 一致，比如 harbor.example.com/c7n。
+// docker-archive 中的镜像推送后 digest 可能变化，有 tag 时不保留 digest
+func Rewrite(name, repo string) (string, error) {
+	ref, err := ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	repo = strings.Trim(strings.TrimSpace(repo), "/")
+	p := &Pusher{Domain: repo}
+	if idx := strings.Index(repo, "/"); idx > 0 {
+		p.Domain, p.Repository = repo[:idx], repo[idx+1:]
+	}
+	target := p.Target(ref)
+	if target.Tag != "" {
+		target.Digest = ""
+	}
+	return target.String(), nil
+}
+
+// Push 并行推送 layout 目录中的镜像，单个镜像失败不影响其他镜像，全部完成后返回所有的错误和按照 images 的顺序推送成功的镜像
+func (p *Pusher) Push(layout string, images []Source) ([]Pushed, error) {
+	concurrency := p.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	results := make([]*Pushed, len(images))
+	errs := make([]error, len(images))
+	var wg sync.WaitGroup
+	for idx, src := range images {
+		wg.Add(1)
+		go func(idx int, src Source) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[idx], errs[idx] = p.push(layout, src)
+			if errs[idx] != nil {
+				errs[idx] = std_errors.WithMessage(errs[idx], fmt.Sprintf("Failed to push image %s", src.Reference))
+				log.Error(errs[idx])
+			}
+		}(idx, src)
+	}
+	wg.Wait()
+
+	var pushed []Pushed
+	for _, r := range results {
+		if r != nil {
+			pushed = append(pushed, *r)
+		}
+	}
+	return pushed, utilerrors.NewAggregate(errs)
+}
+
+func (p *Pusher) push(layout string, src Source) (*Pushed, error) {
+	ref, err := ParseReference(src.Reference)
+	if err != nil {
+		return nil, err
+	}
+	client, err := p.registry()
+	if err != nil {
+		return nil, err
+	}
+	var img *pushImage
+	if src.File != "" {
+		img, err = readDockerArchive(src.File)
+	} else {
+		img, err = readOCIImage(layout, src.Digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	target := p.Target(ref)
+	target.Digest = ""
+	if target.Tag == "" {
+		// 只有 digest 的镜像使用 digest 推送，docker-archive 中的 manifest 是重新生成的，digest 可能变化
+		target.Digest = digest.FromBytes(img.data)
+	}
+	result := &Pushed{Source: src.Reference, Target: target.String()}
+	existing, err := client.ManifestDigest(target.Repository, target.reference(), []string{img.mediaType})
+	if err != nil {
+		return nil, err
+	}
+	if existing == digest.FromBytes(img.data).String() {
+		log.Infof("Image %s already exists, skip it", target)
+		result.Skipped = true
+		return result, nil
+	}
+
+	missing := map[digest.Digest]bool{}
+	for _, desc := range append([]ocispec.Descriptor{img.manifest.Config}, img.manifest.Layers...) {
+		exists, err := client.HasBlob(target.Repository, desc.Digest.String())
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing[desc.Digest] = true
+		}
+	}
+	if err = img.push(missing, func(desc ocispec.Descriptor, body io.Reader) error {
+		return client.PushBlob(target.Repository, desc.Digest.String(), desc.Size, body)
+	}); err != nil {
+		return nil, err
+	}
+	if err = client.PushManifest(target.Repository, target.reference(), img.mediaType, img.data); err != nil {
+		return nil, err
+	}
+	log.Infof("Pushed image %s to %s", src.Reference, target)
+	return result, nil
+}
+
+func (p *Pusher) registry() (*repository.ImageRegistry, error) {
+	p.once.Do(func() {
+		p.client, p.err = repository.NewImageRegistry(Reference{Domain: registryDomain(p.Domain)}.host(), p.Registry)
+	})
+	return p.client, p.err
+}
+
+// pushImage 是需要推送的镜像，push 上传 manifest 中 missing 的 blob
+type pushImage struct {
+	manifest  ocispec.Manifest
+	data      []byte
+	mediaType string
+	push      func(missing map[digest.Digest]bool, upload func(ocispec.Descriptor, io.Reader) error) error
+}
+
+// readOCIImage 读取 OCI layout 中 digest 对应的镜像
+func readOCIImage(layout string, d digest.Digest) (*pushImage, error) {
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	store := newBlobStore(filepath.Join(layout, "blobs"))
+	data, err := ioutil.ReadFile(store.path(d))
+	if err != nil {
+		return nil, err
+	}
+	img := &pushImage{data: data}
+	if err = json.Unmarshal(data, &img.manifest); err != nil {
+		return nil, err
+	}
+	// OCI 的 manifest 中可以没有 mediaType
+	typed := struct {
+		MediaType string `json:"mediaType"`
+	}{}
+	if err = json.Unmarshal(data, &typed); err != nil {
+		return nil, err
+	}
+	if img.mediaType = typed.MediaType; img.mediaType == "" {
+		img.mediaType = ocispec.MediaTypeImageManifest
+	}
+	img.push = func(missing map[digest.Digest]bool, upload func(ocispec.Descriptor, io.Reader) error) error {
+		for _, desc := range append([]ocispec.Descriptor{img.manifest.Config}, img.manifest.Layers...) {
+			if !missing[desc.Digest] {
+				continue
+			}
+			if err := desc.Digest.Validate(); err != nil {
+				return err
+			}
+			if err := uploadFile(store.path(desc.Digest), desc, upload); err != nil {
+				return err
+			}
+			delete(missing, desc.Digest)
+		}
+		return nil
+	}
+	return img, nil
+}
+
+func uploadFile(file string, desc ocispec.Descriptor, upload func(ocispec.Descriptor, io.Reader) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return upload(desc, f)
+}
+
+// readDockerArchive 读取 writeDockerArchive 生成的 tar 文件，根据文件名得到 blob 的 digest 并重新生成 manifest
+func readDockerArchive(file string) (*pushImage, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var archive []dockerArchiveManifest
+	entries := map[string]ocispec.Descriptor{}
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Name == "manifest.json" {
+			if err = json.NewDecoder(tr).Decode(&archive); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		desc := ocispec.Descriptor{Size: h.Size, MediaType: dockerLayerMediaType}
+		if strings.HasSuffix(h.Name, ".json") {
+			desc.MediaType = dockerConfigMediaType
+		} else if magic := make([]byte, 2); h.Size >= 2 {
+			if _, err = io.ReadFull(tr, magic); err != nil {
+				return nil, err
+			}
+			if magic[0] == 0x1f && magic[1] == 0x8b {
+				desc.MediaType = dockerGzipLayerMediaType
+			}
+		}
+		entries[h.Name] = desc
+	}
+	if len(archive) != 1 {
+		return nil, std_errors.Errorf("%s must contain exactly one image", file)
+	}
+
+	m := ocispec.Manifest{Versioned: specs.Versioned{SchemaVersion: 2}}
+	names := map[digest.Digest]string{}
+	describe := func(name string) (ocispec.Descriptor, error) {
+		desc, ok := entries[name]
+		if !ok {
+			return desc, std_errors.Errorf("%s is not found in %s", name, file)
+		}
+		// writeDockerArchive 使用 blob 的 digest 作为文件名
+		encoded := strings.TrimSuffix(strings.TrimSuffix(name, ".json"), "/layer.tar")
+		desc.Digest = digest.NewDigestFromEncoded(digest.SHA256, path.Base(encoded))
+		if err := desc.Digest.Validate(); err != nil {
+			return desc, std_errors.Errorf("%s in %s is not saved by c7nctl package", name, file)
+		}
+		names[desc.Digest] = name
+		return desc, nil
+	}
+	if m.Config, err = describe(archive[0].Config); err != nil {
+		return nil, err
+	}
+	for _, l := range archive[0].Layers {
+		desc, err := describe(l)
+		if err != nil {
+			return nil, err
+		}
+		m.Layers = append(m.Layers, desc)
+	}
+	data, err := json.Marshal(struct {
+		ocispec.Manifest
+		MediaType string `json:"mediaType"`
+	}{m, dockerManifestMediaType})
+	if err != nil {
+		return nil, err
+	}
+
+	img := &pushImage{manifest: m, data: data, mediaType: dockerManifestMediaType}
+	img.push = func(missing map[digest.Digest]bool, upload func(ocispec.Descriptor, io.Reader) error) error {
+		if len(missing) == 0 {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		pending := map[string]ocispec.Descriptor{}
+		for _, desc := range append([]ocispec.Descriptor{m.Config}, m.Layers...) {
+			if missing[desc.Digest] {
+				pending[names[desc.Digest]] = desc
+			}
+		}
+		tr := tar.NewReader(f)
+		for len(pending) > 0 {
+			h, err := tr.Next()
+			if err != nil {
+				return std_errors.WithMessage(err, fmt.Sprintf("Failed to read %s", file))
+			}
+			desc, ok := pending[h.Name]
+			if !ok {
+				continue
+			}
+			if err = upload(desc, tr); err != nil {
+				return err
+			}
+			delete(pending, h.Name)
+		}
+		return nil
+	}
+	return img, nil
+}
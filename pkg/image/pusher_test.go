@@ -0,0 +1,103 @@
+package image
+
+import (
+	"github.com/choerodon/c7nctl/pkg/repository"
+	"github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPusherTarget(t *testing.T) {
+	p := &Pusher{Domain: "https://harbor.example.com/", Repository: "/c7n/"}
+	ref, _ := ParseReference("registry.cn-shanghai.aliyuncs.com/c7n-dev/choerodon-iam:1.1.0")
+	if target := p.Target(ref).String(); target != "harbor.example.com/c7n/choerodon-iam:1.1.0" {
+		t.Errorf("unexpected target %s", target)
+	}
+	p.Repository = ""
+	if target := p.Target(ref).String(); target != "harbor.example.com/choerodon-iam:1.1.0" {
+		t.Errorf("unexpected target %s", target)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	cases := map[string]string{
+		"registry.cn-shanghai.aliyuncs.com/c7n/choerodon-iam:1.1.0": "harbor.example.com/c7n/choerodon-iam:1.1.0",
+		"busybox":             "harbor.example.com/c7n/busybox:latest",
+		"library/redis:6.0.5": "harbor.example.com/c7n/redis:6.0.5",
+	}
+	for name, expected := range cases {
+		target, err := Rewrite(name, "harbor.example.com/c7n/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if target != expected {
+			t.Errorf("rewrite %s: expected %s, got %s", name, expected, target)
+		}
+	}
+}
+
+func TestPush(t *testing.T) {
+	for _, format := range []string{FormatDockerArchive, FormatOCI} {
+		t.Run(format, func(t *testing.T) {
+			source := newFakeRegistry()
+			source.image("c7n/choerodon-iam", "1.1.0", "base", "iam")
+			source.image("c7n/choerodon-asgard", "1.1.0", "base", "asgard")
+			puller, domain, done := newTestPuller(source)
+			defer done()
+			puller.Format = format
+
+			dest, err := ioutil.TempDir("", "images")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dest)
+			pulled, err := puller.Pull([]string{domain + "/c7n/choerodon-iam:1.1.0", domain + "/c7n/choerodon-asgard:1.1.0"}, dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var sources []Source
+			for _, i := range pulled {
+				src := Source{Reference: i.Reference, Digest: i.Digest}
+				if i.File != "" {
+					src.File = filepath.Join(dest, i.File)
+				}
+				sources = append(sources, src)
+			}
+
+			target := newFakeRegistry()
+			server := httptest.NewServer(target)
+			defer server.Close()
+			pusher := &Pusher{Domain: strings.TrimPrefix(server.URL, "http://"), Repository: "offline", Registry: repository.Repository{}}
+			pushed, err := pusher.Push(dest, sources)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pushed) != 2 || pushed[0].Skipped || !strings.HasSuffix(pushed[0].Target, "/offline/choerodon-iam:1.1.0") {
+				t.Fatalf("unexpected pushed images %+v", pushed)
+			}
+			if _, ok := target.manifests["offline/choerodon-iam:1.1.0"]; !ok {
+				t.Errorf("manifest of choerodon-iam is not pushed")
+			}
+			for _, layer := range []string{"base", "iam", "asgard"} {
+				if _, ok := target.blobs[digest.FromString(layer)]; !ok {
+					t.Errorf("layer %s is not pushed", layer)
+				}
+			}
+			if format == FormatOCI && digest.FromBytes(target.manifests["offline/choerodon-iam:1.1.0"]) != pulled[0].Digest {
+				t.Errorf("digest of manifest is changed")
+			}
+
+			uploads := target.uploads
+			if pushed, err = pusher.Push(dest, sources); err != nil {
+				t.Fatal(err)
+			}
+			if !pushed[0].Skipped || !pushed[1].Skipped || target.uploads != uploads {
+				t.Errorf("existing images are pushed again")
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package image
+
+import (
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	std_errors "github.com/pkg/errors"
+	"strings"
+)
+
+const (
+	// 没有指定镜像仓库时使用 docker hub
+	defaultDomain = "docker.io"
+	// docker hub 的 API 地址
+	dockerHubHost = "registry-1.docker.io"
+	defaultTag    = "latest"
+)
+
+// Reference 是镜像的地址，比如 registry.cn-shanghai.aliyuncs.com/c7n/choerodon-iam:1.1.0
+type Reference struct {
+	Domain     string
+	Repository string
+	Tag        string
+	Digest     digest.Digest
+}
+
+// ParseReference 解析镜像地址，没有镜像仓库时使用 docker.io，没有 tag 和 digest 时使用 latest
+func ParseReference(s string) (Reference, error) {
+	ref := Reference{}
+	name := strings.TrimSpace(s)
+	if idx := strings.Index(name, "@"); idx >= 0 {
+		ref.Digest = digest.Digest(name[idx+1:])
+		if err := ref.Digest.Validate(); err != nil {
+			return ref, std_errors.WithMessage(err, fmt.Sprintf("invalid image %s", s))
+		}
+		name = name[:idx]
+	}
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		if ref.Tag = name[idx+1:]; ref.Tag == "" {
+			return ref, std_errors.Errorf("invalid image %s", s)
+		}
+		name = name[:idx]
+	}
+	ref.Domain = defaultDomain
+	if idx := strings.Index(name, "/"); idx > 0 {
+		if first := name[:idx]; strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Domain, name = first, name[idx+1:]
+		}
+	}
+	if name == "" || name != strings.ToLower(name) {
+		return ref, std_errors.Errorf("invalid image %s", s)
+	}
+	if ref.Domain == defaultDomain && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	ref.Repository = name
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = defaultTag
+	}
+	return ref, nil
+}
+
+// Name 返回不包含 tag 和 digest 的镜像名称
+func (r Reference) Name() string {
+	return r.Domain + "/" + r.Repository
+}
+
+func (r Reference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest.String()
+	}
+	return s
+}
+
+// reference 返回获取 manifest 使用的 tag 或者 digest，优先使用 digest
+func (r Reference) reference() string {
+	if r.Digest != "" {
+		return r.Digest.String()
+	}
+	return r.Tag
+}
+
+// host 返回镜像仓库 API 的地址
+func (r Reference) host() string {
+	if r.Domain == defaultDomain {
+		return dockerHubHost
+	}
+	return r.Domain
+}
+
+// FileName 返回保存为 docker-archive 时的文件名，比如 choerodon-iam-1.1.0.tar
+func (r Reference) FileName() string {
+	name := r.Repository[strings.LastIndex(r.Repository, "/")+1:]
+	if r.Tag != "" {
+		return fmt.Sprintf("%s-%s.tar", name, r.Tag)
+	}
+	return fmt.Sprintf("%s-%s.tar", name, r.Digest.Encoded()[:12])
+}
@@ -0,0 +1,44 @@
+package image
+
+import (
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	cases := map[string]Reference{
+		"nginx":             {Domain: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		"choerodon/iam:1.0": {Domain: "docker.io", Repository: "choerodon/iam", Tag: "1.0"},
+		"registry.cn-shanghai.aliyuncs.com/c7n/choerodon-iam:1.1.0": {
+			Domain: "registry.cn-shanghai.aliyuncs.com", Repository: "c7n/choerodon-iam", Tag: "1.1.0"},
+		"localhost:5000/c7n/redis": {Domain: "localhost:5000", Repository: "c7n/redis", Tag: "latest"},
+		"harbor.example.com/c7n/mysql@sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08": {
+			Domain: "harbor.example.com", Repository: "c7n/mysql",
+			Digest: "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+	}
+	for s, expected := range cases {
+		ref, err := ParseReference(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if ref != expected {
+			t.Errorf("%s: expected %+v, got %+v", s, expected, ref)
+		}
+	}
+
+	for _, s := range []string{"", "nginx:", "Nginx", "harbor.example.com/", "nginx@sha256:123"} {
+		if _, err := ParseReference(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestReferenceFileName(t *testing.T) {
+	ref, _ := ParseReference("registry.cn-shanghai.aliyuncs.com/c7n/choerodon-iam:1.1.0")
+	if name := ref.FileName(); name != "choerodon-iam-1.1.0.tar" {
+		t.Errorf("expected choerodon-iam-1.1.0.tar, got %s", name)
+	}
+	if s := ref.String(); s != "registry.cn-shanghai.aliyuncs.com/c7n/choerodon-iam:1.1.0" {
+		t.Errorf("unexpected reference %s", s)
+	}
+}
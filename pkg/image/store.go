@@ -0,0 +1,160 @@
+package image
+
+import (
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 下载 blob 失败时的重试次数，每次从已经下载的位置继续
+const blobRetries = 3
+
+// blobOpener 返回 blob 从 offset 开始的内容，resumed 为 false 时内容从头开始
+type blobOpener func(offset int64) (body io.ReadCloser, resumed bool, err error)
+
+// blobStore 按照 digest 保存 blob，目录结构和 OCI layout 的 blobs 目录一致，多个镜像共享的层只下载一次
+type blobStore struct {
+	dir string
+
+	mu       sync.Mutex
+	inflight map[digest.Digest]*fetchCall
+}
+
+type fetchCall struct {
+	done chan struct{}
+	err  error
+}
+
+func newBlobStore(dir string) *blobStore {
+	return &blobStore{dir: dir, inflight: map[digest.Digest]*fetchCall{}}
+}
+
+func (s *blobStore) path(d digest.Digest) string {
+	return filepath.Join(s.dir, d.Algorithm().String(), d.Encoded())
+}
+
+func (s *blobStore) has(d digest.Digest) bool {
+	_, err := os.Stat(s.path(d))
+	return err == nil
+}
+
+// put 保存内容已知的 blob，比如 manifest
+func (s *blobStore) put(data []byte) (digest.Digest, error) {
+	d := digest.FromBytes(data)
+	if s.has(d) {
+		return d, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path(d)), 0755); err != nil {
+		return d, err
+	}
+	tmp := s.path(d) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return d, err
+	}
+	return d, os.Rename(tmp, s.path(d))
+}
+
+// fetch 下载 desc 对应的 blob，已经存在时跳过。多个镜像同时需要同一个 blob 时只下载一次
+func (s *blobStore) fetch(desc ocispec.Descriptor, open blobOpener) error {
+	if s.has(desc.Digest) {
+		return nil
+	}
+	s.mu.Lock()
+	if c, ok := s.inflight[desc.Digest]; ok {
+		s.mu.Unlock()
+		<-c.done
+		return c.err
+	}
+	c := &fetchCall{done: make(chan struct{})}
+	s.inflight[desc.Digest] = c
+	s.mu.Unlock()
+
+	c.err = s.download(desc, open)
+	s.mu.Lock()
+	delete(s.inflight, desc.Digest)
+	s.mu.Unlock()
+	close(c.done)
+	return c.err
+}
+
+// download 下载到 <digest>.partial 中，中断后再次下载时从已经下载的位置继续，完成后校验 digest
+func (s *blobStore) download(desc ocispec.Descriptor, open blobOpener) error {
+	final := s.path(desc.Digest)
+	partial := final + ".partial"
+	if err := os.MkdirAll(filepath.Dir(final), 0755); err != nil {
+		return err
+	}
+	var err error
+	for attempt := 1; attempt <= blobRetries; attempt++ {
+		if err = s.resume(partial, desc.Size, open); err == nil {
+			break
+		}
+		log.Debugf("Download blob %s failed (attempt %d/%d): %s", desc.Digest, attempt, blobRetries, err)
+	}
+	if err != nil {
+		return std_errors.WithMessage(err, fmt.Sprintf("Failed to download blob %s", desc.Digest))
+	}
+
+	if err = verify(partial, desc.Digest); err != nil {
+		// 内容错误时不能继续下载，下次重新下载
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, final)
+}
+
+func (s *blobStore) resume(partial string, size int64, open blobOpener) error {
+	var offset int64
+	if fi, err := os.Stat(partial); err == nil {
+		offset = fi.Size()
+	}
+	if size > 0 && offset == size {
+		return nil
+	}
+	if size > 0 && offset > size {
+		offset = 0
+	}
+	body, resumed, err := open(offset)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	flag := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !resumed {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	} else {
+		log.Debugf("Resume downloading %s from %d bytes", filepath.Base(partial), offset)
+	}
+	f, err := os.OpenFile(partial, flag, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, body); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func verify(path string, d digest.Digest) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	actual, err := d.Algorithm().FromReader(f)
+	if err != nil {
+		return err
+	}
+	if actual != d {
+		return std_errors.Errorf("digest of blob %s mismatch, got %s", d, actual)
+	}
+	return nil
+}
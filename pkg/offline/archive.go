@@ -0,0 +1,228 @@
+package offline
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	std_errors "github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Create 在 dir 中写入 manifest、所有文件的 checksum 和签名，然后将 dir 打包为 tar.gz 格式的 archive。
+// key 为空时不签名，dir 中以 . 开头的文件和目录是缓存，不会打包
+func Create(dir, archive string, m *Manifest, key ed25519.PrivateKey) error {
+	data, err := yaml.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, ManifestFile), data, 0644); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(dir, SignatureFile))
+
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+	checksums := bytes.Buffer{}
+	for _, f := range files {
+		if f == ChecksumFile {
+			continue
+		}
+		d, err := fileDigest(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&checksums, "%s  %s\n", d.Encoded(), f)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, ChecksumFile), checksums.Bytes(), 0644); err != nil {
+		return err
+	}
+	if key != nil {
+		if err = ioutil.WriteFile(filepath.Join(dir, SignatureFile), sign(key, checksums.Bytes()), 0644); err != nil {
+			return err
+		}
+	}
+	if files, err = listFiles(dir); err != nil {
+		return err
+	}
+	return writeArchive(dir, archive, files)
+}
+
+// listFiles 返回 dir 中所有文件相对于 dir 的路径
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// writeArchive 将 files 打包到 archive 中，archive 中的路径以 dir 的目录名开头
+func writeArchive(dir, archive string, files []string) error {
+	prefix := filepath.Base(filepath.Clean(dir))
+	tmp := archive + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	err = func() error {
+		for _, name := range files {
+			if err := addFile(tw, path.Join(prefix, name), filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gw.Close()
+	}()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return std_errors.WithMessage(err, fmt.Sprintf("Failed to create %s", archive))
+	}
+	return os.Rename(tmp, archive)
+}
+
+func addFile(tw *tar.Writer, name, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: fi.Size(), ModTime: fi.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func fileDigest(file string) (digest.Digest, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return digest.SHA256.FromReader(f)
+}
+
+// parseChecksums 解析 sha256sum 格式的 checksum，返回文件路径对应的 digest
+func parseChecksums(data []byte) (map[string]digest.Digest, error) {
+	checksums := map[string]digest.Digest{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.SplitN(text, "  ", 2)
+		if len(fields) != 2 {
+			return nil, std_errors.Errorf("invalid line %d in %s", line, ChecksumFile)
+		}
+		d := digest.NewDigestFromEncoded(digest.SHA256, fields[0])
+		if err := d.Validate(); err != nil {
+			return nil, std_errors.WithMessage(err, fmt.Sprintf("invalid line %d in %s", line, ChecksumFile))
+		}
+		checksums[strings.TrimPrefix(fields[1], "*")] = d
+	}
+	return checksums, scanner.Err()
+}
+
+// entryName 去掉 archive 中路径的第一级目录，路径不安全时返回错误
+func entryName(name string) (string, error) {
+	clean := path.Clean(strings.TrimPrefix(name, "./"))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", std_errors.Errorf("unsafe path %s in package", name)
+	}
+	idx := strings.Index(clean, "/")
+	if idx < 0 {
+		return "", std_errors.Errorf("%s is not in the package directory", name)
+	}
+	return clean[idx+1:], nil
+}
+
+// Extract 解压离线包到 dest 目录，dest 中的路径不包含离线包的第一级目录
+func Extract(archive, dest string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return std_errors.WithMessage(err, fmt.Sprintf("%s is not a tar.gz file", archive))
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("Failed to read %s", archive))
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := entryName(h.Name)
+		if err != nil {
+			return err
+		}
+		if err = extractFile(tr, filepath.Join(dest, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
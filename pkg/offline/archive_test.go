@@ -0,0 +1,140 @@
+package offline
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestPackage(t *testing.T) (string, *Manifest) {
+	root, err := ioutil.TempDir("", "offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "choerodon-offline-1.1")
+	files := map[string]string{
+		"chart/choerodon-iam-1.1.0.tgz":      "iam chart",
+		"image/choerodon-iam-1.1.0.tar":      "iam image",
+		".blobs/sha256/0123456789abcdef0123": "cache",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m := &Manifest{Name: "choerodon", Version: "1.1", ImageFormat: "docker-archive"}
+	if err = m.AddChart(dir, "choerodon-iam", "1.1.0", filepath.Join(dir, "chart", "choerodon-iam-1.1.0.tgz")); err != nil {
+		t.Fatal(err)
+	}
+	m.Images = []Image{{
+		Reference: "registry.example.com/c7n/choerodon-iam:1.1.0",
+		Digest:    digest.FromString("manifest"),
+		File:      "image/choerodon-iam-1.1.0.tar",
+	}}
+	return dir, m
+}
+
+func TestCreateAndVerify(t *testing.T) {
+	dir, m := newTestPackage(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := dir + ".tar.gz"
+	if err = Create(dir, archive, m, key); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Verify(archive, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Charts) != 1 || result.Charts[0].File != "chart/choerodon-iam-1.1.0.tgz" || result.Charts[0].Digest != digest.FromString("iam chart") {
+		t.Errorf("unexpected charts %+v", result.Charts)
+	}
+	if len(result.Images) != 1 || result.Images[0].Reference != m.Images[0].Reference {
+		t.Errorf("unexpected images %+v", result.Images)
+	}
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err = Verify(archive, other); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("expected invalid signature, got %v", err)
+	}
+}
+
+func TestVerifyModifiedPackage(t *testing.T) {
+	dir, m := newTestPackage(t)
+	defer os.RemoveAll(filepath.Dir(dir))
+	archive := dir + ".tar.gz"
+	if err := Create(dir, archive, m, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改和删除文件后不更新 checksum 重新打包
+	if err := ioutil.WriteFile(filepath.Join(dir, "chart", "choerodon-iam-1.1.0.tgz"), []byte("modified"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "chart", "extra.tgz"), []byte("extra"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{ChecksumFile, ManifestFile, "chart/choerodon-iam-1.1.0.tgz", "chart/extra.tgz"}
+	if err := writeArchive(dir, archive, files); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Verify(archive, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, msg := range []string{
+		"checksum of chart/choerodon-iam-1.1.0.tgz mismatch",
+		"image/choerodon-iam-1.1.0.tar is missing",
+		"chart/extra.tgz is not in checksums.txt",
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q in %v", msg, err)
+		}
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	keyFile := filepath.Join(dir, "c7n.key")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	der, _ = x509.MarshalPKIXPublicKey(pub)
+	pubFile := filepath.Join(dir, "c7n.pub")
+	ioutil.WriteFile(pubFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+
+	loadedKey, err := LoadPrivateKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedPub, err := LoadPublicKey(pubFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loadedKey.Equal(key) || !loadedPub.Equal(pub) {
+		t.Errorf("loaded key mismatch")
+	}
+	if _, err = LoadPrivateKey(pubFile); err == nil {
+		t.Errorf("expected error of loading public key as private key")
+	}
+}
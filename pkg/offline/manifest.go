@@ -0,0 +1,121 @@
+package offline
+
+import (
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	std_errors "github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// ManifestFile 记录离线包的内容
+	ManifestFile = "manifest.yaml"
+	// ChecksumFile 记录离线包中所有文件的 sha256，格式和 sha256sum 一致
+	ChecksumFile = "checksums.txt"
+	// SignatureFile 是 ChecksumFile 的签名
+	SignatureFile = "checksums.txt.sig"
+
+	// ChartDir 和 ImageDir 是离线包中保存 chart 和镜像的目录
+	ChartDir = "chart"
+	ImageDir = "image"
+	// ResourceDir 保存安装定义、values 模版和 sql 脚本，目录结构和 manifests 一致
+	ResourceDir = "resource"
+)
+
+// Manifest 描述离线包中的 chart 和镜像
+type Manifest struct {
+	Name string `yaml:"name"`
+	// 猪齿鱼的版本
+	Version string `yaml:"version"`
+	// 生成离线包的 c7nctl 版本
+	ToolVersion string    `yaml:"tool-version"`
+	CreatedAt   time.Time `yaml:"created-at"`
+	Charts      []Chart   `yaml:"charts"`
+	// docker-archive 或者 oci
+	ImageFormat string  `yaml:"image-format"`
+	Images      []Image `yaml:"images"`
+}
+
+type Chart struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	// 相对于离线包根目录的路径
+	File   string        `yaml:"file"`
+	Digest digest.Digest `yaml:"digest"`
+}
+
+type Image struct {
+	Reference string `yaml:"reference"`
+	// 镜像 manifest 的 digest
+	Digest digest.Digest `yaml:"digest"`
+	// docker-archive 格式时相对于离线包根目录的路径
+	File string `yaml:"file,omitempty"`
+}
+
+// LoadManifest 读取离线包目录 dir 中的 manifest
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("%s is not an offline package", dir))
+	}
+	m := &Manifest{}
+	if err = yaml.Unmarshal(data, m); err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to parse %s", ManifestFile))
+	}
+	return m, nil
+}
+
+// ChartVersions 返回离线包中 chart 的所有版本
+func (m *Manifest) ChartVersions(name string) []string {
+	var versions []string
+	for _, c := range m.Charts {
+		if c.Name == name {
+			versions = append(versions, c.Version)
+		}
+	}
+	return versions
+}
+
+// Chart 返回离线包中 chart 的版本 version
+func (m *Manifest) Chart(name, version string) (Chart, bool) {
+	for _, c := range m.Charts {
+		if c.Name == name && c.Version == version {
+			return c, true
+		}
+	}
+	return Chart{}, false
+}
+
+// AddChart 添加 dir 中的 chart 文件 file
+func (m *Manifest) AddChart(dir, name, version, file string) error {
+	rel, err := filepath.Rel(dir, file)
+	if err != nil {
+		return err
+	}
+	d, err := fileDigest(file)
+	if err != nil {
+		return err
+	}
+	m.Charts = append(m.Charts, Chart{Name: name, Version: version, File: filepath.ToSlash(rel), Digest: d})
+	return nil
+}
+
+// files 返回 manifest 中的 chart 和镜像需要的文件及其 digest，digest 为空时只检查文件是否存在
+func (m *Manifest) files() map[string]digest.Digest {
+	files := map[string]digest.Digest{}
+	for _, c := range m.Charts {
+		files[c.File] = c.Digest
+	}
+	for _, i := range m.Images {
+		if i.File != "" {
+			files[i.File] = ""
+		} else if i.Digest != "" {
+			// OCI layout 中 manifest 保存在 blobs 目录中
+			files[ImageDir+"/blobs/"+i.Digest.Algorithm().String()+"/"+i.Digest.Encoded()] = i.Digest
+		}
+	}
+	return files
+}
@@ -0,0 +1,73 @@
+package offline
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	std_errors "github.com/pkg/errors"
+	"io/ioutil"
+	"strings"
+)
+
+// LoadPrivateKey 读取 PEM 格式的 ed25519 私钥，比如 openssl genpkey -algorithm ed25519 生成的私钥
+func LoadPrivateKey(file string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(file, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, std_errors.WithMessagef(err, "Failed to parse private key %s", file)
+	}
+	k, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, std_errors.Errorf("%s is not an ed25519 private key", file)
+	}
+	return k, nil
+}
+
+// LoadPublicKey 读取 PEM 格式的 ed25519 公钥，比如 openssl pkey -pubout 导出的公钥
+func LoadPublicKey(file string) (ed25519.PublicKey, error) {
+	der, err := readPEM(file, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, std_errors.WithMessagef(err, "Failed to parse public key %s", file)
+	}
+	k, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, std_errors.Errorf("%s is not an ed25519 public key", file)
+	}
+	return k, nil
+}
+
+func readPEM(file, blockType string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, std_errors.Errorf("no %s is found in %s", blockType, file)
+	}
+	return block.Bytes, nil
+}
+
+// sign 返回 base64 编码的签名
+func sign(key ed25519.PrivateKey, data []byte) []byte {
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)) + "\n")
+}
+
+func verifySignature(key ed25519.PublicKey, data, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return std_errors.WithMessage(err, "invalid signature")
+	}
+	if !ed25519.Verify(key, data, sig) {
+		return std_errors.New("signature of checksums is invalid")
+	}
+	return nil
+}
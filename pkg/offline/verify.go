@@ -0,0 +1,140 @@
+package offline
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/ed25519"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/ioutil"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"os"
+	"sort"
+)
+
+// 离线包中 manifest、checksum 和签名的最大长度
+const maxMetadataSize = 16 << 20
+
+// Verify 校验离线包的完整性：所有文件的 checksum 正确、没有多余的文件、manifest 中的 chart 和镜像都存在，
+// key 不为空时校验签名。返回离线包的 manifest 和所有的错误
+func Verify(archive string, key ed25519.PublicKey) (*Manifest, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("%s is not a tar.gz file", archive))
+	}
+	defer gr.Close()
+
+	actual := map[string]digest.Digest{}
+	metadata := map[string][]byte{}
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to read %s", archive))
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := entryName(h.Name)
+		if err != nil {
+			return nil, err
+		}
+		switch name {
+		case ManifestFile, ChecksumFile, SignatureFile:
+			data, err := ioutil.ReadAll(io.LimitReader(tr, maxMetadataSize))
+			if err != nil {
+				return nil, err
+			}
+			metadata[name] = data
+			actual[name] = digest.SHA256.FromBytes(data)
+		default:
+			if actual[name], err = digest.SHA256.FromReader(tr); err != nil {
+				return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to read %s", archive))
+			}
+		}
+	}
+
+	if _, ok := metadata[ChecksumFile]; !ok {
+		return nil, std_errors.Errorf("%s is not found in package", ChecksumFile)
+	}
+	checksums, err := parseChecksums(metadata[ChecksumFile])
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	if sig, ok := metadata[SignatureFile]; key != nil {
+		if !ok {
+			errs = append(errs, std_errors.New("package is not signed"))
+		} else if err = verifySignature(key, metadata[ChecksumFile], sig); err != nil {
+			errs = append(errs, err)
+		}
+	} else if ok {
+		log.Warn("Package is signed, but no public key is provided to verify the signature")
+	}
+	errs = append(errs, compareChecksums(checksums, actual)...)
+
+	m := &Manifest{}
+	if data, ok := metadata[ManifestFile]; !ok {
+		errs = append(errs, std_errors.Errorf("%s is not found in package", ManifestFile))
+	} else if err = yaml.Unmarshal(data, m); err != nil {
+		errs = append(errs, std_errors.WithMessage(err, fmt.Sprintf("Failed to parse %s", ManifestFile)))
+	} else {
+		errs = append(errs, checkManifest(m, checksums)...)
+	}
+	return m, utilerrors.NewAggregate(errs)
+}
+
+// compareChecksums 比较 checksum 文件中记录的 digest 和离线包中实际的 digest
+func compareChecksums(expected, actual map[string]digest.Digest) []error {
+	var errs []error
+	for _, name := range sortedKeys(expected) {
+		d, ok := actual[name]
+		if !ok {
+			errs = append(errs, std_errors.Errorf("%s is missing", name))
+		} else if d != expected[name] {
+			errs = append(errs, std_errors.Errorf("checksum of %s mismatch, expected %s, got %s", name, expected[name], d))
+		}
+	}
+	for _, name := range sortedKeys(actual) {
+		if _, ok := expected[name]; !ok && name != ChecksumFile && name != SignatureFile {
+			errs = append(errs, std_errors.Errorf("%s is not in %s", name, ChecksumFile))
+		}
+	}
+	return errs
+}
+
+// checkManifest 检查 manifest 中的 chart 和镜像是否都在离线包中
+func checkManifest(m *Manifest, checksums map[string]digest.Digest) []error {
+	var errs []error
+	files := m.files()
+	for _, name := range sortedKeys(files) {
+		d, ok := checksums[name]
+		if !ok {
+			errs = append(errs, std_errors.Errorf("%s in %s is missing", name, ManifestFile))
+		} else if files[name] != "" && files[name] != d {
+			errs = append(errs, std_errors.Errorf("digest of %s does not match %s", name, ManifestFile))
+		}
+	}
+	return errs
+}
+
+func sortedKeys(m map[string]digest.Digest) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
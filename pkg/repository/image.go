@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ImageRegistry 通过 OCI distribution API 拉取镜像的 manifest 和 blob，认证方式和 chart 的 OCI 仓库一致
+type ImageRegistry struct {
+	g *registry
+}
+
+// NewImageRegistry 返回访问镜像仓库 host 的客户端，使用 repo 的认证信息和 tls 配置
+func NewImageRegistry(host string, repo Repository) (*ImageRegistry, error) {
+	repo.URL = OCIScheme + host
+	g, err := repo.registry()
+	if err != nil {
+		return nil, err
+	}
+	// 镜像的层可能很大，只限制等待响应的时间
+	g.client.Timeout = 0
+	if t, ok := g.client.Transport.(*http.Transport); ok {
+		t.ResponseHeaderTimeout = time.Minute
+	}
+	if repo.InsecureSkipTLSVerify && strings.HasPrefix(g.base, "https://") {
+		g.probeHTTP()
+	}
+	return &ImageRegistry{g: g}, nil
+}
+
+// Manifest 返回镜像 name 中 reference 的 manifest 及其类型，reference 可以是 tag 或者 digest
+func (r *ImageRegistry) Manifest(name, reference string, accept []string) ([]byte, string, error) {
+	resp, err := r.g.open(name, "/manifests/"+reference, strings.Join(accept, ", "), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaType := resp.Header.Get("Content-Type")
+	if idx := strings.Index(mediaType, ";"); idx > 0 {
+		mediaType = mediaType[:idx]
+	}
+	return data, strings.TrimSpace(mediaType), nil
+}
+
+// Blob 返回镜像 name 中 digest 从 offset 开始的内容，镜像仓库不支持 Range 时 resumed 为 false，内容从头开始
+func (r *ImageRegistry) Blob(name, digest string, offset int64) (body io.ReadCloser, resumed bool, err error) {
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{"Range": []string{fmt.Sprintf("bytes=%d-", offset)}}
+	}
+	resp, err := r.g.open(name, "/blobs/"+digest, "", header)
+	if err != nil {
+		return nil, false, err
+	}
+	return resp.Body, offset > 0 && resp.StatusCode == http.StatusPartialContent, nil
+}
+
+// HasBlob 判断镜像 name 中是否已经存在 digest 对应的 blob
+func (r *ImageRegistry) HasBlob(name, digest string) (bool, error) {
+	return r.g.hasBlob(name, digest)
+}
+
+// PushBlob 上传镜像 name 中长度为 size 的 blob
+func (r *ImageRegistry) PushBlob(name, digest string, size int64, body io.Reader) error {
+	return r.g.pushBlob(name, digest, size, body)
+}
+
+// ManifestDigest 返回镜像 name 中 reference 的 manifest 的 digest，不存在时返回空
+func (r *ImageRegistry) ManifestDigest(name, reference string, accept []string) (string, error) {
+	return r.g.manifestDigest(name, reference, accept)
+}
+
+// PushManifest 上传镜像 name 的 manifest，reference 可以是 tag 或者 digest
+func (r *ImageRegistry) PushManifest(name, reference, mediaType string, data []byte) error {
+	return r.g.pushManifest(name, reference, mediaType, data)
+}
+
+// probeHTTP 和 docker 的 insecure-registries 一致，不安全的镜像仓库只支持 http 时使用 http 访问
+func (g *registry) probeHTTP() {
+	resp, err := g.client.Get(g.base + "/v2/")
+	if err == nil {
+		resp.Body.Close()
+		return
+	}
+	if strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") {
+		g.base = "http://" + strings.TrimPrefix(g.base, "https://")
+	}
+}
@@ -0,0 +1,418 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	std_errors "github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// helm 3.4 的实验性 OCI 支持和 helm 3.8 之后使用的 chart 层的类型
+var chartLayerMediaTypes = []string{
+	"application/tar+gzip",
+	"application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+}
+
+// registry 通过 OCI distribution API 访问保存 chart 的镜像仓库，chart 保存在 <仓库地址>/<chart>:<version> 中
+type registry struct {
+	repo Repository
+	// 镜像仓库的地址，比如 https://harbor.example.com
+	base string
+	// 仓库中的路径，比如 c7n
+	path string
+
+	client *http.Client
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+type ociManifest struct {
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+func (r Repository) registry() (*registry, error) {
+	tlsConfig, err := r.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	ref := strings.TrimPrefix(normalize(r.URL), OCIScheme)
+	host, path := ref, ""
+	if idx := strings.Index(ref, "/"); idx > 0 {
+		host, path = ref[:idx], ref[idx+1:]
+	}
+	scheme := "https"
+	// 本地的镜像仓库一般不使用 https
+	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
+		scheme = "http"
+	}
+	return &registry{
+		repo:   r,
+		base:   fmt.Sprintf("%s://%s", scheme, host),
+		path:   path,
+		client: &http.Client{Timeout: 5 * time.Minute, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}},
+		tokens: map[string]string{},
+	}, nil
+}
+
+// tlsConfig 返回访问仓库使用的 tls 配置
+func (r Repository) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: r.InsecureSkipTLSVerify}
+	if r.CertFile != "" && r.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+		if err != nil {
+			return nil, std_errors.WithMessage(err, "Failed to load client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if r.CaFile != "" {
+		ca, err := ioutil.ReadFile(r.CaFile)
+		if err != nil {
+			return nil, std_errors.WithMessage(err, "Failed to load CA file")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, std_errors.Errorf("no certificate is found in %s", r.CaFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+func (g *registry) name(chart string) string {
+	if g.path == "" {
+		return chart
+	}
+	return g.path + "/" + chart
+}
+
+// listTags 返回 chart 的所有版本，OCI 的 tag 不支持 +，helm 推送时替换成了 _
+func (g *registry) listTags(chart string) ([]string, error) {
+	data, err := g.get(g.name(chart), "/tags/list", "")
+	if err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to list versions of chart %s", chart))
+	}
+	tags := struct {
+		Tags []string `json:"tags"`
+	}{}
+	if err = json.Unmarshal(data, &tags); err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(tags.Tags))
+	for _, t := range tags.Tags {
+		versions = append(versions, strings.ReplaceAll(t, "_", "+"))
+	}
+	return versions, nil
+}
+
+func (g *registry) pullChart(chart, version string) ([]byte, error) {
+	tag := strings.ReplaceAll(version, "+", "_")
+	data, err := g.get(g.name(chart), "/manifests/"+tag, ociManifestMediaType+", "+dockerManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	manifest := ociManifest{}
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	for _, l := range manifest.Layers {
+		for _, mt := range chartLayerMediaTypes {
+			if l.MediaType == mt {
+				return g.get(g.name(chart), "/blobs/"+l.Digest, "")
+			}
+		}
+	}
+	return nil, std_errors.Errorf("%s:%s is not a helm chart", g.name(chart), tag)
+}
+
+// get 请求镜像仓库中 name 的 API 并返回响应的内容
+func (g *registry) get(name, api, accept string) ([]byte, error) {
+	resp, err := g.open(name, api, accept, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// open 请求镜像仓库中 name 的 API，状态码不是 2xx 时返回错误
+func (g *registry) open(name, api, accept string, header http.Header) (*http.Response, error) {
+	if accept != "" {
+		header = cloneHeader(header)
+		header.Set("Accept", accept)
+	}
+	resp, err := g.send(http.MethodGet, name, api, "pull", header, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp, checkResponse(resp)
+}
+
+// send 发送请求到镜像仓库中 name 的 API，api 也可以是上传 blob 时返回的 Location。
+// 返回 401 时根据 WWW-Authenticate 使用 basic 或者 bearer token 认证后重试，actions 是需要的权限，比如 pull 或者 pull,push。
+// body 不能 Seek 时不会重试，所以上传之前需要先完成认证
+func (g *registry) send(method, name, api, actions string, header http.Header, body io.Reader) (*http.Response, error) {
+	u := api
+	switch {
+	case strings.HasPrefix(api, "http://") || strings.HasPrefix(api, "https://"):
+	case strings.HasPrefix(api, "/v2/"):
+		u = g.base + api
+	default:
+		u = g.base + "/v2/" + name + api
+	}
+	scope := "repository:" + name + ":" + actions
+
+	resp, err := g.do(method, u, g.authorization(scope), header, body)
+	if err != nil {
+		return nil, err
+	}
+	seeker, seekable := body.(io.Seeker)
+	if resp.StatusCode == http.StatusUnauthorized && (body == nil || seekable) {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		auth, err := g.authorize(challenge, scope)
+		if err != nil {
+			return nil, err
+		}
+		if seekable {
+			if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		if resp, err = g.do(method, u, auth, header, body); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (g *registry) do(method, url, auth string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := body.(*sizedBody); ok {
+		req.ContentLength = b.size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	return g.client.Do(req)
+}
+
+// hasBlob 判断 name 中是否存在 digest 对应的 blob
+func (g *registry) hasBlob(name, digest string) (bool, error) {
+	resp, err := g.send(http.MethodHead, name, "/blobs/"+digest, "pull,push", nil, nil)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return false, nil
+	}
+	if err = checkResponse(resp); err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// pushBlob 使用一次 PUT 上传长度为 size 的 blob，镜像仓库会校验 digest
+func (g *registry) pushBlob(name, digest string, size int64, body io.Reader) error {
+	resp, err := g.send(http.MethodPost, name, "/blobs/uploads/", "pull,push", nil, nil)
+	if err != nil {
+		return err
+	}
+	if err = checkResponse(resp); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	base, _ := url.Parse(g.base)
+	loc, err := base.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return std_errors.Errorf("registry %s returns invalid upload location %q", g.base, resp.Header.Get("Location"))
+	}
+	q := loc.Query()
+	q.Set("digest", digest)
+	loc.RawQuery = q.Encode()
+
+	header := http.Header{"Content-Type": []string{"application/octet-stream"}}
+	if resp, err = g.send(http.MethodPut, name, loc.String(), "pull,push", header, &sizedBody{Reader: body, size: size}); err != nil {
+		return err
+	}
+	if err = checkResponse(resp); err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// manifestDigest 返回 name 中 reference 的 manifest 的 digest，不存在时返回空
+func (g *registry) manifestDigest(name, reference string, accept []string) (string, error) {
+	header := http.Header{"Accept": []string{strings.Join(accept, ", ")}}
+	resp, err := g.send(http.MethodHead, name, "/manifests/"+reference, "pull,push", header, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return "", nil
+	}
+	if err = checkResponse(resp); err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Docker-Content-Digest"), nil
+}
+
+func (g *registry) pushManifest(name, reference, mediaType string, data []byte) error {
+	header := http.Header{"Content-Type": []string{mediaType}}
+	resp, err := g.send(http.MethodPut, name, "/manifests/"+reference, "pull,push", header, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if err = checkResponse(resp); err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// sizedBody 是长度已知的请求内容
+type sizedBody struct {
+	io.Reader
+	size int64
+}
+
+// checkResponse 状态码不是 2xx 时关闭 resp 并返回错误
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return std_errors.Errorf("%s %s failed: %s %s", resp.Request.Method, resp.Request.URL, resp.Status, strings.TrimSpace(string(body)))
+}
+
+func cloneHeader(h http.Header) http.Header {
+	if h == nil {
+		return http.Header{}
+	}
+	return h.Clone()
+}
+
+func (g *registry) authorization(scope string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.tokens[scope]
+}
+
+// authorize 根据认证质询获取 Authorization，并缓存到 scope 中
+func (g *registry) authorize(challenge, scope string) (string, error) {
+	var auth string
+	switch {
+	case strings.HasPrefix(strings.ToLower(challenge), "basic"):
+		if g.repo.Username == "" {
+			return "", std_errors.Errorf("registry %s requires username and password", g.base)
+		}
+		req, _ := http.NewRequest(http.MethodGet, g.base, nil)
+		req.SetBasicAuth(g.repo.Username, g.repo.Password)
+		auth = req.Header.Get("Authorization")
+	case strings.HasPrefix(strings.ToLower(challenge), "bearer"):
+		params := parseChallenge(challenge[len("bearer"):])
+		token, err := g.fetchToken(params["realm"], params["service"], scope)
+		if err != nil {
+			return "", err
+		}
+		auth = "Bearer " + token
+	default:
+		return "", std_errors.Errorf("unsupported authentication challenge %q of registry %s", challenge, g.base)
+	}
+	g.mu.Lock()
+	g.tokens[scope] = auth
+	g.mu.Unlock()
+	return auth, nil
+}
+
+func (g *registry) fetchToken(realm, service, scope string) (string, error) {
+	if realm == "" {
+		return "", std_errors.Errorf("registry %s returns bearer challenge without realm", g.base)
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if service != "" {
+		q.Set("service", service)
+	}
+	q.Set("scope", scope)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if g.repo.Username != "" {
+		req.SetBasicAuth(g.repo.Username, g.repo.Password)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", std_errors.Errorf("get token of %s from %s failed: %s", scope, realm, resp.Status)
+	}
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// parseChallenge 解析 realm="...",service="...",scope="..."，引号中可能有逗号
+func parseChallenge(s string) map[string]string {
+	params := map[string]string{}
+	var parts []string
+	quoted, start := false, 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, s[start:])
+	for _, part := range parts {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToLower(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	return params
+}
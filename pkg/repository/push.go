@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	helmConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+	helmChartMediaType  = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// Push 上传 chart 包到仓库中，仓库中已经存在相同版本时跳过，返回是否上传了 chart。
+// 根据仓库地址判断仓库的类型：oci:// 开头的是 OCI 镜像仓库，<harbor>/chartrepo/<project> 是 harbor，
+// <nexus>/repository/<repo> 是 nexus 的 helm hosted 仓库，其他的是 chartmuseum
+func (r Repository) Push(file string) (bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	ch, err := loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return false, std_errors.WithMessage(err, fmt.Sprintf("%s is not a valid chart", file))
+	}
+	name, version := ch.Metadata.Name, ch.Metadata.Version
+	if versions, err := r.ListVersions(name); err == nil {
+		for _, v := range versions {
+			if v == version {
+				log.Debugf("Chart %s-%s already exists in %s", name, version, r.URL)
+				return false, nil
+			}
+		}
+	}
+
+	if r.IsOCI() {
+		g, err := r.registry()
+		if err != nil {
+			return false, err
+		}
+		err = g.pushChart(ch.Metadata, data)
+		return err == nil, err
+	}
+	return r.upload(filepath.Base(file), data)
+}
+
+// upload 通过 chart 仓库的 API 上传 chart，仓库返回 409 时表示 chart 已经存在
+func (r Repository) upload(filename string, data []byte) (bool, error) {
+	u, err := url.Parse(normalize(r.URL))
+	if err != nil {
+		return false, err
+	}
+	path := strings.Trim(u.Path, "/")
+	var req *http.Request
+	switch {
+	case strings.HasPrefix(path, "repository/"):
+		req, err = http.NewRequest(http.MethodPut, normalize(r.URL)+"/"+filename, bytes.NewReader(data))
+	case strings.HasPrefix(path, "chartrepo/"):
+		u.Path = "/api/chartrepo/" + strings.TrimPrefix(path, "chartrepo/") + "/charts"
+		req, err = newChartUploadRequest(u.String(), filename, data)
+	default:
+		u.Path = "/api/charts"
+		if path != "" {
+			u.Path = "/api/" + path + "/charts"
+		}
+		req, err = newChartUploadRequest(u.String(), filename, data)
+	}
+	if err != nil {
+		return false, err
+	}
+	if r.Username != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+	tlsConfig, err := r.tlsConfig()
+	if err != nil {
+		return false, err
+	}
+	client := &http.Client{Timeout: 5 * time.Minute, Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		log.Debugf("Chart %s already exists in %s", filename, r.URL)
+		return false, nil
+	}
+	if err = checkResponse(resp); err != nil {
+		return false, std_errors.WithMessage(err, fmt.Sprintf("Failed to push chart %s to %s", filename, r.URL))
+	}
+	return true, resp.Body.Close()
+}
+
+// newChartUploadRequest 返回 chartmuseum 和 harbor 使用的 multipart 上传请求
+func newChartUploadRequest(url, filename string, data []byte) (*http.Request, error) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("chart", filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(part, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req, nil
+}
+
+// pushChart 按照 helm 的 OCI 格式上传 chart，OCI 的 tag 不支持 +，替换成 _
+func (g *registry) pushChart(metadata *chart.Metadata, data []byte) error {
+	name := g.name(metadata.Name)
+	config, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	type descriptor struct {
+		MediaType string        `json:"mediaType"`
+		Digest    digest.Digest `json:"digest"`
+		Size      int64         `json:"size"`
+	}
+	manifest := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		Config:        descriptor{MediaType: helmConfigMediaType, Digest: digest.FromBytes(config), Size: int64(len(config))},
+		Layers:        []descriptor{{MediaType: helmChartMediaType, Digest: digest.FromBytes(data), Size: int64(len(data))}},
+	}
+	for _, blob := range [][]byte{config, data} {
+		d := digest.FromBytes(blob).String()
+		exists, err := g.hasBlob(name, d)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if err = g.pushBlob(name, d, int64(len(blob)), bytes.NewReader(blob)); err != nil {
+				return err
+			}
+		}
+	}
+	m, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	tag := strings.ReplaceAll(metadata.Version, "+", "_")
+	if err = g.pushManifest(name, tag, ociManifestMediaType, m); err != nil {
+		return std_errors.WithMessage(err, fmt.Sprintf("Failed to push chart %s:%s", name, tag))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestPush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := chartutil.Save(&chart.Chart{Metadata: &chart.Metadata{APIVersion: "v2", Name: "choerodon-iam", Version: "1.1.0"}}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var uploads []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/chartrepo/c7n/index.yaml":
+			w.Write([]byte("apiVersion: v1\nentries:\n  choerodon-iam:\n  - name: choerodon-iam\n    version: 1.1.0\n"))
+		case r.Method == http.MethodPost:
+			if _, _, err := r.FormFile("chart"); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			uploads = append(uploads, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodPut:
+			uploads = append(uploads, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	cases := map[string]string{
+		server.URL:                       "POST /api/charts",
+		server.URL + "/c7n":              "POST /api/c7n/charts",
+		server.URL + "/repository/helm/": "PUT /repository/helm/choerodon-iam-1.1.0.tgz",
+		// index 中已经存在相同的版本
+		server.URL + "/chartrepo/c7n": "",
+	}
+	for url, expected := range cases {
+		uploads = nil
+		r := Repository{URL: url, Username: "admin", Password: "secret"}
+		pushed, err := r.Push(file)
+		if err != nil {
+			t.Errorf("%s: %v", url, err)
+			continue
+		}
+		if expected == "" {
+			if pushed || len(uploads) > 0 {
+				t.Errorf("%s: existing chart is pushed", url)
+			}
+		} else if !pushed || len(uploads) != 1 || uploads[0] != expected {
+			t.Errorf("%s: expected %s, got %v", url, expected, uploads)
+		}
+	}
+}
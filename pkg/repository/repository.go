@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/ghodss/yaml"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/getter"
+	helm_repo "helm.sh/helm/v3/pkg/repo"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// OCIScheme 开头的仓库地址是 OCI 镜像仓库，比如 oci://harbor.example.com/c7n
+const OCIScheme = "oci://"
+
+// Repository 是 chart 仓库及其认证信息
+type Repository struct {
+	// 仓库地址，配置中作为前缀匹配 release 使用的仓库地址
+	URL      string `yaml:"url" json:"url"`
+	Username string `yaml:"username" json:"username,omitempty"`
+	Password string `yaml:"password" json:"password,omitempty"`
+	CaFile   string `yaml:"ca-file" json:"caFile,omitempty"`
+	CertFile string `yaml:"cert-file" json:"certFile,omitempty"`
+	KeyFile  string `yaml:"key-file" json:"keyFile,omitempty"`
+	// 跳过 https 证书的校验
+	InsecureSkipTLSVerify bool `yaml:"insecure-skip-tls-verify" json:"insecureSkipTLSVerify,omitempty"`
+	// 从安装命名空间的 secret 中读取认证信息，见 LoadSecrets
+	Secret string `yaml:"secret" json:"secret,omitempty"`
+	// index.yaml 中的 chart 地址与仓库的协议或者域名不同时也发送用户名、密码和客户端证书，与 helm 的 --pass-credentials 一致
+	PassCredentials bool `yaml:"pass-credentials" json:"passCredentials,omitempty"`
+}
+
+var (
+	mu           sync.Mutex
+	repositories []Repository
+	indexes      = map[string]*helm_repo.IndexFile{}
+)
+
+// SetRepositories 设置 chart 仓库的认证信息
+func SetRepositories(repos []Repository) {
+	mu.Lock()
+	defer mu.Unlock()
+	repositories = append([]Repository(nil), repos...)
+	indexes = map[string]*helm_repo.IndexFile{}
+}
+
+// Find 返回访问 url 使用的认证信息，使用最长的前缀匹配，没有配置时只包含 url
+func Find(url string) Repository {
+	mu.Lock()
+	defer mu.Unlock()
+	r := Repository{URL: url}
+	matched := -1
+	for _, repo := range repositories {
+		prefix := normalize(repo.URL)
+		if prefix == "" || len(prefix) <= matched {
+			continue
+		}
+		if target := normalize(url); target == prefix || strings.HasPrefix(target, prefix+"/") {
+			matched = len(prefix)
+			r = repo
+			r.URL = url
+		}
+	}
+	return r
+}
+
+// IsOCI 判断仓库地址是否是 OCI 镜像仓库
+func IsOCI(url string) bool {
+	return strings.HasPrefix(url, OCIScheme)
+}
+
+func normalize(url string) string {
+	return strings.TrimRight(strings.TrimSpace(url), "/")
+}
+
+func (r Repository) IsOCI() bool {
+	return IsOCI(r.URL)
+}
+
+// ListVersions 返回仓库中 chart 的所有版本
+func (r Repository) ListVersions(chart string) ([]string, error) {
+	if r.IsOCI() {
+		g, err := r.registry()
+		if err != nil {
+			return nil, err
+		}
+		return g.listTags(chart)
+	}
+	index, err := r.index()
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, cv := range index.Entries[chart] {
+		versions = append(versions, cv.Version)
+	}
+	if len(versions) == 0 {
+		return nil, std_errors.Errorf("chart %s is not found in %s", chart, r.URL)
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
+// Pull 下载 chart 到 dest 目录，返回 chart 包的路径，已经存在时不再下载
+func (r Repository) Pull(chart, version, dest string) (string, error) {
+	if version == "" {
+		return "", std_errors.Errorf("version of chart %s is required", chart)
+	}
+	file := filepath.Join(dest, fmt.Sprintf("%s-%s.tgz", chart, version))
+	if _, err := os.Stat(file); err == nil {
+		log.Debugf("Chart %s already exists", file)
+		return file, nil
+	}
+	var (
+		data []byte
+		err  error
+	)
+	if r.IsOCI() {
+		var g *registry
+		if g, err = r.registry(); err == nil {
+			data, err = g.pullChart(chart, version)
+		}
+	} else {
+		data, err = r.download(chart, version)
+	}
+	if err != nil {
+		return "", std_errors.WithMessage(err, fmt.Sprintf("Failed to pull chart %s-%s from %s", chart, version, r.URL))
+	}
+	if err = os.MkdirAll(dest, 0755); err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(file, data, 0644); err != nil {
+		return "", err
+	}
+	log.Debugf("Pulled chart %s from %s", file, r.URL)
+	return file, nil
+}
+
+func (r Repository) getterOptions() []getter.Option {
+	return []getter.Option{
+		getter.WithBasicAuth(r.Username, r.Password),
+		getter.WithTLSClientConfig(r.CertFile, r.KeyFile, r.CaFile),
+		getter.WithInsecureSkipVerifyTLS(r.InsecureSkipTLSVerify),
+	}
+}
+
+func (r Repository) get(url string) ([]byte, error) {
+	g, err := getter.NewHTTPGetter(r.getterOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := g.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// index 下载仓库的 index.yaml，同一个仓库只下载一次
+func (r Repository) index() (*helm_repo.IndexFile, error) {
+	key := normalize(r.URL)
+	mu.Lock()
+	index, ok := indexes[key]
+	mu.Unlock()
+	if ok {
+		return index, nil
+	}
+
+	data, err := r.get(key + "/index.yaml")
+	if err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to get index of chart repository %s", r.URL))
+	}
+	index = &helm_repo.IndexFile{}
+	if err = yaml.Unmarshal(data, index); err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to decode index of chart repository %s", r.URL))
+	}
+	index.SortEntries()
+
+	mu.Lock()
+	indexes[key] = index
+	mu.Unlock()
+	return index, nil
+}
+
+func (r Repository) download(chart, version string) ([]byte, error) {
+	index, err := r.index()
+	if err != nil {
+		return nil, err
+	}
+	cv, err := index.Get(chart, version)
+	if err != nil {
+		return nil, std_errors.Errorf("chart %s-%s is not found in %s", chart, version, r.URL)
+	}
+	if len(cv.URLs) == 0 {
+		return nil, std_errors.Errorf("chart %s-%s has no downloadable url", chart, version)
+	}
+	chartURL, err := helm_repo.ResolveReferenceURL(normalize(r.URL)+"/", cv.URLs[0])
+	if err != nil {
+		return nil, err
+	}
+	// index.yaml 可以指向任意地址，只向仓库所在的协议和域名发送认证信息
+	if !r.PassCredentials && !sameOrigin(r.URL, chartURL) {
+		log.Debugf("Chart %s-%s is not hosted by %s, downloading it without credentials", chart, version, r.URL)
+		return Repository{URL: chartURL, CaFile: r.CaFile, InsecureSkipTLSVerify: r.InsecureSkipTLSVerify}.get(chartURL)
+	}
+	return r.get(chartURL)
+}
+
+func sameOrigin(a, b string) bool {
+	u1, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	u2, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return u1.Scheme == u2.Scheme && u1.Host == u2.Host
+}
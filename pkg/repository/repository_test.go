@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFind(t *testing.T) {
+	SetRepositories([]Repository{
+		{URL: "https://harbor.example.com", Username: "admin"},
+		{URL: "https://harbor.example.com/chartrepo/c7n/", Username: "c7n"},
+	})
+	defer SetRepositories(nil)
+
+	cases := map[string]string{
+		"https://harbor.example.com/chartrepo/c7n":     "c7n",
+		"https://harbor.example.com/chartrepo/c7n/":    "c7n",
+		"https://harbor.example.com/chartrepo/c7n-dev": "admin",
+		"https://harbor.example.com.cn/c7n":            "",
+		"https://charts.example.com/c7n":               "",
+	}
+	for url, username := range cases {
+		r := Find(url)
+		if r.Username != username {
+			t.Errorf("%s: expected username %q, got %q", url, username, r.Username)
+		}
+		if r.URL != url {
+			t.Errorf("%s: url is changed to %s", url, r.URL)
+		}
+	}
+}
+
+func TestHTTPRepository(t *testing.T) {
+	index := `apiVersion: v1
+entries:
+  choerodon-iam:
+  - name: choerodon-iam
+    version: 1.1.0
+    urls:
+    - charts/choerodon-iam-1.1.0.tgz
+  - name: choerodon-iam
+    version: 1.0.0
+    urls:
+    - charts/choerodon-iam-1.0.0.tgz
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/c7n/index.yaml":
+			w.Write([]byte(index))
+		case "/c7n/charts/choerodon-iam-1.1.0.tgz":
+			w.Write([]byte("chart"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	SetRepositories(nil)
+	if _, err := Find(server.URL + "/c7n").ListVersions("choerodon-iam"); err == nil {
+		t.Error("expected an error without credentials")
+	}
+
+	SetRepositories([]Repository{{URL: server.URL, Username: "admin", Password: "secret"}})
+	defer SetRepositories(nil)
+	r := Find(server.URL + "/c7n")
+	versions, err := r.ListVersions("choerodon-iam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(versions, ",") != "1.0.0,1.1.0" {
+		t.Errorf("unexpected versions %v", versions)
+	}
+
+	dir, err := ioutil.TempDir("", "repository")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := r.Pull("choerodon-iam", "1.1.0", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(file); string(data) != "chart" {
+		t.Errorf("unexpected chart content %q", data)
+	}
+}
+
+func TestDownloadFromOtherHost(t *testing.T) {
+	// chart 保存在其他域名，不应该收到仓库的认证信息
+	var authorized bool
+	charts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, authorized = r.BasicAuth()
+		w.Write([]byte("chart"))
+	}))
+	defer charts.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, "apiVersion: v1\nentries:\n  choerodon-iam:\n  - name: choerodon-iam\n    version: 1.1.0\n    urls:\n    - %s/choerodon-iam-1.1.0.tgz\n", charts.URL)
+	}))
+	defer server.Close()
+
+	for _, pass := range []bool{false, true} {
+		SetRepositories([]Repository{{URL: server.URL, Username: "admin", Password: "secret", PassCredentials: pass}})
+		dir := t.TempDir()
+		if _, err := Find(server.URL).Pull("choerodon-iam", "1.1.0", dir); err != nil {
+			t.Fatal(err)
+		}
+		if authorized != pass {
+			t.Errorf("pass-credentials %v: credentials sent to %s: %v", pass, charts.URL, authorized)
+		}
+	}
+	SetRepositories(nil)
+}
+
+func TestOCIRepository(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/service/token" {
+			if u, p, ok := r.BasicAuth(); !ok || u != "admin" || p != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Query().Get("scope") != "repository:c7n/choerodon-iam:pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "t0ken"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/service/token",service="harbor-registry",scope="repository:c7n/choerodon-iam:pull,push"`, server.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/c7n/choerodon-iam/tags/list":
+			w.Write([]byte(`{"name":"c7n/choerodon-iam","tags":["1.0.0","1.1.0_build.1"]}`))
+		case "/v2/c7n/choerodon-iam/manifests/1.1.0_build.1":
+			w.Write([]byte(`{"schemaVersion":2,"layers":[{"mediaType":"application/vnd.cncf.helm.chart.content.v1.tar+gzip","digest":"sha256:abc"}]}`))
+		case "/v2/c7n/choerodon-iam/blobs/sha256:abc":
+			w.Write([]byte("chart"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	r := Repository{URL: OCIScheme + strings.TrimPrefix(server.URL, "http://") + "/c7n", Username: "admin", Password: "secret"}
+	if !r.IsOCI() {
+		t.Fatalf("%s should be an oci repository", r.URL)
+	}
+	versions, err := r.ListVersions("choerodon-iam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(versions, ",") != "1.0.0,1.1.0+build.1" {
+		t.Errorf("unexpected versions %v", versions)
+	}
+
+	dir, err := ioutil.TempDir("", "repository")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file, err := r.Pull("choerodon-iam", "1.1.0+build.1", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(file); string(data) != "chart" {
+		t.Errorf("unexpected chart content %q", data)
+	}
+}
+
+func TestLoadSecrets(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "harbor", Namespace: "c7n-system"},
+		Data: map[string][]byte{
+			SecretUsernameKey: []byte("admin"),
+			SecretPasswordKey: []byte("secret"),
+			SecretCaKey:       []byte("ca"),
+		},
+	})
+	SetRepositories([]Repository{{URL: "oci://harbor.example.com", Secret: "harbor"}})
+	defer SetRepositories(nil)
+
+	if err := LoadSecrets(nil, "c7n-system"); err == nil {
+		t.Error("expected an error without kubernetes client")
+	}
+	if err := LoadSecrets(client, "c7n-system"); err != nil {
+		t.Fatal(err)
+	}
+	r := Find("oci://harbor.example.com/c7n")
+	if r.Username != "admin" || r.Password != "secret" {
+		t.Errorf("credentials are not loaded: %+v", r)
+	}
+	if data, _ := ioutil.ReadFile(r.CaFile); string(data) != "ca" {
+		t.Errorf("ca file %s is not written", r.CaFile)
+	}
+	if r.CertFile != "" {
+		t.Errorf("cert file should be empty, got %s", r.CertFile)
+	}
+}
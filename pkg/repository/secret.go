@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"path/filepath"
+)
+
+// secret 中保存认证信息的 key，证书和 kubernetes.io/tls 类型的 secret 一致
+const (
+	SecretUsernameKey = "username"
+	SecretPasswordKey = "password"
+	SecretCaKey       = "ca.crt"
+	SecretCertKey     = "tls.crt"
+	SecretKeyKey      = "tls.key"
+)
+
+// LoadSecrets 从 namespace 中读取仓库配置的 secret，配置文件中已经设置的值优先。证书写入临时目录中供 helm 使用
+func LoadSecrets(client kubernetes.Interface, namespace string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	for idx := range repositories {
+		r := &repositories[idx]
+		if r.Secret == "" {
+			continue
+		}
+		if client == nil {
+			return std_errors.Errorf("kubernetes client is required to read secret %s of chart repository %s", r.Secret, r.URL)
+		}
+		secret, err := client.CoreV1().Secrets(namespace).Get(context.Background(), r.Secret, metav1.GetOptions{})
+		if err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("Failed to get secret %s of chart repository %s", r.Secret, r.URL))
+		}
+		if r.Username == "" {
+			r.Username = string(secret.Data[SecretUsernameKey])
+		}
+		if r.Password == "" {
+			r.Password = string(secret.Data[SecretPasswordKey])
+		}
+		files := map[string]*string{SecretCaKey: &r.CaFile, SecretCertKey: &r.CertFile, SecretKeyKey: &r.KeyFile}
+		for key, file := range files {
+			data, ok := secret.Data[key]
+			if !ok || *file != "" {
+				continue
+			}
+			if *file, err = writeTempFile(fmt.Sprintf("%s-%s", r.Secret, key), data); err != nil {
+				return err
+			}
+		}
+		log.Debugf("Loaded credentials of chart repository %s from secret %s", r.URL, r.Secret)
+	}
+	return nil
+}
+
+func writeTempFile(name string, data []byte) (string, error) {
+	dir := filepath.Join(os.TempDir(), "c7nctl-repository")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return "", err
+	}
+	return file, nil
+}
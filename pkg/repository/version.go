@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver/v3"
+	std_errors "github.com/pkg/errors"
+	"strings"
+)
+
+// DefaultConstraint 返回 choerodon 版本对应的 chart 版本约束，比如 1.1 对应 ~1.1，即 >=1.1.0 <1.2.0
+func DefaultConstraint(version string) string {
+	return "~" + strings.TrimPrefix(version, "v")
+}
+
+// MatchVersion 返回 versions 中满足 constraint 的最高版本，不是 semver 的版本被忽略。
+// 预发布版本只匹配包含预发布版本的约束，比如 >=1.1.0-0 <1.2.0-0 才会匹配 1.1.1-alpha.1
+func MatchVersion(versions []string, constraint string) (string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", std_errors.WithMessage(err, fmt.Sprintf("invalid version constraint %s", constraint))
+	}
+	var (
+		best    *semver.Version
+		matched string
+	)
+	for _, v := range versions {
+		sv, err := semver.NewVersion(v)
+		if err != nil || !c.Check(sv) {
+			continue
+		}
+		if best == nil || sv.GreaterThan(best) {
+			best, matched = sv, v
+		}
+	}
+	if best == nil {
+		return "", std_errors.Errorf("no version satisfies %s", constraint)
+	}
+	return matched, nil
+}
+
+// ResolveVersion 返回仓库中 chart 满足 constraint 的最高版本
+func (r Repository) ResolveVersion(chart, constraint string) (string, error) {
+	versions, err := r.ListVersions(chart)
+	if err != nil {
+		return "", err
+	}
+	version, err := MatchVersion(versions, constraint)
+	if err != nil {
+		return "", std_errors.WithMessage(err, fmt.Sprintf("Failed to resolve version of chart %s in %s", chart, r.URL))
+	}
+	return version, nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import "testing"
+
+func TestMatchVersion(t *testing.T) {
+	versions := []string{"1.0.3", "1.1.0", "1.1.10", "1.1.9", "1.2.0-alpha.1", "1.1.11-rc.1", "latest"}
+	cases := []struct {
+		constraint string
+		expected   string
+	}{
+		{"~1.1.0", "1.1.10"},
+		{DefaultConstraint("1.1"), "1.1.10"},
+		{DefaultConstraint("v1.0"), "1.0.3"},
+		{">=1.1.11-0 <1.2.0-0", "1.1.11-rc.1"},
+		{">=1.1.11-0 <1.2.0", ""},
+		{">=1.2.0-0", "1.2.0-alpha.1"},
+		{"^1.0.0", "1.1.10"},
+		{"~2.0", ""},
+	}
+	for _, c := range cases {
+		v, err := MatchVersion(versions, c.constraint)
+		if c.expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", c.constraint, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.constraint, err)
+			continue
+		}
+		if v != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.constraint, c.expected, v)
+		}
+	}
+	if _, err := MatchVersion(versions, "not a constraint"); err == nil {
+		t.Error("expected an error of invalid constraint")
+	}
+}
@@ -0,0 +1,65 @@
+package resource
+
+import (
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/config"
+	"github.com/choerodon/c7nctl/pkg/slaver"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	// 等待数据库可以连接的默认时间
+	defaultDatabaseTimeout = "10m"
+	// mysql 和 postgres 都支持的查询
+	databaseReadinessQuery = "SELECT 1"
+)
+
+// waitDatabase 执行 sql 前通过 slaver 重复连接数据库并执行简单的查询，直到成功或者超过截止时间，超时时返回最后一次的错误。
+// 能够自行重试的执行器只调用一次
+func (pi *ReleaseJob) waitDatabase(s slaver.Executor, res *config.Resource, sqlType string) error {
+	retry := Retry{Timeout: defaultDatabaseTimeout}
+	if pi.WaitDatabase != nil {
+		retry = *pi.WaitDatabase
+		if retry.Attempts == 0 && retry.Timeout == "" {
+			retry.Timeout = defaultDatabaseTimeout
+		}
+	}
+	attempts, interval, maxInterval, deadline, err := retry.parse()
+	if err != nil {
+		return std_errors.WithMessage(err, fmt.Sprintf("invalid waitDatabase of task %s", pi.Name))
+	}
+
+	address := fmt.Sprintf("%s:%d", res.Host, res.Port)
+	if waiter, ok := s.(slaver.DatabaseWaiter); ok {
+		wait := slaver.DatabaseWait{Attempts: attempts, Interval: interval, MaxInterval: maxInterval}
+		if !deadline.IsZero() {
+			wait.Timeout = time.Until(deadline)
+		}
+		if err := waiter.WaitDatabase(res, sqlType, wait); err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("database %s of task %s is not ready", address, pi.Name))
+		}
+		return nil
+	}
+	for attempt := 1; ; attempt++ {
+		// 不指定数据库，数据库可能由当前任务创建
+		err = s.ExecuteRemoteSql([]string{databaseReadinessQuery}, res, "", sqlType)
+		if err == nil {
+			if attempt > 1 {
+				log.Infof("Database %s of task %s is ready", address, pi.Name)
+			}
+			return nil
+		}
+
+		next := time.Now().Add(interval)
+		if attempt >= attempts || (!deadline.IsZero() && next.After(deadline)) {
+			return std_errors.Errorf("database %s of task %s is not ready after %d attempts, last error: %s", address, pi.Name, attempt, err)
+		}
+		log.Infof("Database %s of task %s is not ready: %s, retry after %s", address, pi.Name, err, interval)
+		time.Sleep(interval)
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package resource
+
+import (
+	"errors"
+	"github.com/choerodon/c7nctl/pkg/config"
+	"github.com/choerodon/c7nctl/pkg/slaver"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSqlExecutor 前 failures 次执行 sql 时返回错误
+type fakeSqlExecutor struct {
+	slaver.Executor
+	failures int
+	sqls     []string
+}
+
+func (f *fakeSqlExecutor) ExecuteRemoteSql(sqlList []string, resource *config.Resource, database, sqlType string) error {
+	f.sqls = append(f.sqls, sqlList...)
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("dial tcp 10.0.0.1:3306: connect: connection refused")
+	}
+	return nil
+}
+
+func TestWaitDatabase(t *testing.T) {
+	res := &config.Resource{Host: "c7n-mysql", Port: 3306}
+	job := ReleaseJob{Name: "predb", WaitDatabase: &Retry{Interval: "1ms", Timeout: "1s"}}
+
+	e := &fakeSqlExecutor{failures: 2}
+	if err := job.waitDatabase(e, res, "mysql"); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.sqls) != 3 || e.sqls[0] != databaseReadinessQuery {
+		t.Errorf("unexpected sqls %v", e.sqls)
+	}
+
+	job.WaitDatabase = &Retry{Attempts: 3, Interval: "1ms"}
+	e = &fakeSqlExecutor{failures: 5}
+	err := job.waitDatabase(e, res, "mysql")
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("want last error after 3 attempts, got %v", err)
+	}
+}
+
+// fakeDatabaseWaiter 自行等待数据库，不应该再通过 ExecuteRemoteSql 轮询
+type fakeDatabaseWaiter struct {
+	fakeSqlExecutor
+	waits []slaver.DatabaseWait
+}
+
+func (f *fakeDatabaseWaiter) WaitDatabase(resource *config.Resource, sqlType string, wait slaver.DatabaseWait) error {
+	f.waits = append(f.waits, wait)
+	return nil
+}
+
+func TestWaitDatabaseWithWaiter(t *testing.T) {
+	res := &config.Resource{Host: "c7n-mysql", Port: 3306}
+	job := ReleaseJob{Name: "predb", WaitDatabase: &Retry{Interval: "1s", MaxInterval: "5s", Timeout: "1m"}}
+
+	e := &fakeDatabaseWaiter{}
+	if err := job.waitDatabase(e, res, "mysql"); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.sqls) != 0 || len(e.waits) != 1 {
+		t.Fatalf("want one wait without polling, got sqls %v waits %v", e.sqls, e.waits)
+	}
+	w := e.waits[0]
+	if w.Interval != time.Second || w.MaxInterval != 5*time.Second || w.Timeout <= 0 || w.Timeout > time.Minute {
+		t.Errorf("unexpected wait %+v", w)
+	}
+}
@@ -0,0 +1,87 @@
+package resource
+
+import (
+	"fmt"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	std_errors "github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/postrender"
+	"time"
+)
+
+// 和 helm 命令行的默认值一致
+const defaultHelmTimeout = 5 * time.Minute
+
+// HelmOptions 是安装和升级 release 时 helm 的选项，release 中没有设置的值使用 basic 中的默认值
+type HelmOptions struct {
+	// 等待 release 的 pod、pvc、service 等资源就绪后才认为安装成功
+	Wait *bool `yaml:"wait"`
+	// 等待资源就绪以及执行 hook 的超时时间，比如 10m，默认为 5m
+	Timeout string `yaml:"timeout"`
+	// 安装或者升级失败时自动回滚，会同时开启 wait
+	Atomic       *bool `yaml:"atomic"`
+	DisableHooks *bool `yaml:"disableHooks"`
+	SkipCRDs     *bool `yaml:"skipCRDs"`
+	// 渲染后的 manifest 交给该可执行文件处理后再安装
+	PostRenderer string `yaml:"postRenderer"`
+}
+
+// Merge 返回 o 中没有设置的值使用 defaults 补全后的选项
+func (o *HelmOptions) Merge(defaults HelmOptions) HelmOptions {
+	if o == nil {
+		return defaults
+	}
+	merged := *o
+	if merged.Wait == nil {
+		merged.Wait = defaults.Wait
+	}
+	if merged.Timeout == "" {
+		merged.Timeout = defaults.Timeout
+	}
+	if merged.Atomic == nil {
+		merged.Atomic = defaults.Atomic
+	}
+	if merged.DisableHooks == nil {
+		merged.DisableHooks = defaults.DisableHooks
+	}
+	if merged.SkipCRDs == nil {
+		merged.SkipCRDs = defaults.SkipCRDs
+	}
+	if merged.PostRenderer == "" {
+		merged.PostRenderer = defaults.PostRenderer
+	}
+	return merged
+}
+
+// Apply 将选项设置到 args 中
+func (o HelmOptions) Apply(args *c7nclient.ChartArgs) error {
+	args.Timeout = defaultHelmTimeout
+	if o.Timeout != "" {
+		timeout, err := time.ParseDuration(o.Timeout)
+		if err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("invalid helm timeout %s", o.Timeout))
+		}
+		args.Timeout = timeout
+	}
+	args.Atomic = isTrue(o.Atomic)
+	// helm 的 atomic 也会开启 wait
+	args.Wait = isTrue(o.Wait) || args.Atomic
+	args.DisableHooks = isTrue(o.DisableHooks)
+	args.SkipCRDs = isTrue(o.SkipCRDs)
+	if o.PostRenderer != "" {
+		pr, err := postrender.NewExec(o.PostRenderer)
+		if err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("invalid post renderer %s", o.PostRenderer))
+		}
+		args.PostRenderer = pr
+	}
+	return nil
+}
+
+// HelmOptions 返回 release 使用的 helm 选项
+func (i *InstallDefinition) HelmOptions(rls *Release) HelmOptions {
+	return rls.Helm.Merge(i.Spec.Basic.Helm)
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
@@ -0,0 +1,59 @@
+package resource
+
+import (
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	"gopkg.in/yaml.v2"
+	"testing"
+	"time"
+)
+
+func TestHelmOptions(t *testing.T) {
+	data := `
+spec:
+  basic:
+    helm:
+      wait: true
+      timeout: 10m
+  release:
+    c7n:
+    - name: choerodon-iam
+      helm:
+        atomic: true
+        wait: false
+    - name: choerodon-asgard
+      helm:
+        timeout: 20m
+        skipCRDs: true
+    - name: choerodon-front
+`
+	instDef := &InstallDefinition{}
+	if err := yaml.Unmarshal([]byte(data), instDef); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []c7nclient.ChartArgs{
+		{Wait: true, Atomic: true, Timeout: 10 * time.Minute},
+		{Wait: true, Timeout: 20 * time.Minute, SkipCRDs: true},
+		{Wait: true, Timeout: 10 * time.Minute},
+	}
+	for idx, rls := range instDef.Spec.Release["c7n"] {
+		args := c7nclient.ChartArgs{}
+		if err := instDef.HelmOptions(rls).Apply(&args); err != nil {
+			t.Fatal(err)
+		}
+		if args != expected[idx] {
+			t.Errorf("%s: expected %+v, got %+v", rls.Name, expected[idx], args)
+		}
+	}
+
+	args := c7nclient.ChartArgs{}
+	if err := (HelmOptions{}).Apply(&args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Timeout != defaultHelmTimeout || args.Wait || args.Atomic {
+		t.Errorf("unexpected default options %+v", args)
+	}
+	if err := (HelmOptions{Timeout: "ten minutes"}).Apply(&args); err == nil {
+		t.Error("expected an error of invalid timeout")
+	}
+}
@@ -61,6 +61,20 @@ type Basic struct {
 	SkipInput bool
 	Timeout   int
 	Slaver    c7nslaver.Slaver
+	// 域名检查失败时终止安装
+	FatalDomainCheck bool `yaml:"fatalDomainCheck"`
+	// 执行 release job 的方式：slaver 或者 k8s-job，默认为 slaver
+	JobRunner string `yaml:"jobRunner"`
+	// 使用 k8s-job 执行时的镜像，必须是使用 docker/slaver.Dockerfile 构建的镜像
+	JobImage string `yaml:"jobImage"`
+	// 安装的实例名称，为空时是命名空间中的默认实例
+	Instance string `yaml:"-"`
+	// 所有 release 默认的 helm 选项
+	Helm HelmOptions `yaml:"helm"`
+	// 用户配置中应用到 release manifest 的补丁
+	Patches []c7ncfg.Patch `yaml:"-"`
+	// 注入到所有 release 的 values 中的调度策略
+	Scheduling Scheduling `yaml:"scheduling"`
 }
 
 func (i *InstallDefinition) IsApplication(name string) bool {
@@ -103,6 +117,9 @@ func (i *InstallDefinition) RenderReleases(name string, client *c7nclient.K8sCli
 			log.Errorf("Release %s render failed: %+v", rls.Name, err)
 		}
 		if err := i.CheckReleaseDomain(rls.Values); err != nil {
+			if i.Spec.Basic.FatalDomainCheck {
+				return std_errors.WithMessage(err, fmt.Sprintf("Check Release Domain %s failed", rls.Name))
+			}
 			log.Errorf("Check Release Domain %s failed: %+v", rls.Name, err)
 		}
 	}
@@ -158,6 +175,13 @@ func (i *InstallDefinition) CreatePersistence(r *Release, client *c7nclient.K8sC
 	for _, p := range r.Persistence {
 		p.Client = client
 		p.Namespace = namespace
+		// 同一个命名空间中不同前缀的实例使用不同的 pvc
+		if p.RefPvcName == "" {
+			p.RefPvcName = i.GetReleaseName(p.Name)
+		}
+		if p.CommonLabels == nil {
+			p.CommonLabels = i.Labels()
+		}
 		if err := p.CheckOrCreatePvc(i.GetStorageClass()); err != nil {
 			log.Error(err)
 		}
@@ -177,7 +201,34 @@ func (i *InstallDefinition) RenderHelmValues(r *Release, fileVals string) (map[s
 		}
 	}
 
-	return c7nutils.Vals(rlsVals, fileValsByte.String())
+	vals, err := c7nutils.Vals(rlsVals, fileValsByte.String())
+	if err != nil {
+		return nil, err
+	}
+	i.ApplyScheduling(r, vals)
+	return vals, nil
+}
+
+// SetInstance 使 slaver 和创建的资源只属于 instance，默认实例不做修改
+func (i *InstallDefinition) SetInstance(instance, namespace string) {
+	if c7nclient.IsDefaultInstance(instance, namespace) {
+		return
+	}
+	i.Spec.Basic.Instance = instance
+	i.Spec.Basic.Slaver.Name = fmt.Sprintf("%s-%s", i.Spec.Basic.Slaver.Name, instance)
+	i.Spec.Basic.Slaver.Instance = instance
+}
+
+// Labels 返回添加到安装创建的资源上的 labels，非默认实例添加 InstanceLabel。每次返回新的 map，不修改全局的 CommonLabels
+func (i *InstallDefinition) Labels() map[string]string {
+	l := map[string]string{}
+	for k, v := range c7nconsts.CommonLabels {
+		l[k] = v
+	}
+	if i.Spec.Basic.Instance != "" {
+		l[c7nconsts.InstanceLabel] = i.Spec.Basic.Instance
+	}
+	return l
 }
 
 func (i *InstallDefinition) SetPrefix(prefix string) {
@@ -221,11 +272,45 @@ func (i *InstallDefinition) MergerConfig(uc *c7ncfg.C7nConfig) {
 	if uc.Spec.ThinMode {
 		i.SetThinMode(true)
 	}
+	if uc.Spec.FatalDomainCheck {
+		i.Spec.Basic.FatalDomainCheck = true
+	}
+	if uc.GetIngressClassName() != "" {
+		i.Spec.Basic.Slaver.IngressClassName = uc.GetIngressClassName()
+	}
+	if uc.Spec.JobRunner != "" {
+		i.Spec.Basic.JobRunner = uc.Spec.JobRunner
+	}
+	if uc.Spec.JobImage != "" {
+		i.Spec.Basic.JobImage = uc.Spec.JobImage
+	}
+	if uc.Spec.HelmWait {
+		wait := true
+		i.Spec.Basic.Helm.Wait = &wait
+	}
+	if uc.Spec.HelmAtomic {
+		atomic := true
+		i.Spec.Basic.Helm.Atomic = &atomic
+	}
+	if uc.Spec.HelmTimeout != "" {
+		i.Spec.Basic.Helm.Timeout = uc.Spec.HelmTimeout
+	}
+	i.Spec.Basic.Patches = uc.Spec.Patches
+	i.Spec.Basic.Scheduling.MergeConfig(uc.Spec.Scheduling)
+}
+
+// UseJobRunner 返回是否使用 k8s job 执行 release job
+func (i *InstallDefinition) UseJobRunner() bool {
+	return i.Spec.Basic.JobRunner == c7nslaver.JobRunnerK8sJob
 }
 
 func (i *InstallDefinition) CheckReleaseDomain(values []c7nclient.ChartValue) error {
 	for _, v := range values {
 		// TODO 添加本地方式检查域名
+		if v.Check == "clusterdomain" && i.UseJobRunner() {
+			log.Warnf("Skip checking domain %s of %s without slaver, run `c7nctl check domains --mode dns,tls` instead", v.Value, v.Name)
+			continue
+		}
 		if v.Check == "clusterdomain" {
 			log.Debugf("Value %s: %s, checking: %s", v.Name, v.Value, v.Check)
 			if err := i.Spec.Basic.Slaver.CheckClusterDomain(v.Value); err != nil {
@@ -301,6 +386,11 @@ func (i *InstallDefinition) render(r *Release) error {
 	return nil
 }
 
+// RenderJobOutputs 在安装 release 前重新渲染，替换渲染时还没有执行的 release job 的输出变量
+func (i *InstallDefinition) RenderJobOutputs(r *Release) error {
+	return i.render(r)
+}
+
 // 传指针的方式好呢，还是返回值的方式好？
 //
 // 在渲染 release 前将 values 渲染完成
@@ -464,6 +554,17 @@ func (i *InstallDefinition) GetResourceDomainUrl(rls string) string {
 	return ""
 }
 
+// GetJobOutput 返回 release job 的 http 请求中提取的变量。任务还没有执行时保留模版，在安装 release 前重新渲染
+func (i *InstallDefinition) GetJobOutput(job, name string) string {
+	if task, err := c7nclient.GetTask(job); err == nil {
+		if v, ok := task.Outputs[name]; ok {
+			return v
+		}
+	}
+	log.Debugf("Output %s of task %s is not captured yet", name, job)
+	return fmt.Sprintf("{{ .GetJobOutput %q %q }}", job, name)
+}
+
 func (i *InstallDefinition) GetImageRepository() string {
 	return i.Spec.Basic.ImageRepository
 }
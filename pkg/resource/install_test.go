@@ -1,6 +1,7 @@
 package resource
 
 import (
+	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
 	"testing"
 )
 
@@ -44,40 +45,32 @@ func TestCleanJobs(t *testing.T) {
 		i.CleanJobs()*/
 }
 
-func TestRequestParserParams(t *testing.T) {
-	/*
-		param1 := ChartValue{
-			GetName:  "name",
-			Value: "value1",
-		}
-		param2 := ChartValue{
-			GetName:  "name2",
-			Value: "value5",
-		}
-		r := Request{
-			Parameters: []ChartValue{param1, param2},
-		}
-		if r.parserParams() != "name=value1&name2=value5" {
-			t.Error("request parames to params error")
-		}
-
-	*/
-}
-
-func TestRequestParserUrl(t *testing.T) {
-	/*
-		param1 := ChartValue{
-			GetName:  "name",
-			Value: "value1",
-		}
-		param2 := ChartValue{
-			GetName:  "name2",
-			Value: "value5",
-		}
-		r := Request{
-			Parameters: []ChartValue{param1, param2},
-		}
-		fmt.Println(r.parserUrl())
+func TestSetInstance(t *testing.T) {
+	i := &InstallDefinition{}
+	i.Spec.Basic.Slaver.Name = "c7n-slaver"
+	// 默认实例不修改
+	i.SetInstance("c7n-system", "c7n-system")
+	if i.Spec.Basic.Slaver.Name != "c7n-slaver" || i.Labels()[c7nconsts.InstanceLabel] != "" {
+		t.Errorf("default instance should not be changed: %s", i.Spec.Basic.Slaver.Name)
+	}
 
-	*/
+	i.SetPrefix("staging")
+	i.SetInstance("staging-c7n-system", "c7n-system")
+	if i.Spec.Basic.Slaver.Name != "c7n-slaver-staging-c7n-system" {
+		t.Errorf("unexpected slaver name %s", i.Spec.Basic.Slaver.Name)
+	}
+	if l := i.Labels(); l[c7nconsts.InstanceLabel] != "staging-c7n-system" || l[c7nconsts.C7nLabelKey] != c7nconsts.C7nLabelValue {
+		t.Errorf("unexpected labels %v", l)
+	}
+	// 实例只记录在安装定义中，不修改全局的 labels
+	if _, ok := c7nconsts.CommonLabels[c7nconsts.InstanceLabel]; ok {
+		t.Errorf("global labels should not be changed: %v", c7nconsts.CommonLabels)
+	}
+	i.Spec.Basic.Slaver.Init(nil, "c7n-system")
+	if i.Spec.Basic.Slaver.CommonLabels[c7nconsts.InstanceLabel] != "staging-c7n-system" {
+		t.Errorf("unexpected slaver labels %v", i.Spec.Basic.Slaver.CommonLabels)
+	}
+	if name := i.GetReleaseName("gitlab-runner-maven"); name != "staging-gitlab-runner-maven" {
+		t.Errorf("unexpected pvc name %s", name)
+	}
 }
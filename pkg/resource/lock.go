@@ -0,0 +1,79 @@
+package resource
+
+import (
+	"fmt"
+	std_errors "github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultLockFile 是默认的 lock 文件，保存在执行 c7nctl 的目录中
+	DefaultLockFile = "c7nctl.lock"
+	lockFileVersion = "v1"
+)
+
+// LockFile 记录安装时每个 release 使用的 chart 版本，使用 --locked 安装时使用其中的版本
+type LockFile struct {
+	Version string `yaml:"version"`
+	// 安装的应用和 choerodon 版本
+	Name             string          `yaml:"name"`
+	ChoerodonVersion string          `yaml:"choerodonVersion"`
+	Generated        time.Time       `yaml:"generated"`
+	Releases         []LockedRelease `yaml:"releases"`
+}
+
+type LockedRelease struct {
+	Name    string `yaml:"name"`
+	Chart   string `yaml:"chart"`
+	RepoURL string `yaml:"repoURL"`
+	Version string `yaml:"version"`
+	// 解析版本时使用的约束，release 直接指定了版本时为空
+	Constraint string `yaml:"constraint,omitempty"`
+}
+
+// LoadLockFile 读取 lock 文件
+func LoadLockFile(path string) (*LockFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to read lock file %s", path))
+	}
+	lock := &LockFile{}
+	if err = yaml.Unmarshal(data, lock); err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to decode lock file %s", path))
+	}
+	if lock.Version != lockFileVersion {
+		return nil, std_errors.Errorf("unsupported lock file version %s of %s", lock.Version, path)
+	}
+	return lock, nil
+}
+
+// Save 按照 release 名称排序后写入 path
+func (l *LockFile) Save(path string) error {
+	l.Version = lockFileVersion
+	sort.Slice(l.Releases, func(i, j int) bool {
+		return l.Releases[i].Name < l.Releases[j].Name
+	})
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Get 返回 release 锁定的版本，没有时返回 nil
+func (l *LockFile) Get(name string) *LockedRelease {
+	for idx := range l.Releases {
+		if l.Releases[idx].Name == name {
+			return &l.Releases[idx]
+		}
+	}
+	return nil
+}
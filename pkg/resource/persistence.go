@@ -42,7 +42,7 @@ func (p *Persistence) CheckOrCreatePv(pvs v1.PersistentVolumeSource) error {
 
 	ti, err := c7nclient.GetTask(p.Name)
 	if err != nil {
-		if err.Error() == "Task info is not found" {
+		if std_errors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
 			ti = &c7nclient.TaskInfo{
 				Name:    p.Name,
 				RefName: p.Name,
@@ -136,7 +136,9 @@ func (p *Persistence) createPv(pvs v1.PersistentVolumeSource) error {
 	}
 
 	news := p.prepareTaskInfo()
-	defer c7nclient.SaveTask(*news)
+	defer func() {
+		c7nclient.SaveTask(*news)
+	}()
 
 	_, err := p.Client.CreatePv(pv)
 	if err != nil {
@@ -178,7 +180,9 @@ func (p *Persistence) createPvc(sc string) error {
 
 	ti := p.prepareTaskInfo()
 	ti.RefName = p.RefPvcName
-	defer c7nclient.SaveTask(*ti)
+	defer func() {
+		c7nclient.SaveTask(*ti)
+	}()
 
 	_, err := p.Client.CreatePvc(p.Namespace, pvc)
 	if err != nil {
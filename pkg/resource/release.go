@@ -1,6 +1,8 @@
 package resource
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	c7nclient "github.com/choerodon/c7nctl/pkg/client"
@@ -8,9 +10,11 @@ import (
 	c7nerrors "github.com/choerodon/c7nctl/pkg/common/errors"
 	"github.com/choerodon/c7nctl/pkg/config"
 	"github.com/choerodon/c7nctl/pkg/slaver"
+	c7nutils "github.com/choerodon/c7nctl/pkg/utils"
 	std_errors "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"strings"
+	core_v1 "k8s.io/api/core/v1"
+	"time"
 )
 
 type Release struct {
@@ -30,26 +34,45 @@ type Release struct {
 	Prefix      string
 	SkipInput   bool
 	PaaSVersion string
+	// helm 的选项，没有设置的值使用 basic.helm 中的默认值
+	Helm *HelmOptions `yaml:"helm"`
+	// 没有指定 version 时解析 chart 版本的 semver 约束，比如 ~1.1.0，默认为 ~<choerodon 版本>
+	VersionConstraint string `yaml:"versionConstraint"`
+	// 全局调度策略的设置，可以不注入或者指定 values 映射
+	Scheduling *ReleaseScheduling `yaml:"scheduling"`
 }
 
 type ReleaseJob struct {
 	Name     string
 	InfraRef string `yaml:"infraRef"`
 	Database string `yaml:"database"`
+	// 使用 sh -c 依次执行的命令，默认在 slaver 中执行
 	Commands []string
-	Mysql    []string
-	Psql     []string `yaml:"psql"`
-	Opens    []string
-	Request  *Request
+	// 执行命令的镜像，为空时在 slaver 中执行
+	Image string
+	// 执行命令的工作目录
+	WorkingDir string `yaml:"workingDir"`
+	// 执行命令的环境变量，value 可以使用安装定义的模版
+	Env []c7nclient.ChartValue
+	// sql 语句，数据库类型由 sqlType 指定，默认为 mysql
+	Sql   []string `yaml:"sql"`
+	Mysql []string
+	Psql  []string `yaml:"psql"`
+	// sql 脚本的路径，相对于安装资源的路径或者是 URL。mysql 的脚本不在事务中执行，中途失败时需要手动清理已经执行的语句；
+	// postgres 的脚本在事务中执行，但是旧版本的 slaver 镜像不保证使用同一个数据库连接，此时也不使用事务
+	SqlFiles []string `yaml:"sqlFiles"`
+	// sql 和 sqlFiles 的数据库类型：mysql 或者 postgres，默认为 mysql
+	SqlType string `yaml:"sqlType"`
+	// 执行 sql 前等待数据库可以连接，默认最多等待 10m
+	WaitDatabase *Retry `yaml:"waitDatabase"`
+	Opens        []string
+	Request      *Request
+	// 按顺序执行的多个请求，前面步骤提取的变量可以在后面的步骤中使用
+	Requests []Request
 }
 
-type Request struct {
-	Header     []c7nclient.ChartValue
-	Url        string
-	Parameters []c7nclient.ChartValue
-	Body       string
-	Method     string
-}
+// ScriptLoader 读取 sqlFiles 中定义的脚本
+type ScriptLoader func(path string) ([]byte, error)
 
 // TODO 移动到 action 包
 func (r *Release) InstallComponent() error {
@@ -81,136 +104,256 @@ func (r *Release) InstallComponent() error {
 }
 
 // 执行 after Task，完成后更新任务状态，并执行 wg.done
-func (r *Release) ExecuteAfterTasks(s *slaver.Slaver) error {
+func (r *Release) ExecuteAfterTasks(s slaver.Executor, load ScriptLoader) error {
 
 	log.Infof("%s performs the necessary post operations", r.Name)
-	return r.executeExternalFunc(r.AfterInstall, s)
+	return r.executeExternalFunc(r.AfterInstall, s, load)
 }
 
-func (r *Release) ExecutePreCommands(s *slaver.Slaver) error {
+func (r *Release) ExecutePreCommands(s slaver.Executor, load ScriptLoader) error {
 	log.Infof("%s performs the necessary pre-operations", r.Name)
-	err := r.executeExternalFunc(r.PreInstall, s)
+	err := r.executeExternalFunc(r.PreInstall, s, load)
 	return err
 }
 
-func (r *Release) executeExternalFunc(c []ReleaseJob, s *slaver.Slaver) error {
-	for _, pi := range c {
-		if len(pi.Commands) > 0 {
-			if err := pi.executeSql(r, "mysql", s); err != nil {
-				return err
-			}
-		}
-		if len(pi.Mysql) > 0 {
-			if err := pi.executeSql(r, "mysql", s); err != nil {
-				return err
-			}
-		}
-		if len(pi.Psql) > 0 {
-			if err := pi.executeSql(r, "postgres", s); err != nil {
-				return err
-			}
-		}
-		if pi.Request != nil {
-			if err := pi.executeRequests(r, s); err != nil {
-				return err
-			}
+func (r *Release) executeExternalFunc(c []ReleaseJob, s slaver.Executor, load ScriptLoader) error {
+	for idx := range c {
+		if err := c[idx].execute(r, s, load); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (pi *ReleaseJob) executeSql(rls *Release, sqlType string, s *slaver.Slaver) error {
-
+// execute 依次执行任务的 sqlFiles、sql、commands 和 requests，所有步骤共用一个以任务名称保存的 task，
+// 全部成功后才设置任务的状态。sqlFiles 根据校验和每次都会检查，其他步骤在任务成功后不再执行
+func (pi *ReleaseJob) execute(rls *Release, s slaver.Executor, load ScriptLoader) error {
 	task, err := c7nclient.GetTask(pi.Name)
 	if err != nil {
-		if std_errors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
-			task = c7nclient.NewReleaseJobTask(pi.Name, consts.SqlTask, consts.Version)
-		} else {
+		if !std_errors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
 			return err
 		}
+		task = c7nclient.NewReleaseJobTask(pi.Name, pi.taskType(), consts.Version)
+		task.RefName = rls.Name
 	}
-	defer c7nclient.SaveTask(*task)
+	defer saveJobTask(task, s)
 
+	if err = pi.executeSteps(rls, task, s, load); err != nil {
+		task.Status = consts.FailedStatus
+		task.Reason = err.Error()
+		return err
+	}
+	task.Status = consts.SucceedStatus
+	task.Reason = ""
+	return nil
+}
+
+func (pi *ReleaseJob) executeSteps(rls *Release, task *c7nclient.TaskInfo, s slaver.Executor, load ScriptLoader) error {
+	if len(pi.SqlFiles) > 0 {
+		if err := pi.executeSqlFiles(task, s, load); err != nil {
+			return err
+		}
+	}
 	if task.Status == consts.SucceedStatus {
 		log.Infof("Task %s of %s had executed", pi.Name, rls.Name)
 		return nil
 	}
 
 	log.Infof("Executing task %s of %s", pi.Name, rls.Name)
+	if pi.isLegacySql() {
+		log.Warnf("Commands of task %s are executed as mysql because it has infraRef, please use sql instead", pi.Name)
+	}
+	for _, sqlType := range []string{"mysql", "postgres"} {
+		if sqlList := pi.sqlStatements(sqlType); len(sqlList) > 0 {
+			if err := pi.executeSql(sqlType, sqlList, s); err != nil {
+				return err
+			}
+		}
+	}
+	if len(pi.Commands) > 0 && !pi.isLegacySql() {
+		if err := pi.executeCommands(rls, s); err != nil {
+			return err
+		}
+	}
+	if pi.Request != nil || len(pi.Requests) > 0 {
+		if err := pi.executeRequests(task, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// taskType 返回任务保存在 task 中的类型
+func (pi *ReleaseJob) taskType() string {
+	switch {
+	case len(pi.SqlFiles) > 0 || len(pi.Sql) > 0 || len(pi.Mysql) > 0 || len(pi.Psql) > 0 || pi.isLegacySql():
+		return consts.SqlTask
+	case len(pi.Commands) > 0:
+		return consts.ShellTask
+	default:
+		return consts.HttpGetTask
+	}
+}
+
+// saveJobTask 保存 task 的最新状态。task 必须以指针传入 defer，defer c7nclient.SaveTask(*task) 在 defer 语句执行时就复制了 task，
+// 最后保存的旧副本会覆盖执行过程中记录的 Migrations
+func saveJobTask(task *c7nclient.TaskInfo, s slaver.Executor) {
+	collectLogs(task, s)
+	if _, err := c7nclient.SaveTask(*task); err != nil {
+		log.Errorf("Save task %s failed: %s", task.Name, err)
+	}
+}
+
+// collectLogs 将 k8s job 等执行器保存的日志记录到 task 中
+func collectLogs(task *c7nclient.TaskInfo, s slaver.Executor) {
+	if l, ok := s.(slaver.LogCollector); ok {
+		if logs := l.CollectLogs(); logs != "" {
+			task.Logs = logs
+		}
+	}
+}
+
+// isLegacySql 旧版本的安装定义使用 commands 定义 sql，这些任务都设置了 infraRef 并且没有使用 sql
+func (pi *ReleaseJob) isLegacySql() bool {
+	return len(pi.Commands) > 0 && pi.InfraRef != "" && len(pi.Sql) == 0 && len(pi.SqlFiles) == 0
+}
+
+func (pi *ReleaseJob) sqlType() string {
+	if pi.SqlType == "" {
+		return "mysql"
+	}
+	return pi.SqlType
+}
+
+// sqlStatements 返回 sqlType 类型的所有 sql 语句
+func (pi *ReleaseJob) sqlStatements(sqlType string) []string {
 	sqlList := make([]string, 0)
-	for _, v := range pi.Commands {
-		sqlList = append(sqlList, v)
+	if sqlType == "mysql" {
+		if pi.isLegacySql() {
+			sqlList = append(sqlList, pi.Commands...)
+		}
+		sqlList = append(sqlList, pi.Mysql...)
 	}
-	for _, v := range pi.Mysql {
-		sqlList = append(sqlList, v)
+	if sqlType == "postgres" {
+		sqlList = append(sqlList, pi.Psql...)
 	}
-	for _, v := range pi.Psql {
-		sqlList = append(sqlList, v)
+	if pi.sqlType() == sqlType {
+		sqlList = append(sqlList, pi.Sql...)
 	}
+	return sqlList
+}
+
+func (pi *ReleaseJob) executeSql(sqlType string, sqlList []string, s slaver.Executor) error {
 	rlsRef, err := c7nclient.GetTask(pi.InfraRef)
 	if err != nil {
 		return err
 	}
-	if err := s.ExecuteRemoteSql(sqlList, &rlsRef.Resource, pi.Database, sqlType); err != nil {
-		task.Status = consts.FailedStatus
-		task.Reason = err.Error()
+	if err := pi.waitDatabase(s, &rlsRef.Resource, sqlType); err != nil {
 		return err
 	}
-	task.Status = consts.SucceedStatus
-	return nil
+	return s.ExecuteRemoteSql(sqlList, &rlsRef.Resource, pi.Database, sqlType)
 }
 
-func (pi *ReleaseJob) executeRequests(rls *Release, s *slaver.Slaver) error {
-	if pi.Request == nil {
-		return nil
+// executeCommands 在 slaver 或者指定的镜像中执行命令，命令的输出写入安装日志，退出码不为 0 时任务失败
+func (pi *ReleaseJob) executeCommands(rls *Release, s slaver.Executor) error {
+	env := make([]core_v1.EnvVar, 0, len(pi.Env))
+	for _, e := range pi.Env {
+		env = append(env, core_v1.EnvVar{Name: e.Name, Value: e.Value})
 	}
-	task, err := c7nclient.GetTask(pi.Name)
-	if err != nil {
-		if std_errors.Is(err, c7nerrors.TaskInfoIsNotFoundError) {
-			task = &c7nclient.TaskInfo{
-				Name:     pi.Name,
-				RefName:  rls.Name,
-				Type:     consts.TaskType,
-				Status:   consts.UninitializedStatus,
-				TaskType: consts.HttpGetTask,
-				Version:  rls.Version,
-			}
-			task = c7nclient.NewReleaseJobTask(pi.Name, consts.HttpGetTask, consts.Version)
-		} else {
-			return err
-		}
+	results, err := s.ExecuteShell(slaver.Shell{
+		Name:       pi.Name,
+		Image:      pi.Image,
+		WorkingDir: pi.WorkingDir,
+		Env:        env,
+		Commands:   pi.Commands,
+	})
+	for _, r := range results {
+		log.Infof("Command of task %s exited with code %d: %s", pi.Name, r.ExitCode, r.Command)
 	}
-	if task.Type == consts.SucceedStatus {
-		log.Infof("task %s had executed", pi.Name)
-		return nil
+	if err != nil {
+		return std_errors.WithMessage(err, fmt.Sprintf("Failed to execute task %s of %s", pi.Name, rls.Name))
 	}
-	defer c7nclient.SaveTask(*task)
+	return nil
+}
 
-	req := pi.Request
-	header := make(map[string][]string)
-	for _, h := range req.Header {
-		header[h.Name] = []string{h.Value}
+// 依次执行 sqlFiles 中的脚本，已经执行过的脚本根据校验和跳过，执行后被修改的脚本会报错
+func (pi *ReleaseJob) executeSqlFiles(task *c7nclient.TaskInfo, s slaver.Executor, load ScriptLoader) error {
+	sqlType := pi.sqlType()
+	rlsRef, err := c7nclient.GetTask(pi.InfraRef)
+	if err != nil {
+		return err
 	}
 
-	reqUrl := req.Url
-	paramsString := req.parserParams()
-	if paramsString != "" {
-		reqUrl = reqUrl + "?" + paramsString
-	}
-	f := slaver.Forward{
-		Url:    reqUrl,
-		Body:   req.Body,
-		Header: header,
-		Method: req.Method,
+	// 有需要执行的脚本时才检查数据库
+	ready := false
+	for _, file := range pi.SqlFiles {
+		script, err := load(file)
+		if err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("Failed to load sql file %s", file))
+		}
+		sum := sha256.Sum256(script)
+		checksum := hex.EncodeToString(sum[:])
+
+		if m := task.GetMigration(file); m != nil {
+			if m.Checksum != checksum {
+				return std_errors.Errorf("sql file %s of task %s was modified after it was applied at %s, applied checksum %s, current checksum %s",
+					file, pi.Name, m.Date.Format(time.RFC3339), m.Checksum, checksum)
+			}
+			log.Infof("Sql file %s of task %s had executed", file, pi.Name)
+			continue
+		}
+
+		if !ready {
+			if err := pi.waitDatabase(s, &rlsRef.Resource, sqlType); err != nil {
+				return err
+			}
+			ready = true
+		}
+		log.Infof("Executing sql file %s of task %s", file, pi.Name)
+		sqlList := c7nutils.SplitSqlStatements(string(script), sqlType)
+		// mysql 的 DDL 会隐式提交事务，所以只有 postgres 的脚本在事务中执行。BEGIN 和 COMMIT 是单独的语句，
+		// 只有执行器在同一个数据库连接中执行所有语句时事务才有效
+		if sqlType == "postgres" {
+			if slaver.SingleSqlConnection(s) {
+				sqlList = append(append([]string{"BEGIN"}, sqlList...), "COMMIT")
+			} else {
+				log.Warnf("Sql file %s of task %s is executed without a transaction, the slaver may not execute all statements on one connection", file, pi.Name)
+			}
+		}
+		if err := s.ExecuteRemoteSql(sqlList, &rlsRef.Resource, pi.Database, sqlType); err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("Failed to execute sql file %s", file))
+		}
+		task.Migrations = append(task.Migrations, c7nclient.Migration{
+			Script:   file,
+			Checksum: checksum,
+			Date:     time.Now(),
+		})
+		// 每个脚本执行完成后保存，避免中断后重复执行
+		if _, err := c7nclient.SaveTask(*task); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	_, err = s.ExecuteRemoteRequest(f)
-	if err != nil {
-		task.Status = consts.FailedStatus
-		task.Reason = err.Error()
-		return err
+// executeRequests 依次执行 http 请求，请求中提取的变量保存在 task 中
+func (pi *ReleaseJob) executeRequests(task *c7nclient.TaskInfo, s slaver.Executor) error {
+	steps := pi.Requests
+	if pi.Request != nil {
+		steps = append([]Request{*pi.Request}, steps...)
 	}
-	task.Status = consts.SucceedStatus
+	outputs := make(map[string]string)
+	for idx, req := range steps {
+		name := req.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", idx+1)
+		}
+		log.Infof("Executing request %s of task %s", name, pi.Name)
+		if err := req.Execute(s.ExecuteRemoteRequestStatus, outputs); err != nil {
+			return std_errors.WithMessage(err, fmt.Sprintf("task %s request %s failed", pi.Name, name))
+		}
+	}
+	task.Outputs = outputs
 	return nil
 }
 
@@ -264,23 +407,6 @@ func (r *Release) HelmValues() []string {
 	return values
 }
 
-func (r *Request) parserParams() string {
-	var params []string
-	for _, p := range r.Parameters {
-		params = append(params, fmt.Sprintf("%s=%s", p.Name, p.Value))
-	}
-	return strings.Join(params, "&")
-}
-
-func (r *Request) parserUrl() string {
-	params := r.parserParams()
-	url := r.Url
-	if params != "" {
-		url = fmt.Sprintf("%s?%s", url, params)
-	}
-	return url
-}
-
 func (r *Release) String() string {
 	b, _ := json.MarshalIndent(*r, "\t", "\t")
 	return string(b)
@@ -1,7 +1,13 @@
 package resource
 
 import (
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	"github.com/choerodon/c7nctl/pkg/common/consts"
+	"github.com/choerodon/c7nctl/pkg/config"
+	"github.com/choerodon/c7nctl/pkg/slaver"
 	c7nutils "github.com/choerodon/c7nctl/pkg/utils"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -68,3 +74,162 @@ func TestRelease_String(t *testing.T) {
 		c7nutils.PrettyPrint(r)
 	}
 }
+
+func TestSqlStatements(t *testing.T) {
+	legacy := ReleaseJob{InfraRef: "c7n-mysql", Commands: []string{"CREATE DATABASE a"}}
+	if !legacy.isLegacySql() || len(legacy.sqlStatements("mysql")) != 1 {
+		t.Errorf("commands with infraRef should be executed as mysql")
+	}
+
+	job := ReleaseJob{
+		InfraRef: "gitlab-postgres",
+		SqlType:  "postgres",
+		Sql:      []string{"CREATE DATABASE b"},
+		Psql:     []string{"CREATE DATABASE c"},
+		Commands: []string{"echo done"},
+	}
+	if job.isLegacySql() {
+		t.Errorf("commands of job with sql should be executed as shell")
+	}
+	if s := job.sqlStatements("postgres"); len(s) != 2 || s[0] != "CREATE DATABASE c" || s[1] != "CREATE DATABASE b" {
+		t.Errorf("unexpected postgres statements %v", s)
+	}
+	if s := job.sqlStatements("mysql"); len(s) != 0 {
+		t.Errorf("unexpected mysql statements %v", s)
+	}
+}
+
+// fakeJobExecutor 记录执行的 sql 和命令
+type fakeJobExecutor struct {
+	fakeSqlExecutor
+	commands []string
+}
+
+func (f *fakeJobExecutor) ExecuteShell(sh slaver.Shell) ([]slaver.CommandResult, error) {
+	f.commands = append(f.commands, sh.Commands...)
+	return nil, nil
+}
+
+// sessionSqlExecutor 在同一个数据库连接中执行所有语句
+type sessionSqlExecutor struct {
+	fakeSqlExecutor
+}
+
+func (s *sessionSqlExecutor) SingleSqlConnection() bool {
+	return true
+}
+
+func TestExecuteSqlFiles(t *testing.T) {
+	if err := c7nclient.InitStateStore(c7nclient.StateOptions{Backend: c7nclient.StateBackendMemory, Namespace: "c7n-system"}); err != nil {
+		t.Fatal(err)
+	}
+	infra := c7nclient.NewReleaseTask("c7n-mysql", "c7n-system", "1.1", "")
+	infra.Resource = config.Resource{Host: "c7n-mysql", Port: 3306}
+	if _, err := c7nclient.SaveTask(*infra); err != nil {
+		t.Fatal(err)
+	}
+	scripts := map[string]string{
+		"sql/a.sql": "CREATE TABLE a (id int);",
+		"sql/b.sql": "CREATE TABLE b (id int);",
+	}
+	load := func(file string) ([]byte, error) {
+		return []byte(scripts[file]), nil
+	}
+	rls := &Release{Name: "choerodon-iam"}
+	job := ReleaseJob{Name: "choerodon-iam-predb", InfraRef: "c7n-mysql", SqlFiles: []string{"sql/a.sql", "sql/b.sql"}}
+
+	e := &fakeSqlExecutor{}
+	if err := job.execute(rls, e, load); err != nil {
+		t.Fatal(err)
+	}
+	task, err := c7nclient.GetTask(job.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(task.Migrations) != 2 || task.GetMigration("sql/b.sql") == nil {
+		t.Fatalf("applied scripts should be saved, got %+v", task.Migrations)
+	}
+
+	// 已经执行的脚本不会重复执行
+	e = &fakeSqlExecutor{}
+	if err = job.execute(rls, e, load); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.sqls) != 0 {
+		t.Errorf("applied scripts should be skipped, got %v", e.sqls)
+	}
+
+	scripts["sql/a.sql"] = "CREATE TABLE a (id bigint);"
+	if err = job.execute(rls, e, load); err == nil || !strings.Contains(err.Error(), "was modified") {
+		t.Errorf("modified script should be detected, got %v", err)
+	}
+
+	// postgres 的脚本只有在同一个数据库连接中执行时才使用事务
+	pg := ReleaseJob{Name: "gitlab-predb", InfraRef: "c7n-mysql", SqlType: "postgres", SqlFiles: []string{"sql/b.sql"}}
+	e = &fakeSqlExecutor{}
+	if err = pg.execute(rls, e, load); err != nil {
+		t.Fatal(err)
+	}
+	if containsString(e.sqls, "BEGIN") {
+		t.Errorf("script should not be wrapped in a transaction without a single connection: %v", e.sqls)
+	}
+	pg.Name = "gitlab-predb-session"
+	session := &sessionSqlExecutor{}
+	if err = pg.execute(rls, session, load); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(session.sqls); n < 2 || session.sqls[1] != "BEGIN" || session.sqls[n-1] != "COMMIT" {
+		t.Errorf("script should be wrapped in a transaction: %v", session.sqls)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestExecuteJobSteps(t *testing.T) {
+	if err := c7nclient.InitStateStore(c7nclient.StateOptions{Backend: c7nclient.StateBackendMemory, Namespace: "c7n-system"}); err != nil {
+		t.Fatal(err)
+	}
+	infra := c7nclient.NewReleaseTask("c7n-mysql", "c7n-system", "1.1", "")
+	if _, err := c7nclient.SaveTask(*infra); err != nil {
+		t.Fatal(err)
+	}
+	rls := &Release{Name: "choerodon-iam"}
+	job := ReleaseJob{
+		Name:     "choerodon-iam-init",
+		InfraRef: "c7n-mysql",
+		Sql:      []string{"CREATE DATABASE iam"},
+		Commands: []string{"echo done"},
+	}
+
+	// sql 和 commands 共用一个任务，sql 执行成功后 commands 仍然会执行
+	e := &fakeJobExecutor{}
+	if err := job.execute(rls, e, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !containsString(e.sqls, "CREATE DATABASE iam") || !reflect.DeepEqual(e.commands, []string{"echo done"}) {
+		t.Errorf("all steps should be executed, got sqls %v commands %v", e.sqls, e.commands)
+	}
+	task, err := c7nclient.GetTask(job.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != consts.SucceedStatus || task.TaskType != consts.SqlTask || task.RefName != rls.Name {
+		t.Errorf("unexpected task %+v", task)
+	}
+
+	// 任务成功后不再重复执行
+	e = &fakeJobExecutor{}
+	if err = job.execute(rls, e, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.sqls) != 0 || len(e.commands) != 0 {
+		t.Errorf("succeeded task should be skipped, got sqls %v commands %v", e.sqls, e.commands)
+	}
+}
@@ -0,0 +1,268 @@
+package resource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	"github.com/choerodon/c7nctl/pkg/slaver"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/util/jsonpath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryInterval    = 2 * time.Second
+	defaultRetryMaxInterval = 30 * time.Second
+)
+
+var variableRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Request 是 release job 中的 http 请求，请求通过 slaver 在集群内部发出
+type Request struct {
+	// 步骤名称，只用于日志
+	Name       string
+	Header     []c7nclient.ChartValue
+	Url        string
+	Parameters []c7nclient.ChartValue
+	Body       string
+	Method     string
+	// 期望的状态码，默认为 2xx 和 3xx
+	ExpectStatus []int `yaml:"expectStatus"`
+	// 对响应 body 的断言，全部满足才算成功
+	Assertions []Assertion
+	// 从响应 body 中提取的变量
+	Capture []Capture
+	Retry   *Retry
+}
+
+// Assertion 使用 JSONPath 或者正则表达式校验响应 body
+//
+// 只设置 regex 时匹配整个 body；设置 jsonPath 时对取到的值进行 equals 或者 regex 校验，都没有设置时只要求值存在
+type Assertion struct {
+	JsonPath string `yaml:"jsonPath"`
+	Regex    string
+	Equals   *string
+}
+
+// Capture 将响应 body 中的值保存为变量，regex 有分组时取第一个分组
+type Capture struct {
+	Name     string
+	JsonPath string `yaml:"jsonPath"`
+	Regex    string
+}
+
+// Retry 请求失败时按照指数退避重试，直到次数用完或者超过 timeout
+type Retry struct {
+	Attempts int
+	// 第一次重试的间隔，默认 2s
+	Interval string
+	// 重试的最大间隔，默认 30s
+	MaxInterval string `yaml:"maxInterval"`
+	// 从第一次请求开始计算的截止时间，例如 5m
+	Timeout string
+}
+
+// RequestExecutor 发出请求并返回状态码和 body
+type RequestExecutor func(f slaver.Forward) (int, string, error)
+
+// Execute 执行请求直到断言成功，成功后将提取的变量写入 vars
+//
+// Url、Body、Header 和 Parameters 中的 ${name} 会被替换为 vars 中已有的变量
+func (r *Request) Execute(exec RequestExecutor, vars map[string]string) error {
+	f := r.forward(vars)
+	attempts, interval, maxInterval, deadline, err := r.Retry.parse()
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		var code int
+		var body string
+		code, body, err = exec(f)
+		if err == nil {
+			err = r.check(code, body)
+		}
+		if err == nil {
+			return r.capture(body, vars)
+		}
+
+		next := time.Now().Add(interval)
+		if attempt >= attempts || (!deadline.IsZero() && next.After(deadline)) {
+			return std_errors.WithMessage(err, fmt.Sprintf("request %s failed after %d attempts", f.Url, attempt))
+		}
+		log.Infof("request %s failed: %s, retry after %s", f.Url, err, interval)
+		time.Sleep(interval)
+		if interval *= 2; interval > maxInterval {
+			interval = maxInterval
+		}
+	}
+}
+
+func (r *Request) forward(vars map[string]string) slaver.Forward {
+	header := make(map[string][]string)
+	for _, h := range r.Header {
+		header[h.Name] = []string{expandVariables(h.Value, vars)}
+	}
+	var params []string
+	for _, p := range r.Parameters {
+		params = append(params, fmt.Sprintf("%s=%s", p.Name, expandVariables(p.Value, vars)))
+	}
+	reqUrl := expandVariables(r.Url, vars)
+	if len(params) > 0 {
+		reqUrl = reqUrl + "?" + strings.Join(params, "&")
+	}
+	return slaver.Forward{
+		Url:    reqUrl,
+		Body:   expandVariables(r.Body, vars),
+		Header: header,
+		Method: r.Method,
+	}
+}
+
+func (r *Request) check(code int, body string) error {
+	if len(r.ExpectStatus) == 0 {
+		if code < 200 || code >= 400 {
+			return std_errors.Errorf("resp code %d not is 2xx or 3xx", code)
+		}
+	} else if !containsInt(r.ExpectStatus, code) {
+		return std_errors.Errorf("resp code %d not in %v", code, r.ExpectStatus)
+	}
+
+	for _, a := range r.Assertions {
+		if err := a.check(body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Request) capture(body string, vars map[string]string) error {
+	for _, c := range r.Capture {
+		value := body
+		if c.JsonPath != "" {
+			v, err := lookupJsonPath(body, c.JsonPath)
+			if err != nil {
+				return std_errors.WithMessage(err, fmt.Sprintf("capture %s failed", c.Name))
+			}
+			value = v
+		}
+		if c.Regex != "" {
+			re, err := regexp.Compile(c.Regex)
+			if err != nil {
+				return err
+			}
+			m := re.FindStringSubmatch(value)
+			if m == nil {
+				return std_errors.Errorf("capture %s failed: %q does not match %s", c.Name, value, c.Regex)
+			}
+			value = m[0]
+			if len(m) > 1 {
+				value = m[1]
+			}
+		}
+		vars[c.Name] = value
+	}
+	return nil
+}
+
+func (a *Assertion) check(body string) error {
+	value := body
+	if a.JsonPath != "" {
+		v, err := lookupJsonPath(body, a.JsonPath)
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+	if a.Equals != nil && value != *a.Equals {
+		return std_errors.Errorf("assert %s failed: want %q, got %q", a.JsonPath, *a.Equals, value)
+	}
+	if a.Regex != "" {
+		matched, err := regexp.MatchString(a.Regex, value)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			return std_errors.Errorf("assert %s failed: %q does not match %s", a.JsonPath, value, a.Regex)
+		}
+	}
+	return nil
+}
+
+func (r *Retry) parse() (attempts int, interval, maxInterval time.Duration, deadline time.Time, err error) {
+	attempts, interval, maxInterval = 1, defaultRetryInterval, defaultRetryMaxInterval
+	if r == nil {
+		return
+	}
+	if r.Interval != "" {
+		if interval, err = time.ParseDuration(r.Interval); err != nil {
+			return
+		}
+	}
+	if r.MaxInterval != "" {
+		if maxInterval, err = time.ParseDuration(r.MaxInterval); err != nil {
+			return
+		}
+	}
+	if r.Timeout != "" {
+		var timeout time.Duration
+		if timeout, err = time.ParseDuration(r.Timeout); err != nil {
+			return
+		}
+		deadline = time.Now().Add(timeout)
+	}
+	switch {
+	case r.Attempts > 0:
+		attempts = r.Attempts
+	case !deadline.IsZero():
+		// 只设置了 timeout 时一直重试到截止时间
+		attempts = int(^uint(0) >> 1)
+	}
+	return
+}
+
+// lookupJsonPath 支持 kubectl 的 jsonpath 语法，例如 {.data.id}，也可以写成 $.data.id 或者 .data.id
+func lookupJsonPath(body, path string) (string, error) {
+	var data interface{}
+	if err := json.Unmarshal([]byte(body), &data); err != nil {
+		return "", std_errors.WithMessage(err, "response body is not json")
+	}
+	if !strings.HasPrefix(path, "{") {
+		path = "{" + strings.TrimPrefix(strings.TrimPrefix(path, "$"), "@") + "}"
+	}
+	jp := jsonpath.New("request")
+	if err := jp.Parse(path); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := jp.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// expandVariables 替换前面步骤提取的 ${name}，不存在的变量保持不变
+func expandVariables(s string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return s
+	}
+	return variableRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := vars[m[2:len(m)-1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+func containsInt(list []int, i int) bool {
+	for _, l := range list {
+		if l == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package resource
+
+import (
+	"errors"
+	c7nclient "github.com/choerodon/c7nctl/pkg/client"
+	"github.com/choerodon/c7nctl/pkg/slaver"
+	"testing"
+)
+
+type fakeResponse struct {
+	code int
+	body string
+	err  error
+}
+
+func fakeExecutor(forwards *[]slaver.Forward, resp ...fakeResponse) RequestExecutor {
+	return func(f slaver.Forward) (int, string, error) {
+		*forwards = append(*forwards, f)
+		r := resp[0]
+		if len(resp) > 1 {
+			resp = resp[1:]
+		}
+		return r.code, r.body, r.err
+	}
+}
+
+func TestRequestExecute(t *testing.T) {
+	enabled := "true"
+	var forwards []slaver.Forward
+	vars := map[string]string{}
+
+	create := Request{
+		Url:          "http://oauth/v1/clients",
+		Method:       "POST",
+		ExpectStatus: []int{201},
+		Capture: []Capture{
+			{Name: "clientId", JsonPath: "$.data.id"},
+			{Name: "secret", JsonPath: ".data.secret", Regex: `^s-(\w+)$`},
+		},
+		Retry: &Retry{Attempts: 3, Interval: "1ms"},
+	}
+	exec := fakeExecutor(&forwards,
+		fakeResponse{err: errors.New("connection refused")},
+		fakeResponse{code: 200, body: `{"data":{"id":"1"}}`},
+		fakeResponse{code: 201, body: `{"data":{"id":"42","secret":"s-abc"}}`},
+	)
+	if err := create.Execute(exec, vars); err != nil {
+		t.Fatal(err)
+	}
+	if len(forwards) != 3 {
+		t.Errorf("want 3 attempts, got %d", len(forwards))
+	}
+	if vars["clientId"] != "42" || vars["secret"] != "abc" {
+		t.Errorf("unexpected captured variables %v", vars)
+	}
+
+	forwards = nil
+	enable := Request{
+		Url:        "http://gitlab/clients/${clientId}",
+		Body:       `{"secret":"${secret}","other":"${other}"}`,
+		Header:     []c7nclient.ChartValue{{Name: "X-Client", Value: "${clientId}"}},
+		Parameters: []c7nclient.ChartValue{{Name: "id", Value: "${clientId}"}},
+		Assertions: []Assertion{
+			{JsonPath: "{.enabled}", Equals: &enabled},
+			{Regex: `"name":"c7n"`},
+		},
+	}
+	exec = fakeExecutor(&forwards, fakeResponse{code: 200, body: `{"enabled":true,"name":"c7n"}`})
+	if err := enable.Execute(exec, vars); err != nil {
+		t.Fatal(err)
+	}
+	f := forwards[0]
+	if f.Url != "http://gitlab/clients/42?id=42" || f.Body != `{"secret":"abc","other":"${other}"}` || f.Header["X-Client"][0] != "42" {
+		t.Errorf("variables are not expanded: %+v", f)
+	}
+
+	// 断言失败并且重试次数用完
+	forwards = nil
+	exec = fakeExecutor(&forwards, fakeResponse{code: 200, body: `{"enabled":false,"name":"c7n"}`})
+	enable.Retry = &Retry{Attempts: 2, Interval: "1ms"}
+	if err := enable.Execute(exec, vars); err == nil {
+		t.Errorf("assertion should fail")
+	}
+	if len(forwards) != 2 {
+		t.Errorf("want 2 attempts, got %d", len(forwards))
+	}
+
+	// 默认只接受 2xx 和 3xx，并且不重试
+	forwards = nil
+	exec = fakeExecutor(&forwards, fakeResponse{code: 502})
+	if err := (&Request{Url: "http://a"}).Execute(exec, vars); err == nil {
+		t.Errorf("502 should fail")
+	}
+	if len(forwards) != 1 {
+		t.Errorf("want 1 attempt, got %d", len(forwards))
+	}
+
+	// 超过截止时间后不再重试
+	forwards = nil
+	exec = fakeExecutor(&forwards, fakeResponse{code: 503})
+	r := &Request{Url: "http://a", Retry: &Retry{Interval: "20ms", Timeout: "50ms"}}
+	if err := r.Execute(exec, vars); err == nil {
+		t.Errorf("503 should fail")
+	}
+	if len(forwards) < 2 || len(forwards) > 3 {
+		t.Errorf("want 2 or 3 attempts before deadline, got %d", len(forwards))
+	}
+}
+
+func TestRequestForward(t *testing.T) {
+	r := Request{
+		Url: "http://choerodon-register:8000/apps",
+		Parameters: []c7nclient.ChartValue{
+			{Name: "name", Value: "value1"},
+			{Name: "name2", Value: "${id}"},
+		},
+	}
+	if f := r.forward(map[string]string{"id": "5"}); f.Url != "http://choerodon-register:8000/apps?name=value1&name2=5" {
+		t.Errorf("unexpected url %s", f.Url)
+	}
+	// 没有参数时不添加 ?
+	r.Parameters = nil
+	if f := r.forward(nil); f.Url != "http://choerodon-register:8000/apps" {
+		t.Errorf("unexpected url %s", f.Url)
+	}
+}
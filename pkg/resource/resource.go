@@ -115,7 +115,7 @@ func (c *Client) GetResource(version, url string) (string, error) {
 		}
 		fu = fmt.Sprintf(consts.BusinessResourceBasePath, version, url, *auth.Data.Token)
 	} else {
-		fu = fmt.Sprintf(consts.OpenSourceResourceBasePath, version) + url
+		fu = fmt.Sprintf(consts.OpenSourceResourceBasePath, version, strings.TrimPrefix(url, "/"))
 	}
 
 	result := new(bytes.Buffer)
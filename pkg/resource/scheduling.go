@@ -0,0 +1,222 @@
+package resource
+
+import (
+	"fmt"
+	c7ncfg "github.com/choerodon/c7nctl/pkg/config"
+	"strings"
+)
+
+// 调度策略中的配置项，也是 chart values 映射中的 key
+const (
+	SchedulingNodeSelector       = "nodeSelector"
+	SchedulingTolerations        = "tolerations"
+	SchedulingAffinity           = "affinity"
+	SchedulingPriorityClassName  = "priorityClassName"
+	SchedulingImagePullSecrets   = "imagePullSecrets"
+	SchedulingPodSecurityContext = "podSecurityContext"
+)
+
+// Scheduling 是注入到所有 release 的 values 中的调度和 pod 策略
+type Scheduling struct {
+	NodeSelector map[string]string `yaml:"nodeSelector"`
+	// 和 pod 的 tolerations、affinity、securityContext 格式一致
+	Tolerations        []interface{}          `yaml:"tolerations"`
+	Affinity           map[string]interface{} `yaml:"affinity"`
+	PriorityClassName  string                 `yaml:"priorityClassName"`
+	PodSecurityContext map[string]interface{} `yaml:"podSecurityContext"`
+	// 拉取镜像使用的 secret 名称
+	ImagePullSecrets []string `yaml:"imagePullSecrets"`
+	// chart 名称对应的 values 路径，覆盖内置的映射，比如 harbor: {nodeSelector: [core.nodeSelector]}
+	Families map[string]SchedulingKeys `yaml:"families"`
+}
+
+// SchedulingKeys 是调度策略的配置项到 chart values 路径的映射，路径使用 . 分隔，没有的配置项不注入
+type SchedulingKeys map[string][]string
+
+// ReleaseScheduling 是 release 对全局调度策略的设置
+type ReleaseScheduling struct {
+	// 不注入全局调度策略
+	Disabled bool `yaml:"disabled"`
+	// 不注入的配置项，比如 [affinity]
+	Skip []string `yaml:"skip"`
+	// 使用的 values 映射，默认根据 chart 名称选择
+	Family string `yaml:"family"`
+}
+
+// 大部分 chart 都把这些配置放在 values 的第一层
+var defaultSchedulingKeys = SchedulingKeys{
+	SchedulingNodeSelector:       {"nodeSelector"},
+	SchedulingTolerations:        {"tolerations"},
+	SchedulingAffinity:           {"affinity"},
+	SchedulingPriorityClassName:  {"priorityClassName"},
+	SchedulingImagePullSecrets:   {"imagePullSecrets"},
+	SchedulingPodSecurityContext: {"podSecurityContext"},
+}
+
+// schedulingFamilies 是配置放在各个组件中的 chart 的 values 映射
+var schedulingFamilies = map[string]SchedulingKeys{
+	// harbor 的 pod securityContext 写在模板中，values 中没有对应的配置，不注入 podSecurityContext
+	"harbor": componentSchedulingKeys("", "nginx", "portal", "core", "jobservice", "registry", "chartmuseum", "trivy", "database.internal", "redis.internal"),
+	// prometheus-operator 各组件的 securityContext 就是 pod 的 securityContext
+	"prometheus-operator": componentSchedulingKeys("securityContext", "prometheusOperator", "prometheus.prometheusSpec", "alertmanager.alertmanagerSpec", "grafana", "kube-state-metrics"),
+}
+
+// componentSchedulingKeys 返回配置放在 components 中的映射，podSecurityContextKey 为组件中 pod securityContext 的 key，为空时不注入
+func componentSchedulingKeys(podSecurityContextKey string, components ...string) SchedulingKeys {
+	keys := SchedulingKeys{SchedulingImagePullSecrets: {"imagePullSecrets"}}
+	for _, c := range components {
+		for _, k := range []string{SchedulingNodeSelector, SchedulingTolerations, SchedulingAffinity, SchedulingPriorityClassName} {
+			keys[k] = append(keys[k], c+"."+k)
+		}
+		if podSecurityContextKey != "" {
+			keys[SchedulingPodSecurityContext] = append(keys[SchedulingPodSecurityContext], c+"."+podSecurityContextKey)
+		}
+	}
+	return keys
+}
+
+// IsEmpty 返回是否没有设置任何策略
+func (s Scheduling) IsEmpty() bool {
+	return len(s.NodeSelector) == 0 && len(s.Tolerations) == 0 && len(s.Affinity) == 0 &&
+		s.PriorityClassName == "" && len(s.ImagePullSecrets) == 0 && len(s.PodSecurityContext) == 0
+}
+
+func (s Scheduling) values() map[string]interface{} {
+	vals := map[string]interface{}{}
+	if len(s.NodeSelector) > 0 {
+		ns := map[string]interface{}{}
+		for k, v := range s.NodeSelector {
+			ns[k] = v
+		}
+		vals[SchedulingNodeSelector] = ns
+	}
+	if len(s.Tolerations) > 0 {
+		vals[SchedulingTolerations] = stringKeys(s.Tolerations)
+	}
+	if len(s.Affinity) > 0 {
+		vals[SchedulingAffinity] = stringKeys(s.Affinity)
+	}
+	if s.PriorityClassName != "" {
+		vals[SchedulingPriorityClassName] = s.PriorityClassName
+	}
+	if len(s.ImagePullSecrets) > 0 {
+		secrets := make([]interface{}, len(s.ImagePullSecrets))
+		for idx, name := range s.ImagePullSecrets {
+			secrets[idx] = map[string]interface{}{"name": name}
+		}
+		vals[SchedulingImagePullSecrets] = secrets
+	}
+	if len(s.PodSecurityContext) > 0 {
+		vals[SchedulingPodSecurityContext] = stringKeys(s.PodSecurityContext)
+	}
+	return vals
+}
+
+// keys 返回 chart 使用的 values 映射
+func (s Scheduling) keys(family string) SchedulingKeys {
+	if keys, ok := s.Families[family]; ok {
+		return keys
+	}
+	if keys, ok := schedulingFamilies[family]; ok {
+		return keys
+	}
+	return defaultSchedulingKeys
+}
+
+// ApplyScheduling 将全局调度策略注入 release 的 values，release 的 values 中已经设置的值不会被覆盖
+func (i *InstallDefinition) ApplyScheduling(rls *Release, vals map[string]interface{}) {
+	s := i.Spec.Basic.Scheduling
+	if s.IsEmpty() || (rls.Scheduling != nil && rls.Scheduling.Disabled) {
+		return
+	}
+	family := rls.Chart
+	var skip []string
+	if rls.Scheduling != nil {
+		skip = rls.Scheduling.Skip
+		if rls.Scheduling.Family != "" {
+			family = rls.Scheduling.Family
+		}
+	}
+	keys := s.keys(family)
+	for k, v := range s.values() {
+		if containsKey(skip, k) {
+			continue
+		}
+		for _, path := range keys[k] {
+			setDefaultValue(vals, strings.Split(path, "."), stringKeys(v))
+		}
+	}
+}
+
+// MergeConfig 使用用户配置中设置的策略
+func (s *Scheduling) MergeConfig(uc c7ncfg.Scheduling) {
+	if len(uc.NodeSelector) > 0 {
+		s.NodeSelector = uc.NodeSelector
+	}
+	if len(uc.Tolerations) > 0 {
+		s.Tolerations = uc.Tolerations
+	}
+	if len(uc.Affinity) > 0 {
+		s.Affinity = uc.Affinity
+	}
+	if uc.PriorityClassName != "" {
+		s.PriorityClassName = uc.PriorityClassName
+	}
+	if len(uc.ImagePullSecrets) > 0 {
+		s.ImagePullSecrets = uc.ImagePullSecrets
+	}
+	if len(uc.PodSecurityContext) > 0 {
+		s.PodSecurityContext = uc.PodSecurityContext
+	}
+}
+
+func containsKey(list []string, key string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// setDefaultValue 在 path 没有值时设置为 v，path 中间不是 map 的值保持不变
+func setDefaultValue(vals map[string]interface{}, path []string, v interface{}) {
+	for _, p := range path[:len(path)-1] {
+		next, ok := vals[p]
+		if !ok {
+			next = map[string]interface{}{}
+			vals[p] = next
+		}
+		if vals, ok = next.(map[string]interface{}); !ok {
+			return
+		}
+	}
+	if _, ok := vals[path[len(path)-1]]; !ok {
+		vals[path[len(path)-1]] = v
+	}
+}
+
+// stringKeys 将 yaml 解析出的 map[interface{}]interface{} 转换为 helm values 使用的 map[string]interface{}
+func stringKeys(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = stringKeys(val)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[k] = stringKeys(val)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for idx, val := range t {
+			l[idx] = stringKeys(val)
+		}
+		return l
+	}
+	return v
+}
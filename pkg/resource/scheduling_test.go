@@ -0,0 +1,108 @@
+package resource
+
+import (
+	c7ncfg "github.com/choerodon/c7nctl/pkg/config"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"testing"
+)
+
+func TestApplyScheduling(t *testing.T) {
+	data := `
+spec:
+  basic:
+    scheduling:
+      nodeSelector:
+        role: c7n
+      tolerations:
+      - key: dedicated
+        operator: Equal
+        value: c7n
+        effect: NoSchedule
+      imagePullSecrets:
+      - c7n-registry
+      podSecurityContext:
+        fsGroup: 1001
+  release:
+    c7n:
+    - name: choerodon-iam
+      chart: choerodon-iam
+      values:
+      - name: nodeSelector.role
+        value: iam
+    - name: harbor
+      chart: harbor
+      scheduling:
+        skip: [imagePullSecrets]
+    - name: nfs-client-provisioner
+      chart: nfs-client-provisioner
+      scheduling:
+        disabled: true
+    - name: prometheus-operator
+      chart: prometheus-operator
+`
+	instDef := &InstallDefinition{}
+	if err := yaml.Unmarshal([]byte(data), instDef); err != nil {
+		t.Fatal(err)
+	}
+	instDef.Spec.Basic.Scheduling.MergeConfig(c7ncfg.Scheduling{PriorityClassName: "c7n-high"})
+	releases := instDef.Spec.Release["c7n"]
+	toleration := []interface{}{map[string]interface{}{"key": "dedicated", "operator": "Equal", "value": "c7n", "effect": "NoSchedule"}}
+
+	vals, err := instDef.RenderHelmValues(releases[0], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"nodeSelector":       map[string]interface{}{"role": "iam"},
+		"tolerations":        toleration,
+		"priorityClassName":  "c7n-high",
+		"imagePullSecrets":   []interface{}{map[string]interface{}{"name": "c7n-registry"}},
+		"podSecurityContext": map[string]interface{}{"fsGroup": 1001},
+	}
+	if !reflect.DeepEqual(vals, expected) {
+		t.Errorf("expected %v, got %v", expected, vals)
+	}
+
+	vals, err = instDef.RenderHelmValues(releases[1], "core:\n  replicas: 2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	core := vals["core"].(map[string]interface{})
+	if core["replicas"] != float64(2) || !reflect.DeepEqual(core["nodeSelector"], map[string]interface{}{"role": "c7n"}) || !reflect.DeepEqual(core["tolerations"], toleration) {
+		t.Errorf("unexpected values of harbor core: %v", core)
+	}
+	if _, ok := vals["imagePullSecrets"]; ok {
+		t.Errorf("skipped imagePullSecrets is injected: %v", vals)
+	}
+	if _, ok := vals["nodeSelector"]; ok {
+		t.Errorf("nodeSelector of harbor should be set in components: %v", vals)
+	}
+	// harbor 的 values 中没有 pod securityContext
+	if _, ok := vals["podSecurityContext"]; ok {
+		t.Errorf("podSecurityContext of harbor is injected: %v", vals)
+	}
+	if _, ok := core["podSecurityContext"]; ok {
+		t.Errorf("podSecurityContext of harbor core is injected: %v", core)
+	}
+
+	vals, err = instDef.RenderHelmValues(releases[2], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vals) != 0 {
+		t.Errorf("scheduling of disabled release is injected: %v", vals)
+	}
+
+	vals, err = instDef.RenderHelmValues(releases[3], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := vals["prometheus"].(map[string]interface{})["prometheusSpec"].(map[string]interface{})
+	if !reflect.DeepEqual(spec["securityContext"], map[string]interface{}{"fsGroup": 1001}) {
+		t.Errorf("unexpected securityContext of prometheus: %v", spec)
+	}
+	if _, ok := vals["podSecurityContext"]; ok {
+		t.Errorf("podSecurityContext of prometheus-operator should be set in components: %v", vals)
+	}
+}
@@ -0,0 +1,257 @@
+package slaver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	sys_errors "errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// TokenHeader 调用 slaver http 接口时携带 token 的 header
+	TokenHeader = "X-C7n-Slaver-Token"
+	// TokenMetadataKey 调用 slaver grpc 接口时携带 token 的 metadata
+	TokenMetadataKey = "c7n-slaver-token"
+
+	// slaver 的认证信息保存在 secret 中的 key
+	secretTokenKey  = "token"
+	secretCACertKey = "ca.crt"
+	secretCertKey   = core_v1.TLSCertKey
+	secretKeyKey    = core_v1.TLSPrivateKeyKey
+
+	// TLSMountPath secret 在 slaver 容器中的挂载路径
+	TLSMountPath = "/etc/c7n-slaver/tls"
+
+	// slaver 容器中的环境变量，证书和私钥为文件路径
+	EnvToken   = "SLAVER_TOKEN"
+	EnvTLSCert = "SLAVER_TLS_CERT"
+	EnvTLSKey  = "SLAVER_TLS_KEY"
+
+	tokenLength  = 32
+	certValidity = 10 * 365 * 24 * time.Hour
+)
+
+var ErrUnauthenticated = sys_errors.New("slaver token is invalid")
+
+// Credentials 是每次安装时为 slaver 生成的 token 和 TLS 证书
+type Credentials struct {
+	Token  string
+	CACert []byte
+	Cert   []byte
+	Key    []byte
+}
+
+// NewCredentials 生成随机 token，自签名的 CA 以及 slaver 的服务端证书，hosts 为证书中的域名或 IP
+func NewCredentials(hosts ...string) (*Credentials, error) {
+	token := make([]byte, tokenLength)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	caTpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "c7n-slaver-ca"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTpl, caTpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "c7n-slaver"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credentials{
+		Token:  hex.EncodeToString(token),
+		CACert: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}),
+		Cert:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		Key:    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}),
+	}, nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+// CredentialsFromSecret 从 secret 中读取 slaver 的认证信息
+func CredentialsFromSecret(secret *core_v1.Secret) (*Credentials, error) {
+	c := &Credentials{
+		Token:  string(secret.Data[secretTokenKey]),
+		CACert: secret.Data[secretCACertKey],
+		Cert:   secret.Data[secretCertKey],
+		Key:    secret.Data[secretKeyKey],
+	}
+	if c.Token == "" || len(c.CACert) == 0 || len(c.Cert) == 0 || len(c.Key) == 0 {
+		return nil, fmt.Errorf("secret %s does not contain slaver credentials", secret.Name)
+	}
+	return c, nil
+}
+
+// Secret 将认证信息转换为 secret
+func (c *Credentials) Secret(name string, labels map[string]string) *core_v1.Secret {
+	return &core_v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Type: core_v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			secretTokenKey:  []byte(c.Token),
+			secretCACertKey: c.CACert,
+			secretCertKey:   c.Cert,
+			secretKeyKey:    c.Key,
+		},
+	}
+}
+
+// ClientTLSConfig 只信任本次安装生成的 CA，并校验 slaver 证书中的 serverName
+func (c *Credentials) ClientTLSConfig(serverName string) (*tls.Config, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(c.CACert) {
+		return nil, sys_errors.New("failed to parse slaver ca certificate")
+	}
+	return &tls.Config{
+		RootCAs:    pool,
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}, nil
+}
+
+// ServerTLSConfig 是 slaver 服务端使用的 TLS 配置
+func (c *Credentials) ServerTLSConfig() (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// DialOptions 返回连接 slaver grpc 服务需要的 TLS 和 token
+func (c *Credentials) DialOptions(serverName string) ([]grpc.DialOption, error) {
+	tlsConfig, err := c.ClientTLSConfig(serverName)
+	if err != nil {
+		return nil, err
+	}
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		grpc.WithPerRPCCredentials(tokenAuth{token: c.Token}),
+	}, nil
+}
+
+// ServerOptions 返回 slaver grpc 服务需要的 TLS 和 token 校验
+func (c *Credentials) ServerOptions() ([]grpc.ServerOption, error) {
+	tlsConfig, err := c.ServerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return []grpc.ServerOption{
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := c.checkMetadata(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := c.checkMetadata(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	}, nil
+}
+
+func (c *Credentials) checkMetadata(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, t := range md.Get(TokenMetadataKey) {
+		if c.validToken(t) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, ErrUnauthenticated.Error())
+}
+
+// CheckRequest 校验 http 请求中的 token
+func (c *Credentials) CheckRequest(r *http.Request) error {
+	if !c.validToken(r.Header.Get(TokenHeader)) {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+func (c *Credentials) validToken(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1
+}
+
+type tokenAuth struct {
+	token string
+}
+
+func (t tokenAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{TokenMetadataKey: t.token}, nil
+}
+
+func (t tokenAuth) RequireTransportSecurity() bool {
+	return true
+}
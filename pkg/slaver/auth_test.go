@@ -0,0 +1,211 @@
+package slaver
+
+import (
+	"context"
+	c7ncfg "github.com/choerodon/c7nctl/pkg/config"
+	pb "github.com/choerodon/c7nctl/pkg/protobuf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type healthServer struct {
+	pb.RouteCallServer
+}
+
+func (healthServer) CheckHealth(ctx context.Context, check *pb.Check) (*pb.Result, error) {
+	return &pb.Result{Success: true}, nil
+}
+
+func startTestServer(t *testing.T, c *Credentials) string {
+	opts, err := c.ServerOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterRouteCallServer(srv, healthServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func checkHealth(s *Slaver) error {
+	conn, err := s.connectGRpc()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, err = pb.NewRouteCallClient(conn).CheckHealth(ctx, &pb.Check{}, grpc.WaitForReady(false))
+	return err
+}
+
+func TestCredentialsFromSecret(t *testing.T) {
+	c, err := NewCredentials("c7n-slaver", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := CredentialsFromSecret(c.Secret("c7n-slaver-auth", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c, got) {
+		t.Errorf("credentials changed after saving to secret")
+	}
+
+	secret := c.Secret("c7n-slaver-auth", nil)
+	delete(secret.Data, secretTokenKey)
+	if _, err = CredentialsFromSecret(secret); err == nil {
+		t.Errorf("secret without token should be rejected")
+	}
+}
+
+func TestConnectGRpc(t *testing.T) {
+	serverCred, err := NewCredentials("c7n-slaver", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCred, err := NewCredentials("c7n-slaver", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTestServer(t, serverCred)
+
+	s := &Slaver{Name: "c7n-slaver", GRpcAddress: addr, Credentials: serverCred}
+	if err = checkHealth(s); err != nil {
+		t.Fatalf("slaver with matched credentials should be reachable: %v", err)
+	}
+
+	// 错误的 token 会被 slaver 拒绝
+	wrongToken := *serverCred
+	wrongToken.Token = otherCred.Token
+	s.Credentials = &wrongToken
+	if err = checkHealth(s); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("want unauthenticated, got %v", err)
+	}
+
+	// 证书不是本次安装签发的 slaver 会被客户端拒绝
+	s.Credentials = otherCred
+	if err = checkHealth(s); err == nil {
+		t.Errorf("slaver with unknown identity should be refused")
+	}
+
+	// 证书中的域名不匹配
+	s.Credentials = serverCred
+	s.Name = "other-slaver"
+	if err = checkHealth(s); err == nil {
+		t.Errorf("slaver with mismatched server name should be refused")
+	}
+
+	s.Credentials = nil
+	if err = checkHealth(s); err == nil {
+		t.Errorf("connect without credentials should fail")
+	}
+	// 返回连接失败的原因
+	if err = s.ExecuteRemoteSql([]string{"SELECT 1"}, &c7ncfg.Resource{}, "", "mysql"); err == nil || !strings.Contains(err.Error(), "credentials are not initialized") {
+		t.Errorf("want the dial error, got %v", err)
+	}
+}
+
+func TestCheckTLS(t *testing.T) {
+	cred, err := NewCredentials("c7n-slaver", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Slaver{Name: "c7n-slaver", GRpcAddress: startTestServer(t, cred), Credentials: cred}
+	if err = s.checkTLS(); err != nil || s.plaintext {
+		t.Fatalf("slaver with tls should not fall back to plaintext: %v", err)
+	}
+
+	// 证书不是本次安装签发的 slaver 不会回退到明文连接
+	other, err := NewCredentials("c7n-slaver", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Credentials = other
+	if err = s.checkTLS(); err == nil || s.plaintext {
+		t.Errorf("slaver with unknown identity should be refused, got %v", err)
+	}
+
+	// 旧版本的 slaver 只提供明文的 grpc 服务
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	pb.RegisterRouteCallServer(srv, healthServer{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	s.GRpcAddress = lis.Addr().String()
+	// 没有允许明文连接时拒绝连接
+	if err = s.checkTLS(); err == nil || s.plaintext {
+		t.Fatalf("legacy slaver should be refused without AllowPlaintext, got %v", err)
+	}
+	s.AllowPlaintext = true
+	if err = s.checkTLS(); err != nil || !s.plaintext {
+		t.Fatalf("legacy slaver should fall back to plaintext: %v", err)
+	}
+	if err = checkHealth(s); err != nil {
+		t.Errorf("legacy slaver should be reachable with plaintext: %v", err)
+	}
+}
+
+func TestRotateCredentials(t *testing.T) {
+	s := &Slaver{Name: "c7n-slaver", Image: "c7n-slaver"}
+	s.Init(fake.NewSimpleClientset(), "c7n-system")
+	if _, err := s.CheckInstall(); err != nil {
+		t.Fatal(err)
+	}
+	first := s.Credentials
+
+	// 不轮换时复用已经存在的认证信息
+	if _, err := s.CheckInstall(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Credentials.Token != first.Token {
+		t.Errorf("credentials should be reused without rotation")
+	}
+
+	s.RotateCredentials = true
+	if _, err := s.CheckInstall(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Credentials.Token == first.Token {
+		t.Fatalf("token should be rotated")
+	}
+	secret, err := s.Client.CoreV1().Secrets("c7n-system").Get(context.Background(), s.secretName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data[secretTokenKey]) != s.Credentials.Token {
+		t.Errorf("rotated token should be saved to the secret")
+	}
+}
+
+func TestCheckRequest(t *testing.T) {
+	c, err := NewCredentials("c7n-slaver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", "http://127.0.0.1/forward", nil)
+	if err = c.CheckRequest(req); err != ErrUnauthenticated {
+		t.Errorf("request without token should be rejected")
+	}
+	req.Header.Set(TokenHeader, c.Token)
+	if err = c.CheckRequest(req); err != nil {
+		t.Error(err)
+	}
+}
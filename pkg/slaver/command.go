@@ -0,0 +1,347 @@
+package slaver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	pb "github.com/choerodon/c7nctl/pkg/protobuf"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+	"io"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 等待执行命令的 pod 结束时查询状态的间隔
+var podPollInterval = 2 * time.Second
+
+const (
+	// StreamOutputMetadataKey 调用 ExecuteCommand 时携带该 metadata，slaver 在命令执行过程中逐行发送输出
+	StreamOutputMetadataKey = "c7n-slaver-stream-output"
+	// OutputMessageName 是命令执行过程中发送的输出消息的名称，命令结束后仍然发送包含全部输出和退出码的结果
+	OutputMessageName = "c7n-slaver-output"
+)
+
+// envNamePattern 是合法的 shell 变量名
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Shell 是在 slaver 或者指定镜像中使用 sh -c 执行的命令
+type Shell struct {
+	// 执行命令的 pod 名称，只在指定镜像时使用
+	Name       string
+	Image      string
+	WorkingDir string
+	Env        []core_v1.EnvVar
+	Commands   []string
+	// 在镜像中执行时等待 pod 结束的时间，默认为 1 小时
+	Timeout time.Duration
+}
+
+// CommandResult 是一条命令的执行结果
+type CommandResult struct {
+	Command  string
+	ExitCode int
+	Output   string
+}
+
+// CommandError 表示命令的退出码不为 0
+type CommandError struct {
+	CommandResult
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command %q exited with code %d", e.Command, e.ExitCode)
+}
+
+// ShellScript 生成切换工作目录和设置环境变量后再执行 command 的脚本，环境变量的名称必须是合法的 shell 变量名
+func ShellScript(command, workingDir string, env []core_v1.EnvVar) (string, error) {
+	if err := validateEnv(env); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if workingDir != "" {
+		fmt.Fprintf(&b, "cd %s || exit $?\n", shellQuote(workingDir))
+	}
+	for _, e := range env {
+		fmt.Fprintf(&b, "export %s=%s\n", e.Name, shellQuote(e.Value))
+	}
+	b.WriteString(command)
+	return b.String(), nil
+}
+
+func validateEnv(env []core_v1.EnvVar) error {
+	for _, e := range env {
+		if !envNamePattern.MatchString(e.Name) {
+			return std_errors.Errorf("invalid environment variable name %q, must be a valid shell identifier", e.Name)
+		}
+	}
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// logOutput 将命令的输出逐行写入安装日志
+func logOutput(prefix, output string) {
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if line != "" {
+			log.Infof("[%s] %s", prefix, line)
+		}
+	}
+}
+
+// ExecuteShell 依次执行命令，指定了镜像时在新的 pod 中执行，否则在 slaver 中执行。命令的输出在执行过程中逐行写入安装日志，
+// 遇到退出码不为 0 的命令时返回 *CommandError
+func (s *Slaver) ExecuteShell(sh Shell) ([]CommandResult, error) {
+	if err := validateEnv(sh.Env); err != nil {
+		return nil, err
+	}
+	if sh.Image != "" {
+		return s.executeShellInPod(sh)
+	}
+	return s.executeRemoteShell(sh)
+}
+
+func (s *Slaver) executeRemoteShell(sh Shell) ([]CommandResult, error) {
+	c, cancel, ctx, err := s.getClient()
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	// 旧版本的 slaver 忽略该 metadata，只在命令结束后返回输出
+	ctx = metadata.AppendToOutgoingContext(ctx, StreamOutputMetadataKey, "true")
+	stream, err := c.ExecuteCommand(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	var results []CommandResult
+	for _, command := range sh.Commands {
+		log.Infof("Executing command: %s", command)
+		script, err := ShellScript(command, sh.WorkingDir, sh.Env)
+		if err != nil {
+			return results, err
+		}
+		routeCommand := &pb.RouteCommand{
+			Name: "sh",
+			Args: []string{"-c", script},
+		}
+		if err := stream.Send(routeCommand); err != nil {
+			return results, err
+		}
+		resp, streamed, err := recvCommandResult(stream, sh.Name)
+		if err != nil {
+			return results, err
+		}
+		result := CommandResult{Command: command, ExitCode: int(resp.StatusCode), Output: resp.Message}
+		// 旧版本的 slaver 不返回退出码
+		if !resp.Success && result.ExitCode == 0 {
+			result.ExitCode = -1
+		}
+		if !streamed {
+			logOutput(sh.Name, result.Output)
+		}
+		results = append(results, result)
+		if !resp.Success {
+			return results, &CommandError{result}
+		}
+	}
+	return results, nil
+}
+
+// recvCommandResult 接收一条命令的结果，执行过程中收到的输出逐行写入安装日志，返回是否收到过输出消息
+func recvCommandResult(stream pb.RouteCall_ExecuteCommandClient, prefix string) (*pb.RouteCommand, bool, error) {
+	streamed := false
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, streamed, err
+		}
+		if resp.Name != OutputMessageName {
+			return resp, streamed, nil
+		}
+		streamed = true
+		logOutput(prefix, resp.Message)
+	}
+}
+
+// executeShellInPod 在 slaver 的命名空间中创建使用指定镜像的 pod 执行所有命令，执行过程中输出 pod 的日志，结束后删除 pod
+func (s *Slaver) executeShellInPod(sh Shell) ([]CommandResult, error) {
+	timeout := sh.Timeout
+	if timeout == 0 {
+		timeout = time.Hour
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	command := strings.Join(sh.Commands, "\n")
+
+	podInterface := s.Client.CoreV1().Pods(s.Namespace)
+	name := podName(sh.Name)
+	pod := &core_v1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   name,
+			Labels: s.CommonLabels,
+		},
+		Spec: core_v1.PodSpec{
+			RestartPolicy: core_v1.RestartPolicyNever,
+			Containers: []core_v1.Container{
+				{
+					Name:            "shell",
+					Image:           sh.Image,
+					ImagePullPolicy: s.ImagePullPolicy,
+					Command:         []string{"sh", "-c", podScript(sh.Commands)},
+					WorkingDir:      sh.WorkingDir,
+					Env:             sh.Env,
+				},
+			},
+		},
+	}
+	log.Infof("Executing commands of %s in image %s", sh.Name, sh.Image)
+	if _, err := podInterface.Create(ctx, pod, meta_v1.CreateOptions{}); err != nil {
+		return nil, std_errors.WithMessage(err, fmt.Sprintf("Failed to create pod %s", name))
+	}
+	defer func() {
+		if err := podInterface.Delete(context.Background(), name, meta_v1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			log.Warnf("Failed to delete pod %s: %s", name, err)
+		}
+	}()
+
+	output, streamed := followPodLogs(ctx, s.Client, s.Namespace, name, sh.Name)
+	exitCode, err := s.waitPodTerminated(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// 无法跟踪日志时在 pod 结束后获取全部日志
+	if !streamed {
+		logs, err := podInterface.GetLogs(name, &core_v1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			log.Warnf("Failed to get logs of pod %s: %s", name, err)
+		}
+		output = string(logs)
+		logOutput(sh.Name, output)
+	}
+	result := CommandResult{Command: command, ExitCode: exitCode, Output: output}
+	if exitCode != 0 {
+		return []CommandResult{result}, &CommandError{result}
+	}
+	return []CommandResult{result}, nil
+}
+
+// podScript 将命令合并为一个脚本，执行每条命令前输出该命令，失败时以该命令的退出码退出
+func podScript(commands []string) string {
+	var script strings.Builder
+	for _, command := range commands {
+		fmt.Fprintf(&script, "echo %s\n(%s\n) || exit $?\n", shellQuote("+ "+command), command)
+	}
+	return script.String()
+}
+
+// podName 根据任务名称生成合法的 pod 名称
+func podName(name string) string {
+	prefix := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(name))
+	if len(prefix) > 50 {
+		prefix = prefix[:50]
+	}
+	return strings.ToLower(fmt.Sprintf("%s-%s", strings.Trim(prefix, "-"), RandomToken(5)))
+}
+
+func (s *Slaver) waitPodTerminated(ctx context.Context, name string) (int, error) {
+	ticker := time.NewTicker(podPollInterval)
+	defer ticker.Stop()
+	for {
+		pod, err := s.Client.CoreV1().Pods(s.Namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Terminated != nil {
+				return int(cs.State.Terminated.ExitCode), nil
+			}
+		}
+		if pod.Status.Phase == core_v1.PodFailed {
+			return -1, std_errors.Errorf("pod %s failed: %s", name, pod.Status.Message)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, std_errors.WithMessage(ctx.Err(), fmt.Sprintf("waiting for pod %s", name))
+		case <-ticker.C:
+		}
+	}
+}
+
+// followPodLogs 等待 pod 的容器启动后逐行读取日志并写入安装日志，容器结束时返回读取到的日志，无法读取日志时返回 false
+func followPodLogs(ctx context.Context, client kubernetes.Interface, namespace, name, prefix string) (string, bool) {
+	if err := waitPodStarted(ctx, client, namespace, name); err != nil {
+		log.Debugf("Waiting for pod %s to start failed: %s", name, err)
+		return "", false
+	}
+	stream, err := client.CoreV1().Pods(namespace).GetLogs(name, &core_v1.PodLogOptions{Follow: true}).Stream(ctx)
+	if err != nil {
+		log.Debugf("Following logs of pod %s failed: %s", name, err)
+		return "", false
+	}
+	defer stream.Close()
+
+	var output strings.Builder
+	reader := bufio.NewReader(stream)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			output.WriteString(line)
+			logOutput(prefix, line)
+		}
+		if err == io.EOF {
+			return output.String(), true
+		}
+		if err != nil {
+			log.Warnf("Reading logs of pod %s failed: %s", name, err)
+			return "", false
+		}
+	}
+}
+
+// waitPodStarted 等待 pod 中的容器开始运行或者 pod 结束
+func waitPodStarted(ctx context.Context, client kubernetes.Interface, namespace, name string) error {
+	ticker := time.NewTicker(podPollInterval)
+	defer ticker.Stop()
+	for {
+		pod, err := client.CoreV1().Pods(namespace).Get(ctx, name, meta_v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if podStarted(pod) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func podStarted(pod *core_v1.Pod) bool {
+	if pod.Status.Phase == core_v1.PodSucceeded || pod.Status.Phase == core_v1.PodFailed {
+		return true
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Running != nil || cs.State.Terminated != nil {
+			return true
+		}
+	}
+	return false
+}
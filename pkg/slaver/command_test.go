@@ -0,0 +1,89 @@
+package slaver
+
+import (
+	"context"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShellScript(t *testing.T) {
+	script, err := ShellScript("echo $A", "/data/it's", []core_v1.EnvVar{{Name: "A", Value: "a b"}})
+	want := "cd '/data/it'\\''s' || exit $?\nexport A='a b'\necho $A"
+	if err != nil || script != want {
+		t.Errorf("want %q, got %q, %v", want, script, err)
+	}
+	// 环境变量名称会直接拼接到脚本中，只允许合法的 shell 变量名
+	for _, name := range []string{"", "1A", "A-B", "A=1; rm -rf /;B", "$(id)"} {
+		if _, err := ShellScript("echo", "", []core_v1.EnvVar{{Name: name, Value: "v"}}); err == nil {
+			t.Errorf("env name %q should be rejected", name)
+		}
+	}
+}
+
+func TestExecuteShellInPod(t *testing.T) {
+	podPollInterval = 10 * time.Millisecond
+	client := fake.NewSimpleClientset()
+	// 模拟 pod 创建后立即以退出码 2 结束
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*core_v1.Pod)
+		pod.Status.ContainerStatuses = []core_v1.ContainerStatus{{
+			State: core_v1.ContainerState{Terminated: &core_v1.ContainerStateTerminated{ExitCode: 2}},
+		}}
+		return false, nil, nil
+	})
+	s := &Slaver{Name: "c7n-slaver"}
+	s.Init(client, "c7n-system")
+
+	results, err := s.ExecuteShell(Shell{
+		Name:       "Init_Gitlab",
+		Image:      "alpine:3.12",
+		WorkingDir: "/tmp",
+		Commands:   []string{"ls", "exit 2"},
+	})
+	if cmdErr, ok := err.(*CommandError); !ok || cmdErr.ExitCode != 2 {
+		t.Fatalf("want exit code 2, got %v", err)
+	}
+	// fake clientset 返回的日志为 fake logs
+	if len(results) != 1 || results[0].Command != "ls\nexit 2" || results[0].Output != "fake logs" {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	var created *core_v1.Pod
+	for _, a := range client.Actions() {
+		if a.Matches("create", "pods") {
+			created = a.(k8stesting.CreateAction).GetObject().(*core_v1.Pod)
+		}
+	}
+	if created == nil || !strings.HasPrefix(created.Name, "init-gitlab-") || created.Spec.Containers[0].WorkingDir != "/tmp" {
+		t.Fatalf("unexpected pod %+v", created)
+	}
+	// 执行完成后删除 pod
+	if pods, _ := client.CoreV1().Pods("c7n-system").List(context.Background(), meta_v1.ListOptions{}); len(pods.Items) != 0 {
+		t.Errorf("pod should be deleted")
+	}
+}
+
+func TestExecuteShellInvalidEnv(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := &Slaver{Name: "c7n-slaver"}
+	s.Init(client, "c7n-system")
+
+	_, err := s.ExecuteShell(Shell{
+		Name:     "Init_Gitlab",
+		Image:    "alpine:3.12",
+		Env:      []core_v1.EnvVar{{Name: "A B", Value: "v"}},
+		Commands: []string{"ls"},
+	})
+	if err == nil {
+		t.Fatal("want error for invalid env name")
+	}
+	if len(client.Actions()) != 0 {
+		t.Errorf("no pod should be created, got %v", client.Actions())
+	}
+}
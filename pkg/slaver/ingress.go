@@ -0,0 +1,284 @@
+package slaver
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/extensions/v1beta1"
+	networking_v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	ingressClassAnnotation        = "kubernetes.io/ingress.class"
+	defaultIngressClassAnnotation = "ingressclass.kubernetes.io/is-default-class"
+)
+
+func (s *Slaver) checkerIngressName() string {
+	return s.Name + "checker"
+}
+
+func (s *Slaver) checkerIngressAnnotations() map[string]string {
+	return map[string]string{
+		"ingress.kubernetes.io/ssl-redirect":       "false",
+		"nginx.ingress.kubernetes.io/ssl-redirect": "false",
+	}
+}
+
+// supportNetworkingV1 检查集群是否支持 networking.k8s.io/v1 的 Ingress，kubernetes 1.19 开始支持，1.22 开始不再支持 extensions/v1beta1
+func (s *Slaver) supportNetworkingV1() bool {
+	resources, err := s.Client.Discovery().ServerResourcesForGroupVersion(networking_v1.SchemeGroupVersion.String())
+	if err != nil {
+		log.Debugf("Discovery %s failed: %s", networking_v1.SchemeGroupVersion, err)
+		return false
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == "ingresses" {
+			return true
+		}
+	}
+	return false
+}
+
+// getIngressClassName 没有配置 ingressClassName 时使用集群默认的 IngressClass，都没有时交给 ingress controller 处理
+func (s *Slaver) getIngressClassName() *string {
+	if s.IngressClassName != "" {
+		return &s.IngressClassName
+	}
+	classes, err := s.Client.NetworkingV1().IngressClasses().List(context.Background(), meta_v1.ListOptions{})
+	if err != nil {
+		log.Debugf("List ingress classes failed: %s", err)
+		return nil
+	}
+	for _, c := range classes.Items {
+		if c.Annotations[defaultIngressClassAnnotation] == "true" {
+			log.Debugf("Using default ingress class %s", c.Name)
+			name := c.Name
+			return &name
+		}
+	}
+	return nil
+}
+
+// InstallIngress 为 domain 添加检查域名的 Ingress 规则
+func (s *Slaver) InstallIngress(domain string) error {
+	svc, err := s.InstallService()
+	if err != nil {
+		return err
+	}
+	if s.supportNetworkingV1() {
+		return s.installIngressV1(domain, svc.Name)
+	}
+	return s.installIngressV1beta1(domain, svc.Name)
+}
+
+func (s *Slaver) installIngressV1(domain, svcName string) error {
+	ingressInterface := s.Client.NetworkingV1().Ingresses(s.Namespace)
+	pathType := networking_v1.PathTypePrefix
+	rule := networking_v1.IngressRule{
+		Host: domain,
+		IngressRuleValue: networking_v1.IngressRuleValue{
+			HTTP: &networking_v1.HTTPIngressRuleValue{
+				Paths: []networking_v1.HTTPIngressPath{{
+					Path:     IngressCheckPath,
+					PathType: &pathType,
+					Backend: networking_v1.IngressBackend{
+						Service: &networking_v1.IngressServiceBackend{
+							Name: svcName,
+							Port: networking_v1.ServiceBackendPort{Name: "http"},
+						},
+					},
+				}},
+			},
+		},
+	}
+
+	ing, err := ingressInterface.Get(context.Background(), s.checkerIngressName(), meta_v1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		for _, r := range ing.Spec.Rules {
+			if r.Host == domain {
+				return nil
+			}
+		}
+		ing.Spec.Rules = append(ing.Spec.Rules, rule)
+		_, err = ingressInterface.Update(context.Background(), ing, meta_v1.UpdateOptions{})
+		return err
+	}
+
+	ingress := &networking_v1.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        s.checkerIngressName(),
+			Labels:      s.CommonLabels,
+			Annotations: s.checkerIngressAnnotations(),
+		},
+		Spec: networking_v1.IngressSpec{
+			IngressClassName: s.getIngressClassName(),
+			Rules:            []networking_v1.IngressRule{rule},
+		},
+	}
+	_, err = ingressInterface.Create(context.Background(), ingress, meta_v1.CreateOptions{})
+	return err
+}
+
+func (s *Slaver) installIngressV1beta1(domain, svcName string) error {
+	ingressInterface := s.Client.ExtensionsV1beta1().Ingresses(s.Namespace)
+	rule := v1beta1.IngressRule{
+		Host: domain,
+		IngressRuleValue: v1beta1.IngressRuleValue{
+			HTTP: &v1beta1.HTTPIngressRuleValue{
+				Paths: []v1beta1.HTTPIngressPath{{
+					Path: IngressCheckPath,
+					Backend: v1beta1.IngressBackend{
+						ServiceName: svcName,
+						ServicePort: intstr.FromString("http"),
+					},
+				}},
+			},
+		},
+	}
+
+	ing, err := ingressInterface.Get(context.Background(), s.checkerIngressName(), meta_v1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil {
+		for _, r := range ing.Spec.Rules {
+			if r.Host == domain {
+				return nil
+			}
+		}
+		ing.Spec.Rules = append(ing.Spec.Rules, rule)
+		_, err = ingressInterface.Update(context.Background(), ing, meta_v1.UpdateOptions{})
+		return err
+	}
+
+	// 旧版本的集群使用注解指定 ingress class
+	annotations := s.checkerIngressAnnotations()
+	if s.IngressClassName != "" {
+		annotations[ingressClassAnnotation] = s.IngressClassName
+	}
+	ingress := &v1beta1.Ingress{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        s.checkerIngressName(),
+			Labels:      s.CommonLabels,
+			Annotations: annotations,
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{rule},
+		},
+	}
+	_, err = ingressInterface.Create(context.Background(), ingress, meta_v1.CreateOptions{})
+	return err
+}
+
+// GetIngressHosts 返回检查域名的 Ingress 中的域名，Ingress 不存在时返回 nil
+func (s *Slaver) GetIngressHosts() ([]string, error) {
+	var hosts []string
+	if s.supportNetworkingV1() {
+		ing, err := s.Client.NetworkingV1().Ingresses(s.Namespace).Get(context.Background(), s.checkerIngressName(), meta_v1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, r := range ing.Spec.Rules {
+			hosts = append(hosts, r.Host)
+		}
+		return hosts, nil
+	}
+	ing, err := s.Client.ExtensionsV1beta1().Ingresses(s.Namespace).Get(context.Background(), s.checkerIngressName(), meta_v1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, r := range ing.Spec.Rules {
+		hosts = append(hosts, r.Host)
+	}
+	return hosts, nil
+}
+
+// DeleteIngress 删除检查域名的 Ingress
+func (s *Slaver) DeleteIngress() error {
+	var err error
+	if s.supportNetworkingV1() {
+		err = s.Client.NetworkingV1().Ingresses(s.Namespace).Delete(context.Background(), s.checkerIngressName(), meta_v1.DeleteOptions{})
+	} else {
+		err = s.Client.ExtensionsV1beta1().Ingresses(s.Namespace).Delete(context.Background(), s.checkerIngressName(), meta_v1.DeleteOptions{})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// DeleteIngressRule 删除 domain 的检查规则，其他域名的规则不受影响，没有规则时删除 Ingress
+func (s *Slaver) DeleteIngressRule(domain string) error {
+	if s.supportNetworkingV1() {
+		return s.deleteIngressRuleV1(domain)
+	}
+	return s.deleteIngressRuleV1beta1(domain)
+}
+
+func (s *Slaver) deleteIngressRuleV1(domain string) error {
+	ingressInterface := s.Client.NetworkingV1().Ingresses(s.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ing, err := ingressInterface.Get(context.Background(), s.checkerIngressName(), meta_v1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		rules := make([]networking_v1.IngressRule, 0, len(ing.Spec.Rules))
+		for _, r := range ing.Spec.Rules {
+			if r.Host != domain {
+				rules = append(rules, r)
+			}
+		}
+		if len(rules) == len(ing.Spec.Rules) {
+			return nil
+		}
+		if len(rules) == 0 {
+			return s.DeleteIngress()
+		}
+		ing.Spec.Rules = rules
+		_, err = ingressInterface.Update(context.Background(), ing, meta_v1.UpdateOptions{})
+		return err
+	})
+}
+
+func (s *Slaver) deleteIngressRuleV1beta1(domain string) error {
+	ingressInterface := s.Client.ExtensionsV1beta1().Ingresses(s.Namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ing, err := ingressInterface.Get(context.Background(), s.checkerIngressName(), meta_v1.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		rules := make([]v1beta1.IngressRule, 0, len(ing.Spec.Rules))
+		for _, r := range ing.Spec.Rules {
+			if r.Host != domain {
+				rules = append(rules, r)
+			}
+		}
+		if len(rules) == len(ing.Spec.Rules) {
+			return nil
+		}
+		if len(rules) == 0 {
+			return s.DeleteIngress()
+		}
+		ing.Spec.Rules = rules
+		_, err = ingressInterface.Update(context.Background(), ing, meta_v1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,146 @@
+package slaver
+
+import (
+	"context"
+	networking_v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"reflect"
+	"testing"
+)
+
+func newIngressSlaver(networkingV1 bool, objects ...runtime.Object) (*Slaver, *fake.Clientset) {
+	client := fake.NewSimpleClientset(objects...)
+	if networkingV1 {
+		client.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*meta_v1.APIResourceList{{
+			GroupVersion: networking_v1.SchemeGroupVersion.String(),
+			APIResources: []meta_v1.APIResource{{Name: "ingresses", Kind: "Ingress", Namespaced: true}},
+		}}
+	}
+	s := &Slaver{Name: "c7n-slaver"}
+	s.Init(client, "c7n-system")
+	return s, client
+}
+
+func TestInstallIngressV1(t *testing.T) {
+	defaultClass := &networking_v1.IngressClass{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:        "nginx",
+			Annotations: map[string]string{defaultIngressClassAnnotation: "true"},
+		},
+	}
+	s, client := newIngressSlaver(true, defaultClass)
+	ctx := context.Background()
+
+	if err := s.InstallIngress("a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.InstallIngress("b.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ing, err := client.NetworkingV1().Ingresses(s.Namespace).Get(ctx, s.checkerIngressName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ing.Spec.IngressClassName == nil || *ing.Spec.IngressClassName != "nginx" {
+		t.Errorf("ingress should use the default ingress class, got %v", ing.Spec.IngressClassName)
+	}
+	backend := ing.Spec.Rules[0].HTTP.Paths[0].Backend.Service
+	if backend == nil || backend.Name != s.Name || backend.Port.Name != "http" {
+		t.Errorf("unexpected backend %+v", backend)
+	}
+	hosts, err := s.GetIngressHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hosts, []string{"a.example.com", "b.example.com"}) {
+		t.Errorf("unexpected hosts %v", hosts)
+	}
+
+	if err = s.DeleteIngress(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.NetworkingV1().Ingresses(s.Namespace).Get(ctx, s.checkerIngressName(), meta_v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("ingress should be deleted, got %v", err)
+	}
+	if hosts, _ = s.GetIngressHosts(); hosts != nil {
+		t.Errorf("want no hosts after deleting, got %v", hosts)
+	}
+
+	// 配置的 ingressClassName 优先于集群默认的 IngressClass
+	s.IngressClassName = "traefik"
+	if err = s.InstallIngress("a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ing, _ = client.NetworkingV1().Ingresses(s.Namespace).Get(ctx, s.checkerIngressName(), meta_v1.GetOptions{})
+	if *ing.Spec.IngressClassName != "traefik" {
+		t.Errorf("want ingress class traefik, got %s", *ing.Spec.IngressClassName)
+	}
+}
+
+func TestInstallIngressV1beta1(t *testing.T) {
+	s, client := newIngressSlaver(false)
+	s.IngressClassName = "nginx"
+	ctx := context.Background()
+
+	if err := s.InstallIngress("a.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ing, err := client.ExtensionsV1beta1().Ingresses(s.Namespace).Get(ctx, s.checkerIngressName(), meta_v1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ing.Annotations[ingressClassAnnotation] != "nginx" {
+		t.Errorf("old clusters should use the ingress class annotation, got %v", ing.Annotations)
+	}
+	if _, err = client.NetworkingV1().Ingresses(s.Namespace).Get(ctx, s.checkerIngressName(), meta_v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("networking.k8s.io/v1 ingress should not be created on old clusters")
+	}
+
+	if err = s.DeleteIngress(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.ExtensionsV1beta1().Ingresses(s.Namespace).Get(ctx, s.checkerIngressName(), meta_v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("ingress should be deleted, got %v", err)
+	}
+}
+
+func TestDeleteIngressRule(t *testing.T) {
+	for _, networkingV1 := range []bool{true, false} {
+		s, _ := newIngressSlaver(networkingV1)
+		for _, d := range []string{"a.example.com", "b.example.com"} {
+			if err := s.InstallIngress(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// 只删除一个域名的规则，其他域名的检查不受影响
+		if err := s.DeleteIngressRule("a.example.com"); err != nil {
+			t.Fatal(err)
+		}
+		hosts, err := s.GetIngressHosts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(hosts, []string{"b.example.com"}) {
+			t.Errorf("networking v1 %v: unexpected hosts %v", networkingV1, hosts)
+		}
+		if err = s.DeleteIngressRule("c.example.com"); err != nil {
+			t.Fatal(err)
+		}
+
+		// 删除最后一个规则时删除 Ingress
+		if err = s.DeleteIngressRule("b.example.com"); err != nil {
+			t.Fatal(err)
+		}
+		if hosts, _ = s.GetIngressHosts(); hosts != nil {
+			t.Errorf("networking v1 %v: ingress should be deleted, got hosts %v", networkingV1, hosts)
+		}
+		if err = s.DeleteIngressRule("b.example.com"); err != nil {
+			t.Errorf("deleting a rule without ingress should not fail: %v", err)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package slaver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	c7ncfg "github.com/choerodon/c7nctl/pkg/config"
+	pb "github.com/choerodon/c7nctl/pkg/protobuf"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JobRunnerSlaver = "slaver"
+	JobRunnerK8sJob = "k8s-job"
+
+	// JobResultPrefix 是 c7n-slaver 在 job 日志的最后一行输出的执行结果的前缀
+	JobResultPrefix = "c7n-job-result: "
+	// job 的 secret 挂载的路径
+	jobSpecPath   = "/etc/c7n-job"
+	jobSpecFile   = "job.json"
+	jobScriptFile = "script.sh"
+	// 保存在 task 中的日志的最大长度
+	maxJobLogLength = 64 << 10
+)
+
+// Executor 执行 release job 中的 sql、http 请求和命令，由 slaver 或者 JobRunner 实现
+type Executor interface {
+	ExecuteRemoteSql(sqlList []string, resource *c7ncfg.Resource, database, sqlType string) error
+	ExecuteRemoteRequestStatus(f Forward) (int, string, error)
+	ExecuteShell(sh Shell) ([]CommandResult, error)
+}
+
+// SqlSession 由在同一个数据库连接中执行一次 ExecuteRemoteSql 所有语句的 Executor 实现，这样 BEGIN 和 COMMIT 才能跨语句使用
+type SqlSession interface {
+	SingleSqlConnection() bool
+}
+
+// SingleSqlConnection 返回 e 是否保证在同一个数据库连接中执行一次 ExecuteRemoteSql 的所有语句
+func SingleSqlConnection(e Executor) bool {
+	if session, ok := e.(SqlSession); ok {
+		return session.SingleSqlConnection()
+	}
+	return false
+}
+
+// DatabaseWaiter 由能够自行重试等待数据库就绪的 Executor 实现，JobRunner 在一个 job 中重试，不为每次检查都创建 job
+type DatabaseWaiter interface {
+	WaitDatabase(resource *c7ncfg.Resource, sqlType string, wait DatabaseWait) error
+}
+
+// DatabaseWait 是等待数据库就绪时的重试参数，重试间隔每次翻倍直到 MaxInterval
+type DatabaseWait struct {
+	Attempts    int           `json:"attempts"`
+	Interval    time.Duration `json:"interval"`
+	MaxInterval time.Duration `json:"maxInterval"`
+	// 从第一次连接开始的超时时间，为 0 时只限制次数
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// LogCollector 由保存了执行日志的 Executor 实现，CollectLogs 返回上次调用之后的日志
+type LogCollector interface {
+	CollectLogs() string
+}
+
+// JobSpec 是 c7n-slaver 在 k8s job 中执行的任务，以 json 的格式保存在 secret 中
+type JobSpec struct {
+	Sql     *SqlJob  `json:"sql,omitempty"`
+	Request *Forward `json:"request,omitempty"`
+}
+
+type SqlJob struct {
+	Datasource *pb.Datasource `json:"datasource"`
+	Statements []string       `json:"statements"`
+	// 不为空时重复连接数据库直到成功后再执行语句
+	Wait *DatabaseWait `json:"wait,omitempty"`
+}
+
+// JobResult 是 c7n-slaver 执行 JobSpec 的结果
+type JobResult struct {
+	Success    bool   `json:"success"`
+	Message    string `json:"message,omitempty"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Body       string `json:"body,omitempty"`
+}
+
+// JobRunner 将每个 release job 作为 k8s Job 执行，用于不允许部署 DaemonSet 或者转发 pod 端口的集群
+type JobRunner struct {
+	Client    kubernetes.Interface
+	Namespace string
+	// 执行 sql 和请求的镜像，必须包含 c7n-slaver，shell 命令没有指定镜像时也使用该镜像
+	Image            string
+	ImagePullPolicy  core_v1.PullPolicy
+	ImagePullSecrets []core_v1.LocalObjectReference
+	Labels           map[string]string
+	// 等待 job 完成的时间，默认为 1 小时
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	logs strings.Builder
+}
+
+func NewJobRunner(client kubernetes.Interface, namespace, image string) *JobRunner {
+	return &JobRunner{
+		Client:    client,
+		Namespace: namespace,
+		Image:     image,
+		Labels:    map[string]string{"app": "c7n-job"},
+		Timeout:   time.Hour,
+	}
+}
+
+// SingleSqlConnection 每次 ExecuteRemoteSql 都是一个 job，c7n-slaver --job 在同一个数据库连接中执行所有语句
+func (r *JobRunner) SingleSqlConnection() bool {
+	return true
+}
+
+// CollectLogs 返回上次调用之后执行的 job 的日志
+func (r *JobRunner) CollectLogs() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	logs := r.logs.String()
+	r.logs.Reset()
+	if len(logs) > maxJobLogLength {
+		logs = logs[len(logs)-maxJobLogLength:]
+	}
+	return logs
+}
+
+func (r *JobRunner) appendLogs(job, logs string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(&r.logs, "==> %s\n%s", job, logs)
+	if logs != "" && !strings.HasSuffix(logs, "\n") {
+		r.logs.WriteString("\n")
+	}
+}
+
+func (r *JobRunner) ExecuteRemoteSql(sqlList []string, resource *c7ncfg.Resource, database, sqlType string) error {
+	return r.runSql("c7n-sql", &SqlJob{Datasource: datasource(resource, database, sqlType), Statements: sqlList})
+}
+
+// WaitDatabase 在一个 job 中重复连接数据库，直到成功或者超过重试的限制
+func (r *JobRunner) WaitDatabase(resource *c7ncfg.Resource, sqlType string, wait DatabaseWait) error {
+	return r.runSql("c7n-wait-db", &SqlJob{Datasource: datasource(resource, "", sqlType), Wait: &wait})
+}
+
+func (r *JobRunner) runSql(name string, job *SqlJob) error {
+	result, err := r.runSpec(name, JobSpec{Sql: job})
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		return std_errors.New(result.Message)
+	}
+	return nil
+}
+
+func datasource(resource *c7ncfg.Resource, database, sqlType string) *pb.Datasource {
+	return &pb.Datasource{
+		Host:     resource.Host,
+		Port:     resource.Port,
+		Username: resource.Username,
+		Password: resource.Password,
+		Database: database,
+		Type:     sqlType,
+	}
+}
+
+func (r *JobRunner) ExecuteRemoteRequestStatus(f Forward) (int, string, error) {
+	result, err := r.runSpec("c7n-request", JobSpec{Request: &f})
+	if err != nil {
+		return 0, "", err
+	}
+	if result.Message != "" && result.StatusCode == 0 {
+		return 0, "", std_errors.New(result.Message)
+	}
+	return result.StatusCode, result.Body, nil
+}
+
+// ExecuteShell 在 sh.Image 或者 JobRunner 的镜像中执行所有命令，执行过程中输出 job 的日志，任意命令的退出码不为 0 时返回 *CommandError
+func (r *JobRunner) ExecuteShell(sh Shell) ([]CommandResult, error) {
+	image := sh.Image
+	if image == "" {
+		image = r.Image
+	}
+	script, err := ShellScript(podScript(sh.Commands), sh.WorkingDir, sh.Env)
+	if err != nil {
+		return nil, err
+	}
+	command := strings.Join(sh.Commands, "\n")
+
+	exitCode, logs, err := r.run(sh.Name, image, []string{"sh", jobSpecPath + "/" + jobScriptFile},
+		map[string][]byte{jobScriptFile: []byte(script)}, true)
+	if err != nil {
+		return nil, err
+	}
+	result := CommandResult{Command: command, ExitCode: exitCode, Output: logs}
+	if exitCode != 0 {
+		return []CommandResult{result}, &CommandError{result}
+	}
+	return []CommandResult{result}, nil
+}
+
+// runSpec 使用 c7n-slaver 执行 spec，解析日志中输出的执行结果
+func (r *JobRunner) runSpec(name string, spec JobSpec) (*JobResult, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	exitCode, logs, err := r.run(name, r.Image, []string{"c7n-slaver", "--job", jobSpecPath + "/" + jobSpecFile},
+		map[string][]byte{jobSpecFile: data}, false)
+	if err != nil {
+		return nil, err
+	}
+	result, output := ParseJobResult(logs)
+	logOutput(name, output)
+	if result == nil {
+		return nil, std_errors.Errorf("job %s exited with code %d without result: %s", name, exitCode, lastLine(output))
+	}
+	return result, nil
+}
+
+// ParseJobResult 从 job 的日志中找到执行结果，返回结果和其他的日志
+func ParseJobResult(logs string) (*JobResult, string) {
+	var result *JobResult
+	var output strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(logs))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, JobResultPrefix) {
+			var res JobResult
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, JobResultPrefix)), &res); err == nil {
+				result = &res
+				continue
+			}
+		}
+		output.WriteString(line)
+		output.WriteString("\n")
+	}
+	return result, output.String()
+}
+
+func lastLine(s string) string {
+	s = strings.TrimRight(s, "\n")
+	return s[strings.LastIndex(s, "\n")+1:]
+}
+
+// run 创建保存了 files 的 secret 和挂载该 secret 的 job，等待 job 结束后返回退出码和日志，并删除 job 和 secret。
+// follow 为 true 时在 job 执行过程中逐行输出日志
+func (r *JobRunner) run(name, image string, command []string, files map[string][]byte, follow bool) (int, string, error) {
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = time.Hour
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	jobName := podName(name)
+	secretInterface := r.Client.CoreV1().Secrets(r.Namespace)
+	jobInterface := r.Client.BatchV1().Jobs(r.Namespace)
+
+	secret := &core_v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: jobName, Labels: r.Labels},
+		Data:       files,
+	}
+	if _, err := secretInterface.Create(ctx, secret, meta_v1.CreateOptions{}); err != nil {
+		return 0, "", std_errors.WithMessage(err, fmt.Sprintf("Failed to create secret %s", jobName))
+	}
+	defer func() {
+		if err := secretInterface.Delete(context.Background(), jobName, meta_v1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			log.Warnf("Failed to delete secret %s: %s", jobName, err)
+		}
+	}()
+
+	backoffLimit := int32(0)
+	job := &batch_v1.Job{
+		ObjectMeta: meta_v1.ObjectMeta{Name: jobName, Labels: r.Labels},
+		Spec: batch_v1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: core_v1.PodTemplateSpec{
+				ObjectMeta: meta_v1.ObjectMeta{Labels: r.Labels},
+				Spec: core_v1.PodSpec{
+					RestartPolicy:    core_v1.RestartPolicyNever,
+					ImagePullSecrets: r.ImagePullSecrets,
+					Containers: []core_v1.Container{{
+						Name:            "job",
+						Image:           image,
+						ImagePullPolicy: r.ImagePullPolicy,
+						Command:         command,
+						VolumeMounts:    []core_v1.VolumeMount{{Name: "spec", MountPath: jobSpecPath, ReadOnly: true}},
+					}},
+					Volumes: []core_v1.Volume{{
+						Name: "spec",
+						VolumeSource: core_v1.VolumeSource{
+							Secret: &core_v1.SecretVolumeSource{SecretName: jobName},
+						},
+					}},
+				},
+			},
+		},
+	}
+	log.Infof("Running job %s with image %s", jobName, image)
+	if _, err := jobInterface.Create(ctx, job, meta_v1.CreateOptions{}); err != nil {
+		return 0, "", std_errors.WithMessage(err, fmt.Sprintf("Failed to create job %s", jobName))
+	}
+	defer func() {
+		propagation := meta_v1.DeletePropagationBackground
+		if err := jobInterface.Delete(context.Background(), jobName, meta_v1.DeleteOptions{PropagationPolicy: &propagation}); err != nil && !errors.IsNotFound(err) {
+			log.Warnf("Failed to delete job %s: %s", jobName, err)
+		}
+	}()
+
+	var logs string
+	streamed := false
+	if follow {
+		if podName, err := r.waitJobPodCreated(ctx, jobName); err != nil {
+			log.Debugf("Waiting for pod of job %s failed: %s", jobName, err)
+		} else {
+			logs, streamed = followPodLogs(ctx, r.Client, r.Namespace, podName, name)
+		}
+	}
+	pod, err := r.waitJobPod(ctx, jobName)
+	if err != nil {
+		return 0, "", err
+	}
+	exitCode := -1
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.State.Terminated != nil {
+			exitCode = int(cs.State.Terminated.ExitCode)
+		}
+	}
+	// 无法跟踪日志时在 job 结束后获取全部日志
+	if !streamed {
+		data, err := r.Client.CoreV1().Pods(r.Namespace).GetLogs(pod.Name, &core_v1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			log.Warnf("Failed to get logs of job %s: %s", jobName, err)
+		}
+		logs = string(data)
+		if follow {
+			logOutput(name, logs)
+		}
+	}
+	r.appendLogs(jobName, logs)
+	return exitCode, logs, nil
+}
+
+// waitJobPodCreated 等待 job 创建 pod，返回 pod 的名称
+func (r *JobRunner) waitJobPodCreated(ctx context.Context, jobName string) (string, error) {
+	ticker := time.NewTicker(podPollInterval)
+	defer ticker.Stop()
+	for {
+		pods, err := r.Client.CoreV1().Pods(r.Namespace).List(ctx, meta_v1.ListOptions{LabelSelector: "job-name=" + jobName})
+		if err != nil {
+			return "", err
+		}
+		if len(pods.Items) > 0 {
+			return pods.Items[0].Name, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitJobPod 等待 job 完成，返回执行 job 的 pod
+func (r *JobRunner) waitJobPod(ctx context.Context, jobName string) (*core_v1.Pod, error) {
+	ticker := time.NewTicker(podPollInterval)
+	defer ticker.Stop()
+	for {
+		job, err := r.Client.BatchV1().Jobs(r.Namespace).Get(ctx, jobName, meta_v1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if job.Status.Succeeded > 0 || job.Status.Failed > 0 {
+			pods, err := r.Client.CoreV1().Pods(r.Namespace).List(ctx, meta_v1.ListOptions{LabelSelector: "job-name=" + jobName})
+			if err != nil {
+				return nil, err
+			}
+			if len(pods.Items) == 0 {
+				return nil, std_errors.Errorf("pod of job %s is not found", jobName)
+			}
+			return &pods.Items[len(pods.Items)-1], nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, std_errors.WithMessage(ctx.Err(), fmt.Sprintf("waiting for job %s", jobName))
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package slaver
+
+import (
+	"context"
+	batch_v1 "k8s.io/api/batch/v1"
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobRunnerExecuteShell(t *testing.T) {
+	podPollInterval = 10 * time.Millisecond
+	client := fake.NewSimpleClientset()
+	var script string
+	client.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		script = string(action.(k8stesting.CreateAction).GetObject().(*core_v1.Secret).Data[jobScriptFile])
+		return false, nil, nil
+	})
+	// 模拟 job 创建后立即完成，pod 以退出码 1 结束
+	client.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batch_v1.Job)
+		job.Status.Failed = 1
+		pod := &core_v1.Pod{
+			ObjectMeta: meta_v1.ObjectMeta{Name: job.Name + "-abcde", Namespace: action.GetNamespace(), Labels: map[string]string{"job-name": job.Name}},
+			Status: core_v1.PodStatus{ContainerStatuses: []core_v1.ContainerStatus{{
+				State: core_v1.ContainerState{Terminated: &core_v1.ContainerStateTerminated{ExitCode: 1}},
+			}}},
+		}
+		if err := client.Tracker().Add(pod); err != nil {
+			t.Fatal(err)
+		}
+		return false, nil, nil
+	})
+	r := NewJobRunner(client, "c7n-system", "c7n-slaver:latest")
+
+	results, err := r.ExecuteShell(Shell{
+		Name:     "init-gitlab",
+		Env:      []core_v1.EnvVar{{Name: "TOKEN", Value: "secret"}},
+		Commands: []string{"false"},
+	})
+	if cmdErr, ok := err.(*CommandError); !ok || cmdErr.ExitCode != 1 {
+		t.Fatalf("want exit code 1, got %v", err)
+	}
+	if len(results) != 1 || results[0].Output != "fake logs" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if !strings.Contains(script, "export TOKEN='secret'") || !strings.Contains(script, "(false\n) || exit $?") {
+		t.Errorf("unexpected script %q", script)
+	}
+	if logs := r.CollectLogs(); !strings.HasPrefix(logs, "==> init-gitlab-") || r.CollectLogs() != "" {
+		t.Errorf("unexpected logs %q", logs)
+	}
+
+	// job 和 secret 执行完成后被删除
+	ctx := context.Background()
+	if jobs, _ := client.BatchV1().Jobs("c7n-system").List(ctx, meta_v1.ListOptions{}); len(jobs.Items) != 0 {
+		t.Errorf("job should be deleted")
+	}
+	if secrets, _ := client.CoreV1().Secrets("c7n-system").List(ctx, meta_v1.ListOptions{}); len(secrets.Items) != 0 {
+		t.Errorf("secret should be deleted")
+	}
+}
+
+func TestParseJobResult(t *testing.T) {
+	result, output := ParseJobResult("executed 2 statements\n" + JobResultPrefix + `{"success":true,"statusCode":200}` + "\n")
+	if result == nil || !result.Success || result.StatusCode != 200 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if output != "executed 2 statements\n" {
+		t.Errorf("unexpected output %q", output)
+	}
+	if result, _ = ParseJobResult("panic: boom\n"); result != nil {
+		t.Errorf("want no result, got %+v", result)
+	}
+}
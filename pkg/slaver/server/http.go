@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/choerodon/c7nctl/pkg/slaver"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+)
+
+// 请求 body 的最大长度
+const maxBodyLength = 10 << 20
+
+type challenge struct {
+	Domain string `json:"domain"`
+	Value  string `json:"value"`
+}
+
+// Handler 返回 slaver 的 http 接口
+//
+//	POST /forward              转发请求，需要 token
+//	POST /c7n/acme-challenge   设置域名的 token，需要 token
+//	GET  /c7n/acme-challenge   通过 ingress 访问，返回 Host 对应的 token
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/forward", s.handleForward)
+	mux.HandleFunc(slaver.IngressCheckPath, s.handleChallenge)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	return mux
+}
+
+func (s *Server) handleForward(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Credentials.CheckRequest(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var f slaver.Forward
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBodyLength)).Decode(&f); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Debugf("Forwarding %s %s", f.Method, f.Url)
+	code, body, err := s.forward(r.Context(), f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(code)
+	io.WriteString(w, body)
+}
+
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		if err := s.Credentials.CheckRequest(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		var c challenge
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxBodyLength)).Decode(&c); err != nil || c.Domain == "" {
+			http.Error(w, "domain and value are required", http.StatusBadRequest)
+			return
+		}
+		s.challenges.Store(c.Domain, c.Value)
+		log.Infof("Listening domain %s", c.Domain)
+	case http.MethodGet:
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		v, ok := s.challenges.Load(host)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, v.(string))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/choerodon/c7nctl/pkg/slaver"
+	std_errors "github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// RunJobFile 读取 JobRunner 保存在 secret 中的任务并执行，用于 c7n-slaver --job
+func (s *Server) RunJobFile(ctx context.Context, path string, out io.Writer) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var spec slaver.JobSpec
+	if err = json.Unmarshal(data, &spec); err != nil {
+		return std_errors.WithMessage(err, fmt.Sprintf("Failed to parse job %s", path))
+	}
+	return s.RunJob(ctx, &spec, out)
+}
+
+// RunJob 执行任务，执行结果以 slaver.JobResultPrefix 开头输出在最后一行。任务执行失败时也只返回结果，返回的错误表示无法输出结果
+func (s *Server) RunJob(ctx context.Context, spec *slaver.JobSpec, out io.Writer) error {
+	result := &slaver.JobResult{}
+	switch {
+	case spec.Sql != nil:
+		s.runSqlJob(ctx, spec.Sql, result, out)
+	case spec.Request != nil:
+		code, body, err := s.forward(ctx, *spec.Request)
+		if err != nil {
+			result.Message = err.Error()
+		} else {
+			fmt.Fprintf(out, "%s %s: %d\n", spec.Request.Method, spec.Request.Url, code)
+			result.StatusCode = code
+			result.Body = body
+			result.Success = code >= 200 && code < 400
+		}
+	default:
+		result.Message = "job is empty"
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s%s\n", slaver.JobResultPrefix, data)
+	return err
+}
+
+func (s *Server) runSqlJob(ctx context.Context, job *slaver.SqlJob, result *slaver.JobResult, out io.Writer) {
+	if job.Datasource == nil {
+		result.Message = "datasource is not specified"
+		return
+	}
+	db, conn, err := s.connectWait(ctx, job, out)
+	if err != nil {
+		result.Message = err.Error()
+		return
+	}
+	defer db.Close()
+	defer conn.Close()
+
+	// sql 中可能有密码，只输出执行的数量
+	for idx, stmt := range job.Statements {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			result.Message = err.Error()
+			fmt.Fprintf(out, "statement %d of %d failed: %s\n", idx+1, len(job.Statements), err)
+			return
+		}
+	}
+	fmt.Fprintf(out, "executed %d statements on %s:%d\n", len(job.Statements), job.Datasource.Host, job.Datasource.Port)
+	result.Success = true
+}
+
+// connectWait 连接数据库，指定了 job.Wait 时按照重试参数重复连接直到成功
+func (s *Server) connectWait(ctx context.Context, job *slaver.SqlJob, out io.Writer) (*sql.DB, *sql.Conn, error) {
+	wait := job.Wait
+	if wait == nil {
+		return s.connect(ctx, job.Datasource)
+	}
+	var deadline time.Time
+	if wait.Timeout > 0 {
+		deadline = time.Now().Add(wait.Timeout)
+	}
+	interval := wait.Interval
+	address := fmt.Sprintf("%s:%d", job.Datasource.Host, job.Datasource.Port)
+	for attempt := 1; ; attempt++ {
+		db, conn, err := s.connect(ctx, job.Datasource)
+		if err == nil {
+			fmt.Fprintf(out, "database %s is ready\n", address)
+			return db, conn, nil
+		}
+		next := time.Now().Add(interval)
+		if attempt >= wait.Attempts || (!deadline.IsZero() && next.After(deadline)) {
+			return nil, nil, std_errors.WithMessage(err, fmt.Sprintf("database %s is not ready after %d attempts", address, attempt))
+		}
+		fmt.Fprintf(out, "database %s is not ready: %s, retry after %s\n", address, err, interval)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		if interval *= 2; wait.MaxInterval > 0 && interval > wait.MaxInterval {
+			interval = wait.MaxInterval
+		}
+	}
+}
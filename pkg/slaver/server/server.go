@@ -0,0 +1,443 @@
+// Package server 是 c7n-slaver 的实现，部署在集群中替安装程序执行 sql、命令和请求，也可以在测试中直接启动
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	pb "github.com/choerodon/c7nctl/pkg/protobuf"
+	"github.com/choerodon/c7nctl/pkg/slaver"
+	mysql "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	std_errors "github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+	// 命令输出的最大长度，避免超过 grpc 消息的大小限制
+	maxOutputLength = 1 << 20
+)
+
+// Server 实现了 RouteCallServer，以及 slaver 的 http 接口
+type Server struct {
+	Credentials *slaver.Credentials
+	// 检查健康状态和转发请求使用的 client
+	HTTPClient *http.Client
+	// 打开数据库连接，sqlType 为 mysql 或者 postgres，方便测试时替换
+	OpenDB func(sqlType, dsn string) (*sql.DB, error)
+
+	// 域名检查的 token，key 为域名
+	challenges sync.Map
+	grpcServer *grpc.Server
+	httpServer *http.Server
+}
+
+func New(c *slaver.Credentials) *Server {
+	return &Server{
+		Credentials: c,
+		HTTPClient: &http.Client{
+			Timeout: defaultTimeout,
+			// 集群内部的服务大多使用自签名证书
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		},
+		OpenDB: openDB,
+	}
+}
+
+// Serve 在 grpcLis 上提供 grpc 服务，在 httpLis 上提供 http 服务，直到其中一个出错或者调用 Stop
+func (s *Server) Serve(grpcLis, httpLis net.Listener) error {
+	opts, err := s.Credentials.ServerOptions()
+	if err != nil {
+		return err
+	}
+	s.grpcServer = grpc.NewServer(opts...)
+	pb.RegisterRouteCallServer(s.grpcServer, s)
+	s.httpServer = &http.Server{Handler: s.Handler()}
+
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- s.grpcServer.Serve(grpcLis)
+	}()
+	go func() {
+		if err := s.httpServer.Serve(httpLis); err != http.ErrServerClosed {
+			errCh <- err
+			return
+		}
+		errCh <- nil
+	}()
+	err = <-errCh
+	s.Stop()
+	return err
+}
+
+// ListenAndServe 监听 grpc 和 http 的地址并提供服务
+func (s *Server) ListenAndServe(grpcAddr, httpAddr string) error {
+	grpcLis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		return err
+	}
+	httpLis, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		grpcLis.Close()
+		return err
+	}
+	log.Infof("c7n-slaver is listening on grpc %s, http %s", grpcAddr, httpAddr)
+	return s.Serve(grpcLis, httpLis)
+}
+
+func (s *Server) Stop() {
+	if s.grpcServer != nil {
+		s.grpcServer.Stop()
+	}
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+}
+
+// CheckHealth 检查 socket 是否可以连接，或者 httpGet 是否返回 2xx 和 3xx
+func (s *Server) CheckHealth(ctx context.Context, check *pb.Check) (*pb.Result, error) {
+	address := net.JoinHostPort(check.Host, strconv.Itoa(int(check.Port)))
+	if check.Type == "socket" {
+		conn, err := net.DialTimeout("tcp", address, defaultTimeout)
+		if err != nil {
+			return &pb.Result{Success: false, Message: err.Error()}, nil
+		}
+		conn.Close()
+		return &pb.Result{Success: true}, nil
+	}
+
+	schema := check.Schema
+	if schema == "" {
+		schema = "http"
+	}
+	u := fmt.Sprintf("%s://%s%s", schema, address, check.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return &pb.Result{Success: false, Message: err.Error()}, nil
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return &pb.Result{Success: false, Message: err.Error()}, nil
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	return &pb.Result{
+		Success:    resp.StatusCode >= 200 && resp.StatusCode < 400,
+		StatusCode: int32(resp.StatusCode),
+		Message:    resp.Status,
+	}, nil
+}
+
+// ExecuteSql 第一条消息是数据源，之后每条 sql 都返回执行结果。一个 stream 使用一个数据库连接，所以 BEGIN 和 COMMIT 可以跨消息使用
+func (s *Server) ExecuteSql(stream pb.RouteCall_ExecuteSqlServer) error {
+	ctx := stream.Context()
+	var db *sql.DB
+	var conn *sql.Conn
+	var connErr error
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+		if db != nil {
+			db.Close()
+		}
+	}()
+
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if in.Datasource != nil {
+			if conn != nil {
+				conn.Close()
+				db.Close()
+				conn, db = nil, nil
+			}
+			db, conn, connErr = s.connect(ctx, in.Datasource)
+			if connErr != nil {
+				log.Errorf("Connect to %s:%d failed: %s", in.Datasource.Host, in.Datasource.Port, connErr)
+			}
+			if in.Sql == "" {
+				continue
+			}
+		}
+
+		out := &pb.RouteSql{Sql: in.Sql, Scope: in.Scope}
+		switch {
+		case connErr != nil:
+			out.Message = connErr.Error()
+		case conn == nil:
+			out.Message = "datasource is not specified"
+		default:
+			if _, err := conn.ExecContext(ctx, in.Sql); err != nil {
+				out.Message = err.Error()
+			} else {
+				out.Success = true
+			}
+		}
+		if err := stream.Send(out); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) connect(ctx context.Context, ds *pb.Datasource) (*sql.DB, *sql.Conn, error) {
+	sqlType := ds.Type
+	if sqlType == "" {
+		sqlType = "mysql"
+	}
+	dsn, err := DSN(ds)
+	if err != nil {
+		return nil, nil, err
+	}
+	db, err := s.OpenDB(sqlType, dsn)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	if err = conn.PingContext(ctx); err != nil {
+		conn.Close()
+		db.Close()
+		return nil, nil, err
+	}
+	return db, conn, nil
+}
+
+// DSN 根据数据源生成 mysql 或者 postgres 的连接字符串
+func DSN(ds *pb.Datasource) (string, error) {
+	switch ds.Type {
+	case "", "mysql":
+		cfg := mysql.NewConfig()
+		cfg.User = ds.Username
+		cfg.Passwd = ds.Password
+		cfg.Net = "tcp"
+		cfg.Addr = net.JoinHostPort(ds.Host, strconv.Itoa(int(defaultPort(ds.Port, 3306))))
+		cfg.DBName = ds.Database
+		cfg.Timeout = defaultTimeout
+		cfg.Params = map[string]string{"charset": "utf8mb4"}
+		return cfg.FormatDSN(), nil
+	case "postgres":
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(ds.Username, ds.Password),
+			Host:     net.JoinHostPort(ds.Host, strconv.Itoa(int(defaultPort(ds.Port, 5432)))),
+			Path:     "/" + ds.Database,
+			RawQuery: "sslmode=disable&connect_timeout=30",
+		}
+		return u.String(), nil
+	}
+	return "", std_errors.Errorf("unsupported datasource type %s", ds.Type)
+}
+
+func defaultPort(port, def int32) int32 {
+	if port == 0 {
+		return def
+	}
+	return port
+}
+
+func openDB(sqlType, dsn string) (*sql.DB, error) {
+	driver := "mysql"
+	if sqlType == "postgres" {
+		driver = "postgres"
+	}
+	return sql.Open(driver, dsn)
+}
+
+// ExecuteCommand 执行命令并返回输出和退出码。调用时携带了 slaver.StreamOutputMetadataKey 时，
+// 命令执行过程中逐行发送名称为 slaver.OutputMessageName 的输出
+func (s *Server) ExecuteCommand(stream pb.RouteCall_ExecuteCommandServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	streamOutput := len(md.Get(slaver.StreamOutputMetadataKey)) > 0
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		log.Debugf("Executing %s %s", in.Name, strings.Join(in.Args, " "))
+
+		out := &pb.RouteCommand{Name: in.Name, Args: in.Args}
+		cmd := exec.CommandContext(stream.Context(), in.Name, in.Args...)
+		var output []byte
+		if streamOutput {
+			output, err = runCommand(cmd, stream)
+		} else {
+			output, err = cmd.CombinedOutput()
+		}
+		if len(output) > maxOutputLength {
+			output = output[len(output)-maxOutputLength:]
+		}
+		out.Message = string(output)
+		if err != nil {
+			out.StatusCode = -1
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				out.StatusCode = int32(exitErr.ExitCode())
+			}
+			if out.Message == "" {
+				out.Message = err.Error()
+			}
+		} else {
+			out.Success = true
+		}
+		if err := stream.Send(out); err != nil {
+			return err
+		}
+	}
+}
+
+// runCommand 执行命令并逐行发送输出，返回全部输出。发送失败时继续读取输出直到命令结束
+func runCommand(cmd *exec.Cmd, stream pb.RouteCall_ExecuteCommandServer) ([]byte, error) {
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		done <- err
+	}()
+
+	var output bytes.Buffer
+	sending := true
+	reader := bufio.NewReader(pr)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			output.WriteString(line)
+			if sending {
+				if err := stream.Send(&pb.RouteCommand{Name: slaver.OutputMessageName, Message: line}); err != nil {
+					log.Warnf("Failed to send output: %s", err)
+					sending = false
+				}
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return output.Bytes(), <-done
+}
+
+// ExecuteRequest 发出 http 请求，返回状态码和 body
+func (s *Server) ExecuteRequest(stream pb.RouteCall_ExecuteRequestServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		schema := in.Schema
+		if schema == "" {
+			schema = "http"
+		}
+		host := in.Host
+		if in.Port != 0 {
+			host = net.JoinHostPort(in.Host, strconv.Itoa(int(in.Port)))
+		}
+		header := make(map[string][]string)
+		for k, v := range in.Header {
+			header[k] = v.Value
+		}
+		f := slaver.Forward{
+			Url:    fmt.Sprintf("%s://%s%s", schema, host, in.Path),
+			Method: in.Method,
+			Body:   in.Body,
+			Header: header,
+		}
+
+		out := &pb.Result{}
+		code, body, err := s.forward(stream.Context(), f)
+		if err != nil {
+			out.Message = err.Error()
+		} else {
+			out.StatusCode = int32(code)
+			out.Message = body
+			out.Success = code >= 200 && code < 400
+		}
+		if err := stream.Send(out); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) forward(ctx context.Context, f slaver.Forward) (int, string, error) {
+	method := f.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequestWithContext(ctx, strings.ToUpper(method), f.Url, strings.NewReader(f.Body))
+	if err != nil {
+		return 0, "", err
+	}
+	for k, v := range f.Header {
+		req.Header[k] = v
+	}
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, "", err
+	}
+	return resp.StatusCode, string(data), nil
+}
+
+// CredentialsFromEnv 读取 slaver 容器中的 token 和 TLS 证书
+func CredentialsFromEnv() (*slaver.Credentials, error) {
+	token := os.Getenv(slaver.EnvToken)
+	if token == "" {
+		return nil, std_errors.Errorf("environment %s is required", slaver.EnvToken)
+	}
+	certFile, keyFile := os.Getenv(slaver.EnvTLSCert), os.Getenv(slaver.EnvTLSKey)
+	if certFile == "" || keyFile == "" {
+		return nil, std_errors.Errorf("environment %s and %s are required", slaver.EnvTLSCert, slaver.EnvTLSKey)
+	}
+	cert, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &slaver.Credentials{Token: token, Cert: cert, Key: key}, nil
+}
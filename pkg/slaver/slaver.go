@@ -3,6 +3,9 @@ package slaver
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	sys_errors "errors"
 	"fmt"
@@ -10,13 +13,13 @@ import (
 	c7nconsts "github.com/choerodon/c7nctl/pkg/common/consts"
 	c7ncfg "github.com/choerodon/c7nctl/pkg/config"
 	pb "github.com/choerodon/c7nctl/pkg/protobuf"
+	std_errors "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"github.com/vinkdong/gox/random"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 	"io/ioutil"
 	v1 "k8s.io/api/apps/v1"
 	core_v1 "k8s.io/api/core/v1"
-	"k8s.io/api/extensions/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -24,7 +27,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/portforward"
 	"k8s.io/client-go/transport/spdy"
-	"math/rand"
+	"math/big"
 	"net"
 	"net/http"
 	"os"
@@ -49,6 +52,18 @@ type Slaver struct {
 	GRpcAddress     string
 	PvcName         string
 	DataPath        string
+	// 每次安装生成的 token 和 TLS 证书，保存在 secret 中
+	Credentials *Credentials `yaml:"-"`
+	// 检查域名的 Ingress 使用的 IngressClass，为空时使用集群默认的 IngressClass
+	IngressClassName string `yaml:"ingressClassName"`
+	// 为 true 时部署前重新生成 token 和证书，每次安装都使用新的认证信息
+	RotateCredentials bool `yaml:"-"`
+	// 为 true 时允许使用明文连接不支持 TLS 和 token 的旧版本 slaver，连接既不加密也不认证，默认拒绝连接
+	AllowPlaintext bool `yaml:"allowPlaintext"`
+	// 非默认实例的名称，添加到 slaver 资源的 InstanceLabel 中
+	Instance string `yaml:"-"`
+	// 旧版本的 slaver 镜像不支持 TLS 和 token，只能使用明文连接
+	plaintext bool
 }
 
 const IngressCheckPath = "/c7n/acme-challenge"
@@ -60,9 +75,7 @@ type Dir struct {
 }
 
 func (s *Slaver) InitSalver(clientset *kubernetes.Clientset, namespace string, stopCh <-chan struct{}) (*Slaver, error) {
-	s.CommonLabels = c7nconsts.CommonLabels
-	s.Namespace = namespace
-	s.Client = clientset
+	s.Init(clientset, namespace)
 
 	if _, err := s.CheckInstall(); err != nil {
 		return s, err
@@ -71,14 +84,35 @@ func (s *Slaver) InitSalver(clientset *kubernetes.Clientset, namespace string, s
 	grpcPort := s.ForwardPort("grpc", stopCh)
 	s.Address = fmt.Sprintf("http://127.0.0.1:%d", port)
 	s.GRpcAddress = fmt.Sprintf("127.0.0.1:%d", grpcPort)
+	if err := s.checkTLS(); err != nil {
+		return s, err
+	}
 	return s, nil
 }
 
+// Init 设置 slaver 所在的集群和命名空间，不会部署 slaver
+func (s *Slaver) Init(client kubernetes.Interface, namespace string) {
+	s.Namespace = namespace
+	s.Client = client
+	// 复制一份 labels，避免修改全局的 CommonLabels
+	s.CommonLabels = map[string]string{"app": s.Name}
+	for k, v := range c7nconsts.CommonLabels {
+		s.CommonLabels[k] = v
+	}
+	if s.Instance != "" {
+		s.CommonLabels[c7nconsts.InstanceLabel] = s.Instance
+	}
+}
+
 /*
 *
 Type: httpGet or socket
 */
 func (s *Slaver) CheckInstall() (*v1.DaemonSet, error) {
+	created, err := s.checkCredentials()
+	if err != nil {
+		return nil, err
+	}
 	ds, err := s.Client.AppsV1().DaemonSets(s.Namespace).Get(context.Background(), s.Name, meta_v1.GetOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -87,17 +121,90 @@ func (s *Slaver) CheckInstall() (*v1.DaemonSet, error) {
 		}
 		return nil, err
 	}
+	// 已经存在的 slaver 不知道新生成的 token，需要重新部署
+	if created {
+		log.Infof("redeploying daemonSet %s with new credentials", s.Name)
+		return s.redeploy()
+	}
 	return ds, err
 }
 
+func (s *Slaver) secretName() string {
+	return s.Name + "-auth"
+}
+
+// 读取 slaver 的认证信息，不存在或者 RotateCredentials 为 true 时重新生成并保存到 secret 中，返回是否生成了新的认证信息
+func (s *Slaver) checkCredentials() (bool, error) {
+	secretInterface := s.Client.CoreV1().Secrets(s.Namespace)
+	secret, err := secretInterface.Get(context.Background(), s.secretName(), meta_v1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	exists := err == nil
+	if exists && !s.RotateCredentials {
+		s.Credentials, err = CredentialsFromSecret(secret)
+		return false, err
+	}
+
+	log.Infof("generating credentials of slaver %s", s.Name)
+	s.Credentials, err = NewCredentials(s.Name, fmt.Sprintf("%s.%s.svc", s.Name, s.Namespace), "localhost", "127.0.0.1")
+	if err != nil {
+		return false, err
+	}
+	newSecret := s.Credentials.Secret(s.secretName(), s.CommonLabels)
+	if exists {
+		newSecret.ResourceVersion = secret.ResourceVersion
+		_, err = secretInterface.Update(context.Background(), newSecret, meta_v1.UpdateOptions{})
+	} else {
+		_, err = secretInterface.Create(context.Background(), newSecret, meta_v1.CreateOptions{})
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Slaver) redeploy() (*v1.DaemonSet, error) {
+	propagation := meta_v1.DeletePropagationBackground
+	err := s.Client.AppsV1().DaemonSets(s.Namespace).Delete(context.Background(), s.Name, meta_v1.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	// 等待旧的 pod 删除，避免端口转发到旧的 slaver
+	for i := 0; i < 60; i++ {
+		if poList, err := s.GetPods(); err == nil && len(poList.Items) == 0 {
+			break
+		}
+		time.Sleep(time.Second * 2)
+	}
+	return s.Install()
+}
+
 func (s *Slaver) Install() (*v1.DaemonSet, error) {
 
+	env := append([]core_v1.EnvVar{
+		{
+			Name: EnvToken,
+			ValueFrom: &core_v1.EnvVarSource{
+				SecretKeyRef: &core_v1.SecretKeySelector{
+					LocalObjectReference: core_v1.LocalObjectReference{Name: s.secretName()},
+					Key:                  secretTokenKey,
+				},
+			},
+		},
+		{Name: EnvTLSCert, Value: TLSMountPath + "/" + secretCertKey},
+		{Name: EnvTLSKey, Value: TLSMountPath + "/" + secretKeyKey},
+	}, s.Env...)
+	volumeMounts := append([]core_v1.VolumeMount{
+		{Name: "tls", MountPath: TLSMountPath, ReadOnly: true},
+	}, s.VolumeMounts...)
+
 	dsContainer := core_v1.Container{
 		Name:            s.Name,
 		Image:           s.Image,
 		Ports:           s.Ports,
-		Env:             s.Env,
-		VolumeMounts:    s.VolumeMounts,
+		Env:             env,
+		VolumeMounts:    volumeMounts,
 		ImagePullPolicy: s.ImagePullPolicy,
 	}
 
@@ -116,6 +223,18 @@ func (s *Slaver) Install() (*v1.DaemonSet, error) {
 		Name:         "data",
 		VolumeSource: volumeSource,
 	}
+	tlsVolume := core_v1.Volume{
+		Name: "tls",
+		VolumeSource: core_v1.VolumeSource{
+			Secret: &core_v1.SecretVolumeSource{
+				SecretName: s.secretName(),
+				Items: []core_v1.KeyToPath{
+					{Key: secretCertKey, Path: secretCertKey},
+					{Key: secretKeyKey, Path: secretKeyKey},
+				},
+			},
+		},
+	}
 
 	tmp := core_v1.PodTemplateSpec{
 		ObjectMeta: meta_v1.ObjectMeta{
@@ -123,7 +242,7 @@ func (s *Slaver) Install() (*v1.DaemonSet, error) {
 		},
 		Spec: core_v1.PodSpec{
 			Containers: []core_v1.Container{dsContainer},
-			Volumes:    []core_v1.Volume{volume},
+			Volumes:    []core_v1.Volume{volume, tlsVolume},
 		},
 	}
 
@@ -225,9 +344,9 @@ loop:
 getFreePort:
 	conn, err := net.DialTimeout("tcp", net.JoinHostPort("", strconv.Itoa(port)), time.Second)
 	if conn != nil {
+		conn.Close()
 		port += 1
 		goto getFreePort
-		conn.Close()
 	}
 
 	out := &bytes.Buffer{}
@@ -267,19 +386,88 @@ func (s *Slaver) MakeDir(dir Dir) error {
 	return sys_errors.New(fmt.Sprintf("can't create dir %s with mode %s", dir.Path, dir.Mode))
 }
 
+// 只连接持有本次安装证书的 slaver，并在每次调用时携带 token
 func (s *Slaver) connectGRpc() (*grpc.ClientConn, error) {
+	if s.plaintext {
+		return grpc.Dial(s.GRpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if s.Credentials == nil {
+		return nil, sys_errors.New("slaver credentials are not initialized")
+	}
+	opts, err := s.Credentials.DialOptions(s.Name)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Dial(s.GRpcAddress, opts...)
+}
+
+// checkTLS 确认 slaver 的 grpc 服务持有本次安装的证书，证书不匹配的 slaver 会被拒绝。旧版本的 slaver 镜像只提供明文的
+// grpc 服务，只有设置了 AllowPlaintext 时才回退到明文连接，否则拒绝连接
+func (s *Slaver) checkTLS() error {
+	if s.Credentials == nil {
+		return sys_errors.New("slaver credentials are not initialized")
+	}
+	tlsConfig, err := s.Credentials.ClientTLSConfig(s.Name)
+	if err != nil {
+		return err
+	}
+	// slaver 启动需要时间，端口转发成功后服务可能还没有监听
+	for i := 0; i < 30; i++ {
+		var conn *tls.Conn
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: time.Second * 10}, "tcp", s.GRpcAddress, tlsConfig)
+		if err == nil {
+			conn.Close()
+			s.plaintext = false
+			return nil
+		}
+		if isCertificateError(err) {
+			return std_errors.WithMessage(err, fmt.Sprintf("slaver %s does not hold the certificate of this installation", s.Name))
+		}
+		if s.isPlaintextSlaver() {
+			if !s.AllowPlaintext {
+				return std_errors.Errorf("slaver %s (image %s) does not support TLS and token authentication, refusing to connect. "+
+					"Set spec.basic.slaver.image to an image built from docker/slaver.Dockerfile, or use --allow-plaintext-slaver "+
+					"to connect without encryption and authentication", s.Name, s.Image)
+			}
+			log.Warnf("Slaver %s (image %s) does not support TLS and token authentication, using an unencrypted "+
+				"and unauthenticated grpc connection because plaintext is allowed", s.Name, s.Image)
+			s.plaintext = true
+			return nil
+		}
+		log.Debugf("waiting slaver %s grpc server: %s", s.Name, err)
+		time.Sleep(time.Second * 2)
+	}
+	return std_errors.WithMessage(err, fmt.Sprintf("connect slaver %s grpc server %s failed", s.Name, s.GRpcAddress))
+}
 
-	return grpc.Dial(s.GRpcAddress, grpc.WithInsecure())
+// isPlaintextSlaver 使用明文连接调用 CheckHealth 检查 slaver 自身的 grpc 端口，调用成功说明是旧版本的 slaver
+func (s *Slaver) isPlaintextSlaver() bool {
+	conn, err := grpc.Dial(s.GRpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	check := &pb.Check{Type: "socket", Host: "127.0.0.1", Port: s.getPort("grpc")}
+	_, err = pb.NewRouteCallClient(conn).CheckHealth(ctx, check)
+	return err == nil
+}
+
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	return sys_errors.As(err, &unknownAuthority) || sys_errors.As(err, &hostname) || sys_errors.As(err, &invalid)
 }
 
 func (s *Slaver) CheckHealth(name string, check *pb.Check) bool {
-	conn, err := s.connectGRpc()
+	c, cancel, ctx, err := s.getClient()
 	if err != nil {
-		log.Errorf("connect %s grpc path  failed", s.GRpcAddress)
+		log.Error(err)
 		return false
 	}
-	c := pb.NewRouteCallClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Hour*1)
+	defer cancel()
 	if check.Type == "socket" {
 		log.Debugf("checking %s:%d", check.Host, check.Port)
 	} else {
@@ -293,7 +481,6 @@ remoteCheck:
 		time.Sleep(time.Second * 20)
 		goto remoteCheck
 	}
-	defer cancel()
 
 	if r.Success == false {
 		log.Debugf("check health failed with msg: %s retry..", r.Message)
@@ -317,40 +504,54 @@ type Forward struct {
 }
 
 func (s *Slaver) ExecuteRemoteRequest(f Forward) (string, error) {
+	code, body, err := s.ExecuteRemoteRequestStatus(f)
+	if err != nil {
+		return body, err
+	}
+	if code >= 400 || code < 200 {
+		log.Infof("request %s ", f.Url)
+		return body, sys_errors.New(fmt.Sprintf("resp code %d not is 2xx or 3xx", code))
+	}
+	return body, nil
+}
+
+// ExecuteRemoteRequestStatus 通过 slaver 转发请求，返回响应的状态码和 body，不校验状态码
+func (s *Slaver) ExecuteRemoteRequestStatus(f Forward) (int, string, error) {
 	url := fmt.Sprint(s.Address, "/forward")
 
 	data, err := json.Marshal(f)
 	if err != nil {
-		return "", err
+		return 0, "", err
+	}
+	if s.Credentials == nil {
+		return 0, "", sys_errors.New("slaver credentials are not initialized")
 	}
 	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
 	if err != nil {
-		return "", err
+		return 0, "", err
+	}
+	for k, v := range f.Header {
+		req.Header[k] = v
 	}
-	req.Header = f.Header
+	req.Header.Set(TokenHeader, s.Credentials.Token)
 	client := http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return 0, "", err
 	}
+	defer resp.Body.Close()
 	data, err = ioutil.ReadAll(resp.Body)
-
-	if resp.StatusCode >= 400 || resp.StatusCode < 200 {
-		log.Infof("request %s ", f.Url)
-		return string(data), sys_errors.New(fmt.Sprintf("resp code %d not is 2xx or 3xx", resp.StatusCode))
+	if err != nil {
+		return resp.StatusCode, "", err
 	}
-
-	return string(data), nil
+	return resp.StatusCode, string(data), nil
 }
 
 func (s *Slaver) ExecuteRemoteSql(sqlList []string, resource *c7ncfg.Resource, database, sqlType string) error {
-	conn, err := s.connectGRpc()
+	c, cancel, ctx, err := s.getClient()
 	if err != nil {
-		r := fmt.Sprintf("connect %s grpc path  failed", s.GRpcAddress)
-		return sys_errors.New(r)
+		return err
 	}
-	c := pb.NewRouteCallClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Hour*1)
 	defer cancel()
 	stream, err := c.ExecuteSql(ctx)
 	if err != nil {
@@ -389,28 +590,46 @@ func (s *Slaver) ExecuteRemoteSql(sqlList []string, resource *c7ncfg.Resource, d
 	return nil
 }
 
+// SingleSqlConnection docker/slaver.Dockerfile 构建的 slaver 在一个 stream 中使用同一个数据库连接，旧版本的 slaver 不保证
+func (s *Slaver) SingleSqlConnection() bool {
+	return !s.plaintext
+}
+
+// getClient 连接 slaver 的 grpc 服务，返回的 cancel 同时关闭连接，调用方必须在使用结束后调用
 func (s *Slaver) getClient() (pb.RouteCallClient, context.CancelFunc, context.Context, error) {
 	conn, err := s.connectGRpc()
 	if err != nil {
-		log.Errorf("connect %s grpc path  failed", s.GRpcAddress)
-		return nil, nil, nil, err
+		return nil, nil, nil, std_errors.WithMessage(err, fmt.Sprintf("connect %s grpc path failed", s.GRpcAddress))
 	}
 	c := pb.NewRouteCallClient(conn)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Hour*1)
-	return c, cancel, ctx, nil
+	return c, func() {
+		cancel()
+		conn.Close()
+	}, ctx, nil
 }
 
 func (s *Slaver) ExecuteRemoteCommand(commands []string) bool {
+	if _, err := s.ExecuteRemoteCommandOutput(commands); err != nil {
+		log.Error(err)
+		return false
+	}
+	return true
+}
+
+// ExecuteRemoteCommandOutput 在 slaver 中依次执行命令，返回每条命令的输出
+func (s *Slaver) ExecuteRemoteCommandOutput(commands []string) ([]string, error) {
 	c, cancel, ctx, err := s.getClient()
 	if err != nil {
-		return false
+		return nil, err
 	}
 	defer cancel()
 	stream, err := c.ExecuteCommand(ctx)
 	if err != nil {
-		return false
+		return nil, err
 	}
 
+	var output []string
 	for _, c := range commands {
 		routeCommand := &pb.RouteCommand{
 			Name: "sh",
@@ -418,21 +637,19 @@ func (s *Slaver) ExecuteRemoteCommand(commands []string) bool {
 		}
 		log.Debugf("executed %s %s", routeCommand.Name, strings.Join(routeCommand.Args, " "))
 		if err := stream.Send(routeCommand); err != nil {
-			log.Error(err)
-			return false
+			return output, err
 		}
 		result, err := stream.Recv()
 		if err != nil {
-			log.Error(err)
-			return false
+			return output, err
 		}
 		if !result.Success {
-			log.Error(result.Message)
-			return false
+			return output, sys_errors.New(result.Message)
 		}
 		log.Debugf(result.Message)
+		output = append(output, result.Message)
 	}
-	return true
+	return output, nil
 }
 
 func (s *Slaver) InstallService() (*core_v1.Service, error) {
@@ -474,97 +691,6 @@ func (s *Slaver) InstallService() (*core_v1.Service, error) {
 	return svcInterface.Create(context.Background(), service, meta_v1.CreateOptions{})
 }
 
-func (s *Slaver) UpdateIngress(ingress *v1beta1.Ingress, domain string) error {
-	for _, r := range ingress.Spec.Rules {
-		if r.Host == domain {
-			return nil
-		}
-	}
-	ruleList := ingress.Spec.Rules
-	ingressRule, err := s.getIngressRule(domain)
-	if err != nil {
-		return err
-	}
-	ingress.Spec.Rules = append(ruleList, ingressRule)
-
-	ingressInterface := s.Client.ExtensionsV1beta1().Ingresses(s.Namespace)
-
-	_, err = ingressInterface.Update(context.Background(), ingress, meta_v1.UpdateOptions{})
-	return err
-}
-
-func (s *Slaver) getIngressRule(domain string) (v1beta1.IngressRule, error) {
-	port := intstr.IntOrString{
-		Type:   1,
-		StrVal: "http",
-	}
-	svc, err := s.InstallService()
-
-	if err != nil {
-		return v1beta1.IngressRule{}, err
-	}
-
-	backend := v1beta1.IngressBackend{
-		ServiceName: svc.Name,
-		ServicePort: port,
-	}
-
-	ingressPath := v1beta1.HTTPIngressPath{
-		Path:    IngressCheckPath,
-		Backend: backend,
-	}
-	ingressRuleValue := v1beta1.IngressRuleValue{
-		HTTP: &v1beta1.HTTPIngressRuleValue{
-			Paths: []v1beta1.HTTPIngressPath{ingressPath},
-		},
-	}
-	ingressRule := v1beta1.IngressRule{
-		Host:             domain,
-		IngressRuleValue: ingressRuleValue,
-	}
-
-	return ingressRule, nil
-}
-
-func (s *Slaver) InstallIngress(domain string) error {
-
-	ingressInterface := s.Client.ExtensionsV1beta1().Ingresses(s.Namespace)
-
-	ing, err := ingressInterface.Get(context.Background(), s.Name+"checker", meta_v1.GetOptions{})
-	if err != nil && !errors.IsNotFound(err) {
-		return err
-	}
-	if err == nil {
-		return s.UpdateIngress(ing, domain)
-	}
-
-	ingressRule, err := s.getIngressRule(domain)
-
-	if err != nil {
-		return err
-	}
-
-	annotations := make(map[string]string)
-	annotations["ingress.kubernetes.io/ssl-redirect"] = "false"
-	annotations["nginx.ingress.kubernetes.io/ssl-redirect"] = "false"
-	ingress := &v1beta1.Ingress{
-		TypeMeta: meta_v1.TypeMeta{
-			Kind:       "Ingress",
-			APIVersion: "extensions/v1beta1",
-		},
-		ObjectMeta: meta_v1.ObjectMeta{
-			Name:        s.Name + "checker",
-			Labels:      s.CommonLabels,
-			Annotations: annotations,
-		},
-		Spec: v1beta1.IngressSpec{
-			Rules: []v1beta1.IngressRule{ingressRule},
-		},
-	}
-	_, err = ingressInterface.Create(context.Background(), ingress, meta_v1.CreateOptions{})
-	return err
-}
-
 func (s *Slaver) SendAll(request *pb.RouteRequest, retry bool) error {
 
 	c, cancel, ctx, err := s.getClient()
@@ -605,24 +731,32 @@ func (s *Slaver) SendAll(request *pb.RouteRequest, retry bool) error {
 }
 
 func (s *Slaver) getHttpPort() int32 {
+	return s.getPort("http")
+}
+
+func (s *Slaver) getPort(name string) int32 {
 	for _, port := range s.Ports {
-		if port.Name == "http" {
+		if port.Name == name {
 			return port.ContainerPort
 		}
 	}
 	return 0
 }
 
+const tokenChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// RandomToken 使用 crypto/rand 生成由数字和字母组成的随机字符串，可以并发调用
 func RandomToken(length int) string {
-	bytes := make([]byte, length)
-	rand.Seed(time.Now().UnixNano())
-	for i := 0; i < length; i++ {
-		random.Seed(time.Now().UnixNano())
-		op := random.RangeIntInclude(random.Slice{Start: 48, End: 57},
-			random.Slice{Start: 65, End: 90}, random.Slice{Start: 97, End: 122})
-		bytes[i] = byte(op) //A=65 and Z = 65+25
+	b := make([]byte, length)
+	max := big.NewInt(int64(len(tokenChars)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		b[i] = tokenChars[n.Int64()]
 	}
-	return string(bytes)
+	return string(b)
 }
 
 func (s *Slaver) CheckClusterDomain(domain string) error {
@@ -630,6 +764,17 @@ func (s *Slaver) CheckClusterDomain(domain string) error {
 	if err != nil {
 		return err
 	}
+	// 检查完成后删除该域名的规则，避免检查域名的规则一直暴露在集群外，其他域名的规则不受影响
+	defer func() {
+		if err := s.DeleteIngressRule(domain); err != nil {
+			log.Warnf("Delete rule of %s in ingress %s failed: %s", domain, s.checkerIngressName(), err)
+		}
+	}()
+	return s.VerifyClusterDomain(domain)
+}
+
+// VerifyClusterDomain 让 slaver 监听随机 token，再通过域名访问 token，检查域名是否解析到了集群。需要先执行 InstallIngress
+func (s *Slaver) VerifyClusterDomain(domain string) error {
 	httpPort := s.getHttpPort()
 	if httpPort == 0 {
 		return sys_errors.New("can't get slaver http port")
@@ -655,9 +800,12 @@ func (s *Slaver) CheckClusterDomain(domain string) error {
 		Method: "POST",
 		Schema: "http",
 		Port:   httpPort,
-		Path:   "/c7n/acme-challenge",
+		Path:   IngressCheckPath,
 		Body:   string(reqBody),
 	}
+	if s.Credentials != nil {
+		req.Header = map[string]*pb.HeaderValue{TokenHeader: {Value: []string{s.Credentials.Token}}}
+	}
 
 	err = s.SendAll(req, true)
 	if err != nil {
@@ -692,6 +840,24 @@ retry:
 	return nil
 }
 
+// Uninstall 删除 slaver 的 DaemonSet、Service、Secret 以及域名检查的 Ingress
 func (s *Slaver) Uninstall() error {
-	return s.Client.AppsV1().DaemonSets(s.Namespace).Delete(context.Background(), s.Name, meta_v1.DeleteOptions{})
+	ctx := context.Background()
+	propagation := meta_v1.DeletePropagationBackground
+	opts := meta_v1.DeleteOptions{PropagationPolicy: &propagation}
+
+	if err := s.DeleteIngress(); err != nil {
+		return err
+	}
+	if err := s.Client.CoreV1().Services(s.Namespace).Delete(ctx, s.Name, opts); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err := s.Client.AppsV1().DaemonSets(s.Namespace).Delete(ctx, s.Name, opts); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err := s.Client.CoreV1().Secrets(s.Namespace).Delete(ctx, s.secretName(), opts); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	log.Infof("Successfully removed slaver %s in namespace %s", s.Name, s.Namespace)
+	return nil
 }
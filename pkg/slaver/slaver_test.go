@@ -1,11 +1,17 @@
 package slaver
 
 import (
+	"context"
 	"github.com/choerodon/c7nctl/pkg/config"
 	pb "github.com/choerodon/c7nctl/pkg/protobuf"
 	"github.com/choerodon/c7nctl/pkg/utils"
 	"github.com/vinkdong/gox/log"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -200,3 +206,66 @@ func TestCheckClusterDomain(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestUninstall(t *testing.T) {
+	slaver := Slaver{
+		Name:  "c7n-slaver",
+		Image: "vinkdong/timing",
+		Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 9000}},
+	}
+	slaver.Init(fake.NewSimpleClientset(), "c7n-system")
+
+	// 没有部署时删除不会报错
+	if err := slaver.Uninstall(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := slaver.CheckInstall(); err != nil {
+		t.Fatal(err)
+	}
+	if slaver.Credentials == nil {
+		t.Fatal("credentials should be generated when deploying slaver")
+	}
+	if _, err := slaver.InstallService(); err != nil {
+		t.Fatal(err)
+	}
+	if err := slaver.Uninstall(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err := slaver.Client.AppsV1().DaemonSets("c7n-system").Get(ctx, "c7n-slaver", meta_v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("daemonSet should be deleted, got %v", err)
+	}
+	if _, err := slaver.Client.CoreV1().Services("c7n-system").Get(ctx, "c7n-slaver", meta_v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("service should be deleted, got %v", err)
+	}
+	if _, err := slaver.Client.CoreV1().Secrets("c7n-system").Get(ctx, "c7n-slaver-auth", meta_v1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("secret should be deleted, got %v", err)
+	}
+}
+
+func TestRandomToken(t *testing.T) {
+	const n = 64
+	tokens := make([]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i] = RandomToken(26)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		if len(token) != 26 || strings.Trim(token, tokenChars) != "" {
+			t.Errorf("unexpected token %q", token)
+		}
+		if seen[token] {
+			t.Errorf("duplicate token %s", token)
+		}
+		seen[token] = true
+	}
+}
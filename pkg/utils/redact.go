@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "******"
+
+// 参数名包含这些单词时认为其值是敏感信息
+var sensitiveWords = []string{"password", "passwd", "secret", "token", "key", "credential"}
+
+func isSensitiveFlag(name string) bool {
+	name = strings.ToLower(strings.TrimLeft(name, "-"))
+	for _, w := range sensitiveWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactArgs 隐藏命令行中的密码等敏感信息，包括 --password xxx、--token=xxx、URL 中的密码、
+// slaver request 的请求头的值和 body，以及 slaver sql 中的字符串，例如 IDENTIFIED BY 'xxx'
+func RedactArgs(args []string) []string {
+	result := make([]string, len(args))
+	slaver, sql := false, false
+	for idx := 0; idx < len(args); idx++ {
+		arg := args[idx]
+		if name, value, ok := splitFlag(arg); ok {
+			redact, valueFlag := valueFlags[name]
+			if !valueFlag && isSensitiveFlag(name) {
+				redact = redactAll
+			}
+			if redact != nil {
+				if value != nil {
+					arg = arg[:len(arg)-len(*value)] + redact(*value)
+				} else if idx+1 < len(args) && (valueFlag || !strings.HasPrefix(args[idx+1], "-")) {
+					// 敏感参数可能是 bool 类型，下一个参数是参数名时不作为值；valueFlags 总是有值
+					result[idx] = arg
+					idx++
+					result[idx] = redact(args[idx])
+					continue
+				}
+			}
+		} else if sql {
+			// slaver sql 的每个参数是一条语句，字符串中的 URL 也会被隐藏
+			result[idx] = sqlLiteral.ReplaceAllStringFunc(arg, func(literal string) string {
+				quote := literal[:1]
+				if len(literal) > 1 && strings.HasSuffix(literal, quote) {
+					return quote + redacted + quote
+				}
+				return quote + redacted
+			})
+			continue
+		} else if arg == "slaver" {
+			slaver = true
+		} else if slaver && arg == "sql" {
+			sql = true
+		}
+		result[idx] = redactURL(arg)
+	}
+	return result
+}
+
+// sql 中单引号或者双引号括起来的字符串，没有结束的引号时到语句的结尾
+var sqlLiteral = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*(?:'|$)|"(?:[^"\\]|\\.|"")*(?:"|$)`)
+
+// 值总是敏感信息的参数，例如 slaver request 的请求头和 body
+var valueFlags = map[string]func(string) string{
+	"-H":       redactHeader,
+	"--header": redactHeader,
+	"-d":       redactAll,
+	"--data":   redactAll,
+}
+
+// splitFlag 将参数拆分为参数名和值，值没有写在同一个参数中时返回 nil，支持 --name=value 以及 -d=value 和 -dvalue
+func splitFlag(arg string) (string, *string, bool) {
+	if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+		return "", nil, false
+	}
+	if _, ok := valueFlags[arg[:2]]; ok && len(arg) > 2 {
+		value := strings.TrimPrefix(arg[2:], "=")
+		return arg[:2], &value, true
+	}
+	if eq := strings.Index(arg, "="); eq > 0 {
+		value := arg[eq+1:]
+		return arg[:eq], &value, true
+	}
+	return arg, nil, true
+}
+
+func redactAll(string) string {
+	return redacted
+}
+
+// redactHeader 隐藏 name:value 形式的请求头的值，只保留名称
+func redactHeader(header string) string {
+	if colon := strings.Index(header, ":"); colon > 0 {
+		return header[:colon+1] + " " + redacted
+	}
+	return redacted
+}
+
+// redactURL 隐藏 URL 中的密码，以及 value 中 key=value 形式的敏感信息
+func redactURL(s string) string {
+	if strings.Contains(s, "://") {
+		if u, err := url.Parse(s); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+				return strings.Replace(u.String(), url.QueryEscape(redacted), redacted, 1)
+			}
+		}
+	}
+	// 例如 --set gitlab.password=xxx
+	if eq := strings.Index(s, "="); eq > 0 && !strings.HasPrefix(s, "-") && isSensitiveFlag(s[:eq]) {
+		return s[:eq+1] + redacted
+	}
+	return s
+}
@@ -3,58 +3,23 @@ package utils
 import (
 	"fmt"
 	"github.com/choerodon/c7nctl/pkg/common/consts"
+	"github.com/choerodon/c7nctl/pkg/repository"
 	std_errors "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	chartmuseum "github.com/yidaqiang/go-chartmuseum"
-	helm_repo "helm.sh/helm/v3/pkg/repo"
-	"regexp"
 )
 
-var client *chartmuseum.Client
-
+// GetReleaseTag 返回仓库中 app 满足 choerodon 版本 version 的最高 chart 版本，版本约束见 repository.DefaultConstraint
 func GetReleaseTag(repo, app, version string) (targetVersion string, err error) {
 	if repo == "" {
 		repo = consts.DefaultRepoUrl
 	}
-	url, path := matchChartRepo(repo)
-	if client == nil {
-		if client, err = chartmuseum.NewClient(chartmuseum.WithBaseURL(url)); err != nil {
-			return "", err
-		}
-	}
-
-	charts := new(helm_repo.ChartVersions)
-	var resp *chartmuseum.Response
-	chartOption := chartmuseum.NewChartOption(app)
-	if charts, resp, err = client.Charts.ListVersions(path, chartOption); err != nil {
-		log.Debug(resp)
+	if targetVersion, err = repository.Find(repo).ResolveVersion(app, repository.DefaultConstraint(version)); err != nil {
 		return "", std_errors.WithMessage(err, fmt.Sprintf("Get Relesea %s version failed", app))
 	}
-
-	reg := regexp.MustCompile("^" + version + ".\\d+$")
-	for _, c := range *charts {
-		tagName := c.Version
-		if reg.MatchString(tagName) {
-			if targetVersion == "" {
-				targetVersion = tagName
-			}
-			log.Debugf("%s version %s", app, targetVersion)
-			if VersionOrdinal(targetVersion) < VersionOrdinal(tagName) {
-				targetVersion = tagName
-			}
-		}
-	}
+	log.Debugf("%s version %s", app, targetVersion)
 	return targetVersion, nil
 }
 
-func matchChartRepo(repo string) (string, string) {
-	spaceReg, _ := regexp.Compile(`^((http://)|(https://))?([a-zA-Z0-9]([a-zA-Z0-9\-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]{2,6}(/)`)
-
-	idx := spaceReg.FindStringIndex(repo)
-
-	return repo[:idx[1]], repo[idx[1]:]
-}
-
 func VersionOrdinal(version string) string {
 	// ISO/IEC 14651:2011
 	const maxByte = 1<<8 - 1
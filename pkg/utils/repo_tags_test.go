@@ -3,6 +3,8 @@ package utils
 import (
 	"fmt"
 	"github.com/choerodon/c7nctl/pkg/common/consts"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -57,9 +59,33 @@ func TestGetReleaseTag(t *testing.T) {
 
 }
 
-func TestCheckMatch2(t *testing.T) {
-	url, path := matchChartRepo(consts.DefaultRepoUrl)
-	t.Logf("url: %s path: %s", url, path)
+func TestGetReleaseTagFromIndex(t *testing.T) {
+	index := `apiVersion: v1
+entries:
+  choerodon-iam:
+  - name: choerodon-iam
+    version: 1.1.9
+  - name: choerodon-iam
+    version: 1.1.10
+  - name: choerodon-iam
+    version: 1.2.0
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/c7n/index.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(index))
+	}))
+	defer server.Close()
+
+	version, err := GetReleaseTag(server.URL+"/c7n/", "choerodon-iam", "1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "1.1.10" {
+		t.Errorf("expected version 1.1.10, got %s", version)
+	}
 }
 
 func Test19to21ReleaseTag(t *testing.T) {
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+const defaultSqlDelimiter = ";"
+
+var (
+	delimiterRegexp  = regexp.MustCompile(`(?i)^\s*DELIMITER\s+(\S+)\s*$`)
+	dollarQuoteRegex = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
+)
+
+// SplitSqlStatements 将 sql 脚本拆分成单条语句
+//
+// 会忽略字符串、标识符和注释中的分隔符。mysql 支持 DELIMITER 指令（用于存储过程和触发器），
+// postgres 支持 $$ 或者 $tag$ 包裹的函数体。只包含注释的语句会被丢弃。
+func SplitSqlStatements(script, sqlType string) []string {
+	mysql := sqlType != "postgres"
+	delimiter := defaultSqlDelimiter
+
+	var statements []string
+	var buf strings.Builder
+	hasContent := false
+	flush := func() {
+		if hasContent {
+			statements = append(statements, strings.TrimSpace(buf.String()))
+		}
+		buf.Reset()
+		hasContent = false
+	}
+
+	lineStart := true
+	for i := 0; i < len(script); {
+		// mysql 客户端的 DELIMITER 指令只能单独占一行
+		if mysql && lineStart {
+			end := strings.IndexByte(script[i:], '\n')
+			line := script[i:]
+			if end >= 0 {
+				line = script[i : i+end]
+			}
+			if m := delimiterRegexp.FindStringSubmatch(line); m != nil {
+				flush()
+				delimiter = m[1]
+				i += len(line)
+				continue
+			}
+		}
+		lineStart = false
+
+		c := script[i]
+		switch {
+		case strings.HasPrefix(script[i:], delimiter):
+			flush()
+			i += len(delimiter)
+			continue
+		case c == '\'' || c == '"' || (mysql && c == '`'):
+			end := quoteEnd(script, i, c, mysql)
+			buf.WriteString(script[i:end])
+			hasContent = true
+			i = end
+			continue
+		case strings.HasPrefix(script[i:], "--") || (mysql && c == '#'):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			buf.WriteString(script[i : i+end])
+			i += end
+			continue
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				end = len(script)
+			} else {
+				end += i + 4
+			}
+			// mysql 的 /*! ... */ 和 /*+ ... */ 会被执行
+			if mysql && i+2 < len(script) && (script[i+2] == '!' || script[i+2] == '+') {
+				hasContent = true
+			}
+			buf.WriteString(script[i:end])
+			i = end
+			continue
+		case !mysql && c == '$':
+			if tag := dollarQuoteRegex.FindString(script[i:]); tag != "" {
+				end := strings.Index(script[i+len(tag):], tag)
+				if end < 0 {
+					end = len(script)
+				} else {
+					end += i + 2*len(tag)
+				}
+				buf.WriteString(script[i:end])
+				hasContent = true
+				i = end
+				continue
+			}
+		}
+
+		if c == '\n' {
+			lineStart = true
+		} else if c != ' ' && c != '\t' && c != '\r' {
+			hasContent = true
+		}
+		buf.WriteByte(c)
+		i++
+	}
+	flush()
+	return statements
+}
+
+// 返回从 start 开始的字符串结束后的位置，引号重复或者（mysql）反斜杠可以转义引号
+func quoteEnd(script string, start int, quote byte, backslash bool) int {
+	for i := start + 1; i < len(script); i++ {
+		switch script[i] {
+		case '\\':
+			if backslash && quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(script) && script[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(script)
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitSqlStatements(t *testing.T) {
+	sqlTest := []struct {
+		Name    string
+		Script  string
+		SqlType string
+		Result  []string
+	}{
+		{
+			Name:    "simple",
+			Script:  "CREATE DATABASE a;\n\nUSE a;\n",
+			SqlType: "mysql",
+			Result:  []string{"CREATE DATABASE a", "USE a"},
+		},
+		{
+			Name:    "no trailing delimiter",
+			Script:  "SELECT 1",
+			SqlType: "mysql",
+			Result:  []string{"SELECT 1"},
+		},
+		{
+			Name:    "delimiter in strings",
+			Script:  `INSERT INTO t VALUES ('a;b', "c;d", 'it''s;', 'e\';f'); SELECT ` + "`x;y`" + ` FROM t;`,
+			SqlType: "mysql",
+			Result:  []string{`INSERT INTO t VALUES ('a;b', "c;d", 'it''s;', 'e\';f')`, "SELECT `x;y` FROM t"},
+		},
+		{
+			Name:    "comments",
+			Script:  "-- create table;\nCREATE TABLE a (id int); # drop;\n/* multi;\nline */\n/*!40101 SET NAMES utf8 */;",
+			SqlType: "mysql",
+			Result:  []string{"-- create table;\nCREATE TABLE a (id int)", "# drop;\n/* multi;\nline */\n/*!40101 SET NAMES utf8 */"},
+		},
+		{
+			Name: "mysql procedure",
+			Script: `DROP PROCEDURE IF EXISTS p;
+DELIMITER $$
+CREATE PROCEDURE p()
+BEGIN
+  SELECT 1;
+  SELECT 2;
+END $$
+delimiter ;
+CALL p();`,
+			SqlType: "mysql",
+			Result: []string{
+				"DROP PROCEDURE IF EXISTS p",
+				"CREATE PROCEDURE p()\nBEGIN\n  SELECT 1;\n  SELECT 2;\nEND",
+				"CALL p()",
+			},
+		},
+		{
+			Name: "postgres function",
+			Script: `CREATE FUNCTION f() RETURNS int AS $body$
+BEGIN
+  RETURN 1;
+END;
+$body$ LANGUAGE plpgsql;
+DO $$ BEGIN PERFORM 1; END $$;
+SELECT '$$;';`,
+			SqlType: "postgres",
+			Result: []string{
+				"CREATE FUNCTION f() RETURNS int AS $body$\nBEGIN\n  RETURN 1;\nEND;\n$body$ LANGUAGE plpgsql",
+				"DO $$ BEGIN PERFORM 1; END $$",
+				"SELECT '$$;'",
+			},
+		},
+		{
+			Name:    "postgres hash and backslash",
+			Script:  `SELECT 'a\'; SELECT 1 # 2;`,
+			SqlType: "postgres",
+			Result:  []string{`SELECT 'a\'`, "SELECT 1 # 2"},
+		},
+		{
+			Name:    "only comments",
+			Script:  "-- nothing\n/* to do */\n",
+			SqlType: "mysql",
+			Result:  nil,
+		},
+	}
+	for _, s := range sqlTest {
+		got := SplitSqlStatements(s.Script, s.SqlType)
+		if !reflect.DeepEqual(got, s.Result) {
+			t.Errorf("%s: want %q, got %q", s.Name, s.Result, got)
+		}
+	}
+}
@@ -140,3 +140,15 @@ func ConditionSkip() bool {
 	//todo skip some test in conditions
 	return true
 }
+
+// Confirm 询问用户是否继续，输入 y 或者 Y 时返回 true
+func Confirm(tip string) (bool, error) {
+	r, err := AcceptUserInput(Input{
+		Tip:   tip + " [Y/N]: ",
+		Regex: "^(y|Y|n|N)$",
+	})
+	if err != nil {
+		return false, err
+	}
+	return r == "y" || r == "Y", nil
+}